@@ -1,23 +1,37 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"os"
+	"os/signal"
 	"path"
 
 	"github.com/spf13/cobra"
 
 	"github.com/greenboxal/agibootstrap/pkg/build"
 	"github.com/greenboxal/agibootstrap/pkg/build/codegen"
+	"github.com/greenboxal/agibootstrap/pkg/build/compile"
 	"github.com/greenboxal/agibootstrap/pkg/build/fiximports"
 	"github.com/greenboxal/agibootstrap/pkg/codex"
+	"github.com/greenboxal/agibootstrap/pkg/lsp"
+	"github.com/greenboxal/agibootstrap/pkg/platform/api"
+	"github.com/greenboxal/agibootstrap/pkg/platform/db/graphstore"
+	"github.com/greenboxal/agibootstrap/pkg/platform/tasks"
+	"github.com/greenboxal/agibootstrap/pkg/platform/vfs/repofs"
+	"github.com/greenboxal/agibootstrap/pkg/psi"
 	"github.com/greenboxal/agibootstrap/pkg/visor"
 
 	// Register languages
 	_ "github.com/greenboxal/agibootstrap/pkg/psi/langs/clang"
 	_ "github.com/greenboxal/agibootstrap/pkg/psi/langs/golang"
+	_ "github.com/greenboxal/agibootstrap/pkg/psi/langs/jsonlang"
 	_ "github.com/greenboxal/agibootstrap/pkg/psi/langs/mdlang"
 	_ "github.com/greenboxal/agibootstrap/pkg/psi/langs/pylang"
+	_ "github.com/greenboxal/agibootstrap/pkg/psi/langs/tomllang"
+	_ "github.com/greenboxal/agibootstrap/pkg/psi/langs/tslang"
+	_ "github.com/greenboxal/agibootstrap/pkg/psi/langs/yamllang"
 )
 
 func main() {
@@ -57,6 +71,45 @@ func main() {
 		},
 	}
 
+	var migrateCmd = &cobra.Command{
+		Use:   "migrate",
+		Short: "Migrate the project datastore",
+		Long:  "This command upgrades the nodes persisted in the project datastore to the current schema version of their node types.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			wd, err := os.Getwd()
+
+			if err != nil {
+				return err
+			}
+
+			cmd.SilenceUsage = true
+
+			p, err := codex.NewProject(cmd.Context(), wd)
+
+			if err != nil {
+				return err
+			}
+
+			defer p.Close()
+
+			count, err := p.Migrate(cmd.Context())
+
+			fmt.Printf("Migrated %d nodes\n", count)
+
+			return err
+		},
+	}
+
+	var generateDryRun bool
+	var generatePatchFile string
+	var generateListTodos bool
+	var generateDiff bool
+	var generateConflictMarkers bool
+	var generateFixDiagnostics bool
+	var generateGlobs []string
+	var generatePackages []string
+	var generatePaths []string
+
 	var generateCmd = &cobra.Command{
 		Use:   "generate [repo path]",
 		Short: "Generate a new file",
@@ -74,7 +127,32 @@ func main() {
 
 			cmd.SilenceUsage = true
 
-			p, err := codex.NewProject(cmd.Context(), wd)
+			scope := build.Scope{
+				Globs:    generateGlobs,
+				Packages: generatePackages,
+			}
+
+			for _, p := range generatePaths {
+				psiPath, err := psi.ParsePath(p)
+
+				if err != nil {
+					return err
+				}
+
+				scope.Paths = append(scope.Paths, psiPath)
+			}
+
+			if generateListTodos {
+				generateDryRun = true
+			}
+
+			var opts []codex.ProjectOption
+
+			if generateDryRun {
+				opts = append(opts, codex.WithOverlayFS())
+			}
+
+			p, err := codex.NewProject(cmd.Context(), wd, opts...)
 
 			if err != nil {
 				return err
@@ -82,7 +160,7 @@ func main() {
 
 			defer p.Close()
 
-			builder := build.NewBuilder(p, build.Configuration{
+			cfg := build.Configuration{
 				OutputDirectory: p.RootPath(),
 				BuildDirectory:  path.Join(p.RootPath(), ".build"),
 
@@ -90,15 +168,57 @@ func main() {
 					&codegen.BuildStep{},
 					&fiximports.BuildStep{},
 				},
-			})
 
-			_, err = builder.Build(cmd.Context())
+				Scope:          scope,
+				ListTodos:      generateListTodos,
+				FixDiagnostics: generateFixDiagnostics,
+				DryRun:         generateDryRun,
+
+				DiffEpochs:           generateDiff,
+				MergeConflictMarkers: generateConflictMarkers,
+			}
+
+			if generateFixDiagnostics {
+				// Compile first, so the compilation errors are fixed by the code generation step.
+				cfg.BuildSteps = append([]build.Step{&compile.BuildStep{}}, cfg.BuildSteps...)
+			}
+
+			if generateDryRun {
+				// Keep the build log out of the working tree.
+				cfg.BuildDirectory, err = os.MkdirTemp("", "agib-build-")
+
+				if err != nil {
+					return err
+				}
+
+				defer os.RemoveAll(cfg.BuildDirectory)
+			}
+
+			builder := build.NewBuilder(p, cfg)
+
+			result, err := builder.Build(cmd.Context())
 
 			if err != nil {
 				fmt.Printf("error: %s\n", err)
+
+				return err
 			}
 
-			return err
+			for _, d := range result.Diagnostics {
+				fmt.Fprintf(os.Stderr, "%s\n", d)
+			}
+
+			for epoch, changes := range result.StructuralChanges {
+				for _, change := range changes {
+					fmt.Fprintf(os.Stderr, "epoch %d: %s\n", epoch, change)
+				}
+			}
+
+			if generateDryRun && !generateListTodos {
+				return writePatch(p.FS().(*repofs.OverlayFS), generatePatchFile)
+			}
+
+			return nil
 		},
 	}
 
@@ -156,7 +276,167 @@ func main() {
 		},
 	}
 
-	rootCmd.AddCommand(initCmd, reindexCmd, generateCmd, commitCmd, debugCmd)
+	var serveAddr string
+
+	var serveCmd = &cobra.Command{
+		Use:   "serve [repo path]",
+		Short: "Serve the project API",
+		Long:  "This command serves a REST and WebSocket API to browse the project graph, run tasks and follow thought streams.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			wd, err := os.Getwd()
+
+			if err != nil {
+				return err
+			}
+
+			if len(args) > 0 {
+				wd = args[0]
+			}
+
+			cmd.SilenceUsage = true
+
+			p, err := codex.NewProject(cmd.Context(), wd)
+
+			if err != nil {
+				return err
+			}
+
+			defer p.Close()
+
+			g, ok := p.Graph().(*graphstore.IndexedGraph)
+
+			if !ok {
+				return fmt.Errorf("project graph is not indexed")
+			}
+
+			srv := api.NewServer(g, p.TaskManager(), p.LogManager())
+
+			defer srv.Close()
+
+			srv.RegisterTask("sync", func(ctx context.Context, args json.RawMessage) (tasks.TaskFunc, error) {
+				return func(progress tasks.TaskProgress) error {
+					return p.Sync()
+				}, nil
+			})
+
+			srv.RegisterTask("reindex", func(ctx context.Context, args json.RawMessage) (tasks.TaskFunc, error) {
+				return func(progress tasks.TaskProgress) error {
+					return p.Reindex()
+				}, nil
+			})
+
+			ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt)
+			defer stop()
+
+			return srv.ListenAndServe(ctx, serveAddr)
+		},
+	}
+
+	var lspCmd = &cobra.Command{
+		Use:   "lsp [repo path]",
+		Short: "Run the language server",
+		Long:  "This command runs a Language Server Protocol server over stdio, providing document symbols, diagnostics and TODO generation to editors.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			wd, err := os.Getwd()
+
+			if err != nil {
+				return err
+			}
+
+			if len(args) > 0 {
+				wd = args[0]
+			}
+
+			cmd.SilenceUsage = true
+
+			// stdout carries the protocol, so anything printed by the build goes to stderr instead.
+			stdout := os.Stdout
+			os.Stdout = os.Stderr
+
+			// Unsaved buffers and generated code are kept in memory; the editor writes the files.
+			p, err := codex.NewProject(cmd.Context(), wd, codex.WithOverlayFS())
+
+			if err != nil {
+				return err
+			}
+
+			defer p.Close()
+
+			return lsp.NewServer(p).Serve(cmd.Context(), os.Stdin, stdout)
+		},
+	}
+
+	var queryRepoPath string
+	var queryLoadSources bool
+
+	var queryCmd = &cobra.Command{
+		Use:   "query <expr>",
+		Short: "Query the project PSI tree",
+		Long:  "This command prints the path and type of the nodes matching a PSI query, relative to the project sources (e.g. '/pkg/agents/**/*<FuncDecl>[comment~=TODO]').",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			q, err := psi.ParseQuery(args[0])
+
+			if err != nil {
+				return err
+			}
+
+			wd := queryRepoPath
+
+			if wd == "" {
+				wd, err = os.Getwd()
+
+				if err != nil {
+					return err
+				}
+			}
+
+			cmd.SilenceUsage = true
+
+			p, err := codex.NewProject(cmd.Context(), wd)
+
+			if err != nil {
+				return err
+			}
+
+			defer p.Close()
+
+			p.Graph().Lock()
+			defer p.Graph().Unlock()
+
+			if queryLoadSources {
+				if err := p.LoadSourceFiles(); err != nil {
+					fmt.Fprintf(os.Stderr, "warning: %s\n", err)
+				}
+			}
+
+			for it := q.Evaluate(p.RootNode()); it.Next(); {
+				n := it.Node()
+
+				fmt.Printf("%s\t%s\n", build.PathFromRoot(p.RootNode(), n), psi.NodeTypeName(n))
+			}
+
+			return nil
+		},
+	}
+
+	queryCmd.Flags().StringVar(&queryRepoPath, "repo", "", "Path to the project, defaults to the working directory")
+	queryCmd.Flags().BoolVar(&queryLoadSources, "sources", true, "Parse source files so their declarations can be queried")
+
+	serveCmd.Flags().StringVar(&serveAddr, "addr", "127.0.0.1:8080", "Address to listen on")
+
+	generateCmd.Flags().BoolVar(&generateDryRun, "dry-run", false, "Generate the changes in memory and print them as a patch instead of committing them")
+	generateCmd.Flags().StringVarP(&generatePatchFile, "output", "o", "-", "File to write the dry-run patch to, or - for stdout")
+
+	generateCmd.Flags().BoolVar(&generateDiff, "diff", false, "Print the structural changes made to the project tree by each build epoch")
+	generateCmd.Flags().BoolVar(&generateConflictMarkers, "conflict-markers", false, "Keep both sides of merge conflicts with files edited during generation, marked with comments")
+	generateCmd.Flags().BoolVar(&generateFixDiagnostics, "fix-diagnostics", false, "Compile the project and ask for its errors to be fixed along with the TODOs")
+	generateCmd.Flags().BoolVar(&generateListTodos, "list", false, "List the TODOs that would be processed without generating any code")
+	generateCmd.Flags().StringSliceVar(&generateGlobs, "glob", nil, "Only process files matching the glob, relative to the project root (e.g. pkg/**/*.go)")
+	generateCmd.Flags().StringSliceVar(&generatePackages, "package", nil, "Only process files in the Go package (e.g. ./pkg/gpt/...)")
+	generateCmd.Flags().StringSliceVar(&generatePaths, "path", nil, "Only process the node at the PSI path, relative to the project sources, as printed by --list")
+
+	rootCmd.AddCommand(initCmd, reindexCmd, migrateCmd, generateCmd, commitCmd, debugCmd, serveCmd, lspCmd, queryCmd)
 
 	if err := rootCmd.Execute(); err != nil {
 		panic(err)
@@ -164,3 +444,20 @@ func main() {
 
 	os.Exit(0)
 }
+
+// writePatch writes the changes pending in the overlay as a unified diff to the given file, or to stdout for "-".
+func writePatch(overlay *repofs.OverlayFS, output string) error {
+	diff, err := overlay.Diff()
+
+	if err != nil {
+		return err
+	}
+
+	if output == "" || output == "-" {
+		_, err = fmt.Print(diff)
+
+		return err
+	}
+
+	return os.WriteFile(output, []byte(diff), 0644)
+}
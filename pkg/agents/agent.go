@@ -3,6 +3,7 @@ package agents
 import (
 	"context"
 
+	"github.com/greenboxal/agibootstrap/pkg/gpt"
 	"github.com/greenboxal/agibootstrap/pkg/platform/db/thoughtstream"
 )
 
@@ -33,6 +34,10 @@ type StepOptions struct {
 	Base   thoughtstream.Branch
 	Head   thoughtstream.Pointer
 	Stream thoughtstream.Stream
+
+	// Provider is the LLM provider used for this step.
+	// If nil, the provider carried by the context or gpt.DefaultProvider is used.
+	Provider gpt.Provider
 }
 
 func (opts *StepOptions) Apply(options ...StepOption) error {
@@ -88,3 +93,11 @@ func WithHeadPointer(head thoughtstream.Pointer) StepOption {
 		return nil
 	}
 }
+
+func WithProvider(provider gpt.Provider) StepOption {
+	return func(opts *StepOptions) error {
+		opts.Provider = provider
+
+		return nil
+	}
+}
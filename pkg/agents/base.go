@@ -106,6 +106,8 @@ func (a *AgentBase) Step(ctx context.Context, options ...StepOption) error {
 		return err
 	}
 
+	ctx = gpt.WithProvider(ctx, gpt.ResolveProvider(ctx, opts.Provider))
+
 	branchStream := opts.Base.Fork()
 
 	prompt := TmlContainer(
@@ -177,6 +179,9 @@ func (a *AgentBase) Introspect(ctx context.Context, prompt AgentPrompt, options
 		return nil, err
 	}
 
+	provider := gpt.ResolveProvider(ctx, opts.Provider)
+	ctx = gpt.WithProvider(ctx, provider)
+
 	actx := a.makeContext(ctx, opts)
 
 	msg, err := prompt.Render(actx)
@@ -187,7 +192,7 @@ func (a *AgentBase) Introspect(ctx context.Context, prompt AgentPrompt, options
 
 	fmt.Printf("\n##############\n%s\n", msg.String())
 
-	replyStream, err := gpt.GlobalModel.PredictChatStream(ctx, msg, predictOptions...)
+	replyStream, err := provider.ChatModel().PredictChatStream(ctx, msg, predictOptions...)
 
 	if err != nil {
 		return nil, err
@@ -27,7 +27,7 @@ func TmlContainer(children ...promptml.Node) AgentPromptFunc {
 func Tml(rootBuilder func(ctx AgentContext) promptml.Parent) AgentPromptFunc {
 	return func(ctx AgentContext) (result chat.Message, err error) {
 		root := rootBuilder(ctx)
-		stage := promptml.NewStage(root, gpt.GlobalModelTokenizer)
+		stage := promptml.NewStage(root, gpt.ResolveProvider(ctx.Context(), nil).Tokenizer())
 		stage.MaxTokens = 10240
 
 		for it := root.ChildrenIterator(); it.Next(); {
@@ -4,6 +4,7 @@ import (
 	"context"
 
 	"github.com/greenboxal/agibootstrap/pkg/platform/project"
+	"github.com/greenboxal/agibootstrap/pkg/psi"
 )
 
 type Builder struct {
@@ -15,8 +16,15 @@ type Result struct {
 	ChangeCount int
 	Errors      []error
 
+	// Diagnostics are the diagnostics attached to the project tree at the end of the build.
+	Diagnostics []*psi.Diagnostic
+
 	TotalSteps  int
 	TotalEpochs int
+
+	// StructuralChanges are the changes to the project tree made by each epoch, when
+	// Configuration.DiffEpochs is set.
+	StructuralChanges [][]psi.NodeChange
 }
 
 func NewBuilder(project project.Project, cfg Configuration) *Builder {
@@ -3,22 +3,84 @@ package codegen
 import (
 	"context"
 	"fmt"
+	"strings"
 
 	"github.com/hashicorp/go-multierror"
+	"github.com/pkg/errors"
+	"github.com/samber/lo"
 
 	"github.com/greenboxal/agibootstrap/pkg/build"
 	"github.com/greenboxal/agibootstrap/pkg/gpt"
-	"github.com/greenboxal/agibootstrap/pkg/platform/mdutils"
 	"github.com/greenboxal/agibootstrap/pkg/platform/project"
 	"github.com/greenboxal/agibootstrap/pkg/platform/vfs"
 	"github.com/greenboxal/agibootstrap/pkg/psi"
 )
 
+// MergeDiagnosticSource is the source of the diagnostics published for the conflicts between
+// generated code and the changes made to a file during the build.
+const MergeDiagnosticSource = "merge"
+
 type BuildStep struct{}
 
+// scopedFile is a source file in the scope of the build.
+type scopedFile struct {
+	path string
+	// whole is true if every node of the file is in scope.
+	whole bool
+}
+
+// Process generates code for the TODOs of the source files in scope. The write lock of the project
+// graph is held while walking and merging, and released while waiting for the model, so readers
+// such as the visor and the language server are not blocked.
 func (bs *BuildStep) Process(ctx context.Context, bctx *build.Context) (result build.StepResult, err error) {
+	scope := bctx.Config().Scope
+
+	var files []scopedFile
+
+	err = psi.UpdateGraph(bctx.Project().Graph(), func() (err error) {
+		files, err = bs.scopedFiles(bctx, &scope)
+
+		return
+	})
+
+	if err != nil {
+		return result, err
+	}
+
+	refs := &scopeReferences{
+		bctx:  bctx,
+		paths: lo.Map(files, func(f scopedFile, _ int) string { return f.path }),
+	}
+
+	for _, f := range files {
+		opts := []NodeProcessorOption{withReferences(refs)}
+
+		// Files matched by a glob or a package are processed as a whole,
+		// the others only under the PSI paths they contain.
+		if !f.whole {
+			opts = append(opts, WithScope(&scope))
+		}
+
+		count, e := bs.processFile(ctx, bctx, f.path, opts...)
+
+		if e != nil {
+			err = multierror.Append(err, e)
+		}
+
+		result.ChangeCount += count
+	}
+
+	return
+}
+
+// scopedFiles resolves scope and returns the source files it matches.
+func (bs *BuildStep) scopedFiles(bctx *build.Context, scope *build.Scope) (files []scopedFile, err error) {
 	langRegistry := bctx.Project().LanguageProvider()
 
+	if err := scope.Resolve(bctx.Project().RootPath()); err != nil {
+		return nil, err
+	}
+
 	err = psi.Walk(bctx.Project(), func(cursor psi.Cursor, entering bool) error {
 		n := cursor.Node()
 
@@ -34,17 +96,13 @@ func (bs *BuildStep) Process(ctx context.Context, bctx *build.Context) (result b
 				filePath := n.Path()
 				lang := langRegistry.ResolveExtension(filePath)
 
-				if lang == nil {
-					break
-				}
-
-				count, e := bs.processFile(ctx, bctx, filePath)
-
-				if e != nil {
-					err = multierror.Append(err, e)
+				if lang != nil && scope.MatchFile(bctx.Project().RootPath(), filePath) {
+					files = append(files, scopedFile{
+						path:  filePath,
+						whole: scope.MatchWholeFile(bctx.Project().RootPath(), filePath),
+					})
 				}
 
-				result.ChangeCount += count
 				cursor.SkipChildren()
 
 			default:
@@ -55,7 +113,54 @@ func (bs *BuildStep) Process(ctx context.Context, bctx *build.Context) (result b
 		return nil
 	})
 
-	return
+	return files, err
+}
+
+// scopeReferences resolves the references between the source files in scope, which the
+// ContextRanker needs to rank the context of code generation requests. They are only resolved
+// once per step, when the context of the first request is prepared, so steps without anything
+// to generate don't pay for it.
+type scopeReferences struct {
+	bctx  *build.Context
+	paths []string
+
+	resolved bool
+}
+
+// Resolve resolves the references if they were not resolved yet. Errors are reported to the
+// build context, since the context can still be ranked without some of the references.
+// The caller must hold the write lock of the project graph.
+func (r *scopeReferences) Resolve(ctx context.Context) {
+	if r.resolved {
+		return
+	}
+
+	r.resolved = true
+
+	byLanguage := map[psi.Language][]psi.SourceFile{}
+
+	for _, path := range r.paths {
+		sf, err := r.bctx.Project().GetSourceFile(path)
+
+		if err != nil {
+			r.bctx.ReportError(err)
+			continue
+		}
+
+		byLanguage[sf.Language()] = append(byLanguage[sf.Language()], sf)
+	}
+
+	for lang, files := range byLanguage {
+		resolver, ok := lang.(psi.ReferenceResolver)
+
+		if !ok {
+			continue
+		}
+
+		if err := resolver.ResolveReferences(ctx, files); err != nil {
+			r.bctx.ReportError(err)
+		}
+	}
 }
 
 func (bs *BuildStep) processFile(ctx context.Context, bctx *build.Context, fsPath string, opts ...NodeProcessorOption) (int, error) {
@@ -63,23 +168,85 @@ func (bs *BuildStep) processFile(ctx context.Context, bctx *build.Context, fsPat
 
 	//bctx.Branch().Infow("Processing file", "file", fsPath)
 
-	sf, err := p.GetSourceFile(fsPath)
+	var sf psi.SourceFile
+	var root psi.Node
 
-	if err != nil {
-		return 0, err
-	}
+	err := psi.UpdateGraph(p.Graph(), func() (err error) {
+		sf, err = p.GetSourceFile(fsPath)
 
-	if sf.Error() != nil {
+		if err != nil {
+			return err
+		}
+
+		// Files that don't parse are skipped.
+		if sf.Error() == nil {
+			root = sf.Root()
+		}
+
+		return nil
+	})
+
+	if err != nil || root == nil {
 		return 0, err
 	}
 
 	// Process the AST nodes
-	updated, err := bs.ProcessNode(ctx, bctx, sf, sf.Root(), opts...)
+	updated, err := bs.ProcessNode(ctx, bctx, sf, root, opts...)
+
+	// The generated code is dropped, and the scope is left for a later build of the new contents.
+	if errors.Is(err, ErrSourceChanged) {
+		bctx.ReportError(err)
+
+		return 0, nil
+	}
 
 	if err != nil {
 		return 0, err
 	}
 
+	var count int
+
+	err = psi.UpdateGraph(p.Graph(), func() (err error) {
+		count, err = bs.writeFile(bctx, sf, updated)
+
+		return
+	})
+
+	return count, err
+}
+
+// writeFile writes the code of updated to sf, and the other files edited by the merges into sf, if
+// they changed, and returns the number of changed files. The caller must hold the write lock of the
+// project graph.
+func (bs *BuildStep) writeFile(bctx *build.Context, sf psi.SourceFile, updated psi.Node) (int, error) {
+	var edited []psi.SourceFile
+
+	// Writing sf reloads it, forgetting the files it edited.
+	if esf, ok := sf.(psi.EditingSourceFile); ok {
+		edited = esf.EditedFiles()
+	}
+
+	count, err := bs.writeSourceFile(bctx, sf, updated)
+
+	if err != nil {
+		return count, err
+	}
+
+	for _, other := range edited {
+		n, err := bs.writeSourceFile(bctx, other, other.Root())
+
+		if err != nil {
+			return count, err
+		}
+
+		count += n
+	}
+
+	return count, nil
+}
+
+// writeSourceFile writes the code of updated to sf, if it changed, and returns the number of changed files.
+func (bs *BuildStep) writeSourceFile(bctx *build.Context, sf psi.SourceFile, updated psi.Node) (int, error) {
 	// Convert the AST back to code
 	newCode, err := sf.ToCode(updated)
 	if err != nil {
@@ -87,6 +254,34 @@ func (bs *BuildStep) processFile(ctx context.Context, bctx *build.Context, fsPat
 	}
 
 	if newCode.Code != sf.OriginalText() {
+		// Keep the changes made to the file while the code was being generated.
+		if msf, ok := sf.(psi.MergingSourceFile); ok {
+			conflicts, err := msf.MergeReplace(newCode.Code, psi.MergeOptions{
+				ConflictMarkers: bctx.Config().MergeConflictMarkers,
+			})
+
+			if err != nil {
+				return 0, err
+			}
+
+			diagnostics := make([]*psi.Diagnostic, 0, len(conflicts))
+
+			for _, conflict := range conflicts {
+				bctx.ReportError(conflict)
+
+				diagnostics = append(diagnostics, psi.NewDiagnostic(
+					MergeDiagnosticSource,
+					psi.SeverityWarning,
+					psi.SourceRange{File: conflict.File},
+					conflict.Error(),
+				))
+			}
+
+			psi.PublishDiagnostics(sf, MergeDiagnosticSource, diagnostics)
+
+			return 1, nil
+		}
+
 		if err := sf.Replace(newCode.Code); err != nil {
 			return 0, nil
 		}
@@ -97,60 +292,58 @@ func (bs *BuildStep) processFile(ctx context.Context, bctx *build.Context, fsPat
 	return 0, nil
 }
 
-// ProcessNode processes the given node and returns the updated node.
+// ProcessNode processes the given node and returns the updated node. The first scope with TODOs is
+// found with the write lock of the project graph held, then processed by NodeProcessor.Step.
 func (bs *BuildStep) ProcessNode(ctx context.Context, bctx *build.Context, sf psi.SourceFile, root psi.Node, opts ...NodeProcessorOption) (psi.Node, error) {
 	processor := &NodeProcessor{
 		Project:    bctx.Project(),
 		SourceFile: sf,
 		Root:       root,
+
+		verifyCodeBlocks:  VerifyCodeBlocks,
+		maxVerifyAttempts: bctx.Config().MaxVerifyAttempts,
+		verifyOptions: psi.VerifyOptions{
+			RunTests: bctx.Config().VerifyTests,
+			Merge: psi.MergeOptions{
+				ConflictMarkers: bctx.Config().MergeConflictMarkers,
+			},
+		},
 	}
 
 	processor.ctx, processor.cancel = context.WithCancel(ctx)
 
+	fixDiagnostics := bctx.Config().FixDiagnostics
+
 	processor.checkShouldProcess = func(fn *NodeScope, cursor psi.Cursor) bool {
-		return len(fn.Todos) > 0
+		if len(fn.Todos) == 0 && (!fixDiagnostics || len(errorDiagnostics(fn.Diagnostics)) == 0) {
+			return false
+		}
+
+		if processor.scope == nil {
+			return true
+		}
+
+		return processor.scope.MatchNode(build.PathFromRoot(bctx.Project().RootNode(), fn.Node))
 	}
 
-	processor.prepareContext = func(processor *NodeProcessor, ctx *NodeScope, root psi.Node, req gpt.CodeGeneratorRequest) (gpt.ContextBag, error) {
-		var err error
+	ranker := NewContextRanker(bctx.Project())
 
-		result := gpt.ContextBag{}
+	if budget := bctx.Config().ContextTokenBudget; budget > 0 {
+		ranker.TokenBudget = budget
+	}
 
-		wholeFile, err := processor.SourceFile.ToCode(root)
+	processor.prepareContext = func(processor *NodeProcessor, ctx *NodeScope, root psi.Node, req gpt.CodeGeneratorRequest) (gpt.ContextBag, error) {
+		focus, err := processor.SourceFile.ToCode(ctx.Node)
 
 		if err != nil {
 			return nil, err
 		}
 
-		queries := []string{wholeFile.Code}
-
-		if req.Objective != "" {
-			queries = append(queries, req.Objective)
-		}
-
-		if req.Plan != "" {
-			queries = append(queries, req.Plan)
+		if processor.references != nil {
+			processor.references.Resolve(processor.ctx)
 		}
 
-		for _, query := range queries {
-			hits, err := bctx.Project().Repo().Query(context.Background(), query, 5)
-
-			if err != nil {
-				return nil, err
-			}
-
-			for _, hit := range hits {
-				key := fmt.Sprintf("for reference only, do not copy: %s @ %d", hit.Entry.Document.Path, hit.Entry.Chunk.Index)
-
-				result[key] = mdutils.CodeBlock{
-					Language: "",
-					Filename: hit.Entry.Document.Path,
-					Code:     hit.Entry.Chunk.Content,
-				}
-			}
-		}
-
-		return result, nil
+		return ranker.Fill(processor.ctx, ctx.Node, []string{focus.Code, req.Objective, req.Plan})
 	}
 
 	processor.prepareObjective = func(p *NodeProcessor, ctx *NodeScope) (result string, err error) {
@@ -158,6 +351,12 @@ func (bs *BuildStep) ProcessNode(ctx context.Context, bctx *build.Context, sf ps
 			result += fmt.Sprintf("- [ ] %s\n", todoRegex.ReplaceAllString(todo, ""))
 		}
 
+		if fixDiagnostics {
+			for _, d := range errorDiagnostics(ctx.Diagnostics) {
+				result += fmt.Sprintf("- [ ] Fix the %s error at line %d: %s\n", d.Source, d.Range.Start.Line, d.Message)
+			}
+		}
+
 		return
 	}
 
@@ -165,21 +364,86 @@ func (bs *BuildStep) ProcessNode(ctx context.Context, bctx *build.Context, sf ps
 		opt(processor)
 	}
 
-	result, err := psi.Rewrite(processor.Root, func(cursor psi.Cursor, entering bool) error {
-		if entering {
-			return processor.OnEnter(cursor)
-		} else {
-			return processor.OnLeave(cursor)
-		}
+	if bctx.Config().ListTodos {
+		processor.checkShouldProcess = listTodos(bctx, processor.checkShouldProcess)
+	}
+
+	g := bctx.Project().Graph()
+
+	err := psi.UpdateGraph(g, func() error {
+		_, err := psi.Rewrite(processor.Root, func(cursor psi.Cursor, entering bool) error {
+			if entering {
+				return processor.OnEnter(cursor)
+			} else {
+				return processor.OnLeave(cursor)
+			}
+		})
+
+		return err
 	})
 
 	if err != nil {
 		return nil, err
 	}
 
-	if err := result.Update(ctx); err != nil {
+	if next := processor.next; next != nil {
+		if _, err := processor.Step(processor.ctx, next.scope, next.cursor); err != nil {
+			return nil, err
+		}
+	}
+
+	// The walk stops at the scope it processes, so the root is updated rather than the node the walk ended on.
+	err = psi.UpdateGraph(g, func() error {
+		return processor.Root.Update(ctx)
+	})
+
+	if err != nil {
 		return nil, err
 	}
 
-	return result, nil
+	return processor.Root, nil
+}
+
+// WithScope restricts the NodeProcessor to the nodes in the given scope.
+func WithScope(scope *build.Scope) NodeProcessorOption {
+	return func(p *NodeProcessor) {
+		p.scope = scope
+	}
+}
+
+// withReferences makes the NodeProcessor resolve refs before preparing the context of a request.
+func withReferences(refs *scopeReferences) NodeProcessorOption {
+	return func(p *NodeProcessor) {
+		p.references = refs
+	}
+}
+
+// listTodos wraps checkShouldProcess so that the scopes that would be processed are printed instead.
+func listTodos(bctx *build.Context, checkShouldProcess func(fn *NodeScope, cursor psi.Cursor) bool) func(fn *NodeScope, cursor psi.Cursor) bool {
+	return func(fn *NodeScope, cursor psi.Cursor) bool {
+		if !checkShouldProcess(fn, cursor) {
+			return false
+		}
+
+		fmt.Printf("%s\n", build.PathFromRoot(bctx.Project().RootNode(), fn.Node))
+
+		for _, todo := range fn.Todos {
+			fmt.Printf("\t%s\n", strings.TrimSpace(todoRegex.ReplaceAllString(todo, "")))
+		}
+
+		if bctx.Config().FixDiagnostics {
+			for _, d := range errorDiagnostics(fn.Diagnostics) {
+				fmt.Printf("\t%s\n", d)
+			}
+		}
+
+		return false
+	}
+}
+
+// errorDiagnostics returns the diagnostics of error severity.
+func errorDiagnostics(diagnostics []*psi.Diagnostic) []*psi.Diagnostic {
+	return lo.Filter(diagnostics, func(d *psi.Diagnostic, _ int) bool {
+		return d.Severity == psi.SeverityError
+	})
 }
@@ -0,0 +1,226 @@
+package codegen
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/greenboxal/aip/aip-langchain/pkg/llm/chat"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+
+	"github.com/greenboxal/agibootstrap/pkg/build"
+	"github.com/greenboxal/agibootstrap/pkg/codex"
+	"github.com/greenboxal/agibootstrap/pkg/gpt"
+	"github.com/greenboxal/agibootstrap/pkg/platform/vfs/repofs"
+	"github.com/greenboxal/agibootstrap/pkg/psi"
+	_ "github.com/greenboxal/agibootstrap/pkg/psi/langs/clang"
+)
+
+// openTestProject writes files to a temporary directory and opens it as a project with an
+// overlay file system and the fake model provider.
+func openTestProject(t *testing.T, files map[string]string) *codex.Project {
+	root := t.TempDir()
+
+	for name, content := range files {
+		require.NoError(t, os.MkdirAll(filepath.Join(root, filepath.Dir(name)), 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(root, name), []byte(content), 0644))
+	}
+
+	require.NoError(t, os.MkdirAll(filepath.Join(root, ".fti"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(root, ".fti", "config.json"), []byte(`{"llm": {"kind": "fake"}}`), 0644))
+
+	p, err := codex.NewProject(context.Background(), root, codex.WithOverlayFS())
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, p.Close()) })
+
+	return p
+}
+
+func TestBuildStep(t *testing.T) {
+	p := openTestProject(t, map[string]string{
+		"go.mod": "module example.com/app\n\ngo 1.20\n",
+
+		"main.go": `package main
+
+func greet() string {
+	// TODO: Return a greeting
+	return ""
+}
+
+func main() {
+	println(greet())
+}
+`,
+	})
+
+	replies := []string{
+		"1. Return hello.",
+		"```go\nfunc greet() string {\n\treturn \"hello\"\n}\n```",
+	}
+
+	provider := p.ModelProvider().(*gpt.FakeProvider)
+	provider.ReplyFunc = func(ctx context.Context, msg chat.Message) (string, error) {
+		// The build must not hold the graph lock while waiting for the model.
+		unlocked := make(chan struct{})
+
+		go func() {
+			_ = psi.ReadGraph(p.Graph(), func() error { return nil })
+			close(unlocked)
+		}()
+
+		select {
+		case <-unlocked:
+		case <-time.After(5 * time.Second):
+			return "", errors.New("the graph is locked while waiting for the model")
+		}
+
+		reply := replies[0]
+
+		if len(replies) > 1 {
+			replies = replies[1:]
+		}
+
+		return reply, nil
+	}
+
+	result, err := build.NewBuilder(p, build.Configuration{
+		OutputDirectory: p.RootPath(),
+		BuildDirectory:  t.TempDir(),
+		BuildSteps:      []build.Step{&BuildStep{}},
+		MaxEpochs:       1,
+		DryRun:          true,
+	}).Build(context.Background())
+	require.NoError(t, err)
+	require.Empty(t, result.Errors)
+	require.Equal(t, 1, result.ChangeCount)
+
+	// Only the scope with the TODO is replaced, the rest of the file is kept.
+	code, err := fs.ReadFile(p.FS().(*repofs.OverlayFS), "main.go")
+	require.NoError(t, err)
+	require.Equal(t, `package main
+
+func greet() string {
+	return "hello"
+}
+
+func main() {
+	println(greet())
+}
+`, string(code))
+}
+
+func TestBuildStepSourceChanged(t *testing.T) {
+	const code = `package main
+
+func greet() string {
+	// TODO: Return a greeting
+	return ""
+}
+`
+
+	p := openTestProject(t, map[string]string{
+		"go.mod":  "module example.com/app\n\ngo 1.20\n",
+		"main.go": code,
+	})
+
+	replies := []string{
+		"1. Return hello.",
+		"```go\nfunc greet() string {\n\treturn \"hello\"\n}\n```",
+	}
+
+	provider := p.ModelProvider().(*gpt.FakeProvider)
+	provider.ReplyFunc = func(ctx context.Context, msg chat.Message) (string, error) {
+		reply := replies[0]
+
+		if len(replies) > 1 {
+			replies = replies[1:]
+		} else {
+			// The file is reloaded, as by an editor, while the code is being generated.
+			err := psi.UpdateGraph(p.Graph(), func() error {
+				sf, err := p.GetSourceFile(filepath.Join(p.RootPath(), "main.go"))
+
+				if err != nil {
+					return err
+				}
+
+				return sf.Load()
+			})
+
+			if err != nil {
+				return "", err
+			}
+		}
+
+		return reply, nil
+	}
+
+	result, err := build.NewBuilder(p, build.Configuration{
+		OutputDirectory: p.RootPath(),
+		BuildDirectory:  t.TempDir(),
+		BuildSteps:      []build.Step{&BuildStep{}},
+		MaxEpochs:       1,
+		DryRun:          true,
+	}).Build(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, 0, result.ChangeCount)
+	require.Len(t, result.Errors, 1)
+	require.ErrorIs(t, result.Errors[0], ErrSourceChanged)
+
+	written, err := fs.ReadFile(p.FS().(*repofs.OverlayFS), "main.go")
+	require.NoError(t, err)
+	require.Equal(t, code, string(written))
+}
+
+func TestBuildStepWritesHeader(t *testing.T) {
+	p := openTestProject(t, map[string]string{
+		"math.h": "#ifndef MATH_H\n#define MATH_H\n\nint add(int a, int b);\n\n#endif\n",
+
+		"math.c": `#include "math.h"
+
+int add(int a, int b) {
+	// TODO: Add a and b, using a new function sum
+	return 0;
+}
+`,
+	})
+
+	replies := []string{
+		"1. Add sum.",
+		"```c\nint sum(int a, int b) {\n\treturn a + b;\n}\n\nint add(int a, int b) {\n\treturn sum(a, b);\n}\n```",
+	}
+
+	provider := p.ModelProvider().(*gpt.FakeProvider)
+	provider.ReplyFunc = func(ctx context.Context, msg chat.Message) (string, error) {
+		reply := replies[0]
+
+		if len(replies) > 1 {
+			replies = replies[1:]
+		}
+
+		return reply, nil
+	}
+
+	result, err := build.NewBuilder(p, build.Configuration{
+		OutputDirectory: p.RootPath(),
+		BuildDirectory:  t.TempDir(),
+		BuildSteps:      []build.Step{&BuildStep{}},
+		MaxEpochs:       1,
+		DryRun:          true,
+	}).Build(context.Background())
+	require.NoError(t, err)
+	require.Empty(t, result.Errors)
+	require.Equal(t, 2, result.ChangeCount)
+
+	// The prototype of the new function is written to the overlay with the implementation.
+	code, err := fs.ReadFile(p.FS().(*repofs.OverlayFS), "math.h")
+	require.NoError(t, err)
+	require.Equal(t, "#ifndef MATH_H\n#define MATH_H\n\nint add(int a, int b);\n\nint sum(int a, int b);\n\n#endif\n", string(code))
+
+	code, err = os.ReadFile(filepath.Join(p.RootPath(), "math.h"))
+	require.NoError(t, err)
+	require.Equal(t, "#ifndef MATH_H\n#define MATH_H\n\nint add(int a, int b);\n\n#endif\n", string(code))
+}
@@ -7,8 +7,11 @@ import (
 	"strings"
 
 	"github.com/dave/dst"
+	"github.com/pkg/errors"
 
+	"github.com/greenboxal/agibootstrap/pkg/build"
 	"github.com/greenboxal/agibootstrap/pkg/gpt"
+	"github.com/greenboxal/agibootstrap/pkg/platform/mdutils"
 	"github.com/greenboxal/agibootstrap/pkg/platform/project"
 	"github.com/greenboxal/agibootstrap/pkg/psi"
 )
@@ -25,6 +28,8 @@ type NodeScope struct {
 	Processor *NodeProcessor
 	Node      psi.Node
 	Todos     []string
+	// Diagnostics are the diagnostics attached to the nodes of the scope, outside of nested scopes.
+	Diagnostics []*psi.Diagnostic
 }
 
 func (n *NodeScope) Root() psi.Node {
@@ -49,13 +54,35 @@ type NodeProcessor struct {
 	prepareObjective   func(p *NodeProcessor, ctx *NodeScope) (string, error)                                                              // A function to prepare the objective for GPT-3.
 	prepareContext     func(p *NodeProcessor, ctx *NodeScope, root psi.Node, baseRequest gpt.CodeGeneratorRequest) (gpt.ContextBag, error) // A function to prepare the context for GPT-3.
 	checkShouldProcess func(fn *NodeScope, cursor psi.Cursor) bool                                                                         // A function to check if a function should be processed.
+	verifyCodeBlocks   func(ctx context.Context, p *NodeProcessor, scope *NodeScope, blocks []mdutils.CodeBlock) error                     // A function to verify the generated code before it is merged.
+
+	next              *nextStep         // The scope found by the walk, processed once the walk is done.
+	scope             *build.Scope      // The scope of the build, if restricted.
+	references        *scopeReferences  // The references resolved before preparing the context, if any.
+	verifyOptions     psi.VerifyOptions // The options used to verify the generated code.
+	maxVerifyAttempts int               // The maximum number of generation rounds per step.
 
 	ctx    context.Context
 	cancel context.CancelFunc
 }
 
+// ErrSourceChanged is returned by NodeProcessor.Step when the source file was reloaded or changed while
+// the model was generating, since the scope and cursor of the step may no longer be part of it.
+var ErrSourceChanged = errors.New("source file changed during code generation")
+
+// nextStep is a scope to process with Step, and the cursor of the walk that found it.
+type nextStep struct {
+	scope  *NodeScope
+	cursor psi.Cursor
+}
+
 var todoRegex = regexp.MustCompile(`(?m)^\s*//\s*TODO:`)
 
+// IsTodoComment returns true if the comment is a TODO the NodeProcessor acts on.
+func IsTodoComment(txt string) bool {
+	return todoRegex.MatchString(strings.TrimSpace(txt))
+}
+
 // OnEnter method is called when entering a node during
 // the AST traversal. It checks if the node is a container,
 // and if so, pushes a new NodeScope onto the FuncStack.
@@ -92,6 +119,12 @@ func (p *NodeProcessor) OnEnter(cursor psi.Cursor) error {
 		}
 	}
 
+	if len(p.FuncStack) > 0 {
+		currentFn := p.FuncStack[len(p.FuncStack)-1]
+
+		currentFn.Diagnostics = append(currentFn.Diagnostics, psi.NodeDiagnostics(cursor.Node())...)
+	}
+
 	return nil
 }
 
@@ -99,7 +132,7 @@ func (p *NodeProcessor) OnEnter(cursor psi.Cursor) error {
 // the AST traversal. It checks if the node is a container,
 // and if so, pops the top NodeScope from the FuncStack.
 // It also checks if the current function should be processed
-// and calls the Step method to process the function if necessary.
+// and, if so, stops the walk so the function can be processed with the Step method.
 //
 // Parameters:
 // - cursor: The psi.Cursor representing the current node.
@@ -108,7 +141,8 @@ func (p *NodeProcessor) OnEnter(cursor psi.Cursor) error {
 // - bool: true to continue traversing the AST, false to stop.
 //
 // OnLeave is responsible for popping the top NodeScope from the FuncStack if the current node is a container.
-// Additionally, it checks if the current function should be processed and calls the Step method to process the function if necessary.
+// Additionally, it checks if the current function should be processed and records it as the next step of the NodeProcessor.
+// The step runs after the walk, since it releases the write lock of the project graph while waiting for the model.
 func (p *NodeProcessor) OnLeave(cursor psi.Cursor) error {
 	e := cursor.Node()
 
@@ -124,11 +158,7 @@ func (p *NodeProcessor) OnLeave(cursor psi.Cursor) error {
 			return nil
 		}
 
-		_, err := p.Step(p.ctx, currentFn, cursor)
-
-		if err != nil {
-			return err
-		}
+		p.next = &nextStep{scope: currentFn, cursor: cursor}
 
 		return psi.ErrAbort
 	}
@@ -168,26 +198,91 @@ func (p *NodeProcessor) OnLeave(cursor psi.Cursor) error {
 //
 // Return Processed Code:
 // 10. Return the processed code as a dst.Node.
+//
+// Step takes the write lock of the project graph to prepare the request and to merge the code blocks,
+// and releases it while waiting for the model. The caller must not hold it. If the root of the source
+// file was replaced or updated in between, the code blocks are not merged and ErrSourceChanged is
+// returned.
 func (p *NodeProcessor) Step(ctx context.Context, scope *NodeScope, cursor psi.Cursor) (result dst.Node, err error) {
+	g := p.Project.Graph()
+
+	var req gpt.CodeGeneratorRequest
+	var root psi.Node
+	var version int64
+
+	err = psi.UpdateGraph(g, func() (err error) {
+		root = p.SourceFile.Root()
+
+		if root != nil {
+			version = root.PsiNodeVersion()
+		}
+
+		req, err = p.prepareRequest(scope, cursor)
+
+		return
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	// The graph is not locked while waiting for the model, so readers such as the visor are not blocked.
+	cg := gpt.NewCodeGenerator(p.Project.ModelProvider())
+	res, err := cg.Generate(ctx, req)
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = psi.UpdateGraph(g, func() error {
+		if current := p.SourceFile.Root(); current != root || (current != nil && current.PsiNodeVersion() != version) {
+			return errors.Wrap(ErrSourceChanged, p.SourceFile.Name())
+		}
+
+		newRoots, err := p.parseCodeBlocks(res.CodeBlocks)
+
+		if err != nil {
+			return err
+		}
+
+		if g == nil {
+			return p.mergeCodeBlocks(ctx, scope, cursor, newRoots)
+		}
+
+		// Merge all code blocks at once, so a failed merge doesn't leave the tree half-mutated.
+		return psi.RunInTransaction(g, func(tx *psi.Transaction) error {
+			return p.mergeCodeBlocks(ctx, scope, cursor, newRoots)
+		})
+	})
+
+	return nil, err
+}
+
+// prepareRequest prepares the code generation request for scope. The caller must hold the write lock
+// of the project graph. The context retrieval and verification callbacks of the request take it
+// themselves, since they run while the model is generating.
+func (p *NodeProcessor) prepareRequest(scope *NodeScope, cursor psi.Cursor) (gpt.CodeGeneratorRequest, error) {
 	stepRoot := cursor.Node()
 
 	todoComment, err := p.prepareObjective(p, scope)
 	if err != nil {
-		return nil, err
+		return gpt.CodeGeneratorRequest{}, err
 	}
 
 	prunedRoot := p.Root
 
 	rootStr, err := p.SourceFile.ToCode(prunedRoot)
 	if err != nil {
-		return nil, err
+		return gpt.CodeGeneratorRequest{}, err
 	}
 
 	stepStr, err := p.SourceFile.ToCode(stepRoot)
 	if err != nil {
-		return nil, err
+		return gpt.CodeGeneratorRequest{}, err
 	}
 
+	g := p.Project.Graph()
+
 	req := gpt.CodeGeneratorRequest{
 		Document:  rootStr,
 		Focus:     stepStr,
@@ -195,38 +290,39 @@ func (p *NodeProcessor) Step(ctx context.Context, scope *NodeScope, cursor psi.C
 		Language:  string(p.SourceFile.Language().Name()),
 		Context:   gpt.ContextBag{},
 
-		RetrieveContext: func(ctx context.Context, req gpt.CodeGeneratorRequest) (gpt.ContextBag, error) {
-			return p.prepareContext(p, scope, p.Root, req)
+		RetrieveContext: func(ctx context.Context, req gpt.CodeGeneratorRequest) (result gpt.ContextBag, err error) {
+			err = psi.UpdateGraph(g, func() (err error) {
+				result, err = p.prepareContext(p, scope, p.Root, req)
+
+				return
+			})
+
+			return
 		},
-	}
 
-	fullContext, err := p.prepareContext(p, scope, prunedRoot, req)
-	if err != nil {
-		return nil, err
+		MaxVerifyAttempts: p.maxVerifyAttempts,
 	}
 
-	req.Context = fullContext
-
-	cg := gpt.NewCodeGenerator()
-	res, err := cg.Generate(ctx, req)
+	if p.verifyCodeBlocks != nil {
+		req.Verify = func(ctx context.Context, req gpt.CodeGeneratorRequest, blocks []mdutils.CodeBlock) error {
+			return psi.UpdateGraph(g, func() error {
+				return p.verifyCodeBlocks(ctx, p, scope, blocks)
+			})
+		}
+	}
 
+	fullContext, err := p.prepareContext(p, scope, prunedRoot, req)
 	if err != nil {
-		return nil, err
+		return gpt.CodeGeneratorRequest{}, err
 	}
 
-	for i, block := range res.CodeBlocks {
-		block.Language = string(p.SourceFile.Language().Name())
-
-		lang := p.Project.LanguageProvider().Resolve(psi.LanguageID(block.Language))
-
-		blockName := fmt.Sprintf("_mergeContents_%d.%s", i, block.Language)
-
-		newRoot, err := lang.ParseCodeBlock(blockName, block)
+	req.Context = fullContext
 
-		if err != nil {
-			return nil, err
-		}
+	return req, nil
+}
 
+func (p *NodeProcessor) mergeCodeBlocks(ctx context.Context, scope *NodeScope, cursor psi.Cursor, newRoots []psi.SourceFile) error {
+	for _, newRoot := range newRoots {
 		// Printer go brrrrrrrrr
 		level := 0
 		_ = psi.Walk(newRoot, func(cursor psi.Cursor, entering bool) error {
@@ -243,12 +339,53 @@ func (p *NodeProcessor) Step(ctx context.Context, scope *NodeScope, cursor psi.C
 			return nil
 		})
 
-		err = p.SourceFile.MergeCompletionResults(ctx, scope, cursor, newRoot, newRoot.Root())
+		err := p.SourceFile.MergeCompletionResults(ctx, scope, cursor, newRoot, newRoot.Root())
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// parseCodeBlocks parses the generated code blocks with the language of the source file being processed.
+func (p *NodeProcessor) parseCodeBlocks(blocks []mdutils.CodeBlock) ([]psi.SourceFile, error) {
+	result := make([]psi.SourceFile, len(blocks))
+
+	for i, block := range blocks {
+		block.Language = string(p.SourceFile.Language().Name())
+
+		lang := p.Project.LanguageProvider().Resolve(psi.LanguageID(block.Language))
+
+		blockName := fmt.Sprintf("_mergeContents_%d.%s", i, block.Language)
+
+		newRoot, err := lang.ParseCodeBlock(blockName, block)
 
 		if err != nil {
 			return nil, err
 		}
+
+		result[i] = newRoot
+	}
+
+	return result, nil
+}
+
+// VerifyCodeBlocks checks that the generated code blocks parse with the language of the source file and,
+// if the source file implements psi.CompletionVerifier, that the file still compiles once they are merged.
+func VerifyCodeBlocks(ctx context.Context, p *NodeProcessor, scope *NodeScope, blocks []mdutils.CodeBlock) error {
+	newRoots, err := p.parseCodeBlocks(blocks)
+
+	if err != nil {
+		return err
+	}
+
+	verifier, ok := p.SourceFile.(psi.CompletionVerifier)
+
+	if !ok {
+		return nil
 	}
 
-	return
+	return verifier.VerifyCompletionResults(ctx, p.Root, newRoots, p.verifyOptions)
 }
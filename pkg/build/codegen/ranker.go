@@ -0,0 +1,325 @@
+package codegen
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/greenboxal/aip/aip-langchain/pkg/tokenizers"
+
+	"github.com/greenboxal/agibootstrap/pkg/gpt"
+	"github.com/greenboxal/agibootstrap/pkg/platform/db/fti"
+	"github.com/greenboxal/agibootstrap/pkg/platform/mdutils"
+	"github.com/greenboxal/agibootstrap/pkg/platform/project"
+	"github.com/greenboxal/agibootstrap/pkg/psi"
+)
+
+// DefaultContextTokenBudget is the default number of tokens of context retrieved for a code generation request.
+const DefaultContextTokenBudget = 2048
+
+// DefaultSimilarityWeight is the default weight of the FTI vector similarity in the ranking score.
+const DefaultSimilarityWeight = 0.3
+
+// ContextRanker ranks the declarations related to a focus node and fills a gpt.ContextBag
+// with the most important ones, up to a token budget.
+//
+// The importance of a declaration is its M_ImportanceScore, computed from the declaration
+// and reference distances between the focus node and the declaration (see psi.Retriever):
+//
+//	importance = 1 - norm(declarationDistance / maxDeclarationDistance, referenceDistance / maxReferenceDistance) / sqrt(2)
+//
+// It is blended with the vector similarity of the declaration file to the request, as found in
+// the FTI repository of the project:
+//
+//	score = (1 - SimilarityWeight) * importance + SimilarityWeight * similarity
+//
+// FTI chunks from files without any related declaration are ranked by their similarity alone,
+// so they only fill the budget left by declarations.
+type ContextRanker struct {
+	Project project.Project
+
+	// TokenBudget is the maximum number of tokens of code added to the context.
+	TokenBudget int
+	// MaxReferenceDistance is the number of reference edges followed from the focus node.
+	MaxReferenceDistance int
+	// SimilarityWeight is the weight of the FTI vector similarity, between 0 and 1.
+	SimilarityWeight float64
+	// MaxHits is the number of FTI hits retrieved per query.
+	MaxHits int
+}
+
+// NewContextRanker creates a ContextRanker for the given project with the default settings.
+func NewContextRanker(p project.Project) *ContextRanker {
+	return &ContextRanker{
+		Project:              p,
+		TokenBudget:          DefaultContextTokenBudget,
+		MaxReferenceDistance: 2,
+		SimilarityWeight:     DefaultSimilarityWeight,
+		MaxHits:              10,
+	}
+}
+
+// RankedContext is a candidate for the context of a code generation request.
+type RankedContext struct {
+	Key   string
+	Block mdutils.CodeBlock
+
+	// Node is the declaration, or nil for FTI chunks.
+	Node psi.Node
+
+	Importance float64
+	Similarity float64
+	Score      float64
+}
+
+// Rank returns the context candidates for focus, sorted by decreasing score.
+// Declarations in the same source file as focus are skipped, since the whole file is already
+// part of the request.
+func (r *ContextRanker) Rank(ctx context.Context, focus psi.Node, queries []string) ([]RankedContext, error) {
+	similarities, hits, err := r.querySimilarities(ctx, queries)
+
+	if err != nil {
+		return nil, err
+	}
+
+	it, err := psi.NewRetriever(psi.WithMaxReferenceDistance(r.MaxReferenceDistance)).Retrieve(focus)
+
+	if err != nil {
+		return nil, err
+	}
+
+	focusFile := sourceFileOf(focus)
+
+	var refs []psi.Reference
+
+	maxDeclarationDistance := 1
+
+	for it.Next() {
+		ref := it.Reference()
+
+		if sourceFileOf(ref.Referee) == focusFile {
+			continue
+		}
+
+		if ref.DeclarationDistance > maxDeclarationDistance {
+			maxDeclarationDistance = ref.DeclarationDistance
+		}
+
+		refs = append(refs, ref)
+	}
+
+	maxReferenceDistance := r.MaxReferenceDistance
+
+	if maxReferenceDistance < 1 {
+		maxReferenceDistance = 1
+	}
+
+	byNode := map[psi.Node]*RankedContext{}
+	files := map[string]bool{}
+
+	for _, ref := range refs {
+		declarationDistance := ref.DeclarationDistance
+
+		if declarationDistance < 0 {
+			declarationDistance = maxDeclarationDistance
+		}
+
+		importance := ImportanceScore(
+			float64(declarationDistance)/float64(maxDeclarationDistance),
+			float64(ref.ReferenceDistance)/float64(maxReferenceDistance),
+		)
+
+		candidate := byNode[ref.Referee]
+
+		if candidate == nil {
+			sf := sourceFileOf(ref.Referee)
+
+			if sf == nil {
+				continue
+			}
+
+			path := r.relativePath(sf.Name())
+
+			// Unnamed declarations are told apart by their path inside the file, so they don't
+			// shadow each other in the context bag.
+			name := psi.NodeTypeName(ref.Referee)
+
+			if rel, err := ref.Referee.CanonicalPath().RelativeTo(sf.CanonicalPath()); err == nil {
+				name = fmt.Sprintf("%s %s", name, rel)
+			}
+
+			candidate = &RankedContext{
+				Key:        fmt.Sprintf("for reference only, do not copy: %s @ %s", path, name),
+				Node:       ref.Referee,
+				Importance: importance,
+				Similarity: similarities[path],
+			}
+
+			if named, ok := ref.Referee.(psi.NamedNode); ok && named.PsiNodeName() != "" {
+				candidate.Key = fmt.Sprintf("for reference only, do not copy: %s @ %s", path, named.PsiNodeName())
+			}
+
+			byNode[ref.Referee] = candidate
+			files[path] = true
+		} else if importance > candidate.Importance {
+			candidate.Importance = importance
+		}
+	}
+
+	result := make([]RankedContext, 0, len(byNode)+len(hits))
+
+	for _, candidate := range byNode {
+		block, err := sourceFileOf(candidate.Node).ToCode(candidate.Node)
+
+		if err != nil {
+			continue
+		}
+
+		block.Filename = r.relativePath(sourceFileOf(candidate.Node).Name())
+
+		candidate.Block = block
+		candidate.Score = (1-r.SimilarityWeight)*candidate.Importance + r.SimilarityWeight*candidate.Similarity
+
+		result = append(result, *candidate)
+	}
+
+	for _, hit := range hits {
+		path := hit.Entry.Document.Path
+
+		if files[path] || focusFile != nil && r.relativePath(focusFile.Name()) == path {
+			continue
+		}
+
+		similarity := clampSimilarity(hit.Distance)
+
+		result = append(result, RankedContext{
+			Key: fmt.Sprintf("for reference only, do not copy: %s @ %d", path, hit.Entry.Chunk.Index),
+			Block: mdutils.CodeBlock{
+				Filename: path,
+				Code:     hit.Entry.Chunk.Content,
+			},
+			Similarity: similarity,
+			Score:      r.SimilarityWeight * similarity,
+		})
+	}
+
+	sort.SliceStable(result, func(i, j int) bool {
+		if result[i].Score != result[j].Score {
+			return result[i].Score > result[j].Score
+		}
+
+		return result[i].Key < result[j].Key
+	})
+
+	return result, nil
+}
+
+// Fill ranks the context candidates for focus and returns the best ones that fit in the token budget.
+func (r *ContextRanker) Fill(ctx context.Context, focus psi.Node, queries []string) (gpt.ContextBag, error) {
+	ranked, err := r.Rank(ctx, focus, queries)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var tokenizer tokenizers.BasicTokenizer
+
+	if provider := r.Project.ModelProvider(); provider != nil {
+		tokenizer = provider.Tokenizer()
+	}
+
+	result := gpt.ContextBag{}
+	remaining := r.TokenBudget
+
+	for _, candidate := range ranked {
+		if _, ok := result[candidate.Key]; ok {
+			continue
+		}
+
+		count, err := countTokens(tokenizer, candidate.Block.Code)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if count > remaining {
+			continue
+		}
+
+		remaining -= count
+		result[candidate.Key] = candidate.Block
+	}
+
+	return result, nil
+}
+
+// querySimilarities queries the FTI repository and returns the best similarity found for each file,
+// along with the hits.
+func (r *ContextRanker) querySimilarities(ctx context.Context, queries []string) (map[string]float64, []fti.OnlineIndexQueryHit, error) {
+	similarities := map[string]float64{}
+
+	var hits []fti.OnlineIndexQueryHit
+
+	for _, query := range queries {
+		if strings.TrimSpace(query) == "" {
+			continue
+		}
+
+		queryHits, err := r.Project.Repo().Query(ctx, query, int64(r.MaxHits))
+
+		if err != nil {
+			return nil, nil, err
+		}
+
+		for _, hit := range queryHits {
+			path := hit.Entry.Document.Path
+
+			if s := clampSimilarity(hit.Distance); s > similarities[path] {
+				similarities[path] = s
+			}
+		}
+
+		hits = append(hits, queryHits...)
+	}
+
+	return similarities, hits, nil
+}
+
+func (r *ContextRanker) relativePath(name string) string {
+	return strings.TrimPrefix(name, r.Project.RootPath()+"/")
+}
+
+// ImportanceScore computes M_ImportanceScore from the normalized declaration and reference distances:
+// one minus the norm of the importance distance vector, scaled to [0, 1].
+func ImportanceScore(declarationDistance, referenceDistance float64) float64 {
+	score := 1 - math.Hypot(declarationDistance, referenceDistance)/math.Sqrt2
+
+	return math.Max(0, math.Min(1, score))
+}
+
+// clampSimilarity maps an inner product distance between normalized embeddings to [0, 1].
+func clampSimilarity(distance float32) float64 {
+	return math.Max(0, math.Min(1, float64(distance)))
+}
+
+// sourceFileOf returns the source file containing n.
+func sourceFileOf(n psi.Node) psi.SourceFile {
+	for ; n != nil; n = n.Parent() {
+		if sf, ok := n.(psi.SourceFile); ok {
+			return sf
+		}
+	}
+
+	return nil
+}
+
+// countTokens counts the tokens of str, estimating four characters per token without a tokenizer.
+func countTokens(tokenizer tokenizers.BasicTokenizer, str string) (int, error) {
+	if tokenizer == nil {
+		return (len(str) + 3) / 4, nil
+	}
+
+	return tokenizer.Count(str)
+}
@@ -0,0 +1,158 @@
+package codegen
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/dave/dst"
+	"github.com/stretchr/testify/require"
+
+	"github.com/greenboxal/agibootstrap/pkg/psi"
+	"github.com/greenboxal/agibootstrap/pkg/psi/langs/golang"
+)
+
+var testRankerFixture = map[string]string{
+	"go.mod": "module example.com/app\n\ngo 1.20\n",
+
+	"main.go": `package main
+
+import "example.com/app/text"
+
+func main() {
+	println(text.Shout("hi"))
+}
+`,
+
+	"text/text.go": `package text
+
+import "strings"
+
+func Shout(s string) string {
+	return exclaim(strings.ToUpper(s))
+}
+
+func exclaim(s string) string {
+	return s + "!"
+}
+
+func Unused() {}
+`,
+}
+
+// findFuncDecl returns the declaration of the function name in root, in depth-first order.
+func findFuncDecl(root psi.Node, name string) psi.Node {
+	var result psi.Node
+
+	_ = psi.Walk(root, func(cursor psi.Cursor, entering bool) error {
+		if !entering || result != nil {
+			return nil
+		}
+
+		if gn, ok := cursor.Node().(golang.Node); ok {
+			if d, ok := gn.Ast().(*dst.FuncDecl); ok && d.Name.Name == name {
+				result = gn
+				return nil
+			}
+		}
+
+		cursor.WalkChildren()
+
+		return nil
+	})
+
+	return result
+}
+
+func TestContextRanker(t *testing.T) {
+	p := openTestProject(t, testRankerFixture)
+	root := p.RootPath()
+	ctx := context.Background()
+
+	var mainFile, textFile psi.SourceFile
+
+	err := psi.UpdateGraph(p.Graph(), func() (err error) {
+		if err := p.LoadSourceFiles(); err != nil {
+			return err
+		}
+
+		if err := p.ResolveReferences(ctx); err != nil {
+			return err
+		}
+
+		if mainFile, err = p.GetSourceFile(filepath.Join(root, "main.go")); err != nil {
+			return err
+		}
+
+		textFile, err = p.GetSourceFile(filepath.Join(root, "text", "text.go"))
+
+		return err
+	})
+	require.NoError(t, err)
+
+	focus := findFuncDecl(mainFile.Root(), "main")
+	shout := findFuncDecl(textFile.Root(), "Shout")
+	exclaim := findFuncDecl(textFile.Root(), "exclaim")
+	require.NotNil(t, focus)
+	require.NotNil(t, shout)
+	require.NotNil(t, exclaim)
+
+	ranker := NewContextRanker(p)
+	ranker.SimilarityWeight = 0
+
+	ranked, err := ranker.Rank(ctx, focus, nil)
+	require.NoError(t, err)
+
+	// Shout is referenced by main and exclaim by Shout. Unused is never reached, and the
+	// declarations of main.go are already part of the request.
+	require.Len(t, ranked, 2)
+
+	// exclaim is one reference further away, but it is declared right next to its call site,
+	// while Shout is in another package.
+	require.Same(t, exclaim, ranked[0].Node)
+	require.Same(t, shout, ranked[1].Node)
+	require.Greater(t, ranked[0].Score, ranked[1].Score)
+	require.NotEqual(t, ranked[0].Key, ranked[1].Key)
+
+	for _, r := range ranked {
+		require.Equal(t, r.Importance, r.Score)
+		require.Equal(t, "text/text.go", r.Block.Filename)
+	}
+
+	require.Contains(t, ranked[0].Block.Code, "func exclaim(")
+	require.Contains(t, ranked[1].Block.Code, "func Shout(")
+
+	tokenizer := p.ModelProvider().Tokenizer()
+
+	exclaimTokens, err := countTokens(tokenizer, ranked[0].Block.Code)
+	require.NoError(t, err)
+	shoutTokens, err := countTokens(tokenizer, ranked[1].Block.Code)
+	require.NoError(t, err)
+
+	for _, tc := range []struct {
+		budget   int
+		expected []int
+	}{
+		{budget: 0},
+		{budget: exclaimTokens, expected: []int{0}},
+		{budget: exclaimTokens + shoutTokens - 1, expected: []int{0}},
+		{budget: exclaimTokens + shoutTokens, expected: []int{0, 1}},
+	} {
+		ranker.TokenBudget = tc.budget
+
+		bag, err := ranker.Fill(ctx, focus, nil)
+		require.NoError(t, err)
+		require.Len(t, bag, len(tc.expected), "budget %d", tc.budget)
+
+		for _, i := range tc.expected {
+			require.Equal(t, ranked[i].Block, bag[ranked[i].Key], "budget %d", tc.budget)
+		}
+	}
+}
+
+func TestImportanceScore(t *testing.T) {
+	require.Equal(t, 1.0, ImportanceScore(0, 0))
+	require.InDelta(t, 0.0, ImportanceScore(1, 1), 1e-9)
+	require.Greater(t, ImportanceScore(0.5, 0), ImportanceScore(0.5, 0.5))
+	require.Greater(t, ImportanceScore(0, 0.5), ImportanceScore(0.5, 0.5))
+}
@@ -2,151 +2,181 @@ package compile
 
 import (
 	"context"
-	"fmt"
-	"go/build"
-	"go/token"
-	"go/types"
-	"path"
-
-	"github.com/hashicorp/go-multierror"
-	"golang.org/x/tools/go/loader"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
 	"golang.org/x/tools/go/packages"
 
-	"github.com/greenboxal/agibootstrap/pkg/codex"
+	"github.com/greenboxal/agibootstrap/pkg/build"
+	"github.com/greenboxal/agibootstrap/pkg/platform/vfs/repofs"
 	"github.com/greenboxal/agibootstrap/pkg/psi"
 )
 
-type BuildError struct {
-	Pkg      string
-	Filename string
-	Line     int
-	Column   int
-	Error    error
-}
+// DiagnosticSource is the source of the diagnostics published by BuildStep.
+const DiagnosticSource = "compile"
 
-// Implement the String() method for BuildError
-func (be BuildError) String() string {
-	return fmt.Sprintf("Package: %s, File: %s, Line: %d, Column: %d, Error: %s", be.Pkg, be.Filename, be.Line, be.Column, be.Error.Error())
-}
+// BuildTags are the build tags the project is compiled with.
+var BuildTags = []string{"selfwip", "psionly"}
+
+// BuildStep compiles the Go packages of the project and publishes their errors as diagnostics on the
+// source files they were found in, replacing the diagnostics of the previous compilation. It makes
+// no changes: the errors are fixed by codegen.BuildStep when build.Configuration.FixDiagnostics is set.
+type BuildStep struct{}
+
+func (s *BuildStep) Process(ctx context.Context, bctx *build.Context) (result build.StepResult, err error) {
+	cfg := &packages.Config{
+		Context:    ctx,
+		Mode:       packages.NeedName | packages.NeedFiles | packages.NeedSyntax | packages.NeedTypes,
+		Dir:        bctx.Project().RootPath(),
+		BuildFlags: []string{"-tags=" + strings.Join(BuildTags, ",")},
+		Tests:      true,
+	}
 
-// FixBuildStep is responsible for fixing all build errors that were found
-type FixBuildStep struct{}
+	// Compile the files as they are in the overlay, when the build doesn't write to the working tree.
+	if overlay, ok := bctx.Project().FS().(*repofs.OverlayFS); ok {
+		if cfg.Overlay, err = overlayFiles(overlay); err != nil {
+			return result, err
+		}
+	}
 
-func (s *FixBuildStep) Process(ctx context.Context, p *codex.Project) (result codex.BuildStepResult, err error) {
-	buildErrors, err := p.buildProject()
+	pkgs, err := packages.Load(cfg, "./...")
 
 	if err != nil {
 		return result, err
 	}
 
-	for _, buildError := range buildErrors {
-		sf, e := p.GetSourceFile(buildError.Filename)
+	byFile := map[string][]*psi.Diagnostic{}
+	// With tests, the files of a package are compiled twice and report the same errors.
+	seen := map[string]bool{}
 
-		if e != nil {
-			err = multierror.Append(err, e)
-			continue
+	for _, pkg := range pkgs {
+		for _, file := range pkg.GoFiles {
+			if _, ok := byFile[file]; !ok {
+				byFile[file] = nil
+			}
 		}
 
-		e = s.ProcessFix(ctx, p, sf, buildError)
+		for _, pkgErr := range pkg.Errors {
+			if seen[pkgErr.Error()] {
+				continue
+			}
 
-		if err != nil {
-			err = multierror.Append(err, e)
+			seen[pkgErr.Error()] = true
+
+			file, line, column := parseErrorPosition(pkgErr.Pos)
+
+			if file == "" {
+				bctx.ReportError(errors.New(pkgErr.Error()))
+				continue
+			}
+
+			byFile[file] = append(byFile[file], psi.NewDiagnostic(
+				DiagnosticSource,
+				psi.SeverityError,
+				psi.SourceRange{File: file, Start: psi.SourcePosition{Line: line, Column: column}},
+				pkgErr.Msg,
+			))
 		}
 	}
 
-	return result, nil
+	err = psi.UpdateGraph(bctx.Project().Graph(), func() error {
+		publishDiagnostics(bctx, byFile)
+
+		return nil
+	})
+
+	return result, err
 }
 
-// ProcessFix applies a fix to a build error. It takes in a psi.SourceFile pointer and a BuildError pointer and returns an error.
-// The function sets the 'prepareObjective' field of the NodeProcessor passed into the p.ProcessNodes function to a function that returns a string that includes the build error message.
-// The 'prepareObjective' function is responsible for generating a string that describes what needs to be done to fix a build error.
-// The expected input parameters are the psi.SourceFile 'sf' and pointer to the BuildError 'buildError' that needs to be fixed.
-// The expected output parameter is an error, which is nil if the process finishes successfully.
-func (s *FixBuildStep) ProcessFix(ctx context.Context, p *codex.Project, sf psi.SourceFile, buildError *BuildError) error {
-	fmt.Printf("Fixing build error: %s\n", buildError.String())
-
-	updated, err := p.ProcessNodes(ctx, sf, func(p *NodeProcessor) {
-		p.prepareObjective = func(p *NodeProcessor, ctx *NodeScope) (string, error) {
-			return "Fix the following build error:\n\n# Error Log\n```log\n" + buildError.String() + "```", nil
+// publishDiagnostics publishes the diagnostics of each compiled file on its source file.
+func publishDiagnostics(bctx *build.Context, byFile map[string][]*psi.Diagnostic) {
+	for file, diagnostics := range byFile {
+		sf, err := bctx.Project().GetSourceFile(file)
+
+		if err != nil {
+			// Generated and ignored files are compiled but are not part of the project tree.
+			continue
 		}
 
-		p.checkShouldProcess = func(fn *NodeScope, cursor psi.Cursor) bool {
-			return true
+		text := sf.OriginalText()
+
+		for _, d := range diagnostics {
+			d.Range.Start.Offset = lineColumnOffset(text, d.Range.Start.Line, d.Range.Start.Column)
+			d.Range.End = d.Range.Start
 		}
-	})
 
-	if err != nil {
-		return err
+		psi.PublishDiagnostics(sf, DiagnosticSource, diagnostics)
 	}
+}
+
+// overlayFiles returns the contents of the files modified in overlay, by absolute path, as expected
+// by packages.Config.Overlay.
+func overlayFiles(overlay *repofs.OverlayFS) (map[string][]byte, error) {
+	changes, err := overlay.Changes()
 
-	// Convert the AST back to code
-	newCode, err := sf.ToCode(updated)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	return sf.Replace(newCode.Code)
+	files := make(map[string][]byte, len(changes))
+
+	for _, change := range changes {
+		files[filepath.Join(overlay.Path(), filepath.FromSlash(change.Path))] = change.Modified
+	}
+
+	return files, nil
 }
 
-// buildProject is responsible for analyzing the project and checking its types.
-// It returns a slice of BuildError and an error. BuildError contains information about type-checking errors and their associated package name, filename, line, column and error.
-func (p *codex.Project) buildProject() (errs []*BuildError, err error) {
-	// Set up the build context
-	buildContext := build.Default
-	buildContext.Dir = p.rootPath
-	buildContext.BuildTags = []string{"selfwip", "psionly"}
+// parseErrorPosition parses the position of a packages.Error, formatted as file:line:column or
+// file:line. Errors with no position, like "-", return an empty file.
+func parseErrorPosition(pos string) (file string, line, column int) {
+	parts := strings.Split(pos, ":")
+
+	for i := 0; i < 2 && len(parts) > 1; i++ {
+		n, err := strconv.Atoi(parts[len(parts)-1])
 
-	fset := token.NewFileSet()
+		if err != nil {
+			break
+		}
 
-	pkgConfig := &packages.Config{
-		Mode: packages.NeedName | packages.NeedFiles | packages.NeedTypes | packages.NeedSyntax | packages.NeedImports,
-		Dir:  p.rootPath,
-		Fset: fset,
+		column, line = line, n
+		parts = parts[:len(parts)-1]
 	}
 
-	// Get all packages in the project
-	pkgs, err := packages.Load(pkgConfig, path.Join(p.rootPath, "..."))
+	file = strings.Join(parts, ":")
 
-	if err != nil {
-		return errs, err
+	if line == 0 || file == "-" {
+		return "", 0, 0
 	}
 
-	lconf := loader.Config{
-		Build:       &buildContext,
-		Cwd:         p.rootPath,
-		ImportPkgs:  map[string]bool{},
-		AllowErrors: true,
+	if column == 0 {
+		column = 1
 	}
 
-	for _, pkg := range pkgs {
-		lconf.Import(pkg.PkgPath)
-	}
+	return file, line, column
+}
 
-	pro, err := lconf.Load()
+// lineColumnOffset returns the byte offset of a line and column of text, both starting at 1.
+func lineColumnOffset(text string, line, column int) int {
+	offset := 0
 
-	if err != nil {
-		return errs, err
-	}
+	for i := 1; i < line; i++ {
+		next := strings.IndexByte(text[offset:], '\n')
 
-	// Iterate through every Go package in the project
-	for _, pkg := range pro.Imported {
-		fmt.Printf("Checking package %s\n", pkg.Pkg.Name())
+		if next == -1 {
+			return len(text)
+		}
 
-		for _, err := range pkg.Errors {
-			buildError := &BuildError{
-				Pkg:   pkg.Pkg.Path(),
-				Error: err,
-			}
+		offset += next + 1
+	}
 
-			if err, ok := err.(types.Error); ok {
-				buildError.Filename = err.Fset.File(err.Pos).Name()
-				buildError.Line = err.Fset.File(err.Pos).Line(err.Pos)
-				buildError.Column = err.Fset.File(err.Pos).Offset(err.Pos)
-			}
+	offset += column - 1
 
-			errs = append(errs, buildError)
-		}
+	if offset > len(text) {
+		offset = len(text)
 	}
 
-	return errs, nil
+	return offset
 }
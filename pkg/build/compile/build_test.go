@@ -0,0 +1,56 @@
+package compile
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/greenboxal/agibootstrap/pkg/build"
+	"github.com/greenboxal/agibootstrap/pkg/codex"
+	"github.com/greenboxal/agibootstrap/pkg/platform/vfs/repofs"
+	_ "github.com/greenboxal/agibootstrap/pkg/psi/langs/golang"
+)
+
+const testMainCode = `package main
+
+func main() {
+	println("hello")
+}
+`
+
+func TestBuildStepCompilesOverlay(t *testing.T) {
+	root := t.TempDir()
+
+	require.NoError(t, os.WriteFile(filepath.Join(root, "go.mod"), []byte("module example.com/app\n\ngo 1.20\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "main.go"), []byte(testMainCode), 0644))
+
+	p, err := codex.NewProject(context.Background(), root, codex.WithOverlayFS())
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, p.Close()) })
+
+	// The error is only in the overlay, the working tree compiles.
+	overlay := p.FS().(*repofs.OverlayFS)
+	require.NoError(t, overlay.WriteFile("main.go", []byte("package main\n\nfunc main() {\n\tprintln(missing)\n}\n")))
+
+	result, err := build.NewBuilder(p, build.Configuration{
+		OutputDirectory: root,
+		BuildDirectory:  t.TempDir(),
+		BuildSteps:      []build.Step{&BuildStep{}},
+		MaxEpochs:       1,
+		DryRun:          true,
+	}).Build(context.Background())
+	require.NoError(t, err)
+
+	require.Len(t, result.Diagnostics, 1)
+	require.Equal(t, DiagnosticSource, result.Diagnostics[0].Source)
+	require.Equal(t, filepath.Join(root, "main.go"), result.Diagnostics[0].Range.File)
+	require.Equal(t, 4, result.Diagnostics[0].Range.Start.Line)
+	require.Contains(t, result.Diagnostics[0].Message, "undefined: missing")
+
+	code, err := os.ReadFile(filepath.Join(root, "main.go"))
+	require.NoError(t, err)
+	require.Equal(t, testMainCode, string(code))
+}
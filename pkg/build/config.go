@@ -14,6 +14,36 @@ type Configuration struct {
 
 	MaxSteps  int
 	MaxEpochs int
+
+	MaxVerifyAttempts int
+	VerifyTests       bool
+
+	// ContextTokenBudget bounds the tokens of related code retrieved for each code generation request.
+	// Defaults to codegen.DefaultContextTokenBudget.
+	ContextTokenBudget int
+
+	// Scope restricts the build to part of the project.
+	Scope Scope
+	// FixDiagnostics also processes the scopes with error diagnostics, such as the ones published by
+	// compile.BuildStep, and asks for them to be fixed in the objective of the code generation requests.
+	FixDiagnostics bool
+	// ListTodos lists the TODOs in scope instead of processing them. It should be combined with DryRun.
+	ListTodos bool
+
+	// MergeConflictMarkers keeps both sides of the conflicts between generated code and changes made
+	// to a file during the build, delimited by conflict marker comments. By default, the changes made
+	// to the file are kept and the conflicts are only reported.
+	MergeConflictMarkers bool
+
+	// DiffEpochs freezes the project tree around each epoch and reports its structural changes
+	// in Result.StructuralChanges.
+	DiffEpochs bool
+
+	// DryRun skips staging, committing and pushing the changes.
+	// The project file system must be a repofs.OverlayFS so file writes stay in memory.
+	// Projects opened with codex.WithOverlayFS also keep their datastore in memory, so a
+	// dry run leaves nothing behind in the repository besides the build directory.
+	DryRun bool
 }
 
 func (bd *Configuration) GetOutputPath(p ...string) string {
@@ -4,10 +4,16 @@ import (
 	"context"
 	"fmt"
 
+	"github.com/pkg/errors"
+
 	"github.com/greenboxal/agibootstrap/pkg/platform/project"
 	"github.com/greenboxal/agibootstrap/pkg/platform/tasks"
+	"github.com/greenboxal/agibootstrap/pkg/platform/vfs/repofs"
+	"github.com/greenboxal/agibootstrap/pkg/psi"
 )
 
+var ErrDryRunRequiresOverlay = errors.New("dry run requires an overlay file system")
+
 type Context struct {
 	builder *Builder
 
@@ -19,6 +25,8 @@ type Context struct {
 	totalSteps   int
 	totalChanges int
 
+	structuralChanges [][]psi.NodeChange
+
 	errors []error
 }
 
@@ -39,16 +47,31 @@ func (bctx *Context) Build(ctx context.Context) (*Result, error) {
 		bctx.ReportError(err)
 	}
 
+	diagnostics, err := bctx.collectDiagnostics()
+
+	if err != nil {
+		bctx.ReportError(err)
+	}
+
 	// Return the total number of totalChanges and no error
 	return &Result{
 		Errors:      bctx.errors,
+		Diagnostics: diagnostics,
 		ChangeCount: bctx.totalChanges,
 		TotalEpochs: bctx.totalEpochs,
 		TotalSteps:  bctx.totalSteps,
+
+		StructuralChanges: bctx.structuralChanges,
 	}, nil
 }
 
 func (bctx *Context) runBuild(ctx context.Context) error {
+	if bctx.cfg.DryRun {
+		if _, ok := bctx.project.FS().(*repofs.OverlayFS); !ok {
+			return ErrDryRunRequiresOverlay
+		}
+	}
+
 	// Execute the build steps until no further totalChanges are made
 	for ; bctx.cfg.MaxEpochs == 0 || bctx.totalEpochs < bctx.cfg.MaxEpochs; bctx.totalEpochs++ {
 		stepChanges := 0
@@ -58,6 +81,18 @@ func (bctx *Context) runBuild(ctx context.Context) error {
 			return err
 		}
 
+		var before psi.Snapshot
+
+		if bctx.cfg.DiffEpochs {
+			snapshot, err := bctx.freezeProject()
+
+			if err != nil {
+				return err
+			}
+
+			before = snapshot
+		}
+
 		// Execute each build step
 		for _, step := range bctx.cfg.BuildSteps {
 			if bctx.cfg.MaxSteps != 0 && bctx.totalSteps >= bctx.cfg.MaxSteps {
@@ -78,7 +113,6 @@ func (bctx *Context) runBuild(ctx context.Context) error {
 					}
 				}()
 
-				// Execute the build step and return the result
 				result, err = step.Process(ctx, bctx)
 
 				return
@@ -93,14 +127,32 @@ func (bctx *Context) runBuild(ctx context.Context) error {
 			bctx.totalSteps++
 		}
 
-		// Stage all the totalChanges in the file system
-		if err := bctx.project.FS().StageAll(); err != nil {
-			return err
+		if bctx.cfg.DiffEpochs {
+			after, err := bctx.freezeProject()
+
+			if err != nil {
+				return err
+			}
+
+			changes, err := psi.Diff(before, after)
+
+			if err != nil {
+				return err
+			}
+
+			bctx.structuralChanges = append(bctx.structuralChanges, changes)
 		}
 
-		// Commit the totalChanges to the file system
-		if err := bctx.project.Commit(); err != nil {
-			return err
+		if !bctx.cfg.DryRun {
+			// Stage all the totalChanges in the file system
+			if err := bctx.project.FS().StageAll(); err != nil {
+				return err
+			}
+
+			// Commit the totalChanges to the file system
+			if err := bctx.project.Commit(); err != nil {
+				return err
+			}
 		}
 
 		// If no totalChanges were made, exit the loop
@@ -116,6 +168,10 @@ func (bctx *Context) runBuild(ctx context.Context) error {
 		}
 	}
 
+	if bctx.cfg.DryRun {
+		return nil
+	}
+
 	// Push the totalChanges to the remote repository
 	if err := bctx.project.FS().Push(); err != nil {
 		return err
@@ -124,6 +180,28 @@ func (bctx *Context) runBuild(ctx context.Context) error {
 	return nil
 }
 
+// freezeProject freezes the project tree with the read lock of the project graph held.
+func (bctx *Context) freezeProject() (snapshot psi.Snapshot, err error) {
+	err = psi.ReadGraph(bctx.project.Graph(), func() (err error) {
+		snapshot, err = psi.Freeze(bctx.project.RootNode())
+
+		return
+	})
+
+	return
+}
+
+// collectDiagnostics collects the diagnostics of the project tree with the read lock of the project graph held.
+func (bctx *Context) collectDiagnostics() (diagnostics []*psi.Diagnostic, err error) {
+	err = psi.ReadGraph(bctx.project.Graph(), func() error {
+		diagnostics = psi.CollectDiagnostics(bctx.project.RootNode())
+
+		return nil
+	})
+
+	return
+}
+
 func (bctx *Context) Close() {
 	if bctx.log != nil {
 		_ = bctx.log.Close()
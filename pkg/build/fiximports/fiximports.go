@@ -15,7 +15,15 @@ import (
 type BuildStep struct{}
 
 func (s *BuildStep) Process(ctx context.Context, bctx *build.Context) (result build.StepResult, err error) {
-	err = psi.Walk(bctx.Project(), func(cursor psi.Cursor, entering bool) error {
+	err = psi.UpdateGraph(bctx.Project().Graph(), func() error {
+		return s.fixImports(bctx, &result)
+	})
+
+	return result, nil
+}
+
+func (s *BuildStep) fixImports(bctx *build.Context, result *build.StepResult) error {
+	return psi.Walk(bctx.Project(), func(cursor psi.Cursor, entering bool) error {
 		n := cursor.Node()
 
 		cursor.SkipChildren()
@@ -74,6 +82,4 @@ func (s *BuildStep) Process(ctx context.Context, bctx *build.Context) (result bu
 
 		return nil
 	})
-
-	return result, nil
 }
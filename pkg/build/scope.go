@@ -0,0 +1,206 @@
+package build
+
+import (
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/tools/go/packages"
+
+	"github.com/greenboxal/agibootstrap/pkg/psi"
+)
+
+// Scope restricts a build to part of the project.
+// A file is in scope if it matches any of the globs, belongs to any of the packages,
+// or contains any of the PSI paths. An empty scope covers the whole project.
+// Files matching a glob or a package are in scope as a whole; in files that only contain
+// PSI paths, only the nodes under those paths are in scope.
+type Scope struct {
+	// Globs are slash separated patterns relative to the project root. "**" matches any number of directories.
+	Globs []string
+	// Packages are Go package patterns, such as import paths or "./pkg/...", resolved from the project root.
+	Packages []string
+	// Paths are PSI paths relative to the project source root, such as /pkg/gpt/codegen.go//@3.
+	Paths []psi.Path
+
+	packageFiles map[string]bool
+}
+
+func (s *Scope) IsEmpty() bool {
+	return len(s.Globs) == 0 && len(s.Packages) == 0 && len(s.Paths) == 0
+}
+
+// Resolve expands the package patterns into the set of files they contain.
+func (s *Scope) Resolve(rootPath string) error {
+	s.packageFiles = map[string]bool{}
+
+	if len(s.Packages) == 0 {
+		return nil
+	}
+
+	pkgs, err := packages.Load(&packages.Config{
+		Mode:  packages.NeedName | packages.NeedFiles,
+		Dir:   rootPath,
+		Tests: true,
+	}, s.Packages...)
+
+	if err != nil {
+		return errors.Wrap(err, "failed to resolve packages")
+	}
+
+	for _, pkg := range pkgs {
+		for _, e := range pkg.Errors {
+			return errors.Errorf("failed to resolve package %s: %s", pkg.PkgPath, e.Msg)
+		}
+
+		for _, f := range pkg.GoFiles {
+			s.packageFiles[f] = true
+		}
+	}
+
+	return nil
+}
+
+// MatchFile returns true if the file at filePath, inside the project at rootPath, is in scope.
+func (s *Scope) MatchFile(rootPath string, filePath string) bool {
+	if s.MatchWholeFile(rootPath, filePath) {
+		return true
+	}
+
+	filePsiPath, ok := s.filePsiPath(rootPath, filePath)
+
+	if !ok {
+		return false
+	}
+
+	for _, p := range s.Paths {
+		if p.HasPrefix(filePsiPath) || filePsiPath.HasPrefix(p) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// MatchWholeFile returns true if every node of the file at filePath is in scope, either because the
+// scope is empty or because the file matches a glob or a package. Files that only match through
+// PSI paths are partially in scope, and their nodes must be filtered with MatchNode.
+func (s *Scope) MatchWholeFile(rootPath string, filePath string) bool {
+	if s.IsEmpty() {
+		return true
+	}
+
+	if s.packageFiles[filePath] {
+		return true
+	}
+
+	relPath, err := filepath.Rel(rootPath, filePath)
+
+	if err != nil {
+		return false
+	}
+
+	relPath = filepath.ToSlash(relPath)
+
+	for _, g := range s.Globs {
+		if matchGlob(strings.TrimPrefix(g, "/"), relPath) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// MatchNode returns true if the node at the given path, relative to the project source root, is under
+// one of the PSI paths. It only applies to files that matched through a PSI path: nodes of a file for
+// which MatchWholeFile returns true are all in scope.
+func (s *Scope) MatchNode(p psi.Path) bool {
+	if len(s.Paths) == 0 {
+		return true
+	}
+
+	for _, prefix := range s.Paths {
+		if p.HasPrefix(prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (s *Scope) filePsiPath(rootPath string, filePath string) (psi.Path, bool) {
+	relPath, err := filepath.Rel(rootPath, filePath)
+
+	if err != nil {
+		return psi.Path{}, false
+	}
+
+	filePsiPath := psi.PathFromComponents()
+
+	for _, name := range strings.Split(filepath.ToSlash(relPath), "/") {
+		filePsiPath = filePsiPath.Child(psi.PathElement{Kind: psi.EdgeKindChild, Name: name})
+	}
+
+	return filePsiPath, true
+}
+
+// PathFromRoot computes the path of n relative to root, following the same naming rules as psi canonical paths.
+// Unlike psi.Node.CanonicalPath, it does not depend on the node having been updated.
+func PathFromRoot(root psi.Node, n psi.Node) psi.Path {
+	var components []psi.PathElement
+
+	for n != nil && n != root {
+		parent := n.Parent()
+
+		if parent == nil {
+			break
+		}
+
+		el := psi.PathElement{Kind: psi.EdgeKindChild}
+
+		if named, ok := n.(psi.NamedNode); ok {
+			el.Name = named.PsiNodeName()
+		} else {
+			el.Index = int64(parent.PsiNodeBase().IndexOfChild(n))
+			el.HasIndex = true
+		}
+
+		components = append([]psi.PathElement{el}, components...)
+		n = parent
+	}
+
+	return psi.PathFromComponents(components...)
+}
+
+// matchGlob matches a slash separated name against pattern, where "**" matches zero or more path segments.
+func matchGlob(pattern string, name string) bool {
+	return matchGlobSegments(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+func matchGlobSegments(pattern []string, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if matchGlobSegments(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+
+			return false
+		}
+
+		if len(name) == 0 {
+			return false
+		}
+
+		if ok, err := path.Match(pattern[0], name[0]); err != nil || !ok {
+			return false
+		}
+
+		pattern = pattern[1:]
+		name = name[1:]
+	}
+
+	return len(name) == 0
+}
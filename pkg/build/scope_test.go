@@ -0,0 +1,43 @@
+package build
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/greenboxal/agibootstrap/pkg/psi"
+)
+
+func mustParsePath(t *testing.T, s string) psi.Path {
+	p, err := psi.ParsePath(s)
+	require.NoError(t, err)
+
+	return p
+}
+
+func TestScopeMatch(t *testing.T) {
+	root := "/repo"
+
+	s := Scope{
+		Globs: []string{"cmd/**/*.go"},
+		Paths: []psi.Path{mustParsePath(t, "/pkg/a.go/@1")},
+	}
+
+	require.NoError(t, s.Resolve(root))
+
+	require.True(t, s.MatchFile(root, "/repo/cmd/agib/main.go"))
+	require.True(t, s.MatchFile(root, "/repo/pkg/a.go"))
+	require.False(t, s.MatchFile(root, "/repo/pkg/b.go"))
+
+	// Files matched by a glob are in scope as a whole, the ones matched by a path only partially.
+	require.True(t, s.MatchWholeFile(root, "/repo/cmd/agib/main.go"))
+	require.False(t, s.MatchWholeFile(root, "/repo/pkg/a.go"))
+
+	require.True(t, s.MatchNode(mustParsePath(t, "/pkg/a.go/@1/@0")))
+	require.False(t, s.MatchNode(mustParsePath(t, "/pkg/a.go/@2")))
+
+	var empty Scope
+
+	require.True(t, empty.MatchWholeFile(root, "/repo/pkg/b.go"))
+	require.True(t, empty.MatchNode(mustParsePath(t, "/pkg/b.go/@2")))
+}
@@ -8,6 +8,9 @@ type StepResult struct {
 	Errors []error
 }
 
+// Step is a build step. Since the project is synchronized in the background, steps take the write
+// lock of the project graph themselves while reading or mutating the project tree, see psi.UpdateGraph.
+// They can release it while waiting on slow operations, such as language model requests.
 type Step interface {
 	Process(ctx context.Context, bctx *Context) (StepResult, error)
 }
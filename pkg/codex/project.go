@@ -10,7 +10,9 @@ import (
 	"sync"
 
 	"github.com/google/uuid"
+	"github.com/hashicorp/go-multierror"
 	"github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
 	badger "github.com/ipfs/go-ds-badger"
 	"github.com/pkg/errors"
 
@@ -18,6 +20,7 @@ import (
 	"github.com/greenboxal/agibootstrap/pkg/platform/db/thoughtstream"
 
 	"github.com/greenboxal/agibootstrap/pkg/codex/vts"
+	"github.com/greenboxal/agibootstrap/pkg/gpt"
 	"github.com/greenboxal/agibootstrap/pkg/platform/db/fti"
 	"github.com/greenboxal/agibootstrap/pkg/platform/project"
 	tasks "github.com/greenboxal/agibootstrap/pkg/platform/tasks"
@@ -57,8 +60,13 @@ type Project struct {
 	tm   *tasks.Manager
 	lm   *thoughtstream.Manager
 
-	rootPath string
-	rootNode *vfs.DirectoryNode
+	rootPath  string
+	rootNode  *vfs.DirectoryNode
+	debugPath string
+
+	// ephemeral projects keep their datastore in memory and their debug logs in a
+	// temporary directory, so they leave nothing behind in the repository.
+	ephemeral bool
 
 	vts          *vts.Scope
 	langRegistry *project.Registry
@@ -69,10 +77,24 @@ type Project struct {
 	currentSyncTask      tasks.Task
 }
 
+// ProjectOption configures a Project when it is created.
+type ProjectOption func(p *Project)
+
+// WithOverlayFS keeps every file write in memory instead of writing to the working tree.
+// The pending changes can be retrieved from the repofs.OverlayFS returned by Project.FS.
+// The project datastore is kept in memory as well and the debug logs go to a temporary
+// directory removed by Close, so nothing is written under .fti.
+func WithOverlayFS() ProjectOption {
+	return func(p *Project) {
+		p.fs = repofs.NewOverlayFS(p.fs)
+		p.ephemeral = true
+	}
+}
+
 // NewProject creates a new codex project with the given root path.
 // It initializes the project file system, repository, and other required data structures.
 // It returns a pointer to the created Project object and an error if any.
-func NewProject(ctx context.Context, rootPath string) (*Project, error) {
+func NewProject(ctx context.Context, rootPath string, opts ...ProjectOption) (*Project, error) {
 	rootFs, err := repofs.NewFS(rootPath)
 
 	if err != nil {
@@ -85,38 +107,51 @@ func NewProject(ctx context.Context, rootPath string) (*Project, error) {
 		return nil, err
 	}
 
-	debugPath := repo.ResolveDbPath("codex", "debug")
+	p := &Project{
+		rootPath: rootPath,
 
-	if err := os.MkdirAll(debugPath, 0755); err != nil {
-		return nil, errors.Wrap(err, "failed to create datastore directory")
+		fs:   rootFs,
+		repo: repo,
+
+		fset: token.NewFileSet(),
+		vts:  vts.NewScope(),
 	}
 
-	dsOpts := badger.DefaultOptions
+	for _, opt := range opts {
+		opt(p)
+	}
 
-	dsPath := repo.ResolveDbPath("codex", "datastore")
+	if p.ephemeral {
+		p.debugPath, err = os.MkdirTemp("", "agib-debug-")
 
-	if err := os.MkdirAll(dsPath, 0755); err != nil {
-		return nil, errors.Wrap(err, "failed to create datastore directory")
-	}
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to create debug directory")
+		}
 
-	ds, err := badger.NewDatastore(dsPath, &dsOpts)
+		p.ds = dssync.MutexWrap(datastore.NewMapDatastore())
+	} else {
+		p.debugPath = repo.ResolveDbPath("codex", "debug")
 
-	if err != nil {
-		return nil, errors.Wrap(err, "failed to create datastore")
-	}
+		if err := os.MkdirAll(p.debugPath, 0755); err != nil {
+			return nil, errors.Wrap(err, "failed to create datastore directory")
+		}
 
-	p := &Project{
-		rootPath: rootPath,
+		dsOpts := badger.DefaultOptions
 
-		ds:   ds,
-		fs:   rootFs,
-		repo: repo,
+		dsPath := repo.ResolveDbPath("codex", "datastore")
 
-		fset: token.NewFileSet(),
-		vts:  vts.NewScope(),
+		if err := os.MkdirAll(dsPath, 0755); err != nil {
+			return nil, errors.Wrap(err, "failed to create datastore directory")
+		}
+
+		p.ds, err = badger.NewDatastore(dsPath, &dsOpts)
+
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to create datastore")
+		}
 	}
 
-	projectUuid, err := ds.Get(ctx, datastore.NewKey("project-uuid"))
+	projectUuid, err := p.ds.Get(ctx, datastore.NewKey("project-uuid"))
 
 	if err != nil {
 		if errors.Is(err, datastore.ErrNotFound) {
@@ -129,7 +164,7 @@ func NewProject(ctx context.Context, rootPath string) (*Project, error) {
 	if len(projectUuid) == 0 {
 		projectUuid = []byte(uuid.New().String())
 
-		err := ds.Put(ctx, datastore.NewKey("project-uuid"), projectUuid)
+		err := p.ds.Put(ctx, datastore.NewKey("project-uuid"), projectUuid)
 
 		if err != nil {
 			return nil, errors.Wrap(err, "failed to put project uuid")
@@ -149,7 +184,7 @@ func NewProject(ctx context.Context, rootPath string) (*Project, error) {
 	p.tm = tasks.NewManager()
 	p.tm.PsiNode().SetParent(p)
 
-	p.lm = thoughtstream.NewManager(p.g, debugPath)
+	p.lm = thoughtstream.NewManager(p.g, p.debugPath)
 	p.lm.PsiNode().SetParent(p)
 
 	if err := p.Sync(); err != nil {
@@ -175,6 +210,9 @@ func (p *Project) LanguageProvider() *project.Registry { return p.langRegistry }
 
 func (p *Project) Repo() *fti.Repository { return p.repo }
 
+// ModelProvider returns the LLM provider configured for the project.
+func (p *Project) ModelProvider() gpt.Provider { return p.repo.Provider() }
+
 func (p *Project) FileSet() *token.FileSet { return p.fset }
 
 // Sync synchronizes the project with the file system.
@@ -183,15 +221,30 @@ func (p *Project) FileSet() *token.FileSet { return p.fset }
 // have valid file extensions specified in the `validExtensions`
 // slice. The function returns an error if any occurs during
 // the sync process.
+//
+// The sync runs as a task, and Sync waits for it to finish, joining the sync in progress if
+// there is one. The caller must not hold the lock of the project graph.
 func (p *Project) Sync() error {
+	task := p.syncTask()
+
+	<-task.Done()
+
+	return task.Error()
+}
+
+// syncTask returns the sync in progress, or starts a new one.
+func (p *Project) syncTask() tasks.Task {
 	p.currentSyncTaskMutex.Lock()
 	defer p.currentSyncTaskMutex.Unlock()
 
 	if p.currentSyncTask != nil {
-		return nil
+		return p.currentSyncTask
 	}
 
 	task := p.tm.SpawnTask(context.Background(), func(progress tasks.TaskProgress) error {
+		p.g.Lock()
+		defer p.g.Unlock()
+
 		maxDepth := 0
 		count := 0
 
@@ -241,11 +294,12 @@ func (p *Project) Sync() error {
 		}
 	}()
 
-	return nil
+	return task
 }
 
 // GetSourceFile retrieves the source file with the given filename from the project.
 // It returns a pointer to the psi.SourceFile and any error that occurred during the process.
+// The caller must hold the write lock of the project graph, since the source file is loaded on first use.
 func (p *Project) GetSourceFile(filename string) (_ psi.SourceFile, err error) {
 	defer func() {
 		if r := recover(); r != nil {
@@ -265,7 +319,13 @@ func (p *Project) GetSourceFile(filename string) (_ psi.SourceFile, err error) {
 		return nil, err
 	}
 
-	psiPath := psi.MustParsePath(relPath)
+	var components []psi.PathElement
+
+	for _, name := range strings.Split(filepath.ToSlash(relPath), "/") {
+		components = append(components, psi.PathElement{Kind: psi.EdgeKindChild, Name: name})
+	}
+
+	psiPath := psi.RelativePathFromComponents(components...)
 	fileNode, err := psi.ResolvePath(p.rootNode, psiPath)
 
 	if err != nil {
@@ -303,11 +363,151 @@ func (p *Project) GetSourceFile(filename string) (_ psi.SourceFile, err error) {
 	return existing, nil
 }
 
+// Migrate upgrades the nodes persisted in the project datastore with an older schema version of their
+// node type, and returns the number of migrated nodes. See psi.WithSchemaVersion.
+func (p *Project) Migrate(ctx context.Context) (int, error) {
+	// Hold the graph lock so the graph doesn't persist nodes while they are migrated.
+	p.g.Lock()
+	defer p.g.Unlock()
+
+	return p.g.Store().MigrateAll(ctx)
+}
+
 // Reindex is a method that performs the reindexing operation for the project.
 // It updates the index of the project to reflect any changes made to its files.
+// The file tree is synchronized while it is walked, and only files whose content changed
+// since they were last indexed are embedded again. Deleted files are dropped from the index.
 // The function returns an error if any error occurs during the reindexing process.
+// The project graph is only locked while the file tree is synchronized, not while embedding.
 func (p *Project) Reindex() error {
-	return nil
+	var files []string
+
+	err := psi.UpdateGraph(p.g, func() error {
+		return p.syncFiles(func(n *vfs.FileNode) error {
+			rel, err := filepath.Rel(p.rootPath, n.Path())
+
+			if err != nil {
+				return err
+			}
+
+			files = append(files, filepath.ToSlash(rel))
+
+			return nil
+		})
+	})
+
+	if err != nil {
+		return err
+	}
+
+	_, err = p.repo.Reindex(context.Background(), p.fs, files)
+
+	return err
+}
+
+// LoadSourceFiles synchronizes the file tree and loads the source file of every file in a
+// known language, making their PSI trees reachable from the root node.
+// Files that fail to load are skipped, and their errors are returned together.
+// The caller must hold the write lock of the project graph.
+func (p *Project) LoadSourceFiles() error {
+	var merr error
+
+	err := p.syncFiles(func(n *vfs.FileNode) error {
+		if p.langRegistry.ResolveExtension(filepath.Base(n.Path())) == nil {
+			return nil
+		}
+
+		if _, err := p.GetSourceFile(n.Path()); err != nil {
+			merr = multierror.Append(merr, err)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	return merr
+}
+
+// ResolveReferences resolves the references between the nodes of the loaded source files,
+// for every language implementing psi.ReferenceResolver. See psi.Retriever.
+// The caller must hold the write lock of the project graph.
+func (p *Project) ResolveReferences(ctx context.Context) error {
+	byLanguage := map[psi.Language][]psi.SourceFile{}
+
+	err := psi.Walk(p.rootNode, func(cursor psi.Cursor, entering bool) error {
+		if !entering {
+			return nil
+		}
+
+		switch n := cursor.Node().(type) {
+		case *vfs.DirectoryNode:
+			cursor.WalkChildren()
+
+			return nil
+
+		case *vfs.FileNode:
+			// Only the source file edge is followed.
+			cursor.SkipChildren()
+
+			return nil
+
+		case psi.SourceFile:
+			byLanguage[n.Language()] = append(byLanguage[n.Language()], n)
+		}
+
+		cursor.SkipChildren()
+		cursor.SkipEdges()
+
+		return nil
+	}, psi.WithWalkEdges(psi.EdgeKind(SourceFileEdge)))
+
+	if err != nil {
+		return err
+	}
+
+	var merr error
+
+	for lang, files := range byLanguage {
+		if resolver, ok := lang.(psi.ReferenceResolver); ok {
+			if err := resolver.ResolveReferences(ctx, files); err != nil {
+				merr = multierror.Append(merr, err)
+			}
+		}
+	}
+
+	return merr
+}
+
+// syncFiles synchronizes the file tree while walking it, calling fn for every file.
+func (p *Project) syncFiles(fn func(n *vfs.FileNode) error) error {
+	filterFn := func(path string) bool {
+		return !p.repo.IsIgnored(path)
+	}
+
+	return psi.Walk(p.rootNode, func(cursor psi.Cursor, entering bool) error {
+		if !entering {
+			return nil
+		}
+
+		switch n := cursor.Node().(type) {
+		case *vfs.DirectoryNode:
+			cursor.WalkChildren()
+
+			return n.Sync(filterFn)
+
+		case *vfs.FileNode:
+			if err := fn(n); err != nil {
+				return err
+			}
+		}
+
+		cursor.SkipChildren()
+
+		return nil
+	})
 }
 
 func (p *Project) Close() error {
@@ -315,5 +515,13 @@ func (p *Project) Close() error {
 		return err
 	}
 
-	return p.ds.Close()
+	if err := p.ds.Close(); err != nil {
+		return err
+	}
+
+	if p.ephemeral {
+		return os.RemoveAll(p.debugPath)
+	}
+
+	return nil
 }
@@ -27,7 +27,7 @@ func (p *Project) Commit() error {
 		return err
 	}
 
-	commitMessage, err := gpt.PrepareCommitMessage(diff)
+	commitMessage, err := gpt.PrepareCommitMessage(p.ModelProvider(), diff)
 
 	if err != nil {
 		return err
@@ -0,0 +1,603 @@
+package gpt
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/greenboxal/aip/aip-controller/pkg/collective/msn"
+	"github.com/greenboxal/aip/aip-langchain/pkg/llm"
+	"github.com/greenboxal/aip/aip-langchain/pkg/llm/chat"
+	"github.com/greenboxal/aip/aip-langchain/pkg/tokenizers"
+	"github.com/pkg/errors"
+)
+
+// CassetteMode selects how a CassetteProvider uses its cassette.
+type CassetteMode string
+
+const (
+	// CassetteModeReplay serves every request from the cassette and fails on a miss.
+	CassetteModeReplay CassetteMode = "replay"
+	// CassetteModeRecord forwards every request to the underlying provider and records the response.
+	CassetteModeRecord CassetteMode = "record"
+	// CassetteModeAuto serves requests from the cassette and records the ones that are missing.
+	CassetteModeAuto CassetteMode = "auto"
+)
+
+const cassetteVersion = 2
+
+// ErrCassetteMiss is returned in replay mode when a request was not recorded in the cassette.
+var ErrCassetteMiss = errors.New("request not found in cassette")
+
+// CassetteConfig configures a cassette wrapping the project provider.
+type CassetteConfig struct {
+	Path string       `json:"path,omitempty"`
+	Mode CassetteMode `json:"mode,omitempty"`
+}
+
+// CassetteConfigFromEnv returns the cassette configuration from the AGIB_CASSETTE and
+// AGIB_CASSETTE_MODE environment variables, falling back to def when they are not set.
+func CassetteConfigFromEnv(def CassetteConfig) CassetteConfig {
+	if p := os.Getenv("AGIB_CASSETTE"); p != "" {
+		def.Path = p
+	}
+
+	if m := os.Getenv("AGIB_CASSETTE_MODE"); m != "" {
+		def.Mode = CassetteMode(m)
+	}
+
+	return def
+}
+
+// CassetteChatInteraction is a recorded chat completion.
+type CassetteChatInteraction struct {
+	Provider string             `json:"provider,omitempty"`
+	Options  llm.PredictOptions `json:"options"`
+	Prompt   chat.Message       `json:"prompt"`
+	Reply    chat.Message       `json:"reply"`
+}
+
+// CassetteEmbeddingInteraction is a recorded embedding of a single chunk.
+type CassetteEmbeddingInteraction struct {
+	Provider   string    `json:"provider,omitempty"`
+	Text       string    `json:"text"`
+	Embeddings []float32 `json:"embeddings"`
+}
+
+// Cassette is a content-addressed store of LLM interactions.
+// Interactions are keyed by the SHA-256 hash of the request that produced them,
+// so replaying the same prompt always yields the same response.
+type Cassette struct {
+	mu   sync.RWMutex
+	path string
+
+	Version    int                                      `json:"version"`
+	Chat       map[string]*CassetteChatInteraction      `json:"chat"`
+	Embeddings map[string]*CassetteEmbeddingInteraction `json:"embeddings"`
+}
+
+// NewCassette creates an empty in-memory cassette.
+func NewCassette() *Cassette {
+	return &Cassette{
+		Version:    cassetteVersion,
+		Chat:       map[string]*CassetteChatInteraction{},
+		Embeddings: map[string]*CassetteEmbeddingInteraction{},
+	}
+}
+
+// OpenCassette loads the cassette stored at path.
+// If the file does not exist, an empty cassette bound to path is returned.
+func OpenCassette(path string) (*Cassette, error) {
+	c := NewCassette()
+	c.path = path
+
+	data, err := os.ReadFile(path)
+
+	if err != nil {
+		if os.IsNotExist(err) {
+			return c, nil
+		}
+
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, c); err != nil {
+		return nil, errors.Wrapf(err, "invalid cassette %s", path)
+	}
+
+	if c.Version != cassetteVersion {
+		return nil, fmt.Errorf("unsupported cassette version %d in %s", c.Version, path)
+	}
+
+	if c.Chat == nil {
+		c.Chat = map[string]*CassetteChatInteraction{}
+	}
+
+	if c.Embeddings == nil {
+		c.Embeddings = map[string]*CassetteEmbeddingInteraction{}
+	}
+
+	return c, nil
+}
+
+// Path returns the file the cassette is persisted to, if any.
+func (c *Cassette) Path() string { return c.path }
+
+// Len returns the number of recorded interactions.
+func (c *Cassette) Len() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return len(c.Chat) + len(c.Embeddings)
+}
+
+// LookupChat returns the chat interaction recorded under key.
+func (c *Cassette) LookupChat(key string) (*CassetteChatInteraction, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	i, ok := c.Chat[key]
+
+	return i, ok
+}
+
+// LookupEmbedding returns the embedding interaction recorded under key.
+func (c *Cassette) LookupEmbedding(key string) (*CassetteEmbeddingInteraction, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	i, ok := c.Embeddings[key]
+
+	return i, ok
+}
+
+// RecordChat records a chat interaction under key and persists the cassette.
+func (c *Cassette) RecordChat(key string, interaction *CassetteChatInteraction) error {
+	c.mu.Lock()
+	c.Chat[key] = interaction
+	c.mu.Unlock()
+
+	return c.Save()
+}
+
+// RecordEmbeddings records a batch of embedding interactions and persists the cassette.
+func (c *Cassette) RecordEmbeddings(interactions map[string]*CassetteEmbeddingInteraction) error {
+	c.mu.Lock()
+	for k, v := range interactions {
+		c.Embeddings[k] = v
+	}
+	c.mu.Unlock()
+
+	return c.Save()
+}
+
+// Save atomically writes the cassette to its path. It is a no-op for in-memory cassettes.
+func (c *Cassette) Save() error {
+	if c.path == "" {
+		return nil
+	}
+
+	c.mu.RLock()
+	data, err := json.MarshalIndent(c, "", "  ")
+	c.mu.RUnlock()
+
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(c.path), 0755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(c.path), filepath.Base(c.path)+".*.tmp")
+
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), c.path)
+}
+
+// cassetteEntry is the part of a message entry which addresses a chat request. The metadata of
+// the entries, such as their IDs and timestamps, is left out so equal prompts have equal keys.
+type cassetteEntry struct {
+	Role   msn.Role `json:"role"`
+	Name   string   `json:"name,omitempty"`
+	Text   string   `json:"text"`
+	Fn     string   `json:"fn,omitempty"`
+	FnArgs string   `json:"fn_args,omitempty"`
+}
+
+// ChatKey returns the content address of a chat request.
+func ChatKey(msg chat.Message, opts llm.PredictOptions) string {
+	entries := make([]cassetteEntry, len(msg.Entries))
+
+	for i, e := range msg.Entries {
+		entries[i] = cassetteEntry{Role: e.Role, Name: e.Name, Text: e.Text, Fn: e.Fn, FnArgs: e.FnArgs}
+	}
+
+	return hashCassetteRequest(struct {
+		Kind    string             `json:"kind"`
+		Prompt  []cassetteEntry    `json:"prompt"`
+		Options llm.PredictOptions `json:"options"`
+	}{"chat", entries, opts})
+}
+
+// EmbeddingKey returns the content address of an embedding request for a single chunk.
+func EmbeddingKey(text string) string {
+	return hashCassetteRequest(struct {
+		Kind string `json:"kind"`
+		Text string `json:"text"`
+	}{"embedding", text})
+}
+
+func hashCassetteRequest(v any) string {
+	data, err := json.Marshal(v)
+
+	if err != nil {
+		panic(err)
+	}
+
+	h := sha256.Sum256(data)
+
+	return hex.EncodeToString(h[:])
+}
+
+// CassetteProvider wraps a Provider and records its interactions to a Cassette,
+// or replays them from it.
+type CassetteProvider struct {
+	inner    Provider
+	cassette *Cassette
+	mode     CassetteMode
+}
+
+// NewCassetteProvider creates a provider that records or replays the interactions of inner.
+// inner may be nil in replay mode.
+func NewCassetteProvider(inner Provider, cassette *Cassette, mode CassetteMode) (*CassetteProvider, error) {
+	switch mode {
+	case "":
+		mode = CassetteModeReplay
+	case CassetteModeReplay, CassetteModeRecord, CassetteModeAuto:
+	default:
+		return nil, fmt.Errorf("invalid cassette mode: %q", mode)
+	}
+
+	if inner == nil && mode != CassetteModeReplay {
+		return nil, fmt.Errorf("cassette mode %q requires a provider", mode)
+	}
+
+	return &CassetteProvider{
+		inner:    inner,
+		cassette: cassette,
+		mode:     mode,
+	}, nil
+}
+
+func (p *CassetteProvider) Name() string {
+	if p.inner == nil {
+		return "cassette"
+	}
+
+	return "cassette:" + p.inner.Name()
+}
+
+func (p *CassetteProvider) Cassette() *Cassette { return p.cassette }
+func (p *CassetteProvider) Mode() CassetteMode  { return p.mode }
+
+func (p *CassetteProvider) ChatModel() chat.LanguageModel {
+	m := &cassetteChatModel{p: p}
+
+	if p.inner != nil {
+		m.inner = p.inner.ChatModel()
+	}
+
+	return m
+}
+
+func (p *CassetteProvider) Embedder() llm.Embedder {
+	e := &cassetteEmbedder{p: p}
+
+	if p.inner != nil {
+		e.inner = p.inner.Embedder()
+	}
+
+	return e
+}
+
+func (p *CassetteProvider) Tokenizer() tokenizers.BasicTokenizer {
+	if p.inner == nil {
+		return tokenizerForModel(defaultOpenAIModel)
+	}
+
+	return p.inner.Tokenizer()
+}
+
+// ForChain routes the chain through the inner provider, sharing the same cassette.
+func (p *CassetteProvider) ForChain(name string) Provider {
+	if p.inner == nil {
+		return p
+	}
+
+	return &CassetteProvider{
+		inner:    ProviderForChain(p.inner, name),
+		cassette: p.cassette,
+		mode:     p.mode,
+	}
+}
+
+func (p *CassetteProvider) shouldReplay() bool { return p.mode != CassetteModeRecord }
+func (p *CassetteProvider) shouldRecord() bool { return p.mode != CassetteModeReplay }
+
+func (p *CassetteProvider) innerName() string {
+	if p.inner == nil {
+		return ""
+	}
+
+	return p.inner.Name()
+}
+
+type cassetteChatModel struct {
+	p     *CassetteProvider
+	inner chat.LanguageModel
+}
+
+func (m *cassetteChatModel) MaxTokens() int {
+	if m.inner == nil {
+		return 16384
+	}
+
+	return m.inner.MaxTokens()
+}
+
+// lookup returns the content address of the request and its interaction.
+// hit reports whether the interaction was replayed from the cassette.
+func (m *cassetteChatModel) lookup(msg chat.Message, options []llm.PredictOption) (key string, interaction *CassetteChatInteraction, hit bool, err error) {
+	var opts llm.PredictOptions
+
+	for _, opt := range options {
+		opt(&opts)
+	}
+
+	key = ChatKey(msg, opts)
+
+	if m.p.shouldReplay() {
+		if i, ok := m.p.cassette.LookupChat(key); ok {
+			return key, i, true, nil
+		}
+	}
+
+	if !m.p.shouldRecord() {
+		return key, nil, false, errors.Wrapf(ErrCassetteMiss, "chat %s", key)
+	}
+
+	return key, &CassetteChatInteraction{
+		Provider: m.p.innerName(),
+		Options:  opts,
+		Prompt:   msg,
+	}, false, nil
+}
+
+func (m *cassetteChatModel) PredictChat(ctx context.Context, msg chat.Message, options ...llm.PredictOption) (chat.Message, error) {
+	key, interaction, hit, err := m.lookup(msg, options)
+
+	if err != nil {
+		return chat.Message{}, err
+	}
+
+	if hit {
+		return interaction.Reply, nil
+	}
+
+	reply, err := m.inner.PredictChat(ctx, msg, options...)
+
+	if err != nil {
+		return chat.Message{}, err
+	}
+
+	interaction.Reply = reply
+
+	if err := m.p.cassette.RecordChat(key, interaction); err != nil {
+		return chat.Message{}, err
+	}
+
+	return reply, nil
+}
+
+func (m *cassetteChatModel) PredictChatStream(ctx context.Context, msg chat.Message, options ...llm.PredictOption) (chat.MessageStream, error) {
+	key, interaction, hit, err := m.lookup(msg, options)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if hit {
+		return &replayMessageStream{reply: interaction.Reply}, nil
+	}
+
+	stream, err := m.inner.PredictChatStream(ctx, msg, options...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &recordingMessageStream{
+		MessageStream: stream,
+		cassette:      m.p.cassette,
+		key:           key,
+		interaction:   interaction,
+	}, nil
+}
+
+// replayMessageStream streams a recorded reply, one fragment per entry.
+type replayMessageStream struct {
+	reply chat.Message
+	index int
+}
+
+func (s *replayMessageStream) Recv() (chat.MessageFragment, error) {
+	if s.index >= len(s.reply.Entries) {
+		return chat.MessageFragment{}, io.EOF
+	}
+
+	frag := chat.MessageFragment{
+		MessageIndex: s.index,
+		Delta:        s.reply.Entries[s.index].Text,
+	}
+
+	s.index++
+
+	return frag, nil
+}
+
+func (s *replayMessageStream) Close() error {
+	s.index = len(s.reply.Entries)
+
+	return nil
+}
+
+// recordingMessageStream forwards a live stream and records the reply once it is complete.
+type recordingMessageStream struct {
+	chat.MessageStream
+
+	cassette    *Cassette
+	key         string
+	interaction *CassetteChatInteraction
+	texts       []strings.Builder
+	recorded    bool
+}
+
+func (s *recordingMessageStream) Recv() (chat.MessageFragment, error) {
+	frag, err := s.MessageStream.Recv()
+
+	if err == io.EOF {
+		if rerr := s.record(); rerr != nil {
+			return frag, rerr
+		}
+
+		return frag, err
+	} else if err != nil {
+		return frag, err
+	}
+
+	for len(s.texts) <= frag.MessageIndex {
+		s.texts = append(s.texts, strings.Builder{})
+	}
+
+	s.texts[frag.MessageIndex].WriteString(frag.Delta)
+
+	return frag, nil
+}
+
+func (s *recordingMessageStream) record() error {
+	if s.recorded {
+		return nil
+	}
+
+	s.recorded = true
+
+	entries := make([]chat.MessageEntry, len(s.texts))
+
+	for i := range s.texts {
+		entries[i] = chat.MessageEntry{
+			Role: msn.RoleAI,
+			Text: s.texts[i].String(),
+		}
+	}
+
+	s.interaction.Reply = chat.Compose(entries...)
+
+	return s.cassette.RecordChat(s.key, s.interaction)
+}
+
+type cassetteEmbedder struct {
+	p     *CassetteProvider
+	inner llm.Embedder
+}
+
+func (e *cassetteEmbedder) MaxTokensPerChunk() int {
+	if e.inner == nil {
+		return 2048
+	}
+
+	return e.inner.MaxTokensPerChunk()
+}
+
+func (e *cassetteEmbedder) GetEmbeddings(ctx context.Context, chunks []string) ([]llm.Embedding, error) {
+	result := make([]llm.Embedding, len(chunks))
+	keys := make([]string, len(chunks))
+
+	var missing []int
+
+	for i, chunk := range chunks {
+		keys[i] = EmbeddingKey(chunk)
+
+		if e.p.shouldReplay() {
+			if rec, ok := e.p.cassette.LookupEmbedding(keys[i]); ok {
+				result[i] = llm.Embedding{Embeddings: rec.Embeddings}
+				continue
+			}
+		}
+
+		if !e.p.shouldRecord() {
+			return nil, errors.Wrapf(ErrCassetteMiss, "embedding %s", keys[i])
+		}
+
+		missing = append(missing, i)
+	}
+
+	if len(missing) == 0 {
+		return result, nil
+	}
+
+	pending := make([]string, len(missing))
+
+	for i, idx := range missing {
+		pending[i] = chunks[idx]
+	}
+
+	embs, err := e.inner.GetEmbeddings(ctx, pending)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(embs) != len(pending) {
+		return nil, fmt.Errorf("expected %d embeddings, got %d", len(pending), len(embs))
+	}
+
+	recorded := make(map[string]*CassetteEmbeddingInteraction, len(missing))
+
+	for i, idx := range missing {
+		result[idx] = embs[i]
+
+		recorded[keys[idx]] = &CassetteEmbeddingInteraction{
+			Provider:   e.p.innerName(),
+			Text:       chunks[idx],
+			Embeddings: embs[i].Embeddings,
+		}
+	}
+
+	if err := e.p.cassette.RecordEmbeddings(recorded); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
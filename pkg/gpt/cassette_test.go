@@ -0,0 +1,119 @@
+package gpt
+
+import (
+	"context"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/greenboxal/aip/aip-controller/pkg/collective/msn"
+	"github.com/greenboxal/aip/aip-langchain/pkg/llm"
+	"github.com/greenboxal/aip/aip-langchain/pkg/llm/chat"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+// TestCassetteProviderRoundTrip records a synthetic cassette from the scripted replies of the fake
+// provider, and replays it.
+func TestCassetteProviderRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "cassette.json")
+	prompt := chat.Compose(chat.Entry(msn.RoleUser, "What is the answer?"))
+	streamPrompt := chat.Compose(chat.Entry(msn.RoleUser, "Stream the answer."))
+
+	// Record
+	fake := NewFakeProvider("42", "streamed")
+	cassette, err := OpenCassette(path)
+	require.NoError(t, err)
+
+	recorder, err := NewCassetteProvider(fake, cassette, CassetteModeRecord)
+	require.NoError(t, err)
+
+	reply, err := recorder.ChatModel().PredictChat(ctx, prompt, llm.WithMaxTokens(16))
+	require.NoError(t, err)
+	require.Equal(t, "42", reply.Entries[0].Text)
+
+	stream, err := recorder.ChatModel().PredictChatStream(ctx, streamPrompt)
+	require.NoError(t, err)
+	require.Equal(t, "streamed", readStream(t, stream))
+
+	recorded, err := recorder.Embedder().GetEmbeddings(ctx, []string{"a", "b"})
+	require.NoError(t, err)
+
+	require.Len(t, fake.Requests, 2)
+	require.Equal(t, 4, cassette.Len())
+
+	// Replay
+	cassette, err = OpenCassette(path)
+	require.NoError(t, err)
+	require.Equal(t, 4, cassette.Len())
+
+	player, err := NewCassetteProvider(nil, cassette, CassetteModeReplay)
+	require.NoError(t, err)
+
+	reply, err = player.ChatModel().PredictChat(ctx, prompt, llm.WithMaxTokens(16))
+	require.NoError(t, err)
+	require.Equal(t, "42", reply.Entries[0].Text)
+
+	stream, err = player.ChatModel().PredictChatStream(ctx, streamPrompt)
+	require.NoError(t, err)
+	require.Equal(t, "streamed", readStream(t, stream))
+
+	replayed, err := player.Embedder().GetEmbeddings(ctx, []string{"b", "a"})
+	require.NoError(t, err)
+	require.Equal(t, recorded[1].Embeddings, replayed[0].Embeddings)
+	require.Equal(t, recorded[0].Embeddings, replayed[1].Embeddings)
+
+	// Requests differing in their options or content are misses.
+	_, err = player.ChatModel().PredictChat(ctx, prompt, llm.WithMaxTokens(32))
+	require.True(t, errors.Is(err, ErrCassetteMiss))
+
+	_, err = player.Embedder().GetEmbeddings(ctx, []string{"c"})
+	require.True(t, errors.Is(err, ErrCassetteMiss))
+
+	// Auto mode replays hits and records misses.
+	fake = NewFakeProvider("auto")
+	auto, err := NewCassetteProvider(fake, cassette, CassetteModeAuto)
+	require.NoError(t, err)
+
+	reply, err = auto.ChatModel().PredictChat(ctx, prompt, llm.WithMaxTokens(16))
+	require.NoError(t, err)
+	require.Equal(t, "42", reply.Entries[0].Text)
+
+	reply, err = auto.ChatModel().PredictChat(ctx, prompt, llm.WithMaxTokens(32))
+	require.NoError(t, err)
+	require.Equal(t, "auto", reply.Entries[0].Text)
+	require.Len(t, fake.Requests, 1)
+	require.Equal(t, 5, cassette.Len())
+}
+
+func TestChatKeyIgnoresMetadata(t *testing.T) {
+	a := chat.Compose(chat.Entry(msn.RoleUser, "hello"))
+	b := chat.Compose(chat.Entry(msn.RoleUser, "hello"))
+	b.Entries[0].ThreadID = "thread"
+
+	require.Equal(t, ChatKey(a, llm.PredictOptions{}), ChatKey(b, llm.PredictOptions{}))
+
+	c := chat.Compose(chat.Entry(msn.RoleAI, "hello"))
+
+	require.NotEqual(t, ChatKey(a, llm.PredictOptions{}), ChatKey(c, llm.PredictOptions{}))
+}
+
+func readStream(t *testing.T, stream chat.MessageStream) string {
+	var sb strings.Builder
+
+	for {
+		frag, err := stream.Recv()
+
+		if err == io.EOF {
+			break
+		}
+
+		require.NoError(t, err)
+
+		sb.WriteString(frag.Delta)
+	}
+
+	return sb.String()
+}
@@ -8,8 +8,8 @@ import (
 
 	"github.com/greenboxal/aip/aip-langchain/pkg/chain"
 	"github.com/greenboxal/aip/aip-langchain/pkg/llm/chat"
-	"github.com/greenboxal/aip/aip-langchain/pkg/providers/openai"
 	"github.com/hashicorp/go-multierror"
+	"github.com/pkg/errors"
 
 	mdutils2 "github.com/greenboxal/agibootstrap/pkg/platform/mdutils"
 )
@@ -23,7 +23,16 @@ type CodeGeneratorRequest struct {
 	Language  string
 	Plan      string
 
+	// Diagnostics holds the verification errors of the previous attempt, if any.
+	Diagnostics string
+
 	RetrieveContext func(ctx context.Context, req CodeGeneratorRequest) (ContextBag, error)
+
+	// Verify checks the generated code blocks. If it returns an error, its message is fed back
+	// to the model and the code is generated again, up to MaxVerifyAttempts times.
+	Verify func(ctx context.Context, req CodeGeneratorRequest, blocks []mdutils2.CodeBlock) error
+	// MaxVerifyAttempts bounds the number of generation rounds. Defaults to DefaultMaxVerifyAttempts.
+	MaxVerifyAttempts int
 }
 type CodeGeneratorResponse struct {
 	MessageLog chat.Message
@@ -31,20 +40,30 @@ type CodeGeneratorResponse struct {
 }
 
 type CodeGenerator struct {
-	client *openai.Client
-	model  *openai.ChatLanguageModel
+	provider Provider
 
 	planChain     chain.Chain
 	generateChain chain.Chain
 	verifyChain   chain.Chain
 }
 
+// DefaultMaxVerifyAttempts is the default number of generation rounds before giving up on verification.
+const DefaultMaxVerifyAttempts = 3
+
+var ErrNoCodeBlocks = errors.New("no code blocks found in reply")
+var ErrVerificationFailed = errors.New("generated code failed verification")
+
 var blockCodeHeaderRegex = regexp.MustCompile("(?m)^\\w*\\x60\\x60\\x60([a-zA-Z0-9_-]+)?\\w*$")
 
-func NewCodeGenerator() *CodeGenerator {
+// NewCodeGenerator creates a new CodeGenerator using the given provider.
+// If provider is nil, DefaultProvider is used.
+func NewCodeGenerator(provider Provider) *CodeGenerator {
+	if provider == nil {
+		provider = DefaultProvider()
+	}
+
 	cg := &CodeGenerator{
-		client: GlobalClient,
-		model:  GlobalModel,
+		provider: provider,
 	}
 
 	cg.planChain = chain.New(
@@ -52,7 +71,7 @@ func NewCodeGenerator() *CodeGenerator {
 
 		chain.Sequential(
 			chat.Predict(
-				GlobalModel,
+				ProviderForChain(provider, "CodeGeneratorPlanner").ChatModel(),
 				CodeGeneratorPlannerPrompt,
 			),
 		),
@@ -63,7 +82,7 @@ func NewCodeGenerator() *CodeGenerator {
 
 		chain.Sequential(
 			chat.Predict(
-				GlobalModel,
+				ProviderForChain(provider, "CodeGenerator").ChatModel(),
 				CodeGeneratorPrompt,
 				chat.WithMaxTokens(4000),
 			),
@@ -75,7 +94,7 @@ func NewCodeGenerator() *CodeGenerator {
 
 		chain.Sequential(
 			chat.Predict(
-				GlobalModel,
+				ProviderForChain(provider, "CodeGeneratorVerifier").ChatModel(),
 				CodeGeneratorPrompt,
 			),
 		),
@@ -84,6 +103,8 @@ func NewCodeGenerator() *CodeGenerator {
 	return cg
 }
 
+func (g *CodeGenerator) Provider() Provider { return g.provider }
+
 func (g *CodeGenerator) Generate(ctx context.Context, req CodeGeneratorRequest) (result CodeGeneratorResponse, err error) {
 	state := &CodeGeneratorContext{
 		gen: g,
@@ -118,6 +139,7 @@ type CodeGeneratorContext struct {
 
 	chatHistory []chat.Message
 	codeBlocks  []mdutils2.CodeBlock
+	attempts    int
 
 	errors []error
 }
@@ -134,8 +156,6 @@ func (s *CodeGeneratorContext) Append(ctx chain.ChainContext, msg chat.Message)
 
 func (s *CodeGeneratorContext) Run(ctx context.Context) (err error) {
 	defer func() {
-		var err error
-
 		for _, e := range s.errors {
 			err = multierror.Append(err, e)
 		}
@@ -210,12 +230,37 @@ func (s *CodeGeneratorContext) stepGenerate(ctx context.Context) {
 }
 
 func (s *CodeGeneratorContext) stepVerify(ctx context.Context) {
+	var err error
+
 	if len(s.codeBlocks) == 0 {
-		s.setState(CodeGenStateGenerate)
+		err = ErrNoCodeBlocks
+	} else if s.req.Verify != nil {
+		err = s.req.Verify(ctx, s.req, s.codeBlocks)
+	}
+
+	if err == nil {
+		s.setState(CodeGenStateDone)
 		return
 	}
 
-	s.setState(CodeGenStateDone)
+	s.attempts++
+
+	maxAttempts := s.req.MaxVerifyAttempts
+
+	if maxAttempts <= 0 {
+		maxAttempts = DefaultMaxVerifyAttempts
+	}
+
+	if s.attempts >= maxAttempts {
+		s.abort(errors.Wrapf(ErrVerificationFailed, "after %d attempts: %s", s.attempts, err))
+		return
+	}
+
+	// Discard the rejected blocks and try again with the diagnostics in the prompt.
+	s.req.Diagnostics = err.Error()
+	s.codeBlocks = nil
+
+	s.setState(CodeGenStateGenerate)
 }
 
 func (s *CodeGeneratorContext) processResult(result chat.Message) {
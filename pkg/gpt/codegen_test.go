@@ -0,0 +1,79 @@
+package gpt
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+
+	"github.com/greenboxal/agibootstrap/pkg/platform/mdutils"
+)
+
+func newTestCodeGeneratorRequest(verify func(ctx context.Context, req CodeGeneratorRequest, blocks []mdutils.CodeBlock) error) CodeGeneratorRequest {
+	return CodeGeneratorRequest{
+		Objective: "// TODO: Implement Hello",
+		Document:  mdutils.CodeBlock{Language: "go", Filename: "hello.go", Code: "package hello\n\nfunc Hello() string {\n\t// TODO: Implement Hello\n}\n"},
+		Focus:     mdutils.CodeBlock{Language: "go", Filename: "hello.go", Code: "func Hello() string {\n\t// TODO: Implement Hello\n}\n"},
+		Language:  "go",
+		Context:   ContextBag{},
+
+		Verify:            verify,
+		MaxVerifyAttempts: 3,
+	}
+}
+
+func TestCodeGeneratorRepairLoop(t *testing.T) {
+	provider := NewFakeProvider(
+		"1. Return the greeting.",
+		"```go\nfunc Hello() string {\n\treturn greeting\n}\n```",
+		"```go\nfunc Hello() string {\n\treturn \"hello\"\n}\n```",
+	)
+
+	var verified []string
+
+	req := newTestCodeGeneratorRequest(func(ctx context.Context, req CodeGeneratorRequest, blocks []mdutils.CodeBlock) error {
+		verified = append(verified, blocks[0].Code)
+
+		if strings.Contains(blocks[0].Code, "greeting") {
+			return fmt.Errorf("hello.go:4:9: undefined: greeting")
+		}
+
+		return nil
+	})
+
+	res, err := NewCodeGenerator(provider).Generate(context.Background(), req)
+	require.NoError(t, err)
+
+	require.Len(t, res.CodeBlocks, 1)
+	require.Contains(t, res.CodeBlocks[0].Code, `return "hello"`)
+	require.Len(t, verified, 2)
+
+	// The plan, the rejected attempt and the repaired one.
+	require.Len(t, provider.Requests, 3)
+	require.NotContains(t, provider.Requests[1].String(), "undefined: greeting")
+	require.Contains(t, provider.Requests[2].String(), "undefined: greeting")
+}
+
+func TestCodeGeneratorRepairLoopGivesUp(t *testing.T) {
+	provider := NewFakeProvider(
+		"1. Return the greeting.",
+		"```go\nfunc Hello() string {\n\treturn greeting\n}\n```",
+	)
+
+	attempts := 0
+
+	req := newTestCodeGeneratorRequest(func(ctx context.Context, req CodeGeneratorRequest, blocks []mdutils.CodeBlock) error {
+		attempts++
+
+		return fmt.Errorf("hello.go:4:9: undefined: greeting")
+	})
+
+	_, err := NewCodeGenerator(provider).Generate(context.Background(), req)
+	require.Error(t, err)
+	require.True(t, errors.Is(err, ErrVerificationFailed))
+	require.Equal(t, 3, attempts)
+	require.Len(t, provider.Requests, 4)
+}
@@ -47,19 +47,24 @@ Refactor feature
 		chain.NewTemplatePrompt("\t```markdown")),
 )
 
-var CommitMessageChain = chain.New(
-	chain.WithName("CommitMessageGenerator"),
-
-	chain.Sequential(
-		chat.Predict(
-			GlobalModel,
-			CommitMessagePrompt,
-			chat.WithMaxTokens(1024),
+// NewCommitMessageChain creates the commit message chain using the given provider.
+func NewCommitMessageChain(provider Provider) chain.Chain {
+	return chain.New(
+		chain.WithName("CommitMessageGenerator"),
+
+		chain.Sequential(
+			chat.Predict(
+				ProviderForChain(provider, "CommitMessageGenerator").ChatModel(),
+				CommitMessagePrompt,
+				chat.WithMaxTokens(1024),
+			),
 		),
-	),
-)
+	)
+}
 
-func PrepareCommitMessage(diff string) (string, error) {
+// PrepareCommitMessage generates a commit message for the given diff.
+// If provider is nil, DefaultProvider is used.
+func PrepareCommitMessage(provider Provider, diff string) (string, error) {
 	ctx := context.Background()
 	cctx := chain.NewChainContext(ctx)
 
@@ -72,7 +77,7 @@ func PrepareCommitMessage(diff string) (string, error) {
 
 	cctx.SetInput(RequestKey, req)
 
-	if err := CommitMessageChain.Run(cctx); err != nil {
+	if err := NewCommitMessageChain(ResolveProvider(ctx, provider)).Run(cctx); err != nil {
 		return "", err
 	}
 
@@ -21,6 +21,8 @@ type PlanStepStatus struct {
 	Status *GoalCompletion `json:"Status" jsonschema:"title=Status,description=Status of the step."`
 }
 
+var PlanType = psi.RegisterNodeType[*Plan]("featureextractors.Plan")
+
 type Plan struct {
 	psi.NodeBase
 
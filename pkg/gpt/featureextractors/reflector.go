@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"math/rand"
 	"reflect"
 	"sync"
 
@@ -20,6 +21,10 @@ import (
 )
 
 type ReflectOptions struct {
+	// Provider is the LLM provider used for the reflection.
+	// If nil, the provider carried by the context or gpt.DefaultProvider is used.
+	Provider gpt.Provider
+
 	History []*thoughtstream.Thought
 	Query   string
 
@@ -65,7 +70,8 @@ func reflectSingle[T any](ctx context.Context, req ReflectOptions) (def T, _ cha
 
 		req.ExampleOutput = reflect.New(typ).Elem().Interface()
 
-		faker.New().Struct().Fill(req.ExampleOutput)
+		// A fixed seed keeps the prompt, and so its cassette key, the same across runs.
+		faker.NewWithSeed(rand.NewSource(0)).Struct().Fill(req.ExampleOutput)
 	}
 
 	schemaJson, err := json.Marshal(schema)
@@ -138,13 +144,14 @@ func reflectSingle[T any](ctx context.Context, req ReflectOptions) (def T, _ cha
 	}
 
 	cctx := chain.NewChainContext(ctx)
+	provider := gpt.ProviderForChain(gpt.ResolveProvider(ctx, req.Provider), "FeatureReflector")
 
 	stepChain := chain.New(
 		chain.WithName("FeatureReflector"),
 
 		chain.Sequential(
 			chat.Predict(
-				gpt.GlobalModel,
+				provider.ChatModel(),
 				prompt,
 				chat.WithMaxTokens(1024),
 			),
@@ -3,18 +3,59 @@ package featureextractors
 import (
 	"context"
 	"fmt"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/greenboxal/aip/aip-controller/pkg/collective/msn"
 	"github.com/stretchr/testify/require"
 
+	"github.com/greenboxal/agibootstrap/pkg/gpt"
 	"github.com/greenboxal/agibootstrap/pkg/platform/db/thoughtstream"
 )
 
+// withCassette returns a context using the cassette recorded for the current test.
+// Tests replay testdata/cassettes/<test>.json by default, and are skipped if it was not recorded;
+// set AGIB_CASSETTE_MODE=record to record it against the default provider. Cassettes are only
+// committed when recorded against a live provider, the cassette provider itself is tested in
+// pkg/gpt with synthetic fixtures.
+func withCassette(t *testing.T, ctx context.Context) context.Context {
+	cfg := gpt.CassetteConfig{
+		Path: filepath.Join("testdata", "cassettes", t.Name()+".json"),
+		Mode: gpt.CassetteMode(os.Getenv("AGIB_CASSETTE_MODE")),
+	}
+
+	if cfg.Mode == "" {
+		cfg.Mode = gpt.CassetteModeReplay
+	}
+
+	if cfg.Mode == gpt.CassetteModeReplay {
+		if _, err := os.Stat(cfg.Path); os.IsNotExist(err) {
+			t.Skipf("no cassette recorded for %s, record it with AGIB_CASSETTE_MODE=record", t.Name())
+		}
+	}
+
+	cassette, err := gpt.OpenCassette(cfg.Path)
+	require.NoError(t, err)
+
+	var inner gpt.Provider
+
+	if cfg.Mode != gpt.CassetteModeReplay {
+		inner = gpt.DefaultProvider()
+	}
+
+	provider, err := gpt.NewCassetteProvider(inner, cassette, cfg.Mode)
+	require.NoError(t, err)
+
+	return gpt.WithProvider(ctx, provider)
+}
+
 func TestReflector(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
+	ctx = withCassette(t, ctx)
+
 	history := []*thoughtstream.Thought{
 		{
 			From: thoughtstream.CommHandle{
@@ -53,6 +94,8 @@ func TestQueryPlan(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
+	ctx = withCassette(t, ctx)
+
 	history := []*thoughtstream.Thought{
 		{
 			From: thoughtstream.CommHandle{
@@ -87,6 +130,8 @@ func TestSentiment(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
+	ctx = withCassette(t, ctx)
+
 	history := []*thoughtstream.Thought{
 		{
 			From: thoughtstream.CommHandle{
@@ -121,6 +166,8 @@ func TestGoalCompletion(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
+	ctx = withCassette(t, ctx)
+
 	history := []*thoughtstream.Thought{
 		{
 			From: thoughtstream.CommHandle{
@@ -24,6 +24,7 @@ func PrepareContext(ctx context.Context, req CodeGeneratorRequest) chain.ChainCo
 	cctx.SetInput(FocusKey, req.Focus)
 	cctx.SetInput(ContextKey, req.Context)
 	cctx.SetInput(LanguageKey, req.Language)
+	cctx.SetInput(DiagnosticsKey, req.Diagnostics)
 
 	return cctx
 }
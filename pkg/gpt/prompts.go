@@ -14,11 +14,11 @@ var ContextKey chain.ContextKey[any] = "Context"
 var DocumentKey chain.ContextKey[string] = "Document"
 var FocusKey chain.ContextKey[string] = "Focus"
 var LanguageKey chain.ContextKey[string] = "Language"
+var DiagnosticsKey chain.ContextKey[string] = "Diagnostics"
 
 // CodeGeneratorPrompt is the prompt used to generate code.
 var CodeGeneratorPrompt chat.Prompt
 var CodeGeneratorPlannerPrompt chat.Prompt
-var CodeGeneratorChain chain.Chain
 
 func init() {
 	CodeGeneratorPlannerPrompt = chat.ComposeTemplate(
@@ -87,21 +87,29 @@ You are going to be given a detailed plan to generate the code. You will be give
 Read the plan above and modify the document as necessary. Focus on the items below:
 
 {{ .Focus | renderMarkdown 2 }}
+{{ if .Diagnostics }}
+# Diagnostics
+Your previous answer failed verification with the errors below. Fix them.
 
+{{ .Diagnostics }}
+{{ end }}
 Read the plan above and modify the document as necessary. Focus on the items above.
-		`, chain.WithRequiredInput(ObjectiveKey), chain.WithRequiredInput(DocumentKey), chain.WithRequiredInput(PlanKey), chain.WithRequiredInput(FocusKey))),
+		`, chain.WithRequiredInput(ObjectiveKey), chain.WithRequiredInput(DocumentKey), chain.WithRequiredInput(PlanKey), chain.WithRequiredInput(FocusKey), chain.WithRequiredInput(DiagnosticsKey))),
 
 		chat.EntryTemplate(
 			msn.RoleAI,
 			chain.NewTemplatePrompt("\t```{{ .Language }}", chain.WithRequiredInput(LanguageKey))),
 	)
+}
 
-	CodeGeneratorChain = chain.New(
+// NewCodeGeneratorChain creates a single-shot code generation chain using the given provider.
+func NewCodeGeneratorChain(provider Provider) chain.Chain {
+	return chain.New(
 		chain.WithName("GoCodeGenerator"),
 
 		chain.Sequential(
 			chat.Predict(
-				GlobalModel,
+				ProviderForChain(provider, "GoCodeGenerator").ChatModel(),
 				CodeGeneratorPrompt,
 				chat.WithMaxTokens(4000),
 			),
@@ -0,0 +1,231 @@
+package gpt
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/greenboxal/aip/aip-langchain/pkg/llm"
+	"github.com/greenboxal/aip/aip-langchain/pkg/llm/chat"
+	"github.com/greenboxal/aip/aip-langchain/pkg/tokenizers"
+)
+
+// ProviderKind identifies a Provider implementation in the provider registry.
+type ProviderKind string
+
+const (
+	ProviderKindOpenAI           ProviderKind = "openai"
+	ProviderKindOpenAICompatible ProviderKind = "openai-compatible"
+	ProviderKindFake             ProviderKind = "fake"
+)
+
+// Provider supplies the language models used by the chains in this package,
+// by the agents and by the FTI repository.
+type Provider interface {
+	// Name returns a human-readable name for the provider.
+	Name() string
+
+	// ChatModel returns the chat model used for predictions.
+	ChatModel() chat.LanguageModel
+	// Embedder returns the embedder used to index and query documents.
+	Embedder() llm.Embedder
+	// Tokenizer returns a tokenizer compatible with ChatModel.
+	Tokenizer() tokenizers.BasicTokenizer
+}
+
+// ChainProvider is implemented by providers that route individual chains to different providers.
+type ChainProvider interface {
+	Provider
+
+	// ForChain returns the provider that should be used by the chain with the given name.
+	ForChain(name string) Provider
+}
+
+// ProviderConfig describes a single provider.
+type ProviderConfig struct {
+	Kind ProviderKind `json:"kind"`
+
+	Model          string `json:"model,omitempty"`
+	EmbeddingModel string `json:"embedding_model,omitempty"`
+	MaxTokens      int    `json:"max_tokens,omitempty"`
+
+	// Temperature is the sampling temperature. It is a pointer so an explicit 0 can be told
+	// apart from an unset value, which uses the default of the provider.
+	Temperature *float32 `json:"temperature,omitempty"`
+
+	BaseURL string `json:"base_url,omitempty"`
+	APIKey  string `json:"api_key,omitempty"`
+}
+
+// Configuration is the LLM configuration of a project.
+// The embedded ProviderConfig is the default provider, and Chains overrides
+// the provider used by individual chains, keyed by chain name (e.g. "CodeGenerator").
+type Configuration struct {
+	ProviderConfig
+
+	Chains map[string]ProviderConfig `json:"chains,omitempty"`
+
+	// Cassette, if set, records or replays every interaction with the providers.
+	// It can be overridden with the AGIB_CASSETTE and AGIB_CASSETTE_MODE environment variables.
+	Cassette CassetteConfig `json:"cassette,omitempty"`
+}
+
+// NewProvider creates the provider described by this configuration.
+// If no provider kind is configured, DefaultProvider is used.
+func (c Configuration) NewProvider() (Provider, error) {
+	p, err := c.newProvider()
+
+	if err != nil {
+		return nil, err
+	}
+
+	cassetteConfig := CassetteConfigFromEnv(c.Cassette)
+
+	if cassetteConfig.Path == "" {
+		return p, nil
+	}
+
+	cassette, err := OpenCassette(cassetteConfig.Path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return NewCassetteProvider(p, cassette, cassetteConfig.Mode)
+}
+
+func (c Configuration) newProvider() (Provider, error) {
+	var err error
+	var def Provider
+
+	if c.Kind == "" {
+		def = DefaultProvider()
+	} else {
+		def, err = NewProvider(c.ProviderConfig)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(c.Chains) == 0 {
+		return def, nil
+	}
+
+	router := &chainRouter{
+		Provider: def,
+		chains:   make(map[string]Provider, len(c.Chains)),
+	}
+
+	for name, cfg := range c.Chains {
+		p, err := NewProvider(cfg)
+
+		if err != nil {
+			return nil, fmt.Errorf("chain %s: %w", name, err)
+		}
+
+		router.chains[name] = p
+	}
+
+	return router, nil
+}
+
+// ProviderFactory creates a Provider from its configuration.
+type ProviderFactory func(cfg ProviderConfig) (Provider, error)
+
+var providerFactories = map[ProviderKind]ProviderFactory{}
+
+// RegisterProvider registers a provider factory for the given kind.
+func RegisterProvider(kind ProviderKind, factory ProviderFactory) {
+	providerFactories[kind] = factory
+}
+
+// NewProvider creates a provider from the given configuration using the registered factories.
+func NewProvider(cfg ProviderConfig) (Provider, error) {
+	factory := providerFactories[cfg.Kind]
+
+	if factory == nil {
+		return nil, fmt.Errorf("unknown llm provider kind: %q", cfg.Kind)
+	}
+
+	return factory(cfg)
+}
+
+// ProviderForChain returns the provider that should be used by the chain with the given name.
+func ProviderForChain(p Provider, name string) Provider {
+	if cp, ok := p.(ChainProvider); ok {
+		return cp.ForChain(name)
+	}
+
+	return p
+}
+
+type chainRouter struct {
+	Provider
+
+	chains map[string]Provider
+}
+
+func (r *chainRouter) ForChain(name string) Provider {
+	if p := r.chains[name]; p != nil {
+		return p
+	}
+
+	return r.Provider
+}
+
+var defaultProviderMutex sync.Mutex
+var defaultProvider Provider
+
+// DefaultProvider returns the process-wide default provider.
+// Unless overridden with SetDefaultProvider, it is an OpenAI provider created on first use.
+func DefaultProvider() Provider {
+	defaultProviderMutex.Lock()
+	defer defaultProviderMutex.Unlock()
+
+	if defaultProvider == nil {
+		defaultProvider = NewOpenAIProvider(ProviderConfig{Kind: ProviderKindOpenAI})
+	}
+
+	return defaultProvider
+}
+
+// SetDefaultProvider overrides the process-wide default provider.
+func SetDefaultProvider(p Provider) {
+	defaultProviderMutex.Lock()
+	defer defaultProviderMutex.Unlock()
+
+	defaultProvider = p
+}
+
+type providerContextKey struct{}
+
+// WithProvider returns a copy of ctx carrying the given provider.
+func WithProvider(ctx context.Context, p Provider) context.Context {
+	return context.WithValue(ctx, providerContextKey{}, p)
+}
+
+// ProviderFromContext returns the provider carried by ctx, if any.
+func ProviderFromContext(ctx context.Context) Provider {
+	if ctx == nil {
+		return nil
+	}
+
+	p, _ := ctx.Value(providerContextKey{}).(Provider)
+
+	return p
+}
+
+// ResolveProvider returns p if it is not nil, the provider carried by ctx if there is one,
+// or DefaultProvider otherwise.
+func ResolveProvider(ctx context.Context, p Provider) Provider {
+	if p != nil {
+		return p
+	}
+
+	if p := ProviderFromContext(ctx); p != nil {
+		return p
+	}
+
+	return DefaultProvider()
+}
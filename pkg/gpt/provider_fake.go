@@ -0,0 +1,178 @@
+package gpt
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"io"
+	"math"
+	"sync"
+
+	"github.com/greenboxal/aip/aip-controller/pkg/collective/msn"
+	"github.com/greenboxal/aip/aip-langchain/pkg/llm"
+	"github.com/greenboxal/aip/aip-langchain/pkg/llm/chat"
+	"github.com/greenboxal/aip/aip-langchain/pkg/tokenizers"
+)
+
+// FakeEmbeddingDimensions matches the dimensions of the OpenAI embeddings so the FTI index can be shared.
+const FakeEmbeddingDimensions = 1536
+
+func init() {
+	RegisterProvider(ProviderKindFake, func(cfg ProviderConfig) (Provider, error) {
+		return NewFakeProvider(), nil
+	})
+}
+
+// FakeReplyFunc computes the reply of a FakeProvider for the given prompt.
+type FakeReplyFunc func(ctx context.Context, msg chat.Message) (string, error)
+
+// FakeProvider is an in-process Provider for tests and offline runs.
+// It replies with the configured canned replies in order, repeating the last one once
+// they are exhausted. Without any replies, it echoes the last message of the prompt.
+// Embeddings are derived deterministically from the hash of each chunk.
+type FakeProvider struct {
+	mu        sync.Mutex
+	replies   []string
+	calls     int
+	tokenizer tokenizers.BasicTokenizer
+
+	// ReplyFunc, if set, overrides the canned replies.
+	ReplyFunc FakeReplyFunc
+	// Requests records every prompt sent to the chat model.
+	Requests []chat.Message
+}
+
+// NewFakeProvider creates a new FakeProvider replying with the given canned replies.
+func NewFakeProvider(replies ...string) *FakeProvider {
+	return &FakeProvider{
+		replies: replies,
+	}
+}
+
+func (f *FakeProvider) Name() string                  { return string(ProviderKindFake) }
+func (f *FakeProvider) ChatModel() chat.LanguageModel { return fakeChatModel{f} }
+func (f *FakeProvider) Embedder() llm.Embedder        { return fakeEmbedder{} }
+
+func (f *FakeProvider) Tokenizer() tokenizers.BasicTokenizer {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.tokenizer == nil {
+		f.tokenizer = tokenizerForModel(defaultOpenAIModel)
+	}
+
+	return f.tokenizer
+}
+
+func (f *FakeProvider) reply(ctx context.Context, msg chat.Message) (string, error) {
+	f.mu.Lock()
+	f.Requests = append(f.Requests, msg)
+	index := f.calls
+	f.calls++
+	f.mu.Unlock()
+
+	if f.ReplyFunc != nil {
+		return f.ReplyFunc(ctx, msg)
+	}
+
+	if len(f.replies) > 0 {
+		if index >= len(f.replies) {
+			index = len(f.replies) - 1
+		}
+
+		return f.replies[index], nil
+	}
+
+	if len(msg.Entries) == 0 {
+		return "", nil
+	}
+
+	return msg.Entries[len(msg.Entries)-1].Text, nil
+}
+
+type fakeChatModel struct {
+	f *FakeProvider
+}
+
+func (m fakeChatModel) MaxTokens() int { return 16384 }
+
+func (m fakeChatModel) PredictChat(ctx context.Context, msg chat.Message, options ...llm.PredictOption) (chat.Message, error) {
+	reply, err := m.f.reply(ctx, msg)
+
+	if err != nil {
+		return chat.Message{}, err
+	}
+
+	return chat.Compose(chat.Entry(msn.RoleAI, reply)), nil
+}
+
+func (m fakeChatModel) PredictChatStream(ctx context.Context, msg chat.Message, options ...llm.PredictOption) (chat.MessageStream, error) {
+	reply, err := m.f.reply(ctx, msg)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &staticMessageStream{text: reply}, nil
+}
+
+// staticMessageStream streams a complete reply as a single fragment.
+type staticMessageStream struct {
+	text string
+	done bool
+}
+
+func (s *staticMessageStream) Recv() (chat.MessageFragment, error) {
+	if s.done {
+		return chat.MessageFragment{}, io.EOF
+	}
+
+	s.done = true
+
+	return chat.MessageFragment{Delta: s.text}, nil
+}
+
+func (s *staticMessageStream) Close() error {
+	s.done = true
+
+	return nil
+}
+
+type fakeEmbedder struct{}
+
+func (fakeEmbedder) MaxTokensPerChunk() int { return 2048 }
+
+func (fakeEmbedder) GetEmbeddings(ctx context.Context, chunks []string) ([]llm.Embedding, error) {
+	result := make([]llm.Embedding, len(chunks))
+
+	for i, chunk := range chunks {
+		result[i] = llm.Embedding{Embeddings: fakeEmbedding(chunk)}
+	}
+
+	return result, nil
+}
+
+// fakeEmbedding expands the SHA-256 of text into a normalized vector.
+func fakeEmbedding(text string) []float32 {
+	vec := make([]float32, FakeEmbeddingDimensions)
+	seed := sha256.Sum256([]byte(text))
+	norm := float64(0)
+
+	for i := 0; i < len(vec); i += 8 {
+		block := sha256.Sum256(append(seed[:], byte(i), byte(i>>8)))
+
+		for j := 0; j < 8 && i+j < len(vec); j++ {
+			v := float32(binary.LittleEndian.Uint32(block[j*4:]))/float32(math.MaxUint32)*2 - 1
+			vec[i+j] = v
+			norm += float64(v * v)
+		}
+	}
+
+	norm = math.Sqrt(norm)
+
+	for i := range vec {
+		vec[i] = float32(float64(vec[i]) / norm)
+	}
+
+	return vec
+}
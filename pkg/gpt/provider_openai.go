@@ -0,0 +1,171 @@
+package gpt
+
+import (
+	"context"
+	"math"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/greenboxal/aip/aip-langchain/pkg/llm"
+	"github.com/greenboxal/aip/aip-langchain/pkg/llm/chat"
+	"github.com/greenboxal/aip/aip-langchain/pkg/providers/openai"
+	"github.com/greenboxal/aip/aip-langchain/pkg/tokenizers"
+	goopenai "github.com/sashabaranov/go-openai"
+)
+
+const defaultOpenAIModel = "gpt-3.5-turbo-16k"
+const defaultOpenAITemperature = 1.0
+
+func init() {
+	RegisterProvider(ProviderKindOpenAI, func(cfg ProviderConfig) (Provider, error) {
+		return NewOpenAIProvider(cfg), nil
+	})
+
+	RegisterProvider(ProviderKindOpenAICompatible, func(cfg ProviderConfig) (Provider, error) {
+		return NewOpenAIProvider(cfg), nil
+	})
+}
+
+// OpenAIProvider is a Provider backed by the OpenAI API or by any endpoint implementing the same API.
+type OpenAIProvider struct {
+	cfg ProviderConfig
+
+	client    *openai.Client
+	model     *openAIChatModel
+	embedder  *openai.Embedder
+	tokenizer tokenizers.BasicTokenizer
+}
+
+// NewOpenAIProvider creates a new OpenAIProvider.
+// The API key is taken from the configuration, the OPENAI_API_KEY environment variable,
+// or ~/.openai/api-key, in this order. BaseURL points the client to an OpenAI-compatible endpoint.
+func NewOpenAIProvider(cfg ProviderConfig) *OpenAIProvider {
+	if cfg.Model == "" {
+		cfg.Model = defaultOpenAIModel
+	}
+
+	if cfg.EmbeddingModel == "" {
+		cfg.EmbeddingModel = openai.AdaEmbeddingV2.String()
+	}
+
+	temperature := float32(defaultOpenAITemperature)
+
+	if cfg.Temperature != nil {
+		temperature = *cfg.Temperature
+	}
+
+	clientConfig := goopenai.DefaultConfig(resolveOpenAIKey(cfg.APIKey))
+
+	if cfg.BaseURL != "" {
+		clientConfig.BaseURL = cfg.BaseURL
+	}
+
+	p := &OpenAIProvider{cfg: cfg}
+
+	p.client = &openai.Client{Client: goopenai.NewClientWithConfig(clientConfig)}
+
+	p.model = &openAIChatModel{
+		ChatLanguageModel: &openai.ChatLanguageModel{
+			Client:      p.client,
+			Model:       cfg.Model,
+			Temperature: temperature,
+		},
+
+		maxTokens:   cfg.MaxTokens,
+		temperature: temperature,
+	}
+
+	var embeddingModel openai.EmbeddingModel
+
+	_ = embeddingModel.UnmarshalText([]byte(cfg.EmbeddingModel))
+
+	p.embedder = &openai.Embedder{
+		Client: p.client,
+		Model:  embeddingModel,
+	}
+
+	p.tokenizer = tokenizerForModel(cfg.Model)
+
+	return p
+}
+
+func (p *OpenAIProvider) Name() string                         { return string(p.cfg.Kind) + ":" + p.cfg.Model }
+func (p *OpenAIProvider) Client() *openai.Client               { return p.client }
+func (p *OpenAIProvider) ChatModel() chat.LanguageModel        { return p.model }
+func (p *OpenAIProvider) Embedder() llm.Embedder               { return p.embedder }
+func (p *OpenAIProvider) Tokenizer() tokenizers.BasicTokenizer { return p.tokenizer }
+
+// openAIChatModel allows overriding the context size for models unknown to the OpenAI client,
+// as is the case with most OpenAI-compatible local endpoints, and applies the configured
+// temperature, which the OpenAI client only takes from the predict options.
+type openAIChatModel struct {
+	*openai.ChatLanguageModel
+
+	maxTokens   int
+	temperature float32
+}
+
+func (m *openAIChatModel) PredictChat(ctx context.Context, msg chat.Message, options ...llm.PredictOption) (chat.Message, error) {
+	return m.ChatLanguageModel.PredictChat(ctx, msg, m.predictOptions(options)...)
+}
+
+func (m *openAIChatModel) PredictChatStream(ctx context.Context, msg chat.Message, options ...llm.PredictOption) (chat.MessageStream, error) {
+	return m.ChatLanguageModel.PredictChatStream(ctx, msg, m.predictOptions(options)...)
+}
+
+// predictOptions prepends the configured temperature to options, so callers can still override it.
+// The OpenAI client omits a zero temperature from requests, which the API then treats as the
+// default, so zero is sent as the smallest temperature instead.
+func (m *openAIChatModel) predictOptions(options []llm.PredictOption) []llm.PredictOption {
+	temperature := m.temperature
+
+	if temperature == 0 {
+		temperature = math.SmallestNonzeroFloat32
+	}
+
+	return append([]llm.PredictOption{llm.WithTemperature(temperature)}, options...)
+}
+
+func (m *openAIChatModel) MaxTokens() int {
+	if m.maxTokens != 0 {
+		return m.maxTokens
+	}
+
+	return m.ChatLanguageModel.MaxTokens()
+}
+
+// tokenizerForModel returns the TikToken tokenizer for the given model,
+// falling back to the default model encoding for models TikToken doesn't know about.
+func tokenizerForModel(model string) (result tokenizers.BasicTokenizer) {
+	defer func() {
+		if r := recover(); r != nil {
+			result = tokenizers.TikTokenForModel(defaultOpenAIModel)
+		}
+	}()
+
+	return tokenizers.TikTokenForModel(model)
+}
+
+func resolveOpenAIKey(key string) string {
+	if key != "" {
+		return key
+	}
+
+	if key := os.Getenv("OPENAI_API_KEY"); key != "" {
+		return key
+	}
+
+	home := os.Getenv("HOME")
+
+	if home != "" {
+		p := path.Join(home, ".openai", "api-key")
+		key, err := os.ReadFile(p)
+
+		if err == nil {
+			return strings.TrimSpace(string(key))
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,112 @@
+package gpt
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"testing"
+
+	"github.com/greenboxal/aip/aip-controller/pkg/collective/msn"
+	"github.com/greenboxal/aip/aip-langchain/pkg/llm"
+	"github.com/greenboxal/aip/aip-langchain/pkg/llm/chat"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOpenAIProviderTemperature(t *testing.T) {
+	temperature := func(cfg ProviderConfig) float32 {
+		p := NewOpenAIProvider(cfg)
+
+		return llm.NewPredictOptions(p.model.predictOptions(nil)...).Temperature
+	}
+
+	var unset ProviderConfig
+	require.NoError(t, json.Unmarshal([]byte(`{"kind": "openai"}`), &unset))
+	require.Nil(t, unset.Temperature)
+	require.Equal(t, float32(defaultOpenAITemperature), temperature(unset))
+
+	var zero ProviderConfig
+	require.NoError(t, json.Unmarshal([]byte(`{"kind": "openai", "temperature": 0}`), &zero))
+	require.NotNil(t, zero.Temperature)
+	require.Equal(t, float32(0), *zero.Temperature)
+	require.Equal(t, float32(math.SmallestNonzeroFloat32), temperature(zero))
+
+	var set ProviderConfig
+	require.NoError(t, json.Unmarshal([]byte(`{"kind": "openai", "temperature": 0.2}`), &set))
+	require.Equal(t, float32(0.2), temperature(set))
+
+	// Options passed by the caller override the configured temperature.
+	p := NewOpenAIProvider(set)
+	opts := llm.NewPredictOptions(p.model.predictOptions([]llm.PredictOption{llm.WithTemperature(0.7)})...)
+	require.Equal(t, float32(0.7), opts.Temperature)
+}
+
+func TestConfigurationNewProvider(t *testing.T) {
+	cfg := Configuration{
+		ProviderConfig: ProviderConfig{Kind: ProviderKindFake},
+
+		Chains: map[string]ProviderConfig{
+			"CodeGenerator": {Kind: ProviderKindOpenAI, Model: "gpt-4"},
+		},
+	}
+
+	p, err := cfg.NewProvider()
+	require.NoError(t, err)
+
+	require.Equal(t, "fake", p.Name())
+	require.Equal(t, "fake", ProviderForChain(p, "CommitMessage").Name())
+	require.Equal(t, "openai:gpt-4", ProviderForChain(p, "CodeGenerator").Name())
+
+	_, err = Configuration{ProviderConfig: ProviderConfig{Kind: "unknown"}}.NewProvider()
+	require.Error(t, err)
+
+	_, err = Configuration{
+		ProviderConfig: ProviderConfig{Kind: ProviderKindFake},
+		Chains:         map[string]ProviderConfig{"CodeGenerator": {Kind: "unknown"}},
+	}.NewProvider()
+	require.Error(t, err)
+}
+
+func TestResolveProvider(t *testing.T) {
+	def := NewFakeProvider("default")
+	fromCtx := NewFakeProvider("context")
+	explicit := NewFakeProvider("explicit")
+
+	SetDefaultProvider(def)
+	defer SetDefaultProvider(nil)
+
+	ctx := context.Background()
+
+	require.Same(t, def, ResolveProvider(ctx, nil))
+	require.Same(t, def, ResolveProvider(nil, nil))
+	require.Same(t, fromCtx, ResolveProvider(WithProvider(ctx, fromCtx), nil))
+	require.Same(t, explicit, ResolveProvider(WithProvider(ctx, fromCtx), explicit))
+}
+
+func TestFakeProvider(t *testing.T) {
+	ctx := context.Background()
+	p := NewFakeProvider("first", "second")
+
+	predict := func(text string) string {
+		reply, err := p.ChatModel().PredictChat(ctx, chat.Compose(chat.Entry(msn.RoleUser, text)))
+		require.NoError(t, err)
+
+		return reply.Entries[0].Text
+	}
+
+	require.Equal(t, "first", predict("a"))
+	require.Equal(t, "second", predict("b"))
+	require.Equal(t, "second", predict("c"))
+	require.Len(t, p.Requests, 3)
+	require.Equal(t, "b", p.Requests[1].Entries[0].Text)
+
+	echo := NewFakeProvider()
+	reply, err := echo.ChatModel().PredictChat(ctx, chat.Compose(chat.Entry(msn.RoleUser, "hello")))
+	require.NoError(t, err)
+	require.Equal(t, "hello", reply.Entries[0].Text)
+
+	embeddings, err := p.Embedder().GetEmbeddings(ctx, []string{"a", "a", "b"})
+	require.NoError(t, err)
+	require.Len(t, embeddings[0].Embeddings, FakeEmbeddingDimensions)
+	require.Equal(t, embeddings[0].Embeddings, embeddings[1].Embeddings)
+	require.NotEqual(t, embeddings[0].Embeddings, embeddings[2].Embeddings)
+}
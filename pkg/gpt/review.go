@@ -42,24 +42,29 @@ Commit message description goes in here. It can be multiple lines long.
 `, chain.WithRequiredInput(DocumentKey))),
 )
 
-var ReviewChain = chain.New(
-	chain.WithName("ReviewGenerator"),
+// NewReviewChain creates the review chain using the given provider.
+func NewReviewChain(provider Provider) chain.Chain {
+	return chain.New(
+		chain.WithName("ReviewGenerator"),
 
-	chain.Sequential(
-		chat.Predict(
-			GlobalModel,
-			ReviewPrompt,
+		chain.Sequential(
+			chat.Predict(
+				ProviderForChain(provider, "ReviewGenerator").ChatModel(),
+				ReviewPrompt,
+			),
 		),
-	),
-)
+	)
+}
 
-func PrepareReview(diff string) (string, error) {
+// PrepareReview generates a review for the given diff.
+// If provider is nil, DefaultProvider is used.
+func PrepareReview(provider Provider, diff string) (string, error) {
 	ctx := context.Background()
 	cctx := chain.NewChainContext(ctx)
 
 	cctx.SetInput(DocumentKey, diff)
 
-	if err := ReviewChain.Run(cctx); err != nil {
+	if err := NewReviewChain(ResolveProvider(ctx, provider)).Run(cctx); err != nil {
 		return "", err
 	}
 
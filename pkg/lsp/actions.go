@@ -0,0 +1,290 @@
+package lsp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/pkg/errors"
+
+	"github.com/greenboxal/agibootstrap/pkg/build"
+	"github.com/greenboxal/agibootstrap/pkg/build/codegen"
+	"github.com/greenboxal/agibootstrap/pkg/platform/vfs/repofs"
+	"github.com/greenboxal/agibootstrap/pkg/psi"
+)
+
+// CommandGenerateTodo runs code generation for the TODOs of a scope.
+// Its arguments are the document URI and the PSI path of the scope, relative to the project sources.
+const CommandGenerateTodo = "agib.generateTodo"
+
+// codeActions offers to generate the TODOs of the innermost scope enclosing the start of the range.
+func (s *Server) codeActions(p CodeActionParams) ([]CodeAction, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	result := make([]CodeAction, 0)
+
+	sf, err := s.getSourceFile(p.TextDocument.URI)
+
+	if err != nil || sf.Root() == nil {
+		return result, nil
+	}
+
+	scope := enclosingTodoScope(sf, sf.Root(), p.Range.Start)
+
+	if scope == nil {
+		return result, nil
+	}
+
+	uriArg, err := json.Marshal(p.TextDocument.URI)
+
+	if err != nil {
+		return nil, err
+	}
+
+	pathArg, err := json.Marshal(build.PathFromRoot(s.project.RootNode(), scope).String())
+
+	if err != nil {
+		return nil, err
+	}
+
+	result = append(result, CodeAction{
+		Title: "Generate TODO",
+		Kind:  "refactor.rewrite",
+		Command: &Command{
+			Title:     "Generate TODO",
+			Command:   CommandGenerateTodo,
+			Arguments: []json.RawMessage{uriArg, pathArg},
+		},
+	})
+
+	return result, nil
+}
+
+// enclosingTodoScope returns the innermost container under n that contains pos and has TODOs.
+func enclosingTodoScope(sf psi.SourceFile, n psi.Node, pos Position) psi.Node {
+	for _, child := range n.Children() {
+		if !child.IsContainer() {
+			continue
+		}
+
+		rng, ok := nodeRange(sf, child)
+
+		if !ok || !rng.Contains(pos) {
+			continue
+		}
+
+		if inner := enclosingTodoScope(sf, child, pos); inner != nil {
+			return inner
+		}
+
+		if hasTodos(child) {
+			return child
+		}
+	}
+
+	return nil
+}
+
+func hasTodos(n psi.Node) bool {
+	for _, c := range n.Comments() {
+		if codegen.IsTodoComment(c) {
+			return true
+		}
+	}
+
+	for _, child := range n.Children() {
+		if hasTodos(child) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (s *Server) executeCommand(p ExecuteCommandParams) error {
+	if p.Command != CommandGenerateTodo {
+		return &ResponseError{Code: CodeInvalidParams, Message: "unknown command: " + p.Command}
+	}
+
+	var uri, pathStr string
+
+	if len(p.Arguments) != 2 {
+		return &ResponseError{Code: CodeInvalidParams, Message: "expected the document uri and scope path"}
+	}
+
+	if err := json.Unmarshal(p.Arguments[0], &uri); err != nil {
+		return invalidParams(err)
+	}
+
+	if err := json.Unmarshal(p.Arguments[1], &pathStr); err != nil {
+		return invalidParams(err)
+	}
+
+	scopePath, err := psi.ParsePath(pathStr)
+
+	if err != nil {
+		return invalidParams(err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.generating {
+		return errors.New("code generation is already running")
+	}
+
+	s.generating = true
+
+	// Generation runs in the background, since the message loop has to keep running to receive
+	// the response to workspace/applyEdit.
+	go func() {
+		defer func() {
+			s.mu.Lock()
+			s.generating = false
+			s.mu.Unlock()
+		}()
+
+		if err := s.generateTodo(s.ctx, uri, scopePath); err != nil {
+			s.showMessage(MessageTypeError, fmt.Sprintf("Generate TODO failed: %s", err))
+		}
+	}()
+
+	return nil
+}
+
+// generateTodo runs the codegen build step restricted to scopePath and sends the resulting
+// changes to the client as a workspace edit. Nothing is written to disk.
+func (s *Server) generateTodo(ctx context.Context, uri string, scopePath psi.Path) error {
+	before, after, err := s.runGenerateTodo(ctx, uri, scopePath)
+
+	if err != nil {
+		return err
+	}
+
+	if before == after {
+		s.showMessage(MessageTypeInfo, "Generate TODO made no changes")
+		return nil
+	}
+
+	var result ApplyWorkspaceEditResult
+
+	err = s.conn.Call(ctx, "workspace/applyEdit", ApplyWorkspaceEditParams{
+		Label: "Generate TODO",
+		Edit: WorkspaceEdit{
+			Changes: map[string][]TextEdit{
+				uri: {{
+					Range:   Range{End: endOfText(before)},
+					NewText: after,
+				}},
+			},
+		},
+	}, &result)
+
+	if err == nil && !result.Applied {
+		err = errors.Errorf("edit was not applied: %s", result.FailureReason)
+	}
+
+	if err != nil {
+		// Put the editor contents back so the PSI tree matches the buffer again.
+		unlock := s.lockGraph()
+		s.updateDocument(uri, &before)
+		unlock()
+
+		return err
+	}
+
+	return nil
+}
+
+// runGenerateTodo builds the scope in dry run mode and returns the contents of the document before and
+// after the build. The build takes the graph lock itself, so mu is only held while reading the document.
+func (s *Server) runGenerateTodo(ctx context.Context, uri string, scopePath psi.Path) (before, after string, err error) {
+	overlay, ok := s.project.FS().(*repofs.OverlayFS)
+
+	if !ok {
+		return "", "", build.ErrDryRunRequiresOverlay
+	}
+
+	rel, original, err := s.readDocument(overlay, uri)
+
+	if err != nil {
+		return "", "", err
+	}
+
+	buildDir, err := os.MkdirTemp("", "agib-lsp-")
+
+	if err != nil {
+		return "", "", err
+	}
+
+	defer os.RemoveAll(buildDir)
+
+	builder := build.NewBuilder(s.project, build.Configuration{
+		OutputDirectory: s.project.RootPath(),
+		BuildDirectory:  buildDir,
+
+		BuildSteps: []build.Step{
+			&codegen.BuildStep{},
+		},
+
+		MaxEpochs: 1,
+		DryRun:    true,
+
+		Scope: build.Scope{
+			Paths: []psi.Path{scopePath},
+		},
+	})
+
+	result, err := builder.Build(ctx)
+
+	if err != nil {
+		return "", "", err
+	}
+
+	if len(result.Errors) > 0 {
+		return "", "", multierror.Append(nil, result.Errors...)
+	}
+
+	s.mu.Lock()
+	updated, err := fs.ReadFile(overlay, rel)
+	s.mu.Unlock()
+
+	if err != nil {
+		return "", "", err
+	}
+
+	return original, string(updated), nil
+}
+
+// readDocument returns the path of the document at uri relative to the overlay, and its contents.
+func (s *Server) readDocument(overlay *repofs.OverlayFS, uri string) (rel, contents string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	path, err := s.documentPath(uri)
+
+	if err != nil {
+		return "", "", err
+	}
+
+	rel, err = filepath.Rel(s.project.RootPath(), path)
+
+	if err != nil {
+		return "", "", err
+	}
+
+	rel = filepath.ToSlash(rel)
+
+	data, err := fs.ReadFile(overlay, rel)
+
+	if err != nil {
+		return "", "", err
+	}
+
+	return rel, string(data), nil
+}
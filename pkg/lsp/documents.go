@@ -0,0 +1,167 @@
+package lsp
+
+import (
+	"go/scanner"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/pkg/errors"
+
+	"github.com/greenboxal/agibootstrap/pkg/psi"
+)
+
+var errorPositionRegex = regexp.MustCompile(`(\d+):(\d+)`)
+
+func uriToPath(uri string) (string, error) {
+	u, err := url.Parse(uri)
+
+	if err != nil {
+		return "", err
+	}
+
+	if u.Scheme != "file" {
+		return "", errors.Errorf("unsupported uri scheme: %s", u.Scheme)
+	}
+
+	return filepath.FromSlash(u.Path), nil
+}
+
+func pathToURI(path string) string {
+	return (&url.URL{Scheme: "file", Path: filepath.ToSlash(path)}).String()
+}
+
+// offsetToPosition converts a byte offset in text to an LSP position, which counts UTF-16 code units.
+func offsetToPosition(text string, offset int) Position {
+	var pos Position
+
+	if offset > len(text) {
+		offset = len(text)
+	}
+
+	for _, r := range text[:offset] {
+		if r == '\n' {
+			pos.Line++
+			pos.Character = 0
+		} else {
+			pos.Character += utf16.RuneLen(r)
+		}
+	}
+
+	return pos
+}
+
+// lineColumnToPosition converts a 1-based line and byte column to an LSP position.
+func lineColumnToPosition(text string, line, column int) Position {
+	offset := 0
+
+	for l := 1; l < line && offset < len(text); l++ {
+		next := strings.IndexByte(text[offset:], '\n')
+
+		if next < 0 {
+			offset = len(text)
+			break
+		}
+
+		offset += next + 1
+	}
+
+	offset += column - 1
+
+	if offset < 0 {
+		offset = 0
+	}
+
+	return offsetToPosition(text, offset)
+}
+
+// endOfText returns the position after the last character of text.
+func endOfText(text string) Position {
+	return offsetToPosition(text, len(text))
+}
+
+// sourceFileDiagnostics converts the diagnostics attached to sf, and its parse error if the language
+// published no parser diagnostics for it, into LSP diagnostics.
+// Go scanner errors carry exact positions; other errors are positioned by the first line:column
+// found in their message, or at the start of the file.
+func sourceFileDiagnostics(sf psi.SourceFile) []Diagnostic {
+	result := make([]Diagnostic, 0)
+
+	text := sf.OriginalText()
+	source := string(sf.Language().Name())
+	hasParserDiagnostics := false
+
+	for _, d := range psi.CollectDiagnostics(sf) {
+		var rng Range
+
+		if d.Range.IsValid() {
+			rng.Start = offsetToPosition(text, d.Range.Start.Offset)
+			rng.End = offsetToPosition(text, d.Range.End.Offset)
+		}
+
+		if d.Source == psi.DiagnosticSourceParser {
+			hasParserDiagnostics = true
+		}
+
+		result = append(result, Diagnostic{
+			Range:    rng,
+			Severity: DiagnosticSeverity(d.Severity),
+			Source:   source + "/" + d.Source,
+			Message:  d.Message,
+		})
+	}
+
+	if sf.Error() == nil || hasParserDiagnostics {
+		return result
+	}
+
+	var errs []error
+
+	if merr, ok := sf.Error().(*multierror.Error); ok {
+		errs = merr.Errors
+	} else {
+		errs = []error{sf.Error()}
+	}
+
+	for _, err := range errs {
+		var list scanner.ErrorList
+
+		if errors.As(err, &list) {
+			for _, e := range list {
+				pos := lineColumnToPosition(text, e.Pos.Line, e.Pos.Column)
+
+				result = append(result, Diagnostic{
+					Range:    Range{Start: pos, End: pos},
+					Severity: SeverityError,
+					Source:   source,
+					Message:  e.Msg,
+				})
+			}
+
+			continue
+		}
+
+		var pos Position
+
+		// ANTLR reports "line:column" with 0-based columns.
+		if m := errorPositionRegex.FindStringSubmatch(err.Error()); m != nil {
+			line, _ := strconv.Atoi(m[1])
+			column, _ := strconv.Atoi(m[2])
+
+			pos = lineColumnToPosition(text, line, column+1)
+		}
+
+		result = append(result, Diagnostic{
+			Range:    Range{Start: pos, End: pos},
+			Severity: SeverityError,
+			Source:   source,
+			Message:  err.Error(),
+		})
+	}
+
+	return result
+}
@@ -0,0 +1,243 @@
+package lsp
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/textproto"
+	"strconv"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// JSON-RPC error codes used by the protocol.
+const (
+	CodeParseError     = -32700
+	CodeInvalidRequest = -32600
+	CodeMethodNotFound = -32601
+	CodeInvalidParams  = -32602
+	CodeInternalError  = -32603
+)
+
+// ErrExit is returned by a Handler to stop Run without an error.
+var ErrExit = errors.New("exit")
+
+// ResponseError is the error of a JSON-RPC response.
+type ResponseError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *ResponseError) Error() string {
+	return fmt.Sprintf("jsonrpc error %d: %s", e.Code, e.Message)
+}
+
+// Handler handles an incoming request or notification. The result is ignored for notifications.
+type Handler func(ctx context.Context, method string, params json.RawMessage) (any, error)
+
+type message struct {
+	JSONRPC string           `json:"jsonrpc"`
+	ID      *json.RawMessage `json:"id,omitempty"`
+	Method  string           `json:"method,omitempty"`
+	Params  json.RawMessage  `json:"params,omitempty"`
+	Result  json.RawMessage  `json:"result,omitempty"`
+	Error   *ResponseError   `json:"error,omitempty"`
+}
+
+// Conn is a JSON-RPC 2.0 connection using the LSP base protocol framing (Content-Length headers).
+// Incoming messages are handled in order, so handlers must not block on calls to the peer.
+type Conn struct {
+	r *bufio.Reader
+	w io.Writer
+
+	wmu sync.Mutex
+
+	mu      sync.Mutex
+	nextID  int64
+	pending map[string]chan *message
+}
+
+// NewConn creates a new Conn reading from r and writing to w.
+func NewConn(r io.Reader, w io.Writer) *Conn {
+	return &Conn{
+		r:       bufio.NewReader(r),
+		w:       w,
+		pending: map[string]chan *message{},
+	}
+}
+
+// Run reads and dispatches messages until the reader is closed or ctx is done.
+func (c *Conn) Run(ctx context.Context, handler Handler) error {
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		msg, err := c.read()
+
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+
+			return err
+		}
+
+		if msg.Method == "" {
+			c.dispatchResponse(msg)
+			continue
+		}
+
+		result, err := handler(ctx, msg.Method, msg.Params)
+
+		if errors.Is(err, ErrExit) {
+			return nil
+		}
+
+		if msg.ID == nil {
+			continue
+		}
+
+		reply := &message{ID: msg.ID}
+
+		if err != nil {
+			var rpcErr *ResponseError
+
+			if !errors.As(err, &rpcErr) {
+				rpcErr = &ResponseError{Code: CodeInternalError, Message: err.Error()}
+			}
+
+			reply.Error = rpcErr
+		} else {
+			reply.Result, err = json.Marshal(result)
+
+			if err != nil {
+				return err
+			}
+		}
+
+		if err := c.write(reply); err != nil {
+			return err
+		}
+	}
+}
+
+// Notify sends a notification to the peer.
+func (c *Conn) Notify(method string, params any) error {
+	data, err := json.Marshal(params)
+
+	if err != nil {
+		return err
+	}
+
+	return c.write(&message{Method: method, Params: data})
+}
+
+// Call sends a request to the peer and waits for its response, decoding the result into result if not nil.
+// It must not be called from a Handler, as responses are read by Run.
+func (c *Conn) Call(ctx context.Context, method string, params any, result any) error {
+	data, err := json.Marshal(params)
+
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	c.nextID++
+	rawID := json.RawMessage(strconv.FormatInt(c.nextID, 10))
+	ch := make(chan *message, 1)
+	c.pending[string(rawID)] = ch
+	c.mu.Unlock()
+
+	defer func() {
+		c.mu.Lock()
+		delete(c.pending, string(rawID))
+		c.mu.Unlock()
+	}()
+
+	if err := c.write(&message{ID: &rawID, Method: method, Params: data}); err != nil {
+		return err
+	}
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+
+	case reply := <-ch:
+		if reply.Error != nil {
+			return reply.Error
+		}
+
+		if result == nil || len(reply.Result) == 0 {
+			return nil
+		}
+
+		return json.Unmarshal(reply.Result, result)
+	}
+}
+
+func (c *Conn) dispatchResponse(msg *message) {
+	if msg.ID == nil {
+		return
+	}
+
+	c.mu.Lock()
+	ch := c.pending[string(*msg.ID)]
+	c.mu.Unlock()
+
+	if ch != nil {
+		ch <- msg
+	}
+}
+
+func (c *Conn) read() (*message, error) {
+	header, err := textproto.NewReader(c.r).ReadMIMEHeader()
+
+	if err != nil {
+		return nil, err
+	}
+
+	length, err := strconv.Atoi(header.Get("Content-Length"))
+
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid Content-Length header")
+	}
+
+	data := make([]byte, length)
+
+	if _, err := io.ReadFull(c.r, data); err != nil {
+		return nil, err
+	}
+
+	msg := &message{}
+
+	if err := json.Unmarshal(data, msg); err != nil {
+		return nil, errors.Wrap(err, "invalid message")
+	}
+
+	return msg, nil
+}
+
+func (c *Conn) write(msg *message) error {
+	msg.JSONRPC = "2.0"
+
+	data, err := json.Marshal(msg)
+
+	if err != nil {
+		return err
+	}
+
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	if _, err := fmt.Fprintf(c.w, "Content-Length: %d\r\n\r\n", len(data)); err != nil {
+		return err
+	}
+
+	_, err = c.w.Write(data)
+
+	return err
+}
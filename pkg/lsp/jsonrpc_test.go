@@ -0,0 +1,146 @@
+package lsp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func frame(t *testing.T, v any) string {
+	data, err := json.Marshal(v)
+	require.NoError(t, err)
+
+	return fmt.Sprintf("Content-Length: %d\r\n\r\n%s", len(data), data)
+}
+
+func readFrames(t *testing.T, data []byte) []*message {
+	c := NewConn(bytes.NewReader(data), io.Discard)
+
+	var result []*message
+
+	for {
+		msg, err := c.read()
+
+		if errors.Is(err, io.EOF) {
+			return result
+		}
+
+		require.NoError(t, err)
+
+		result = append(result, msg)
+	}
+}
+
+func TestConnFraming(t *testing.T) {
+	input := frame(t, map[string]any{"jsonrpc": "2.0", "id": 1, "method": "echo", "params": map[string]string{"text": "héllo"}}) +
+		frame(t, map[string]any{"jsonrpc": "2.0", "method": "notify", "params": map[string]string{}}) +
+		frame(t, map[string]any{"jsonrpc": "2.0", "id": "two", "method": "fail"}) +
+		frame(t, map[string]any{"jsonrpc": "2.0", "id": 3, "method": "unknown"})
+
+	var out bytes.Buffer
+	var notified []string
+
+	err := NewConn(bytes.NewBufferString(input), &out).Run(context.Background(), func(ctx context.Context, method string, params json.RawMessage) (any, error) {
+		switch method {
+		case "echo":
+			var p map[string]string
+
+			require.NoError(t, json.Unmarshal(params, &p))
+
+			return p["text"], nil
+
+		case "notify":
+			notified = append(notified, method)
+
+			return "ignored", nil
+
+		case "fail":
+			return nil, errors.New("boom")
+		}
+
+		return nil, &ResponseError{Code: CodeMethodNotFound, Message: "method not found: " + method}
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{"notify"}, notified)
+
+	// Notifications get no response; the others are answered in order with the request id.
+	replies := readFrames(t, out.Bytes())
+	require.Len(t, replies, 3)
+
+	require.Equal(t, "2.0", replies[0].JSONRPC)
+	require.Equal(t, "1", string(*replies[0].ID))
+	require.JSONEq(t, `"héllo"`, string(replies[0].Result))
+	require.Nil(t, replies[0].Error)
+
+	require.Equal(t, `"two"`, string(*replies[1].ID))
+	require.Equal(t, CodeInternalError, replies[1].Error.Code)
+	require.Equal(t, "boom", replies[1].Error.Message)
+
+	require.Equal(t, CodeMethodNotFound, replies[2].Error.Code)
+
+	// Content-Length counts bytes, not characters.
+	r := bufio.NewReader(&out)
+	header, err := r.ReadString('\n')
+	require.NoError(t, err)
+	require.Equal(t, fmt.Sprintf("Content-Length: %d\r\n", len(`{"jsonrpc":"2.0","id":1,"result":"héllo"}`)), header)
+}
+
+func TestConnExitAndInvalidFrames(t *testing.T) {
+	input := frame(t, map[string]any{"jsonrpc": "2.0", "method": "exit"}) +
+		frame(t, map[string]any{"jsonrpc": "2.0", "id": 1, "method": "never"})
+
+	err := NewConn(bytes.NewBufferString(input), io.Discard).Run(context.Background(), func(ctx context.Context, method string, params json.RawMessage) (any, error) {
+		require.Equal(t, "exit", method)
+
+		return nil, ErrExit
+	})
+	require.NoError(t, err)
+
+	err = NewConn(bytes.NewBufferString("Content-Length: abc\r\n\r\n{}"), io.Discard).Run(context.Background(), func(ctx context.Context, method string, params json.RawMessage) (any, error) {
+		return nil, nil
+	})
+	require.Error(t, err)
+
+	err = NewConn(bytes.NewBufferString("Content-Length: 5\r\n\r\n{nope"), io.Discard).Run(context.Background(), func(ctx context.Context, method string, params json.RawMessage) (any, error) {
+		return nil, nil
+	})
+	require.Error(t, err)
+}
+
+func TestConnCall(t *testing.T) {
+	clientIn, serverOut := io.Pipe()
+	serverIn, clientOut := io.Pipe()
+
+	server := NewConn(serverIn, serverOut)
+	client := NewConn(clientIn, clientOut)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go func() {
+		_ = client.Run(ctx, func(ctx context.Context, method string, params json.RawMessage) (any, error) {
+			return map[string]string{"method": method}, nil
+		})
+	}()
+
+	go func() {
+		_ = server.Run(ctx, func(ctx context.Context, method string, params json.RawMessage) (any, error) {
+			return nil, nil
+		})
+	}()
+
+	var result map[string]string
+
+	require.NoError(t, server.Call(ctx, "workspace/applyEdit", map[string]string{}, &result))
+	require.Equal(t, "workspace/applyEdit", result["method"])
+
+	_ = clientOut.Close()
+	_ = serverOut.Close()
+}
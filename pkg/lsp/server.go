@@ -0,0 +1,295 @@
+package lsp
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	"github.com/greenboxal/agibootstrap/pkg/platform/logging"
+	"github.com/greenboxal/agibootstrap/pkg/platform/project"
+	"github.com/greenboxal/agibootstrap/pkg/platform/vfs/repofs"
+	"github.com/greenboxal/agibootstrap/pkg/psi"
+)
+
+// Server is a Language Server Protocol frontend for a project. Open documents are mapped to
+// psi.SourceFile through Project.GetSourceFile.
+//
+// If the project file system is a repofs.OverlayFS, unsaved buffers are written to the overlay
+// so the PSI tree follows the editor. Otherwise documents are only reloaded when saved.
+type Server struct {
+	logger  *zap.SugaredLogger
+	project project.Project
+	conn    *Conn
+
+	ctx context.Context
+
+	// mu guards generating and the documents of the overlay. It is always taken after the write
+	// lock of the project graph, see lockGraph.
+	mu         sync.Mutex
+	generating bool
+}
+
+// NewServer creates a new Server for the project.
+func NewServer(p project.Project) *Server {
+	return &Server{
+		logger:  logging.GetLogger("lsp"),
+		project: p,
+	}
+}
+
+// Serve runs the protocol over r and w until the client exits or ctx is done.
+func (s *Server) Serve(ctx context.Context, r io.Reader, w io.Writer) error {
+	s.ctx = ctx
+	s.conn = NewConn(r, w)
+
+	return s.conn.Run(ctx, s.handle)
+}
+
+func (s *Server) handle(ctx context.Context, method string, params json.RawMessage) (any, error) {
+	// Requests load and read source files, while builds started by code actions mutate them in the background.
+	defer s.lockGraph()()
+
+	switch method {
+	case "initialize":
+		return InitializeResult{
+			Capabilities: ServerCapabilities{
+				TextDocumentSync: &TextDocumentSyncOptions{
+					OpenClose: true,
+					Change:    TextDocumentSyncKindFull,
+					Save:      true,
+				},
+				DocumentSymbolProvider: true,
+				CodeActionProvider:     true,
+				ExecuteCommandProvider: &ExecuteCommandOptions{
+					Commands: []string{CommandGenerateTodo},
+				},
+			},
+			ServerInfo: &ServerInfo{Name: "agib"},
+		}, nil
+
+	case "initialized", "shutdown":
+		return nil, nil
+
+	case "exit":
+		return nil, ErrExit
+
+	case "textDocument/didOpen":
+		var p DidOpenTextDocumentParams
+
+		if err := json.Unmarshal(params, &p); err != nil {
+			return nil, invalidParams(err)
+		}
+
+		s.updateDocument(p.TextDocument.URI, &p.TextDocument.Text)
+
+		return nil, nil
+
+	case "textDocument/didChange":
+		var p DidChangeTextDocumentParams
+
+		if err := json.Unmarshal(params, &p); err != nil {
+			return nil, invalidParams(err)
+		}
+
+		if len(p.ContentChanges) == 0 {
+			return nil, nil
+		}
+
+		s.updateDocument(p.TextDocument.URI, &p.ContentChanges[len(p.ContentChanges)-1].Text)
+
+		return nil, nil
+
+	case "textDocument/didSave":
+		var p DidSaveTextDocumentParams
+
+		if err := json.Unmarshal(params, &p); err != nil {
+			return nil, invalidParams(err)
+		}
+
+		s.updateDocument(p.TextDocument.URI, nil)
+
+		return nil, nil
+
+	case "textDocument/didClose":
+		var p DidCloseTextDocumentParams
+
+		if err := json.Unmarshal(params, &p); err != nil {
+			return nil, invalidParams(err)
+		}
+
+		s.closeDocument(p.TextDocument.URI)
+
+		return nil, nil
+
+	case "textDocument/documentSymbol":
+		var p DocumentSymbolParams
+
+		if err := json.Unmarshal(params, &p); err != nil {
+			return nil, invalidParams(err)
+		}
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		sf, err := s.getSourceFile(p.TextDocument.URI)
+
+		if err != nil {
+			return []DocumentSymbol{}, nil
+		}
+
+		return documentSymbols(sf), nil
+
+	case "textDocument/codeAction":
+		var p CodeActionParams
+
+		if err := json.Unmarshal(params, &p); err != nil {
+			return nil, invalidParams(err)
+		}
+
+		return s.codeActions(p)
+
+	case "workspace/executeCommand":
+		var p ExecuteCommandParams
+
+		if err := json.Unmarshal(params, &p); err != nil {
+			return nil, invalidParams(err)
+		}
+
+		return nil, s.executeCommand(p)
+	}
+
+	if strings.HasPrefix(method, "$/") {
+		return nil, nil
+	}
+
+	return nil, &ResponseError{Code: CodeMethodNotFound, Message: "method not found: " + method}
+}
+
+// lockGraph takes the write lock of the project graph, if any, and returns the function releasing it.
+// The graph lock is always taken before mu, like builds do through psi.UpdateGraph.
+func (s *Server) lockGraph() (unlock func()) {
+	g := s.project.Graph()
+
+	if g == nil {
+		return func() {}
+	}
+
+	g.Lock()
+
+	return g.Unlock
+}
+
+// updateDocument reloads the document at uri, with text as its contents if not nil, and publishes its diagnostics.
+func (s *Server) updateDocument(uri string, text *string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	path, err := s.documentPath(uri)
+
+	if err != nil {
+		return
+	}
+
+	if text != nil {
+		overlay, ok := s.project.FS().(*repofs.OverlayFS)
+
+		if !ok {
+			return
+		}
+
+		if err := overlay.WriteFile(path, []byte(*text)); err != nil {
+			s.logger.Error(err)
+			return
+		}
+	}
+
+	sf, err := s.project.GetSourceFile(path)
+
+	if err != nil {
+		s.logger.Debugw("cannot load document", "uri", uri, "error", err)
+		return
+	}
+
+	if err := sf.Load(); err != nil && sf.Error() == nil {
+		s.logger.Error(err)
+		return
+	}
+
+	s.publishDiagnostics(uri, sourceFileDiagnostics(sf))
+}
+
+// closeDocument drops the unsaved contents of the document at uri and reloads it from disk.
+func (s *Server) closeDocument(uri string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	path, err := s.documentPath(uri)
+
+	if err != nil {
+		return
+	}
+
+	if overlay, ok := s.project.FS().(*repofs.OverlayFS); ok {
+		overlay.Discard(path)
+	}
+
+	if sf, err := s.project.GetSourceFile(path); err == nil {
+		_ = sf.Load()
+	}
+
+	s.publishDiagnostics(uri, []Diagnostic{})
+}
+
+func (s *Server) publishDiagnostics(uri string, diagnostics []Diagnostic) {
+	err := s.conn.Notify("textDocument/publishDiagnostics", PublishDiagnosticsParams{
+		URI:         uri,
+		Diagnostics: diagnostics,
+	})
+
+	if err != nil {
+		s.logger.Error(err)
+	}
+}
+
+func (s *Server) showMessage(typ MessageType, message string) {
+	if err := s.conn.Notify("window/showMessage", ShowMessageParams{Type: typ, Message: message}); err != nil {
+		s.logger.Error(err)
+	}
+}
+
+// documentPath returns the file system path of the document at uri, if it belongs to the project.
+func (s *Server) documentPath(uri string) (string, error) {
+	path, err := uriToPath(uri)
+
+	if err != nil {
+		return "", err
+	}
+
+	rel, err := filepath.Rel(s.project.RootPath(), path)
+
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", errors.Errorf("%s is outside of the project", path)
+	}
+
+	return path, nil
+}
+
+func (s *Server) getSourceFile(uri string) (psi.SourceFile, error) {
+	path, err := s.documentPath(uri)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return s.project.GetSourceFile(path)
+}
+
+func invalidParams(err error) error {
+	return &ResponseError{Code: CodeInvalidParams, Message: err.Error()}
+}
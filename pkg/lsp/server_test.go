@@ -0,0 +1,223 @@
+package lsp
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/greenboxal/agibootstrap/pkg/codex"
+)
+
+const testDocument = `package hello
+
+type Greeter struct {
+	Name string
+}
+
+func (g *Greeter) Greet() string {
+	return "hello " + g.Name
+}
+
+const DefaultName = "world"
+`
+
+type testClient struct {
+	t    *testing.T
+	conn *Conn
+
+	diagnostics chan PublishDiagnosticsParams
+}
+
+// newTestClient serves a project with a single Go file over an in-memory connection.
+func newTestClient(t *testing.T) (*testClient, string) {
+	root := t.TempDir()
+
+	require.NoError(t, os.WriteFile(filepath.Join(root, "go.mod"), []byte("module example.com/hello\n\ngo 1.20\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "hello.go"), []byte(testDocument), 0644))
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	p, err := codex.NewProject(ctx, root, codex.WithOverlayFS())
+	require.NoError(t, err)
+
+	serverIn, clientOut := io.Pipe()
+	clientIn, serverOut := io.Pipe()
+
+	c := &testClient{
+		t:           t,
+		conn:        NewConn(clientIn, clientOut),
+		diagnostics: make(chan PublishDiagnosticsParams, 16),
+	}
+
+	served := make(chan struct{})
+
+	go func() {
+		defer close(served)
+
+		_ = NewServer(p).Serve(ctx, serverIn, serverOut)
+	}()
+
+	go func() {
+		_ = c.conn.Run(ctx, func(ctx context.Context, method string, params json.RawMessage) (any, error) {
+			if method == "textDocument/publishDiagnostics" {
+				var p PublishDiagnosticsParams
+
+				if err := json.Unmarshal(params, &p); err == nil {
+					c.diagnostics <- p
+				}
+			}
+
+			return nil, nil
+		})
+	}()
+
+	t.Cleanup(func() {
+		_ = c.conn.Notify("exit", nil)
+		<-served
+
+		cancel()
+		_ = clientOut.Close()
+		_ = serverOut.Close()
+
+		require.NoError(t, p.Close())
+	})
+
+	return c, pathToURI(filepath.Join(root, "hello.go"))
+}
+
+func (c *testClient) call(method string, params any, result any) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	require.NoError(c.t, c.conn.Call(ctx, method, params, result))
+}
+
+func (c *testClient) notify(method string, params any) {
+	require.NoError(c.t, c.conn.Notify(method, params))
+}
+
+func (c *testClient) nextDiagnostics() PublishDiagnosticsParams {
+	select {
+	case d := <-c.diagnostics:
+		return d
+
+	case <-time.After(30 * time.Second):
+		c.t.Fatal("timed out waiting for diagnostics")
+	}
+
+	return PublishDiagnosticsParams{}
+}
+
+func (c *testClient) documentSymbols(uri string) []DocumentSymbol {
+	var symbols []DocumentSymbol
+
+	c.call("textDocument/documentSymbol", DocumentSymbolParams{TextDocument: TextDocumentIdentifier{URI: uri}}, &symbols)
+
+	return symbols
+}
+
+func symbolNames(symbols []DocumentSymbol) []string {
+	var result []string
+
+	for _, s := range symbols {
+		result = append(result, s.Name)
+		result = append(result, symbolNames(s.Children)...)
+	}
+
+	return result
+}
+
+func findSymbol(symbols []DocumentSymbol, name string) *DocumentSymbol {
+	for i := range symbols {
+		if symbols[i].Name == name {
+			return &symbols[i]
+		}
+
+		if s := findSymbol(symbols[i].Children, name); s != nil {
+			return s
+		}
+	}
+
+	return nil
+}
+
+func TestServerDocumentLifecycle(t *testing.T) {
+	c, uri := newTestClient(t)
+
+	var init InitializeResult
+
+	c.call("initialize", InitializeParams{}, &init)
+	require.True(t, init.Capabilities.DocumentSymbolProvider)
+	require.Equal(t, TextDocumentSyncKindFull, init.Capabilities.TextDocumentSync.Change)
+
+	c.notify("initialized", struct{}{})
+
+	// didOpen loads the buffer and publishes its diagnostics.
+	c.notify("textDocument/didOpen", DidOpenTextDocumentParams{
+		TextDocument: TextDocumentItem{URI: uri, LanguageID: "go", Version: 1, Text: testDocument},
+	})
+
+	d := c.nextDiagnostics()
+	require.Equal(t, uri, d.URI)
+	require.Empty(t, d.Diagnostics)
+
+	symbols := c.documentSymbols(uri)
+	require.ElementsMatch(t, []string{"Greeter", "Greet", "DefaultName"}, symbolNames(symbols))
+
+	greeter := findSymbol(symbols, "Greeter")
+	require.Equal(t, SymbolKindStruct, greeter.Kind)
+	require.Equal(t, 2, greeter.Range.Start.Line)
+
+	greet := findSymbol(symbols, "Greet")
+	require.Equal(t, SymbolKindMethod, greet.Kind)
+	require.Equal(t, "*Greeter", greet.Detail)
+	require.Equal(t, 6, greet.Range.Start.Line)
+
+	require.Equal(t, SymbolKindConstant, findSymbol(symbols, "DefaultName").Kind)
+
+	// didChange replaces the buffer; the symbols follow the unsaved contents.
+	changed := testDocument + "\nfunc Farewell() string {\n\treturn \"bye\"\n}\n"
+
+	c.notify("textDocument/didChange", DidChangeTextDocumentParams{
+		TextDocument:   VersionedTextDocumentIdentifier{URI: uri, Version: 2},
+		ContentChanges: []TextDocumentContentChangeEvent{{Text: changed}},
+	})
+
+	require.Empty(t, c.nextDiagnostics().Diagnostics)
+
+	farewell := findSymbol(c.documentSymbols(uri), "Farewell")
+	require.NotNil(t, farewell)
+	require.Equal(t, SymbolKindFunction, farewell.Kind)
+	require.Equal(t, 12, farewell.Range.Start.Line)
+
+	// Syntax errors are published as diagnostics at their position.
+	c.notify("textDocument/didChange", DidChangeTextDocumentParams{
+		TextDocument:   VersionedTextDocumentIdentifier{URI: uri, Version: 3},
+		ContentChanges: []TextDocumentContentChangeEvent{{Text: "package hello\n\nfunc Broken( {\n"}},
+	})
+
+	d = c.nextDiagnostics()
+	require.NotEmpty(t, d.Diagnostics)
+	require.Equal(t, SeverityError, d.Diagnostics[0].Severity)
+	require.Equal(t, 2, d.Diagnostics[0].Range.Start.Line)
+
+	// didClose drops the buffer and clears the diagnostics.
+	c.notify("textDocument/didClose", DidCloseTextDocumentParams{TextDocument: TextDocumentIdentifier{URI: uri}})
+
+	require.Empty(t, c.nextDiagnostics().Diagnostics)
+	require.ElementsMatch(t, []string{"Greeter", "Greet", "DefaultName"}, symbolNames(c.documentSymbols(uri)))
+
+	// The working tree is never written.
+	path, err := uriToPath(uri)
+	require.NoError(t, err)
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, testDocument, string(data))
+}
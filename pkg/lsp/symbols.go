@@ -0,0 +1,140 @@
+package lsp
+
+import (
+	"go/token"
+
+	"github.com/dave/dst"
+
+	"github.com/greenboxal/agibootstrap/pkg/psi"
+	"github.com/greenboxal/agibootstrap/pkg/psi/langs/golang"
+)
+
+// nodeRange returns the LSP range of n in sf.
+func nodeRange(sf psi.SourceFile, n psi.Node) (Range, bool) {
+	rng, ok := psi.NodeSourceRange(n)
+
+	if !ok {
+		return Range{}, false
+	}
+
+	text := sf.OriginalText()
+
+	return Range{
+		Start: offsetToPosition(text, rng.Start.Offset),
+		End:   offsetToPosition(text, rng.End.Offset),
+	}, true
+}
+
+// documentSymbols builds the symbol tree of sf from its PSI tree.
+func documentSymbols(sf psi.SourceFile) []DocumentSymbol {
+	if sf.Root() == nil {
+		return []DocumentSymbol{}
+	}
+
+	return collectSymbols(sf, sf.Root())
+}
+
+func collectSymbols(sf psi.SourceFile, n psi.Node) []DocumentSymbol {
+	result := make([]DocumentSymbol, 0)
+
+	for _, child := range n.Children() {
+		if !child.IsContainer() {
+			continue
+		}
+
+		symbols := nodeSymbols(sf, child)
+
+		if len(symbols) == 0 {
+			result = append(result, collectSymbols(sf, child)...)
+			continue
+		}
+
+		if len(symbols) == 1 {
+			symbols[0].Children = collectSymbols(sf, child)
+		}
+
+		result = append(result, symbols...)
+	}
+
+	return result
+}
+
+// nodeSymbols returns the symbols declared by n itself. A Go value spec may declare several.
+func nodeSymbols(sf psi.SourceFile, n psi.Node) []DocumentSymbol {
+	rng, _ := nodeRange(sf, n)
+
+	symbol := func(name, detail string, kind SymbolKind) DocumentSymbol {
+		return DocumentSymbol{
+			Name:           name,
+			Detail:         detail,
+			Kind:           kind,
+			Range:          rng,
+			SelectionRange: rng,
+		}
+	}
+
+	gn, ok := n.(golang.Node)
+
+	if !ok {
+		if named, ok := n.(psi.NamedNode); ok {
+			return []DocumentSymbol{symbol(named.PsiNodeName(), "", SymbolKindNamespace)}
+		}
+
+		return nil
+	}
+
+	switch d := gn.Ast().(type) {
+	case *dst.FuncDecl:
+		if d.Recv != nil && len(d.Recv.List) > 0 {
+			return []DocumentSymbol{symbol(d.Name.Name, receiverName(d.Recv.List[0].Type), SymbolKindMethod)}
+		}
+
+		return []DocumentSymbol{symbol(d.Name.Name, "", SymbolKindFunction)}
+
+	case *dst.TypeSpec:
+		kind := SymbolKindClass
+
+		switch d.Type.(type) {
+		case *dst.StructType:
+			kind = SymbolKindStruct
+		case *dst.InterfaceType:
+			kind = SymbolKindInterface
+		}
+
+		return []DocumentSymbol{symbol(d.Name.Name, "", kind)}
+
+	case *dst.ValueSpec:
+		kind := SymbolKindVariable
+
+		if parent, ok := n.Parent().(golang.Node); ok {
+			if decl, ok := parent.Ast().(*dst.GenDecl); ok && decl.Tok == token.CONST {
+				kind = SymbolKindConstant
+			}
+		}
+
+		var result []DocumentSymbol
+
+		for _, name := range d.Names {
+			result = append(result, symbol(name.Name, "", kind))
+		}
+
+		return result
+	}
+
+	return nil
+}
+
+func receiverName(expr dst.Expr) string {
+	switch e := expr.(type) {
+	case *dst.StarExpr:
+		return "*" + receiverName(e.X)
+	case *dst.Ident:
+		return e.Name
+	case *dst.IndexExpr:
+		return receiverName(e.X)
+	case *dst.IndexListExpr:
+		return receiverName(e.X)
+	}
+
+	return ""
+}
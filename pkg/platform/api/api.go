@@ -1 +1,323 @@
 package api
+
+import (
+	"context"
+	"encoding/json"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	"github.com/greenboxal/agibootstrap/pkg/platform/db/graphstore"
+	"github.com/greenboxal/agibootstrap/pkg/platform/db/thoughtstream"
+	"github.com/greenboxal/agibootstrap/pkg/platform/logging"
+	"github.com/greenboxal/agibootstrap/pkg/platform/tasks"
+	"github.com/greenboxal/agibootstrap/pkg/psi"
+)
+
+// Server exposes the PSI graph, the task manager and the thought streams of a project over HTTP.
+//
+// Routes:
+//
+//	GET    /v1/nodes?path=            node attributes, children and edges
+//	GET    /v1/nodes/children?path=   paths of the node children
+//	GET    /v1/nodes/edges?path=      edges of the node
+//	GET    /v1/nodes/events?path=     WebSocket streaming the changes of the node and its descendants
+//	GET    /v1/tasks                  tasks spawned through the API
+//	POST   /v1/tasks                  spawn a registered task kind
+//	GET    /v1/tasks/{id}             task status
+//	DELETE /v1/tasks/{id}             cancel a task
+//	GET    /v1/thoughts/stream?path=  WebSocket streaming the thoughts of a log or branch
+//
+// Paths are PSI paths relative to the graph root. The root UUID component may be omitted.
+// Requests sent to a host other than a loopback name or the listen address, or sent by browsers from
+// another origin, are rejected, and POST bodies must be JSON.
+type Server struct {
+	logger *zap.SugaredLogger
+
+	g  *graphstore.IndexedGraph
+	tm *tasks.Manager
+	lm *thoughtstream.Manager
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	router chi.Router
+
+	// addr is the address passed to ListenAndServe, set before serving.
+	addr string
+
+	mu        sync.RWMutex
+	factories map[string]TaskFactory
+	// tasks are the tasks spawned through the API, evicted finishedTaskRetention after they finish.
+	tasks map[string]*taskEntry
+}
+
+// NewServer creates a new Server. tm and lm may be nil, disabling the task and thought endpoints.
+func NewServer(g *graphstore.IndexedGraph, tm *tasks.Manager, lm *thoughtstream.Manager) *Server {
+	s := &Server{
+		logger: logging.GetLogger("api"),
+
+		g:  g,
+		tm: tm,
+		lm: lm,
+
+		factories: map[string]TaskFactory{},
+		tasks:     map[string]*taskEntry{},
+	}
+
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+
+	r := chi.NewRouter()
+	r.Use(middleware.Recoverer)
+	r.Use(s.rejectCrossOrigin)
+
+	r.Route("/v1", func(r chi.Router) {
+		r.Group(func(r chi.Router) {
+			r.Use(s.readLockGraph)
+
+			r.Get("/nodes", s.handleGetNode)
+			r.Get("/nodes/children", s.handleGetNodeChildren)
+			r.Get("/nodes/edges", s.handleGetNodeEdges)
+		})
+
+		r.Get("/nodes/events", s.handleStreamNodeEvents)
+
+		r.Get("/tasks", s.handleListTasks)
+		r.Post("/tasks", s.handleSpawnTask)
+		r.Get("/tasks/{id}", s.handleGetTask)
+		r.Delete("/tasks/{id}", s.handleCancelTask)
+
+		r.Get("/thoughts/stream", s.handleStreamThoughts)
+	})
+
+	s.router = r
+
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.router.ServeHTTP(w, r)
+}
+
+// ListenAndServe serves the API on addr until ctx is done.
+func (s *Server) ListenAndServe(ctx context.Context, addr string) error {
+	srv := &http.Server{
+		Addr:    addr,
+		Handler: s,
+	}
+
+	go func() {
+		<-ctx.Done()
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		_ = srv.Shutdown(shutdownCtx)
+	}()
+
+	s.addr = addr
+
+	s.logger.Infow("serving api", "addr", addr)
+
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+
+	return nil
+}
+
+// Close cancels every task spawned through the API and closes open streams.
+func (s *Server) Close() error {
+	s.cancel()
+
+	return nil
+}
+
+// readLockGraph holds the read lock of the graph while serving the request.
+func (s *Server) readLockGraph(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.g.RLock()
+		defer s.g.RUnlock()
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// rejectCrossOrigin rejects the requests made by a page served from another origin, so that any
+// web site opened in a browser on the same machine cannot drive the API. Requests for another host
+// are rejected too, since a page can rebind its own name to a loopback address and be same-origin.
+func (s *Server) rejectCrossOrigin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.allowedHost(r.Host) {
+			writeError(w, http.StatusForbidden, errors.Errorf("unexpected host %q", r.Host))
+			return
+		}
+
+		if !sameOrigin(r) {
+			writeError(w, http.StatusForbidden, errors.New("cross-origin requests are not allowed"))
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// allowedHost returns true if host, with or without a port, is a loopback name or address, or the
+// host of the listen address.
+func (s *Server) allowedHost(host string) bool {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
+
+	if ip := net.ParseIP(host); ip != nil && ip.IsLoopback() {
+		return true
+	}
+
+	listenHost, _, err := net.SplitHostPort(s.addr)
+
+	if err != nil || listenHost == "" {
+		return false
+	}
+
+	if ip := net.ParseIP(listenHost); ip != nil && ip.IsUnspecified() {
+		return false
+	}
+
+	return strings.EqualFold(host, listenHost)
+}
+
+// sameOrigin returns true if the request has no Origin header, as sent by non-browser clients,
+// or if its Origin matches the host the request was sent to.
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+
+	if origin == "" {
+		return true
+	}
+
+	u, err := url.Parse(origin)
+
+	if err != nil {
+		return false
+	}
+
+	return strings.EqualFold(u.Host, r.Host)
+}
+
+// requireJSON writes the error response and returns false if the request body is not JSON.
+func requireJSON(w http.ResponseWriter, r *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+
+	if err != nil || mediaType != "application/json" {
+		writeError(w, http.StatusUnsupportedMediaType, errors.New("the request body must be application/json"))
+		return false
+	}
+
+	return true
+}
+
+// resolvePath parses str and resolves it from the graph root.
+// Unlike psi.ResolvePath, it fails instead of returning the deepest node found.
+func (s *Server) resolvePath(str string) (psi.Node, error) {
+	var components []psi.PathElement
+
+	if str != "" && str != "/" {
+		p, err := psi.ParsePath(str)
+
+		if err != nil {
+			return nil, err
+		}
+
+		components = p.Components()
+	}
+
+	if root, err := s.g.ResolveNode(psi.PathFromComponents()); err == nil && len(components) > 0 {
+		if components[0].Kind == psi.EdgeKindChild && components[0].Name == root.UUID() {
+			components = components[1:]
+		}
+	}
+
+	p := psi.PathFromComponents(components...)
+
+	n, err := s.g.ResolveNode(p)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(components) > 0 {
+		parent, err := s.g.ResolveNode(p.Parent())
+
+		if err != nil {
+			return nil, err
+		}
+
+		last := components[len(components)-1]
+
+		if last.Kind != psi.EdgeKindSelf && last.Kind != psi.EdgeKindParent && parent.ResolveChild(last) != n {
+			return nil, psi.ErrNodeNotFound
+		}
+	}
+
+	return n, nil
+}
+
+// resolveRequestNode resolves the node at the path query parameter, writing the error response if it fails.
+func (s *Server) resolveRequestNode(w http.ResponseWriter, r *http.Request) (psi.Node, bool) {
+	n, err := s.resolvePath(r.URL.Query().Get("path"))
+
+	if errors.Is(err, psi.ErrNodeNotFound) {
+		writeError(w, http.StatusNotFound, err)
+
+		return nil, false
+	} else if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+
+		return nil, false
+	}
+
+	return n, true
+}
+
+// pathOf returns the canonical path of n without the root UUID component.
+func (s *Server) pathOf(n psi.Node) string {
+	return s.formatPath(n.CanonicalPath())
+}
+
+// formatPath returns p without the root UUID component.
+func (s *Server) formatPath(p psi.Path) string {
+	components := p.Components()
+
+	if root, err := s.g.ResolveNode(psi.PathFromComponents()); err == nil && len(components) > 0 {
+		if components[0].Name == root.UUID() {
+			components = components[1:]
+		}
+	}
+
+	return psi.PathFromComponents(components...).String()
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
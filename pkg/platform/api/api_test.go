@@ -0,0 +1,74 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/greenboxal/agibootstrap/pkg/platform/tasks"
+)
+
+func TestServerRejectsCrossSiteRequests(t *testing.T) {
+	s := NewServer(nil, tasks.NewManager(), nil)
+	defer s.Close()
+
+	s.RegisterTask("noop", func(ctx context.Context, args json.RawMessage) (tasks.TaskFunc, error) {
+		return func(progress tasks.TaskProgress) error { return nil }, nil
+	})
+
+	do := func(host, origin, contentType string) int {
+		req := httptest.NewRequest(http.MethodPost, "http://"+host+"/v1/tasks", strings.NewReader(`{"kind": "noop"}`))
+
+		if origin != "" {
+			req.Header.Set("Origin", origin)
+		}
+
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, req)
+
+		return w.Code
+	}
+
+	require.Equal(t, http.StatusCreated, do("localhost:8080", "", "application/json"))
+	require.Equal(t, http.StatusCreated, do("localhost:8080", "http://localhost:8080", "application/json; charset=utf-8"))
+	require.Equal(t, http.StatusCreated, do("127.0.0.1:8080", "http://127.0.0.1:8080", "application/json"))
+	require.Equal(t, http.StatusCreated, do("[::1]:8080", "", "application/json"))
+	require.Equal(t, http.StatusForbidden, do("localhost:8080", "http://evil.example", "application/json"))
+	require.Equal(t, http.StatusUnsupportedMediaType, do("localhost:8080", "", "text/plain"))
+	require.Equal(t, http.StatusUnsupportedMediaType, do("localhost:8080", "", ""))
+
+	// DNS rebinding: a page whose name resolves to the loopback address is same-origin with itself.
+	require.Equal(t, http.StatusForbidden, do("evil.example:8080", "http://evil.example:8080", "application/json"))
+	require.Equal(t, http.StatusForbidden, do("evil.example:8080", "", "application/json"))
+}
+
+func TestServerAllowsListenHost(t *testing.T) {
+	testCases := []struct {
+		addr, host string
+		expected   bool
+	}{
+		{addr: "", host: "localhost", expected: true},
+		{addr: "", host: "127.0.0.2:80", expected: true},
+		{addr: "", host: "example.com", expected: false},
+		{addr: "devbox:8080", host: "devbox:8080", expected: true},
+		{addr: "devbox:8080", host: "other:8080", expected: false},
+		{addr: "192.168.1.2:8080", host: "192.168.1.2:8080", expected: true},
+		{addr: "0.0.0.0:8080", host: "0.0.0.0:8080", expected: false},
+		{addr: ":8080", host: "example.com:8080", expected: false},
+	}
+
+	for _, tc := range testCases {
+		s := &Server{addr: tc.addr}
+
+		require.Equal(t, tc.expected, s.allowedHost(tc.host), "%s on %s", tc.host, tc.addr)
+	}
+}
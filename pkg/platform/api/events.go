@@ -0,0 +1,126 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/samber/lo"
+
+	"github.com/greenboxal/agibootstrap/pkg/psi"
+)
+
+type NodeEventView struct {
+	Kind       psi.GraphEventKind `json:"kind"`
+	Path       string             `json:"path"`
+	UUID       string             `json:"uuid"`
+	Type       string             `json:"type,omitempty"`
+	OldVersion int64              `json:"old_version"`
+	NewVersion int64              `json:"new_version"`
+	Edge       *EdgeView          `json:"edge,omitempty"`
+}
+
+// handleStreamNodeEvents streams the changes of the node at the path query parameter and its
+// descendants over a WebSocket. The type, edge and kind query parameters, which may be repeated,
+// restrict the events to the given node types, edge kinds and event kinds.
+func (s *Server) handleStreamNodeEvents(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	filter := psi.GraphEventFilter{
+		NodeTypes: query["type"],
+		EdgeKinds: lo.Map(query["edge"], func(k string, _ int) psi.EdgeKind { return psi.EdgeKind(k) }),
+		Kinds:     lo.Map(query["kind"], func(k string, _ int) psi.GraphEventKind { return psi.GraphEventKind(k) }),
+	}
+
+	s.g.RLock()
+	n, ok := s.resolveRequestNode(w, r)
+
+	if ok {
+		filter.PathPrefix = n.CanonicalPath()
+	}
+	s.g.RUnlock()
+
+	if !ok {
+		return
+	}
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+
+	if err != nil {
+		return
+	}
+
+	defer conn.Close()
+
+	events := s.g.Subscribe(filter)
+	defer s.g.Unsubscribe(events)
+
+	closed := make(chan struct{})
+
+	go func() {
+		defer close(closed)
+
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+
+		case <-closed:
+			return
+
+		case ev, ok := <-events:
+			if !ok {
+				_ = conn.WriteControl(
+					websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "client too slow"),
+					time.Now().Add(thoughtStreamWriteTimeout),
+				)
+
+				return
+			}
+
+			_ = conn.SetWriteDeadline(time.Now().Add(thoughtStreamWriteTimeout))
+
+			if err := conn.WriteJSON(s.nodeEventView(ev)); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (s *Server) nodeEventView(ev psi.GraphEvent) NodeEventView {
+	s.g.RLock()
+	defer s.g.RUnlock()
+
+	view := NodeEventView{
+		Kind:       ev.Kind,
+		Path:       s.formatPath(ev.Path),
+		UUID:       ev.Node.UUID(),
+		Type:       psi.NodeTypeName(ev.Node),
+		OldVersion: ev.OldVersion,
+		NewVersion: ev.NewVersion,
+	}
+
+	if ev.Edge != nil {
+		key := ev.Edge.Key().GetKey()
+
+		view.Edge = &EdgeView{
+			Kind:  string(key.Kind),
+			Name:  key.Name,
+			Index: key.Index,
+		}
+
+		if to := ev.Edge.To(); to != nil {
+			view.Edge.To = s.pathOf(to)
+		}
+	}
+
+	return view
+}
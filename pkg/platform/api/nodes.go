@@ -0,0 +1,101 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/greenboxal/agibootstrap/pkg/psi"
+)
+
+type NodeView struct {
+	Path       string         `json:"path"`
+	UUID       string         `json:"uuid"`
+	Type       string         `json:"type,omitempty"`
+	Version    int64          `json:"version"`
+	Attributes map[string]any `json:"attributes,omitempty"`
+	Children   []string       `json:"children"`
+	Edges      []EdgeView     `json:"edges"`
+}
+
+type EdgeView struct {
+	Kind  string `json:"kind"`
+	Name  string `json:"name,omitempty"`
+	Index int64  `json:"index,omitempty"`
+	To    string `json:"to,omitempty"`
+}
+
+func (s *Server) handleGetNode(w http.ResponseWriter, r *http.Request) {
+	n, ok := s.resolveRequestNode(w, r)
+
+	if !ok {
+		return
+	}
+
+	view := NodeView{
+		Path:       s.pathOf(n),
+		UUID:       n.UUID(),
+		Version:    n.PsiNodeVersion(),
+		Attributes: n.Attributes(),
+		Children:   s.childrenOf(n),
+		Edges:      s.edgesOf(n),
+	}
+
+	if typ := n.PsiNodeType(); typ != nil {
+		view.Type = typ.Name()
+	}
+
+	writeJSON(w, http.StatusOK, view)
+}
+
+func (s *Server) handleGetNodeChildren(w http.ResponseWriter, r *http.Request) {
+	n, ok := s.resolveRequestNode(w, r)
+
+	if !ok {
+		return
+	}
+
+	writeJSON(w, http.StatusOK, s.childrenOf(n))
+}
+
+func (s *Server) handleGetNodeEdges(w http.ResponseWriter, r *http.Request) {
+	n, ok := s.resolveRequestNode(w, r)
+
+	if !ok {
+		return
+	}
+
+	writeJSON(w, http.StatusOK, s.edgesOf(n))
+}
+
+func (s *Server) childrenOf(n psi.Node) []string {
+	children := n.Children()
+	result := make([]string, len(children))
+
+	for i, c := range children {
+		result[i] = s.pathOf(c)
+	}
+
+	return result
+}
+
+func (s *Server) edgesOf(n psi.Node) []EdgeView {
+	result := make([]EdgeView, 0)
+
+	for it := n.Edges(); it.Next(); {
+		e := it.Edge()
+		key := e.Key().GetKey()
+
+		view := EdgeView{
+			Kind:  string(key.Kind),
+			Name:  key.Name,
+			Index: key.Index,
+		}
+
+		if to := e.To(); to != nil {
+			view.To = s.pathOf(to)
+		}
+
+		result = append(result, view)
+	}
+
+	return result
+}
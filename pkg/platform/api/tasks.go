@@ -0,0 +1,194 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/pkg/errors"
+
+	"github.com/greenboxal/agibootstrap/pkg/platform/tasks"
+)
+
+// finishedTaskRetention is how long a finished task stays listed, so clients polling it can see its result.
+const finishedTaskRetention = 10 * time.Minute
+
+// TaskFactory creates the function of a task spawned through the API from the request arguments.
+type TaskFactory func(ctx context.Context, args json.RawMessage) (tasks.TaskFunc, error)
+
+type SpawnTaskRequest struct {
+	Kind string          `json:"kind"`
+	Args json.RawMessage `json:"args,omitempty"`
+}
+
+type TaskView struct {
+	ID        string    `json:"id"`
+	Kind      string    `json:"kind"`
+	Progress  float64   `json:"progress"`
+	Completed bool      `json:"completed"`
+	Error     string    `json:"error,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type taskEntry struct {
+	kind      string
+	task      tasks.Task
+	createdAt time.Time
+}
+
+// RegisterTask makes the task kind available to POST /v1/tasks.
+func (s *Server) RegisterTask(kind string, factory TaskFactory) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.factories[kind] = factory
+}
+
+func (s *Server) handleListTasks(w http.ResponseWriter, r *http.Request) {
+	s.mu.RLock()
+	result := make([]TaskView, 0, len(s.tasks))
+
+	for _, entry := range s.tasks {
+		result = append(result, s.taskView(entry))
+	}
+	s.mu.RUnlock()
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].CreatedAt.Before(result[j].CreatedAt)
+	})
+
+	writeJSON(w, http.StatusOK, result)
+}
+
+func (s *Server) handleSpawnTask(w http.ResponseWriter, r *http.Request) {
+	if s.tm == nil {
+		writeError(w, http.StatusNotImplemented, errors.New("tasks are not available"))
+		return
+	}
+
+	if !requireJSON(w, r) {
+		return
+	}
+
+	var req SpawnTaskRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	s.mu.RLock()
+	factory := s.factories[req.Kind]
+	s.mu.RUnlock()
+
+	if factory == nil {
+		writeError(w, http.StatusBadRequest, errors.Errorf("unknown task kind: %s", req.Kind))
+		return
+	}
+
+	fn, err := factory(r.Context(), req.Args)
+
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	// Tasks outlive the request, so they are bound to the server context instead.
+	entry := &taskEntry{
+		kind:      req.Kind,
+		task:      s.tm.SpawnTask(s.ctx, fn),
+		createdAt: time.Now(),
+	}
+
+	s.mu.Lock()
+	s.tasks[entry.task.UUID()] = entry
+	s.mu.Unlock()
+
+	go s.evictTask(entry)
+
+	writeJSON(w, http.StatusCreated, s.taskView(entry))
+}
+
+func (s *Server) handleGetTask(w http.ResponseWriter, r *http.Request) {
+	entry, ok := s.getTask(w, r)
+
+	if !ok {
+		return
+	}
+
+	writeJSON(w, http.StatusOK, s.taskView(entry))
+}
+
+func (s *Server) handleCancelTask(w http.ResponseWriter, r *http.Request) {
+	entry, ok := s.getTask(w, r)
+
+	if !ok {
+		return
+	}
+
+	entry.task.Cancel()
+
+	writeJSON(w, http.StatusAccepted, s.taskView(entry))
+}
+
+func (s *Server) getTask(w http.ResponseWriter, r *http.Request) (*taskEntry, bool) {
+	id := chi.URLParam(r, "id")
+
+	s.mu.RLock()
+	entry := s.tasks[id]
+	s.mu.RUnlock()
+
+	if entry == nil {
+		writeError(w, http.StatusNotFound, errors.Errorf("task not found: %s", id))
+		return nil, false
+	}
+
+	return entry, true
+}
+
+// evictTask removes entry from the tasks listed by the API once it has been finished for finishedTaskRetention.
+func (s *Server) evictTask(entry *taskEntry) {
+	select {
+	case <-entry.task.Done():
+	case <-s.ctx.Done():
+		return
+	}
+
+	timer := time.NewTimer(finishedTaskRetention)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+	case <-s.ctx.Done():
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.tasks, entry.task.UUID())
+}
+
+func (s *Server) taskView(entry *taskEntry) TaskView {
+	t := entry.task
+
+	view := TaskView{
+		ID:        t.UUID(),
+		Kind:      entry.kind,
+		Progress:  t.Progress(),
+		Completed: t.IsCompleted(),
+		CreatedAt: entry.createdAt,
+	}
+
+	// Error blocks until the task completes.
+	if view.Completed {
+		if err := t.Error(); err != nil {
+			view.Error = err.Error()
+		}
+	}
+
+	return view
+}
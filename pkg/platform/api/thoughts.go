@@ -0,0 +1,234 @@
+package api
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/pkg/errors"
+
+	"github.com/greenboxal/agibootstrap/pkg/platform/db/thoughtstream"
+	"github.com/greenboxal/agibootstrap/pkg/platform/stdlib/obsfx/collectionsfx"
+	"github.com/greenboxal/agibootstrap/pkg/psi"
+)
+
+// thoughtStreamBuffer bounds the thoughts queued for a slow client before the stream is dropped.
+const thoughtStreamBuffer = 256
+
+const thoughtStreamWriteTimeout = 10 * time.Second
+
+var upgrader = websocket.Upgrader{
+	CheckOrigin: sameOrigin,
+}
+
+type ThoughtView struct {
+	Path    string                    `json:"path"`
+	Pointer thoughtstream.Pointer     `json:"pointer"`
+	From    thoughtstream.CommHandle  `json:"from"`
+	Text    string                    `json:"text"`
+	ReplyTo *thoughtstream.CommHandle `json:"reply_to,omitempty"`
+}
+
+// thoughtListener forwards the thoughts added to a node to a stream.
+// It is a pointer so it can be removed from the children list again.
+type thoughtListener struct {
+	ch       chan *thoughtstream.Thought
+	dropped  chan struct{}
+	dropOnce sync.Once
+}
+
+func (l *thoughtListener) OnListChanged(ev collectionsfx.ListChangeEvent[psi.Node]) {
+	for ev.Next() {
+		if !ev.WasAdded() || ev.WasPermutated() {
+			continue
+		}
+
+		for _, n := range ev.AddedSlice() {
+			t, ok := n.(*thoughtstream.Thought)
+
+			if !ok {
+				continue
+			}
+
+			select {
+			case l.ch <- t:
+			default:
+				l.dropOnce.Do(func() { close(l.dropped) })
+			}
+		}
+	}
+}
+
+// handleStreamThoughts streams the thoughts of the log or branch at the path query parameter, or of the
+// log named by the name query parameter, over a WebSocket. The existing thoughts are sent first, followed
+// by every thought pushed while the connection is open.
+func (s *Server) handleStreamThoughts(w http.ResponseWriter, r *http.Request) {
+	var n psi.Node
+
+	_ = psi.ReadGraph(s.g, func() error {
+		n = s.resolveThoughtStream(w, r)
+
+		return nil
+	})
+
+	if n == nil {
+		return
+	}
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+
+	if err != nil {
+		return
+	}
+
+	defer conn.Close()
+
+	listener := &thoughtListener{
+		ch:      make(chan *thoughtstream.Thought, thoughtStreamBuffer),
+		dropped: make(chan struct{}),
+	}
+
+	var existing []ThoughtView
+
+	// Existing thoughts are read after registering the listener so none is missed.
+	// Thoughts pushed in between may be sent twice, which clients detect by path.
+	_ = psi.ReadGraph(s.g, func() error {
+		n.ChildrenList().AddListListener(listener)
+
+		for _, t := range existingThoughts(n) {
+			existing = append(existing, s.thoughtView(t))
+		}
+
+		return nil
+	})
+
+	defer func() {
+		_ = psi.ReadGraph(s.g, func() error {
+			n.ChildrenList().RemoveListListener(listener)
+
+			return nil
+		})
+	}()
+
+	for _, view := range existing {
+		if err := writeThought(conn, view); err != nil {
+			return
+		}
+	}
+
+	closed := make(chan struct{})
+
+	go func() {
+		defer close(closed)
+
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+
+		case <-closed:
+			return
+
+		case <-listener.dropped:
+			_ = conn.WriteControl(
+				websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "client too slow"),
+				time.Now().Add(thoughtStreamWriteTimeout),
+			)
+
+			return
+
+		case t := <-listener.ch:
+			var view ThoughtView
+
+			_ = psi.ReadGraph(s.g, func() error {
+				view = s.thoughtView(t)
+
+				return nil
+			})
+
+			if err := writeThought(conn, view); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// resolveThoughtStream resolves the node named by the request, writing the error response if it fails.
+// It must be called with the read lock of the graph held.
+func (s *Server) resolveThoughtStream(w http.ResponseWriter, r *http.Request) psi.Node {
+	name := r.URL.Query().Get("name")
+
+	if name == "" {
+		n, _ := s.resolveRequestNode(w, r)
+
+		return n
+	}
+
+	if s.lm == nil {
+		writeError(w, http.StatusNotImplemented, errors.New("thought streams are not available"))
+		return nil
+	}
+
+	n := s.lm.ResolveChild(psi.PathElement{Kind: psi.EdgeKindChild, Name: name})
+
+	if n == nil {
+		writeError(w, http.StatusNotFound, errors.Errorf("thought stream not found: %s", name))
+		return nil
+	}
+
+	return n
+}
+
+// thoughtView must be called with the read lock of the graph held.
+func (s *Server) thoughtView(t *thoughtstream.Thought) ThoughtView {
+	return ThoughtView{
+		Path:    s.pathOf(t),
+		Pointer: t.Pointer,
+		From:    t.From,
+		Text:    t.Text,
+		ReplyTo: t.ReplyTo,
+	}
+}
+
+func writeThought(conn *websocket.Conn, view ThoughtView) error {
+	if err := conn.SetWriteDeadline(time.Now().Add(thoughtStreamWriteTimeout)); err != nil {
+		return err
+	}
+
+	return conn.WriteJSON(view)
+}
+
+func existingThoughts(n psi.Node) []*thoughtstream.Thought {
+	switch n := n.(type) {
+	case *thoughtstream.ThoughtLog:
+		return append([]*thoughtstream.Thought(nil), n.Messages()...)
+
+	case thoughtstream.Branch:
+		var result []*thoughtstream.Thought
+
+		for it := n.Stream(); it.Next(); {
+			result = append(result, it.Value())
+		}
+
+		return result
+	}
+
+	var result []*thoughtstream.Thought
+
+	for _, c := range n.Children() {
+		if t, ok := c.(*thoughtstream.Thought); ok {
+			result = append(result, t)
+		}
+	}
+
+	return result
+}
@@ -1,5 +1,7 @@
 package fti
 
+import "github.com/greenboxal/agibootstrap/pkg/gpt"
+
 var defaultConfig = Config{
 	Embedding: struct {
 		Provider string `json:"provider"`
@@ -21,6 +23,9 @@ type Config struct {
 	} `json:"embedding"`
 
 	ChunkSpecs []ChunkSpec `json:"chunk_specs"`
+
+	// LLM configures the language model providers used by the project.
+	LLM gpt.Configuration `json:"llm"`
 }
 
 type ChunkSpec struct {
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"strconv"
 	"sync"
 
@@ -74,8 +75,9 @@ func NewOnlineIndex(repo *Repository) (*OnlineIndex, error) {
 // The function initializes a write lock, which ensures the thread-safety of the online index.
 // The function then calculates the base index as the total number of entries in the index.
 // For each embedding in the image, the function creates an OnlineIndexEntry, which holds the index, chunk, and embedding of the image.
-// It then calls the putEntry() function to store the entry in the repository.
+// It then calls the putEntry() function to store the entry in memory.
 // Finally, it adds the embedding to the faiss index.
+// The changes are only written to disk by Save.
 // If any error occurs during the process, it returns the error. Otherwise, it returns nil.
 func (oi *OnlineIndex) Add(img *ObjectSnapshotImage) error {
 	oi.m.Lock()
@@ -112,52 +114,34 @@ func (oi *OnlineIndex) Query(q llm.Embedding, k int64) ([]OnlineIndexQueryHit, e
 		return nil, err
 	}
 
-	hits := make([]OnlineIndexQueryHit, len(indices))
+	hits := make([]OnlineIndexQueryHit, 0, len(indices))
 
 	for i, idx := range indices {
+		// faiss pads the results with -1 when the index has less than k entries.
+		if idx < 0 {
+			continue
+		}
+
 		entry, err := oi.lookupEntry(idx)
 
 		if err != nil {
 			return nil, err
 		}
 
-		hits[i] = OnlineIndexQueryHit{
+		hits = append(hits, OnlineIndexQueryHit{
 			Entry:    entry,
 			Distance: distances[i],
-		}
+		})
 	}
 
 	return hits, nil
 }
 
-// putEntry stores the given entry in the index with the specified index ID.
-//
-// The function takes an index ID and an entry as input and stores the entry in the index.
-// It first resolves the path to the index directory using the provided repository.
-// Then it creates the index directory if it doesn't already exist.
-// Next, it resolves the path to store the entry file using the index ID.
-// The function then adds the entry to the mapping using the index ID as the key.
-// After that, it marshals the entry into JSON format.
-// Finally, it writes the JSON data to the specified entry file in the index directory.
-// The function returns an error if any error occurs during the process; otherwise, it returns nil.
+// putEntry stores the given entry in the mapping with the specified index ID.
 func (oi *OnlineIndex) putEntry(idx int64, entry *OnlineIndexEntry) error {
-	indexPath := oi.Repository.ResolveDbPath("index")
-
-	if err := os.MkdirAll(indexPath, 0755); err != nil {
-		return err
-	}
-
-	p := oi.Repository.ResolveDbPath("index", strconv.FormatInt(idx, 10))
-
 	oi.mapping[idx] = entry
 
-	data, err := json.Marshal(entry)
-
-	if err != nil {
-		return err
-	}
-
-	return os.WriteFile(p, data, 0644)
+	return nil
 }
 
 // lookupEntry looks up an entry in the online index by its index ID.
@@ -167,6 +151,10 @@ func (oi *OnlineIndex) lookupEntry(idx int64) (*OnlineIndexEntry, error) {
 	oi.m.Lock()
 	defer oi.m.Unlock()
 
+	return oi.lookupEntryLocked(idx)
+}
+
+func (oi *OnlineIndex) lookupEntryLocked(idx int64) (*OnlineIndexEntry, error) {
 	existing := oi.mapping[idx]
 
 	if existing == nil {
@@ -189,3 +177,134 @@ func (oi *OnlineIndex) lookupEntry(idx int64) (*OnlineIndexEntry, error) {
 
 	return existing, nil
 }
+
+// loadEntries reads every entry of the faiss index into the mapping.
+func (oi *OnlineIndex) loadEntries() error {
+	for i := int64(0); i < oi.idx.Ntotal(); i++ {
+		if _, err := oi.lookupEntryLocked(i); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Documents returns the content hash of every document in the index, keyed by path.
+func (oi *OnlineIndex) Documents() (map[string]string, error) {
+	oi.m.Lock()
+	defer oi.m.Unlock()
+
+	if err := oi.loadEntries(); err != nil {
+		return nil, err
+	}
+
+	result := map[string]string{}
+
+	for _, entry := range oi.mapping {
+		result[entry.Document.Path] = entry.Document.Hash
+	}
+
+	return result, nil
+}
+
+// Retain removes every entry whose document does not satisfy keep.
+// Flat faiss indexes shift their IDs on removal, so the index is rebuilt from the
+// remaining entries and their IDs are reassigned in order.
+func (oi *OnlineIndex) Retain(keep func(doc DocumentReference) bool) error {
+	oi.m.Lock()
+	defer oi.m.Unlock()
+
+	if err := oi.loadEntries(); err != nil {
+		return err
+	}
+
+	idx, err := faiss.NewIndexFlatIP(oi.idx.D())
+
+	if err != nil {
+		return err
+	}
+
+	mapping := make(map[int64]*OnlineIndexEntry, len(oi.mapping))
+
+	for i := int64(0); i < oi.idx.Ntotal(); i++ {
+		entry := oi.mapping[i]
+
+		if !keep(entry.Document) {
+			continue
+		}
+
+		entry.Index = idx.Ntotal()
+
+		if err := idx.Add(entry.Embedding.Embeddings); err != nil {
+			idx.Delete()
+			return err
+		}
+
+		mapping[entry.Index] = entry
+	}
+
+	oi.idx.Delete()
+	oi.idx = idx
+	oi.mapping = mapping
+
+	return nil
+}
+
+// Save writes the index to the index directory of the repository.
+// The entries and the faiss index are written to a staging directory which then replaces
+// the current one, so readers never see a partially written index.
+func (oi *OnlineIndex) Save() error {
+	oi.m.Lock()
+	defer oi.m.Unlock()
+
+	if err := oi.loadEntries(); err != nil {
+		return err
+	}
+
+	indexPath := oi.Repository.ResolveDbPath("index")
+	stagingPath := oi.Repository.ResolveDbPath("index.new")
+	oldPath := oi.Repository.ResolveDbPath("index.old")
+
+	if err := os.RemoveAll(stagingPath); err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(stagingPath, 0755); err != nil {
+		return err
+	}
+
+	for idx, entry := range oi.mapping {
+		data, err := json.Marshal(entry)
+
+		if err != nil {
+			return err
+		}
+
+		if err := os.WriteFile(filepath.Join(stagingPath, strconv.FormatInt(idx, 10)), data, 0644); err != nil {
+			return err
+		}
+	}
+
+	if err := faiss.WriteIndex(oi.idx, filepath.Join(stagingPath, "index.faiss")); err != nil {
+		return err
+	}
+
+	if err := os.RemoveAll(oldPath); err != nil {
+		return err
+	}
+
+	if err := os.Rename(indexPath, oldPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	if err := os.Rename(stagingPath, indexPath); err != nil {
+		return err
+	}
+
+	// The index now lives in the index directory.
+	if err := os.Remove(oi.Repository.ResolveDbPath("index.faiss")); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return os.RemoveAll(oldPath)
+}
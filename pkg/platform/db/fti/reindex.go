@@ -0,0 +1,230 @@
+package fti
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+
+	"github.com/greenboxal/aip/aip-langchain/pkg/chunkers"
+	"github.com/greenboxal/aip/aip-langchain/pkg/llm"
+	"github.com/pkg/errors"
+)
+
+// ReindexResult summarizes the changes made by Reindex.
+type ReindexResult struct {
+	// Unchanged is the number of files whose content matched the index.
+	Unchanged int
+	// Restored is the number of changed files loaded from existing object snapshots.
+	Restored int
+	// Embedded is the number of files that had to be embedded again.
+	Embedded int
+	// Removed is the number of documents dropped from the index.
+	Removed int
+}
+
+// Reindex brings the index up to date with files, the names in fsys of every file that should be indexed.
+// Documents are identified by their path resolved against the repository root.
+//
+// Files whose content hash matches the indexed document are left untouched. Changed files are
+// loaded from the snapshot stored under objects when one exists for their hash, and are only
+// embedded again otherwise. Documents that are not in files anymore are dropped from the index.
+// The index is saved once every file has been processed.
+func (r *Repository) Reindex(ctx context.Context, fsys fs.FS, files []string) (ReindexResult, error) {
+	var result ReindexResult
+
+	indexed, err := r.index.Documents()
+
+	if err != nil {
+		return result, err
+	}
+
+	type pendingFile struct {
+		doc  DocumentReference
+		data []byte
+	}
+
+	var pending []pendingFile
+
+	current := make(map[string]string, len(files))
+
+	for _, name := range files {
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+
+		p := r.ResolvePath(name)
+
+		if r.isDbPath(p) || r.IsIgnored(p) {
+			continue
+		}
+
+		data, err := fs.ReadFile(fsys, name)
+
+		if err != nil {
+			return result, err
+		}
+
+		if len(data) == 0 {
+			continue
+		}
+
+		h := sha256.Sum256(data)
+
+		doc := DocumentReference{
+			Path: p,
+			Hash: hex.EncodeToString(h[:]),
+		}
+
+		current[doc.Path] = doc.Hash
+
+		if indexed[doc.Path] == doc.Hash {
+			result.Unchanged++
+			continue
+		}
+
+		pending = append(pending, pendingFile{doc: doc, data: data})
+	}
+
+	for path := range indexed {
+		if _, ok := current[path]; !ok {
+			result.Removed++
+		}
+	}
+
+	err = r.index.Retain(func(doc DocumentReference) bool {
+		return doc.Hash != "" && current[doc.Path] == doc.Hash
+	})
+
+	if err != nil {
+		return result, err
+	}
+
+	for _, f := range pending {
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+
+		restored, err := r.restoreObject(f.doc)
+
+		if err != nil {
+			return result, err
+		}
+
+		if restored {
+			result.Restored++
+			continue
+		}
+
+		r.logger.Infow("Updating file", "path", f.doc.Path)
+
+		if err := r.updateObject(ctx, f.doc, f.data); err != nil {
+			return result, err
+		}
+
+		result.Embedded++
+	}
+
+	if err := r.index.Save(); err != nil {
+		return result, err
+	}
+
+	return result, nil
+}
+
+// restoreObject adds the snapshot stored for doc to the index without embedding it again.
+// It returns false if there is no complete snapshot for the current chunk specifications.
+func (r *Repository) restoreObject(doc DocumentReference) (bool, error) {
+	objectDir := r.ResolveDbPath("objects", doc.Hash)
+
+	data, err := os.ReadFile(filepath.Join(objectDir, "meta.json"))
+
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+
+		return false, err
+	}
+
+	var meta ObjectSnapshotMetadata
+
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return false, errors.Wrapf(err, "invalid snapshot metadata for %s", doc.Hash)
+	}
+
+	if meta.Hash != doc.Hash || len(meta.ChunkCount) != len(r.config.ChunkSpecs) {
+		return false, nil
+	}
+
+	images := make([]*ObjectSnapshotImage, len(r.config.ChunkSpecs))
+
+	for i, spec := range r.config.ChunkSpecs {
+		img, err := r.loadSnapshotImage(objectDir, spec, doc, meta.ChunkCount[i])
+
+		if err != nil {
+			if os.IsNotExist(errors.Cause(err)) {
+				return false, nil
+			}
+
+			return false, err
+		}
+
+		images[i] = img
+	}
+
+	for _, img := range images {
+		if err := r.index.Add(img); err != nil {
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+// loadSnapshotImage reads the chunks and embeddings written by updateFileWithSpec.
+func (r *Repository) loadSnapshotImage(objectDir string, spec ChunkSpec, doc DocumentReference, count int) (*ObjectSnapshotImage, error) {
+	img := &ObjectSnapshotImage{
+		Chunks:     make([]chunkers.Chunk, count),
+		Embeddings: make([]llm.Embedding, count),
+		Document:   doc,
+	}
+
+	for i := 0; i < count; i++ {
+		chunkPath := filepath.Join(objectDir, fmt.Sprintf("%dm%d.%d.txt", spec.MaxTokens, spec.Overlap, i))
+		embPath := filepath.Join(objectDir, fmt.Sprintf("%dm%d.%d.f32", spec.MaxTokens, spec.Overlap, i))
+
+		content, err := os.ReadFile(chunkPath)
+
+		if err != nil {
+			return nil, err
+		}
+
+		buffer, err := os.ReadFile(embPath)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if len(buffer)%4 != 0 {
+			return nil, errors.Errorf("invalid embedding file %s", embPath)
+		}
+
+		emb := make([]float32, len(buffer)/4)
+
+		for j := range emb {
+			emb[j] = math.Float32frombits(binary.LittleEndian.Uint32(buffer[j*4:]))
+		}
+
+		img.Chunks[i] = chunkers.Chunk{Index: i, Content: string(content)}
+		img.Embeddings[i] = llm.Embedding{Embeddings: emb}
+	}
+
+	return img, nil
+}
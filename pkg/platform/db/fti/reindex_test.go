@@ -0,0 +1,171 @@
+package fti
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/greenboxal/aip/aip-langchain/pkg/chunkers"
+	"github.com/stretchr/testify/require"
+)
+
+// wordChunker splits text into chunks of chunkSize words, so tests don't need the tiktoken encodings.
+type wordChunker struct{}
+
+func (wordChunker) SplitTextIntoChunks(ctx context.Context, text string, chunkSize int, overlapSize int) ([]chunkers.Chunk, error) {
+	words := strings.Fields(text)
+
+	var result []chunkers.Chunk
+
+	for start := 0; start < len(words); start += chunkSize - overlapSize {
+		end := start + chunkSize
+
+		if end > len(words) {
+			end = len(words)
+		}
+
+		result = append(result, chunkers.Chunk{
+			Index:      len(result),
+			Content:    strings.Join(words[start:end], " "),
+			TokenCount: end - start,
+		})
+
+		if end == len(words) {
+			break
+		}
+	}
+
+	return result, nil
+}
+
+func (c wordChunker) SplitTextIntoStrings(ctx context.Context, text string, chunkSize int, overlapSize int) ([]string, error) {
+	chunks, err := c.SplitTextIntoChunks(ctx, text, chunkSize, overlapSize)
+
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]string, len(chunks))
+
+	for i, chunk := range chunks {
+		result[i] = chunk.Content
+	}
+
+	return result, nil
+}
+
+func openTestRepository(t *testing.T, root string) *Repository {
+	r, err := NewRepository(root)
+	require.NoError(t, err)
+
+	r.chunker = wordChunker{}
+
+	return r
+}
+
+func newTestRepository(t *testing.T) string {
+	root := t.TempDir()
+
+	require.NoError(t, os.MkdirAll(filepath.Join(root, ".fti"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(root, ".fti", "config.json"), []byte(`{
+		"llm": {"kind": "fake"},
+		"chunk_specs": [{"max_tokens": 2, "overlap": 1}, {"max_tokens": 4, "overlap": 0}]
+	}`), 0644))
+
+	writeTestFile(t, root, "a.txt", "the first file")
+	writeTestFile(t, root, "b.txt", "the second file")
+
+	return root
+}
+
+func writeTestFile(t *testing.T, root, name, content string) {
+	require.NoError(t, os.WriteFile(filepath.Join(root, name), []byte(content), 0644))
+}
+
+func reindex(t *testing.T, r *Repository, root string, files ...string) ReindexResult {
+	result, err := r.Reindex(context.Background(), os.DirFS(root), files)
+	require.NoError(t, err)
+
+	return result
+}
+
+func indexedDocuments(t *testing.T, root string) map[string]string {
+	r := openTestRepository(t, root)
+
+	docs, err := r.index.Documents()
+	require.NoError(t, err)
+
+	return docs
+}
+
+func TestReindex(t *testing.T) {
+	root := newTestRepository(t)
+
+	r := openTestRepository(t, root)
+
+	require.Equal(t, ReindexResult{Embedded: 2}, reindex(t, r, root, "a.txt", "b.txt"))
+	require.Equal(t, ReindexResult{Unchanged: 2}, reindex(t, r, root, "a.txt", "b.txt"))
+
+	first := indexedDocuments(t, root)
+	require.Len(t, first, 2)
+
+	// A changed file is embedded again.
+	writeTestFile(t, root, "a.txt", "the first file, changed")
+	require.Equal(t, ReindexResult{Unchanged: 1, Embedded: 1}, reindex(t, r, root, "a.txt", "b.txt"))
+
+	changed := indexedDocuments(t, root)
+	require.NotEqual(t, first[r.ResolvePath("a.txt")], changed[r.ResolvePath("a.txt")])
+
+	// Reverting it restores the snapshot of its previous content instead.
+	writeTestFile(t, root, "a.txt", "the first file")
+	require.Equal(t, ReindexResult{Unchanged: 1, Restored: 1}, reindex(t, r, root, "a.txt", "b.txt"))
+	require.Equal(t, first, indexedDocuments(t, root))
+
+	// Files that are not listed anymore are dropped.
+	require.Equal(t, ReindexResult{Unchanged: 1, Removed: 1}, reindex(t, r, root, "a.txt"))
+
+	docs := indexedDocuments(t, root)
+	require.Equal(t, map[string]string{r.ResolvePath("a.txt"): first[r.ResolvePath("a.txt")]}, docs)
+
+	// The remaining entries are renumbered, so queries still resolve every hit.
+	r = openTestRepository(t, root)
+	require.Equal(t, ReindexResult{Unchanged: 1}, reindex(t, r, root, "a.txt"))
+
+	hits, err := r.index.Query(r.index.mapping[0].Embedding, 1)
+	require.NoError(t, err)
+	require.Len(t, hits, 1)
+	require.Equal(t, r.ResolvePath("a.txt"), hits[0].Entry.Document.Path)
+
+	// Asking for more hits than there are entries only returns the entries.
+	hits, err = r.index.Query(r.index.mapping[0].Embedding, 100)
+	require.NoError(t, err)
+	require.Len(t, hits, int(r.index.idx.Ntotal()))
+}
+
+func TestReindexRecoversInterruptedSave(t *testing.T) {
+	root := newTestRepository(t)
+
+	r := openTestRepository(t, root)
+
+	reindex(t, r, root, "a.txt", "b.txt")
+
+	saved := indexedDocuments(t, root)
+
+	// Simulate a Save interrupted between its two renames: the previous index was moved
+	// to index.old and the staged one was never moved into place.
+	require.NoError(t, os.Rename(r.ResolveDbPath("index"), r.ResolveDbPath("index.old")))
+	require.NoError(t, os.MkdirAll(r.ResolveDbPath("index.new"), 0755))
+	require.NoError(t, os.WriteFile(r.ResolveDbPath("index.new", "0"), []byte("partial"), 0644))
+
+	require.Equal(t, saved, indexedDocuments(t, root))
+
+	// The recovered index is up to date and can be saved again.
+	r = openTestRepository(t, root)
+	require.Equal(t, ReindexResult{Unchanged: 2}, reindex(t, r, root, "a.txt", "b.txt"))
+
+	require.NoDirExists(t, r.ResolveDbPath("index.old"))
+	require.NoDirExists(t, r.ResolveDbPath("index.new"))
+	require.Equal(t, saved, indexedDocuments(t, root))
+}
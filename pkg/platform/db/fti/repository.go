@@ -17,10 +17,12 @@ import (
 
 	"github.com/DataIntelligenceCrew/go-faiss"
 	"github.com/greenboxal/aip/aip-langchain/pkg/chunkers"
-	"github.com/greenboxal/aip/aip-langchain/pkg/llm"
-	"github.com/greenboxal/aip/aip-langchain/pkg/providers/openai"
 	"github.com/pkg/errors"
 	ignore "github.com/sabhiram/go-gitignore"
+	"go.uber.org/zap"
+
+	"github.com/greenboxal/agibootstrap/pkg/gpt"
+	"github.com/greenboxal/agibootstrap/pkg/platform/logging"
 )
 
 var ErrNoConfig = errors.New("no config file found")
@@ -33,24 +35,23 @@ type Repository struct {
 	ftiPath    string
 	configPath string
 
-	embedder llm.Embedder
+	provider gpt.Provider
 	chunker  chunkers.Chunker
 	index    *OnlineIndex
 
 	ignore *ignore.GitIgnore
+	logger *zap.SugaredLogger
 }
 
 // NewRepository creates a new Repository with the given repository path.
 // It initializes the repository by loading the configuration and ignore file,
 // creating a new online index, and loading the index if it exists.
 func NewRepository(repoPath string) (r *Repository, err error) {
-	r = &Repository{}
+	r = &Repository{
+		logger: logging.GetLogger("fti"),
+	}
 
 	r.chunker = chunkers.TikToken{}
-	r.embedder = &openai.Embedder{
-		Client: openai.NewClient(),
-		Model:  openai.AdaEmbeddingV2,
-	}
 
 	r.repoPath = repoPath
 	r.ftiPath = filepath.Join(r.repoPath, ".fti")
@@ -62,6 +63,12 @@ func NewRepository(repoPath string) (r *Repository, err error) {
 		}
 	}
 
+	r.provider, err = r.config.LLM.NewProvider()
+
+	if err != nil {
+		return nil, err
+	}
+
 	if err := r.loadIgnoreFile(); err != nil {
 		if !os.IsNotExist(err) {
 			return nil, err
@@ -83,7 +90,14 @@ func NewRepository(repoPath string) (r *Repository, err error) {
 	return r, nil
 }
 
-func (r *Repository) RepoPath() string { return r.repoPath }
+func (r *Repository) RepoPath() string       { return r.repoPath }
+func (r *Repository) Config() Config         { return r.config }
+func (r *Repository) Provider() gpt.Provider { return r.provider }
+
+// SetProvider overrides the LLM provider configured for the repository.
+func (r *Repository) SetProvider(provider gpt.Provider) {
+	r.provider = provider
+}
 
 func (r *Repository) ResolveDbPath(p ...string) string {
 	return filepath.Join(r.ftiPath, filepath.Join(p...))
@@ -123,6 +137,13 @@ func (r *Repository) RelativeToRoot(name string) string {
 	return p
 }
 
+// isDbPath returns true if name is inside the .fti directory.
+func (r *Repository) isDbPath(name string) bool {
+	relPath, err := filepath.Rel(r.ftiPath, name)
+
+	return err == nil && !strings.HasPrefix(relPath, "..")
+}
+
 func (r *Repository) loadConfig() error {
 	if !r.FileExists(r.configPath) {
 		return ErrNoConfig
@@ -178,9 +199,7 @@ func (r *Repository) IterateFiles(ctx context.Context) Iterator[FileCursor] {
 			return false
 		}
 
-		relPath, err := filepath.Rel(r.ftiPath, f.Path)
-
-		if err == nil && !strings.HasPrefix(relPath, "..") {
+		if r.isDbPath(f.Path) {
 			return false
 		}
 
@@ -218,7 +237,7 @@ func (r *Repository) Init() error {
 // Update updates the repository by iterating over the files in the repository and updating each file.
 // It uses the provided context to handle cancellation.
 // For each file, it calls the UpdateFile function to perform the update operation.
-// After updating all files, it writes the index to the index.faiss file.
+// After updating all files, it saves the index.
 func (r *Repository) Update(ctx context.Context) error {
 	for it := r.IterateFiles(ctx); it.Next(); {
 		f := it.Item()
@@ -228,13 +247,7 @@ func (r *Repository) Update(ctx context.Context) error {
 		}
 	}
 
-	err := faiss.WriteIndex(r.index.idx, r.ResolveDbPath("index.faiss"))
-
-	if err != nil {
-		return err
-	}
-
-	return nil
+	return r.index.Save()
 }
 
 // UpdateFile updates a file in the repository.
@@ -244,7 +257,7 @@ func (r *Repository) Update(ctx context.Context) error {
 // It updates the metadata with the count of chunks for each specification and writes the metadata to a JSON file.
 // Returns an error if any occurred, or nil if the update was successful.
 func (r *Repository) UpdateFile(ctx context.Context, f FileCursor) error {
-	fmt.Printf("Updating file %s\n", f.Path)
+	r.logger.Infow("Updating file", "path", f.Path)
 
 	fh, err := r.OpenFile(f.Path)
 	if err != nil {
@@ -266,7 +279,13 @@ func (r *Repository) UpdateFile(ctx context.Context, f FileCursor) error {
 	h := hasher.Sum(nil)
 	fileHash := hex.EncodeToString(h)
 
-	objectDir := r.ResolveDbPath("objects", fileHash)
+	return r.updateObject(ctx, DocumentReference{Path: f.Path, Hash: fileHash}, data)
+}
+
+// updateObject embeds data for every chunk specification and stores the resulting snapshot
+// under the objects directory named after the document hash.
+func (r *Repository) updateObject(ctx context.Context, doc DocumentReference, data []byte) error {
+	objectDir := r.ResolveDbPath("objects", doc.Hash)
 	metaPath := filepath.Join(objectDir, "meta.json")
 
 	if err := os.MkdirAll(objectDir, 0755); err != nil {
@@ -274,13 +293,13 @@ func (r *Repository) UpdateFile(ctx context.Context, f FileCursor) error {
 	}
 
 	meta := &ObjectSnapshotMetadata{
-		Path:       f.Path,
-		Hash:       fileHash,
+		Path:       doc.Path,
+		Hash:       doc.Hash,
 		ChunkCount: make([]int, len(r.config.ChunkSpecs)),
 	}
 
 	for i, chunkSpec := range r.config.ChunkSpecs {
-		img, err := r.updateFileWithSpec(ctx, chunkSpec, objectDir, f.Path, data)
+		img, err := r.updateFileWithSpec(ctx, chunkSpec, objectDir, doc, data)
 		if err != nil {
 			return err
 		}
@@ -301,14 +320,14 @@ func (r *Repository) UpdateFile(ctx context.Context, f FileCursor) error {
 }
 
 // updateFileWithSpec updates a file in the repository with the specified chunk specification.
-// It takes a context, which can be used for cancellation, the chunk specification, the directory to store the file, and the file data.
+// It takes a context, which can be used for cancellation, the chunk specification, the directory to store the file, the document being indexed, and the file data.
 // The function splits the file data into chunks based on the chunk specification.
 // It retrieves embeddings for each chunk using the embedder.
 // The function creates a new ObjectSnapshotImage with the chunks and embeddings.
 // For each chunk, it writes the content to a text file and the embeddings to a binary file.
 // Finally, it writes the ObjectSnapshotImage to an image file and adds it to the index.
 // Returns the ObjectSnapshotImage if the update is successful, or an error otherwise.
-func (r *Repository) updateFileWithSpec(ctx context.Context, spec ChunkSpec, objectDir string, path string, data []byte) (*ObjectSnapshotImage, error) {
+func (r *Repository) updateFileWithSpec(ctx context.Context, spec ChunkSpec, objectDir string, doc DocumentReference, data []byte) (*ObjectSnapshotImage, error) {
 	imagePath := filepath.Join(objectDir, fmt.Sprintf("%dm%d.png", spec.MaxTokens, spec.Overlap))
 
 	chunks, err := r.chunker.SplitTextIntoChunks(ctx, string(data), spec.MaxTokens, spec.Overlap)
@@ -323,7 +342,7 @@ func (r *Repository) updateFileWithSpec(ctx context.Context, spec ChunkSpec, obj
 		chunksStr[i] = chunk.Content
 	}
 
-	embeddings, err := r.embedder.GetEmbeddings(ctx, chunksStr)
+	embeddings, err := r.provider.Embedder().GetEmbeddings(ctx, chunksStr)
 
 	if err != nil {
 		return nil, err
@@ -332,7 +351,7 @@ func (r *Repository) updateFileWithSpec(ctx context.Context, spec ChunkSpec, obj
 	img := &ObjectSnapshotImage{
 		Chunks:     chunks,
 		Embeddings: embeddings,
-		Document:   DocumentReference{Path: path},
+		Document:   doc,
 	}
 
 	for i, chunk := range chunks {
@@ -379,7 +398,7 @@ func (r *Repository) updateFileWithSpec(ctx context.Context, spec ChunkSpec, obj
 // It takes a context, which can be used for cancellation, the query string, and the maximum number of results (k) to return.
 // The function returns a slice of OnlineIndexQueryHit, which contains information about the matching files, and an error, if any.
 func (r *Repository) Query(ctx context.Context, query string, k int64) ([]OnlineIndexQueryHit, error) {
-	embs, err := r.embedder.GetEmbeddings(ctx, []string{query})
+	embs, err := r.provider.Embedder().GetEmbeddings(ctx, []string{query})
 
 	if err != nil {
 		return nil, err
@@ -395,7 +414,21 @@ func (r *Repository) Query(ctx context.Context, query string, k int64) ([]Online
 }
 
 func (r *Repository) loadIndex() error {
-	p := r.ResolveDbPath("index.faiss")
+	indexPath := r.ResolveDbPath("index")
+	oldPath := r.ResolveDbPath("index.old")
+
+	// An interrupted OnlineIndex.Save leaves the previous index behind.
+	if !r.FileExists(indexPath) && r.FileExists(oldPath) {
+		if err := os.Rename(oldPath, indexPath); err != nil {
+			return err
+		}
+	}
+
+	p := r.ResolveDbPath("index", "index.faiss")
+
+	if !r.FileExists(p) {
+		p = r.ResolveDbPath("index.faiss")
+	}
 
 	if !r.FileExists(p) {
 		return nil
@@ -12,6 +12,7 @@ import (
 
 type DocumentReference struct {
 	Path string
+	Hash string
 }
 
 type ObjectSnapshotMetadata struct {
@@ -38,8 +38,14 @@ type IndexedGraph struct {
 
 	nodeCache map[psi.NodeID]*cachedNode
 
-	proc            goprocess.Process
-	nodeUpdateQueue chan nodeUpdateRequest
+	proc goprocess.Process
+
+	// Node updates are queued without blocking, since they are reported by writers holding the graph lock,
+	// and coalesced until the run loop persists them.
+	pendingMu      sync.Mutex
+	pendingUpdates []nodeUpdateRequest
+	pendingIndex   map[psi.Node]int
+	pendingSignal  chan struct{}
 }
 
 func NewIndexedGraph(ctx context.Context, ds datastore.Batching, root psi.Node) *IndexedGraph {
@@ -54,7 +60,8 @@ func NewIndexedGraph(ctx context.Context, ds datastore.Batching, root psi.Node)
 
 		nodeCache: map[psi.NodeID]*cachedNode{},
 
-		nodeUpdateQueue: make(chan nodeUpdateRequest, 256),
+		pendingIndex:  map[psi.Node]int{},
+		pendingSignal: make(chan struct{}, 1),
 	}
 
 	g.Init(g)
@@ -64,6 +71,9 @@ func NewIndexedGraph(ctx context.Context, ds datastore.Batching, root psi.Node)
 	return g
 }
 
+// Store returns the store the graph is persisted to.
+func (g *IndexedGraph) Store() *Store { return g.store }
+
 func (g *IndexedGraph) getCacheEntry(id psi.NodeID, create bool) *cachedNode {
 	if create {
 		g.mu.Lock()
@@ -214,29 +224,88 @@ func (g *IndexedGraph) GetNodeChildren(path psi.Path) (result []psi.Path, err er
 }
 
 func (g *IndexedGraph) OnNodeInvalidated(n psi.Node) {
-	g.nodeUpdateQueue <- nodeUpdateRequest{
-		Node:    n,
-		Version: n.PsiNodeVersion(),
-	}
+	g.queueUpdates(n)
 }
 
 func (g *IndexedGraph) OnNodeUpdated(n psi.Node) {
-	g.nodeUpdateQueue <- nodeUpdateRequest{
-		Node:    n,
-		Version: n.PsiNodeVersion(),
+	g.queueUpdates(n)
+}
+
+// OnTransactionCommitted queues a single update with every node touched by the transaction,
+// so each of them is persisted once.
+func (g *IndexedGraph) OnTransactionCommitted(tx *psi.Transaction) {
+	g.queueUpdates(tx.UpdatedNodes()...)
+}
+
+// queueUpdates queues the nodes to be persisted. Nodes already queued are persisted once, at their latest version.
+func (g *IndexedGraph) queueUpdates(nodes ...psi.Node) {
+	if len(nodes) == 0 {
+		return
+	}
+
+	g.pendingMu.Lock()
+
+	for _, n := range nodes {
+		req := nodeUpdateRequest{
+			Node:    n,
+			Version: n.PsiNodeVersion(),
+		}
+
+		if idx, ok := g.pendingIndex[n]; ok {
+			g.pendingUpdates[idx] = req
+		} else {
+			g.pendingIndex[n] = len(g.pendingUpdates)
+			g.pendingUpdates = append(g.pendingUpdates, req)
+		}
+	}
+
+	g.pendingMu.Unlock()
+
+	select {
+	case g.pendingSignal <- struct{}{}:
+	default:
 	}
 }
 
+func (g *IndexedGraph) takeUpdates() []nodeUpdateRequest {
+	g.pendingMu.Lock()
+	defer g.pendingMu.Unlock()
+
+	batch := g.pendingUpdates
+
+	g.pendingUpdates = nil
+	g.pendingIndex = map[psi.Node]int{}
+
+	return batch
+}
+
 func (g *IndexedGraph) run(proc goprocess.Process) {
 	ctx := goprocessctx.OnClosingContext(proc)
 
-	for item := range g.nodeUpdateQueue {
-		fn, err := g.store.UpsertNode(ctx, item.Node)
+	for {
+		select {
+		case <-proc.Closing():
+			return
 
-		if err != nil {
-			g.logger.Error(err)
+		case <-g.pendingSignal:
+		}
+
+		batch := g.takeUpdates()
+
+		// Nodes are read while they are serialized.
+		g.RLock()
+
+		for _, item := range batch {
+			fn, err := g.store.UpsertNode(ctx, item.Node)
+
+			if err != nil {
+				g.logger.Error(err)
+				continue
+			}
+
+			g.logger.Infow("Updated node", "uuid", item.Node.UUID(), "version", item.Version, "cid", fn.Cid)
 		}
 
-		g.logger.Infow("Updated node", "uuid", item.Node.UUID(), "version", item.Version, "cid", fn.Cid)
+		g.RUnlock()
 	}
 }
@@ -0,0 +1,283 @@
+package graphstore
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/greenboxal/aip/aip-forddb/pkg/typesystem"
+	"github.com/hashicorp/go-multierror"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	"github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/codec/dagjson"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/pkg/errors"
+
+	"github.com/greenboxal/agibootstrap/pkg/psi"
+)
+
+// MigrateAll upgrades the head version of every node persisted with an older schema version of its
+// node type, and returns the number of migrated nodes. Migrated nodes are written as a new version
+// of the node, so the versions persisted before the migration are kept as they were. Nodes that fail
+// to migrate are left untouched.
+func (s *Store) MigrateAll(ctx context.Context) (count int, err error) {
+	heads, latest, err := s.scanNodeRefs(ctx)
+
+	if err != nil {
+		return 0, err
+	}
+
+	batch, err := s.ds.Batch(ctx)
+
+	if err != nil {
+		return 0, err
+	}
+
+	var merr error
+
+	for _, head := range heads {
+		migrated, err := s.batchMigrateNode(ctx, batch, head.key, head.value, latest[head.key.Parent().BaseNamespace()]+1)
+
+		if err != nil {
+			merr = multierror.Append(merr, errors.Wrap(err, head.key.Parent().BaseNamespace()))
+			continue
+		}
+
+		if migrated {
+			count++
+		}
+	}
+
+	if err := batch.Commit(ctx); err != nil {
+		return 0, err
+	}
+
+	return count, merr
+}
+
+type nodeHead struct {
+	key   datastore.Key
+	value []byte
+}
+
+// scanNodeRefs returns the HEAD refs of the persisted nodes, and the latest version of each node by UUID.
+func (s *Store) scanNodeRefs(ctx context.Context) (heads []nodeHead, latest map[string]int64, err error) {
+	res, err := s.ds.Query(ctx, query.Query{Prefix: "refs/nodes/"})
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	defer res.Close()
+
+	latest = map[string]int64{}
+
+	for r := range res.Next() {
+		if r.Error != nil {
+			return nil, nil, r.Error
+		}
+
+		key := datastore.NewKey(r.Key)
+
+		if key.BaseNamespace() == "HEAD" {
+			heads = append(heads, nodeHead{key: key, value: r.Value})
+			continue
+		}
+
+		version, err := strconv.ParseInt(key.BaseNamespace(), 10, 64)
+
+		if err != nil {
+			continue
+		}
+
+		if id := key.Parent().BaseNamespace(); version > latest[id] {
+			latest[id] = version
+		}
+	}
+
+	return heads, latest, nil
+}
+
+func (s *Store) batchMigrateNode(ctx context.Context, batch datastore.Batch, headKey datastore.Key, value []byte, version int64) (bool, error) {
+	id, err := cid.Cast(value)
+
+	if err != nil {
+		return false, err
+	}
+
+	fn, err := s.GetNodeByCid(ctx, id)
+
+	if err != nil {
+		return false, err
+	}
+
+	data, err := s.readObject(ctx, fn.Cid.Cid)
+
+	if err != nil {
+		return false, err
+	}
+
+	data, nt, err := upgradeNodeData(fn, data)
+
+	if err != nil || nt == nil {
+		return false, err
+	}
+
+	contentId, err := s.os.Put(ctx, bytes.NewReader(data))
+
+	if err != nil {
+		return false, err
+	}
+
+	if err := s.batchCopyChildEdges(ctx, batch, fn.Cid.Cid, contentId); err != nil {
+		return false, err
+	}
+
+	fn.Cid = cidlink.Link{Cid: contentId}
+	fn.TypeName = nt.Name()
+	fn.SchemaVersion = nt.SchemaVersion()
+	fn.Version = version
+
+	data, err = ipld.Encode(typesystem.Wrap(fn), dagjson.Encode)
+
+	if err != nil {
+		return false, err
+	}
+
+	id, err = s.os.Put(ctx, bytes.NewReader(data))
+
+	if err != nil {
+		return false, err
+	}
+
+	versionKey := fmt.Sprintf("refs/nodes/%s/%d", fn.UUID, fn.Version)
+
+	if err := batch.Put(ctx, headKey, id.Bytes()); err != nil {
+		return false, err
+	}
+
+	if err := batch.Put(ctx, datastore.NewKey(versionKey), id.Bytes()); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// batchCopyChildEdges copies the child edges of the node content from to the node content to,
+// since they are keyed by content.
+func (s *Store) batchCopyChildEdges(ctx context.Context, batch datastore.Batch, from, to cid.Cid) error {
+	res, err := s.ds.Query(ctx, query.Query{Prefix: fmt.Sprintf("nodes/%s/", from)})
+
+	if err != nil {
+		return err
+	}
+
+	defer res.Close()
+
+	for r := range res.Next() {
+		if r.Error != nil {
+			return r.Error
+		}
+
+		edgeKey := strings.TrimPrefix(r.Key, fmt.Sprintf("/nodes/%s/", from))
+
+		if err := batch.Put(ctx, datastore.NewKey(fmt.Sprintf("nodes/%s/%s", to, edgeKey)), r.Value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *Store) readObject(ctx context.Context, id cid.Cid) ([]byte, error) {
+	reader, err := s.os.Get(ctx, id)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return io.ReadAll(reader)
+}
+
+// upgradeNodeData migrates the serialized data of a frozen node to the current schema version of its
+// node type. It returns the node type if the data was migrated, and nil if it was already current.
+//
+// Nodes persisted before node type names were recorded are matched with their node type by the Go
+// type recorded in their data.
+func upgradeNodeData(fn *FrozenNode, data []byte) ([]byte, psi.NodeType, error) {
+	var nt psi.NodeType
+
+	if fn.TypeName != "" {
+		nt, _ = psi.LookupNodeType(fn.TypeName)
+
+		if nt == nil || fn.SchemaVersion == nt.SchemaVersion() {
+			return data, nil, nil
+		}
+	}
+
+	var doc map[string]any
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	if err := dec.Decode(&doc); err != nil {
+		return nil, nil, err
+	}
+
+	fields, _ := doc["node"].(map[string]any)
+
+	if fields == nil {
+		return data, nil, nil
+	}
+
+	if nt == nil {
+		goTypeName, _ := fields["@type"].(string)
+
+		if nt = nodeTypeForGoType(goTypeName); nt == nil || fn.SchemaVersion == nt.SchemaVersion() {
+			return data, nil, nil
+		}
+	}
+
+	if err := nt.Migrate(fields, fn.SchemaVersion); err != nil {
+		return nil, nil, err
+	}
+
+	var buf bytes.Buffer
+
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+
+	if err := enc.Encode(doc); err != nil {
+		return nil, nil, err
+	}
+
+	return bytes.TrimSpace(buf.Bytes()), nt, nil
+}
+
+// nodeTypeForGoType returns the registered node type whose runtime type has the given typesystem name.
+func nodeTypeForGoType(name string) psi.NodeType {
+	if name == "" {
+		return nil
+	}
+
+	for _, nt := range psi.RegisteredNodeTypes() {
+		typ := nt.RuntimeType()
+
+		if typ == nil || typ.Kind() == reflect.Interface {
+			continue
+		}
+
+		if typesystem.TypeFrom(typ).Name().NormalizedFullNameWithArguments() == name {
+			return nt
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,102 @@
+package graphstore
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	dssync "github.com/ipfs/go-datastore/sync"
+	"github.com/stretchr/testify/require"
+
+	"github.com/greenboxal/agibootstrap/pkg/psi"
+)
+
+type migrationTestNode struct {
+	psi.NodeBase
+
+	Title string
+	Body  string
+}
+
+func TestStoreMigration(t *testing.T) {
+	ctx := context.Background()
+	ds := dssync.MutexWrap(datastore.NewMapDatastore())
+	s := NewStore(ds, NewObjectStore(ds))
+
+	// Version 0 stores the title in Body.
+	psi.RegisterNodeType[*migrationTestNode]("test.Migration")
+
+	n := &migrationTestNode{Body: "hello"}
+	n.Init(n, "")
+
+	child := &migrationTestNode{Body: "child"}
+	child.Init(child, "")
+	child.SetParent(n)
+
+	original, err := s.UpsertNode(ctx, n)
+	require.NoError(t, err)
+
+	psi.RegisterNodeType[*migrationTestNode](
+		"test.Migration",
+		psi.WithSchemaVersion(1),
+		psi.WithMigration(0, func(fields map[string]any) error {
+			fields["Title"] = fields["Body"]
+			fields["Body"] = ""
+
+			return nil
+		}),
+	)
+
+	fn, err := s.GetNodeByID(ctx, n.UUID(), -1)
+	require.NoError(t, err)
+	require.Equal(t, 0, fn.SchemaVersion)
+
+	loaded, err := s.LoadNode(ctx, fn)
+	require.NoError(t, err)
+	require.Equal(t, "hello", loaded.(*migrationTestNode).Title)
+	require.Equal(t, "", loaded.(*migrationTestNode).Body)
+
+	count, err := s.MigrateAll(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 1, count)
+
+	fn, err = s.GetNodeByID(ctx, n.UUID(), -1)
+	require.NoError(t, err)
+	require.Equal(t, "test.Migration", fn.TypeName)
+	require.Equal(t, 1, fn.SchemaVersion)
+
+	// The migrated node is a new version, the original one is kept as it was.
+	require.Equal(t, original.Version+1, fn.Version)
+
+	migrated, err := s.GetNodeByID(ctx, n.UUID(), fn.Version)
+	require.NoError(t, err)
+	require.Equal(t, fn.Cid, migrated.Cid)
+
+	previous, err := s.GetNodeByID(ctx, n.UUID(), original.Version)
+	require.NoError(t, err)
+	require.Equal(t, original.Cid, previous.Cid)
+	require.Equal(t, 0, previous.SchemaVersion)
+
+	// Child edges are keyed by node content, and follow the migrated content.
+	require.Equal(t, childEdgeCount(t, ds, original), childEdgeCount(t, ds, fn))
+	require.Equal(t, 1, childEdgeCount(t, ds, fn))
+
+	loaded, err = s.LoadNode(ctx, fn)
+	require.NoError(t, err)
+	require.Equal(t, "hello", loaded.(*migrationTestNode).Title)
+
+	count, err = s.MigrateAll(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 0, count)
+}
+
+func childEdgeCount(t *testing.T, ds datastore.Datastore, fn *FrozenNode) int {
+	res, err := ds.Query(context.Background(), query.Query{Prefix: "nodes/" + fn.Cid.String() + "/", KeysOnly: true})
+	require.NoError(t, err)
+
+	entries, err := res.Rest()
+	require.NoError(t, err)
+
+	return len(entries)
+}
@@ -25,6 +25,11 @@ type FrozenNode struct {
 	UUID psi.NodeID   `json:"uuid"`
 	Type psi.NodeType `json:"type"`
 
+	// TypeName and SchemaVersion identify the node type and the schema version the node was persisted with.
+	// See psi.WithSchemaVersion.
+	TypeName      string `json:"typeName,omitempty"`
+	SchemaVersion int    `json:"schemaVersion,omitempty"`
+
 	Attributes map[string]interface{} `json:"attr,omitempty"`
 }
 
@@ -109,6 +114,8 @@ func FreezeGraph(g psi.Graph) (*FrozenGraph, error) {
 			Attributes: n.Attributes(),
 		}
 
+		fn.setNodeType(n)
+
 		fg.Nodes = append(fg.Nodes, fn)
 
 		childrenIndex := int64(0)
@@ -154,3 +161,11 @@ func SerializeGraph(g psi.Graph) ([]byte, error) {
 
 	return ipld.Encode(typesystem.Wrap(fg), dagjson.Encode)
 }
+
+// setNodeType records the node type of n and its current schema version.
+func (fn *FrozenNode) setNodeType(n psi.Node) {
+	if nt := psi.NodeTypeOf(n); nt != nil {
+		fn.TypeName = nt.Name()
+		fn.SchemaVersion = nt.SchemaVersion()
+	}
+}
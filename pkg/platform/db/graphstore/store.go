@@ -67,6 +67,8 @@ func (s *Store) batchUpsertNode(ctx context.Context, batch datastore.Batch, n ps
 		Attributes: n.Attributes(),
 	}
 
+	fn.setNodeType(n)
+
 	data, err = ipld.Encode(typesystem.Wrap(fn), dagjson.Encode)
 
 	if err != nil {
@@ -190,14 +192,16 @@ func (s *Store) batchUpsertEdge(ctx context.Context, batch datastore.Batch, edge
 	return fe, contentId, nil
 }
 
+// LoadNode loads a frozen node, upgrading it first if it was persisted with an older schema version
+// of its node type.
 func (s *Store) LoadNode(ctx context.Context, fn *FrozenNode) (psi.Node, error) {
-	reader, err := s.os.Get(ctx, fn.Cid.Cid)
+	data, err := s.readObject(ctx, fn.Cid.Cid)
 
 	if err != nil {
 		return nil, err
 	}
 
-	data, err := io.ReadAll(reader)
+	data, _, err = upgradeNodeData(fn, data)
 
 	if err != nil {
 		return nil, err
@@ -235,7 +239,15 @@ func (s *Store) GetEdgeByCid(ctx context.Context, id cid.Cid) (*FrozenEdge, erro
 		return nil, err
 	}
 
-	return typesystem.Unwrap(n).(*FrozenEdge), nil
+	// Depending on the prototype, the typesystem unwraps either the struct or a pointer to it.
+	switch fe := typesystem.Unwrap(n).(type) {
+	case *FrozenEdge:
+		return fe, nil
+	case FrozenEdge:
+		return &fe, nil
+	default:
+		return nil, fmt.Errorf("unexpected frozen edge type %T", fe)
+	}
 }
 
 func (s *Store) GetNodeByCid(ctx context.Context, id cid.Cid) (*FrozenNode, error) {
@@ -257,7 +269,15 @@ func (s *Store) GetNodeByCid(ctx context.Context, id cid.Cid) (*FrozenNode, erro
 		return nil, err
 	}
 
-	return typesystem.Unwrap(n).(*FrozenNode), nil
+	// Depending on the prototype, the typesystem unwraps either the struct or a pointer to it.
+	switch fn := typesystem.Unwrap(n).(type) {
+	case *FrozenNode:
+		return fn, nil
+	case FrozenNode:
+		return &fn, nil
+	default:
+		return nil, fmt.Errorf("unexpected frozen node type %T", fn)
+	}
 }
 
 func (s *Store) GetNodeByID(ctx context.Context, id psi.NodeID, version int64) (*FrozenNode, error) {
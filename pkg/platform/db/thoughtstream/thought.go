@@ -12,6 +12,8 @@ type CommHandle struct {
 	Role msn.Role
 }
 
+var ThoughtType = psi.RegisterNodeType[*Thought]("thoughtstream.Thought")
+
 type Thought struct {
 	psi.NodeBase
 
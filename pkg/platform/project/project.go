@@ -3,6 +3,7 @@ package project
 import (
 	"go/token"
 
+	"github.com/greenboxal/agibootstrap/pkg/gpt"
 	"github.com/greenboxal/agibootstrap/pkg/platform/db/fti"
 	"github.com/greenboxal/agibootstrap/pkg/platform/db/thoughtstream"
 	"github.com/greenboxal/agibootstrap/pkg/platform/tasks"
@@ -19,6 +20,7 @@ type Project interface {
 	RootPath() string
 	RootNode() psi.Node
 	Repo() *fti.Repository
+	ModelProvider() gpt.Provider
 	FS() repofs.FS
 	FileSet() *token.FileSet
 	Graph() psi.Graph
@@ -30,6 +30,12 @@ type ListChangeEvent[T any] interface {
 	AddedSlice() []T
 }
 
+// rewindableListChangeEvent is implemented by events that can be iterated again,
+// so every listener sees the full change.
+type rewindableListChangeEvent interface {
+	rewind()
+}
+
 type listChangeIterator[T any] struct {
 	ListChangeEvent[T]
 
@@ -54,6 +60,11 @@ func (l *listChangeIterator[T]) Next() bool {
 	return true
 }
 
+func (l *listChangeIterator[T]) rewind() {
+	l.ListChangeEvent = nil
+	l.index = 0
+}
+
 type listChangeEvent[T any] struct {
 	list         BasicObservableList
 	removedSlice []T
@@ -74,6 +85,10 @@ func (l *listChangeEvent[T]) Next() bool {
 	return true
 }
 
+func (l *listChangeEvent[T]) rewind() {
+	l.consumed = false
+}
+
 func (l *listChangeEvent[T]) BasicList() BasicObservableList {
 	return l.list
 }
@@ -42,6 +42,10 @@ func (g *genericInvalidationListExpressionHelper[T]) OnListChanged(ev ListChange
 	})
 
 	g.listListeners.ForEachListener(func(l ListListener[T]) bool {
+		if r, ok := ev.(rewindableListChangeEvent); ok {
+			r.rewind()
+		}
+
 		l.OnListChanged(ev)
 
 		return true
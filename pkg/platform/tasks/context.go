@@ -7,6 +7,8 @@ import (
 	"github.com/jbenet/goprocess"
 )
 
+// taskContext is the state of a running task. mu guards the fields changed while the task runs, which
+// are read by other goroutines. err is only written by the task goroutine before done is closed.
 type taskContext struct {
 	mu             sync.Mutex
 	t              *task
@@ -20,21 +22,37 @@ type taskContext struct {
 }
 
 func (t *taskContext) Context() context.Context {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
 	return t.ctx
 }
 
 func (t *taskContext) Update(current, total int) {
+	t.mu.Lock()
+
 	if t.complete {
+		t.mu.Unlock()
 		return
 	}
 
 	t.current = current
 	t.total = total
 
+	t.mu.Unlock()
+
 	t.t.Invalidate()
 	t.t.Update(nil)
 }
 
+// progress returns the last progress reported by the task.
+func (t *taskContext) progress() (current, total int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.current, t.total
+}
+
 func (t *taskContext) Err() error {
 	t.Wait()
 
@@ -42,41 +60,39 @@ func (t *taskContext) Err() error {
 }
 
 func (t *taskContext) Cancel() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
 	if t.complete {
 		return
 	}
 
-	t.mu.Lock()
-	defer t.mu.Unlock()
-
 	if t.cancel != nil {
 		t.cancel()
 	}
 }
 
 func (t *taskContext) Wait() {
-	if t.complete {
-		return
-	}
-
 	if t.done != nil {
-		_, _ = <-t.done
+		<-t.done
 	}
 }
 
 func (t *taskContext) onComplete() {
+	t.mu.Lock()
+
 	if t.complete {
+		t.mu.Unlock()
 		return
 	}
 
 	defer close(t.done)
 
-	t.mu.Lock()
-	defer t.mu.Unlock()
-
 	t.cancel = nil
 	t.ctx = nil
 	t.complete = true
 
+	t.mu.Unlock()
+
 	t.t.Update(nil)
 }
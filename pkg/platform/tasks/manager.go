@@ -26,7 +26,7 @@ func NewManager() *Manager {
 		tasks: map[string]Task{},
 	}
 
-	m.NodeBase.Init(&m.NodeBase, "")
+	m.NodeBase.Init(m, "")
 
 	return m
 }
@@ -53,6 +53,9 @@ func (m *Manager) SpawnTask(ctx context.Context, taskFn TaskFunc) Task {
 	tc.ctx, tc.cancel = context.WithCancel(ctx)
 	tc.ctx = context.WithValue(tc.ctx, taskCtxKey, t)
 
+	// onComplete clears tc.ctx, so it is captured before the task can run.
+	taskCtx := tc.ctx
+
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
@@ -77,7 +80,7 @@ func (m *Manager) SpawnTask(ctx context.Context, taskFn TaskFunc) Task {
 		defer tc.onComplete()
 
 		defer func() {
-			if e := recover(); err != nil {
+			if e := recover(); e != nil {
 				if e, ok := e.(error); ok {
 					tc.err = e
 				} else {
@@ -94,7 +97,7 @@ func (m *Manager) SpawnTask(ctx context.Context, taskFn TaskFunc) Task {
 	})
 
 	go func() {
-		<-tc.ctx.Done()
+		<-taskCtx.Done()
 
 		m.onTaskComplete(t)
 	}()
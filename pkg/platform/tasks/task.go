@@ -35,6 +35,7 @@ type Task interface {
 	Error() error
 
 	Done() <-chan struct{}
+	Cancel()
 }
 
 type task struct {
@@ -49,10 +50,29 @@ type task struct {
 func (t *task) PsiNodeName() string   { return t.UUID() }
 func (t *task) Name() string          { return t.name }
 func (t *task) Description() string   { return t.description }
-func (t *task) Progress() float64     { return float64(t.tc.current) / float64(t.tc.total) }
-func (t *task) IsCompleted() bool     { return t.tc.complete }
 func (t *task) Error() error          { return t.tc.Err() }
 func (t *task) Done() <-chan struct{} { return t.tc.done }
+func (t *task) Cancel()               { t.tc.Cancel() }
+
+// IsCompleted returns true once the task has finished, without blocking.
+func (t *task) IsCompleted() bool {
+	select {
+	case <-t.Done():
+		return true
+	default:
+		return false
+	}
+}
+
+func (t *task) Progress() float64 {
+	current, total := t.tc.progress()
+
+	if total == 0 {
+		return 0
+	}
+
+	return float64(current) / float64(total)
+}
 
 func (t *task) Init() {
 	t.NodeBase.Init(t, "")
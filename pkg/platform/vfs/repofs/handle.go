@@ -61,6 +61,10 @@ func (o FsFileHandle) Put(src io.Reader) error {
 		return err
 	}
 
+	if wfs, ok := o.FS.(WritableFS); ok {
+		return wfs.WriteFile(o.Path, data)
+	}
+
 	return os.WriteFile(o.Path, data, 0644)
 }
 
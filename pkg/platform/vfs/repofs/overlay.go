@@ -0,0 +1,212 @@
+package repofs
+
+import (
+	"bytes"
+	"io/fs"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/pmezard/go-difflib/difflib"
+)
+
+// WritableFS is implemented by file systems that handle writes themselves.
+// FsFileHandle writes through it instead of writing to disk directly.
+type WritableFS interface {
+	WriteFile(name string, data []byte) error
+}
+
+// FileChange describes a file modified in an OverlayFS.
+type FileChange struct {
+	Path     string
+	Original []byte
+	Modified []byte
+}
+
+// UnifiedDiff returns the change as a unified diff.
+func (fc FileChange) UnifiedDiff() (string, error) {
+	return difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        splitLines(fc.Original),
+		B:        splitLines(fc.Modified),
+		FromFile: "a/" + fc.Path,
+		ToFile:   "b/" + fc.Path,
+		Context:  3,
+	})
+}
+
+func splitLines(data []byte) []string {
+	if len(data) == 0 {
+		return nil
+	}
+
+	lines := strings.SplitAfter(string(data), "\n")
+
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	} else {
+		lines[len(lines)-1] += "\n"
+	}
+
+	return lines
+}
+
+// OverlayFS keeps every write in memory on top of another FS, leaving the working tree
+// and the git repository untouched. Reads return the in-memory contents when a file was
+// written. StageAll, Commit and Push are no-ops.
+type OverlayFS struct {
+	FS
+
+	mu    sync.RWMutex
+	files map[string][]byte
+}
+
+// NewOverlayFS creates a new OverlayFS on top of base.
+func NewOverlayFS(base FS) *OverlayFS {
+	return &OverlayFS{
+		FS:    base,
+		files: map[string][]byte{},
+	}
+}
+
+func (o *OverlayFS) Open(name string) (fs.File, error) {
+	o.mu.RLock()
+	data, ok := o.files[o.key(name)]
+	o.mu.RUnlock()
+
+	if !ok {
+		return o.FS.Open(name)
+	}
+
+	return &overlayFile{
+		Reader: bytes.NewReader(data),
+		info: overlayFileInfo{
+			name: path.Base(name),
+			size: int64(len(data)),
+		},
+	}, nil
+}
+
+func (o *OverlayFS) WriteFile(name string, data []byte) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.files[o.key(name)] = bytes.Clone(data)
+
+	return nil
+}
+
+// Discard drops the in-memory contents of name, if any, so reads go to the underlying FS again.
+func (o *OverlayFS) Discard(name string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	delete(o.files, o.key(name))
+}
+
+// Changes returns the files whose contents differ from the underlying FS, sorted by path.
+func (o *OverlayFS) Changes() ([]FileChange, error) {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+
+	changes := make([]FileChange, 0, len(o.files))
+
+	for name, data := range o.files {
+		original, err := fs.ReadFile(o.FS, name)
+
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+
+		if bytes.Equal(original, data) {
+			continue
+		}
+
+		changes = append(changes, FileChange{
+			Path:     name,
+			Original: original,
+			Modified: data,
+		})
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Path < changes[j].Path
+	})
+
+	return changes, nil
+}
+
+// Diff returns the unified diff of every changed file.
+func (o *OverlayFS) Diff() (string, error) {
+	changes, err := o.Changes()
+
+	if err != nil {
+		return "", err
+	}
+
+	var buf strings.Builder
+
+	for _, change := range changes {
+		diff, err := change.UnifiedDiff()
+
+		if err != nil {
+			return "", err
+		}
+
+		buf.WriteString(diff)
+	}
+
+	return buf.String(), nil
+}
+
+func (o *OverlayFS) IsDirty() (bool, error) {
+	changes, err := o.Changes()
+
+	if err != nil {
+		return false, err
+	}
+
+	return len(changes) > 0, nil
+}
+
+func (o *OverlayFS) GetStagedChanges() (string, error)      { return o.Diff() }
+func (o *OverlayFS) GetUncommittedChanges() (string, error) { return o.Diff() }
+func (o *OverlayFS) Checkout(commit string) error           { return nil }
+func (o *OverlayFS) Commit(message string) (string, error)  { return "", nil }
+func (o *OverlayFS) Push() error                            { return nil }
+func (o *OverlayFS) StageAll() error                        { return nil }
+
+// key normalizes name to a slash separated path relative to the repository root.
+func (o *OverlayFS) key(name string) string {
+	if filepath.IsAbs(name) {
+		if rel, err := filepath.Rel(o.FS.Path(), name); err == nil {
+			name = rel
+		}
+	}
+
+	return path.Clean(filepath.ToSlash(name))
+}
+
+type overlayFile struct {
+	*bytes.Reader
+
+	info overlayFileInfo
+}
+
+func (f *overlayFile) Stat() (fs.FileInfo, error) { return f.info, nil }
+func (f *overlayFile) Close() error               { return nil }
+
+type overlayFileInfo struct {
+	name string
+	size int64
+}
+
+func (fi overlayFileInfo) Name() string       { return fi.name }
+func (fi overlayFileInfo) Size() int64        { return fi.size }
+func (fi overlayFileInfo) Mode() fs.FileMode  { return 0644 }
+func (fi overlayFileInfo) ModTime() time.Time { return time.Time{} }
+func (fi overlayFileInfo) IsDir() bool        { return false }
+func (fi overlayFileInfo) Sys() any           { return nil }
@@ -0,0 +1,143 @@
+package repofs
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newTestOverlay(t *testing.T) (*OverlayFS, string) {
+	root := t.TempDir()
+
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "pkg"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "pkg", "a.go"), []byte("package pkg\n\nfunc A() {}\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "README.md"), []byte("readme\n"), 0644))
+
+	base, err := NewFS(root)
+	require.NoError(t, err)
+
+	return NewOverlayFS(base), root
+}
+
+func TestOverlayFSReadWrite(t *testing.T) {
+	o, root := newTestOverlay(t)
+
+	// Reads fall through to the underlying FS until a file is written.
+	data, err := fs.ReadFile(o, "pkg/a.go")
+	require.NoError(t, err)
+	require.Equal(t, "package pkg\n\nfunc A() {}\n", string(data))
+
+	require.NoError(t, o.WriteFile("pkg/a.go", []byte("package pkg\n\nfunc B() {}\n")))
+
+	data, err = fs.ReadFile(o, "pkg/a.go")
+	require.NoError(t, err)
+	require.Equal(t, "package pkg\n\nfunc B() {}\n", string(data))
+
+	info, err := fs.Stat(o, "pkg/a.go")
+	require.NoError(t, err)
+	require.Equal(t, "a.go", info.Name())
+	require.Equal(t, int64(len(data)), info.Size())
+
+	// Absolute paths inside the repository address the same file.
+	data, err = fs.ReadFile(o, filepath.Join(root, "pkg", "a.go"))
+	require.NoError(t, err)
+	require.Equal(t, "package pkg\n\nfunc B() {}\n", string(data))
+
+	// The working tree is untouched.
+	data, err = os.ReadFile(filepath.Join(root, "pkg", "a.go"))
+	require.NoError(t, err)
+	require.Equal(t, "package pkg\n\nfunc A() {}\n", string(data))
+
+	// New files only exist in memory.
+	require.NoError(t, o.WriteFile(filepath.Join(root, "pkg", "b.go"), []byte("package pkg\n")))
+
+	data, err = fs.ReadFile(o, "pkg/b.go")
+	require.NoError(t, err)
+	require.Equal(t, "package pkg\n", string(data))
+
+	_, err = os.Stat(filepath.Join(root, "pkg", "b.go"))
+	require.True(t, os.IsNotExist(err))
+
+	// Discard restores the contents of the underlying FS.
+	o.Discard("pkg/a.go")
+
+	data, err = fs.ReadFile(o, "pkg/a.go")
+	require.NoError(t, err)
+	require.Equal(t, "package pkg\n\nfunc A() {}\n", string(data))
+
+	// The git operations are no-ops.
+	require.NoError(t, o.StageAll())
+	require.NoError(t, o.Push())
+
+	id, err := o.Commit("message")
+	require.NoError(t, err)
+	require.Empty(t, id)
+}
+
+func TestOverlayFSChanges(t *testing.T) {
+	o, _ := newTestOverlay(t)
+
+	dirty, err := o.IsDirty()
+	require.NoError(t, err)
+	require.False(t, dirty)
+
+	// Writing the same contents back is not a change.
+	require.NoError(t, o.WriteFile("README.md", []byte("readme\n")))
+
+	dirty, err = o.IsDirty()
+	require.NoError(t, err)
+	require.False(t, dirty)
+
+	require.NoError(t, o.WriteFile("pkg/a.go", []byte("package pkg\n\nfunc A() {}\n\nfunc B() {}\n")))
+	require.NoError(t, o.WriteFile("new.txt", []byte("new\n")))
+
+	changes, err := o.Changes()
+	require.NoError(t, err)
+	require.Len(t, changes, 2)
+	require.Equal(t, "new.txt", changes[0].Path)
+	require.Nil(t, changes[0].Original)
+	require.Equal(t, "pkg/a.go", changes[1].Path)
+
+	dirty, err = o.IsDirty()
+	require.NoError(t, err)
+	require.True(t, dirty)
+
+	diff, err := o.Diff()
+	require.NoError(t, err)
+	require.Equal(t, `--- a/new.txt
++++ b/new.txt
+@@ -0,0 +1 @@
++new
+--- a/pkg/a.go
++++ b/pkg/a.go
+@@ -1,3 +1,5 @@
+ package pkg
+ 
+ func A() {}
++
++func B() {}
+`, diff)
+
+	staged, err := o.GetStagedChanges()
+	require.NoError(t, err)
+	require.Equal(t, diff, staged)
+}
+
+func TestFileChangeUnifiedDiffMissingNewline(t *testing.T) {
+	diff, err := FileChange{
+		Path:     "a.txt",
+		Original: []byte("a\nb"),
+		Modified: []byte("a\nc\n"),
+	}.UnifiedDiff()
+	require.NoError(t, err)
+	require.Equal(t, `--- a/a.txt
++++ b/a.txt
+@@ -1,2 +1,2 @@
+ a
+-b
++c
+`, diff)
+}
@@ -1,5 +1,7 @@
 package psi
 
+import "sort"
+
 func NewCursor() Cursor {
 	return &cursor{}
 }
@@ -19,10 +21,14 @@ type Cursor interface {
 	// SkipChildren skips the children of the current node.
 	SkipChildren()
 
-	// WalkEdges walks the edges of the current node.
+	// WalkEdges walks the nodes the current node has edges to, after its children.
+	// Only edges of the kinds set with WithWalkEdges are followed, or every non-child edge if none were set.
 	WalkEdges()
 	// SkipEdges skips the edges of the current node.
 	SkipEdges()
+	// Edge returns the key of the edge followed to reach the current node,
+	// or nil if the node was reached as a child or is the root of the walk.
+	Edge() EdgeReference
 
 	// Replace replaces the current node with the given node, modifying the AST.
 	// If this operation happens during the enter phase, the children of the new node will be visited.
@@ -54,6 +60,7 @@ type cursor struct {
 
 	walkChildren bool
 	walkEdges    bool
+	edgeKinds    []EdgeKind
 }
 
 func (c *cursor) push(st cursorState) {
@@ -75,6 +82,14 @@ func (c *cursor) SkipChildren() { c.state.walkChildren = false }
 func (c *cursor) WalkEdges()    { c.state.walkEdges = true }
 func (c *cursor) SkipEdges()    { c.state.walkEdges = false }
 
+func (c *cursor) Edge() EdgeReference {
+	if it, ok := c.state.iterator.(edgeFollowingIterator); ok {
+		return it.Edge()
+	}
+
+	return nil
+}
+
 func (c *cursor) SetCurrent(node Node) {
 	c.state.current = node
 }
@@ -166,14 +181,20 @@ func (c *cursor) Walk(n Node, walkFn WalkFunc) (err error) {
 				return err
 			}
 
-			if c.state.walkEdges {
-				panic("not implemented")
-			}
+			if c.state.walkChildren || c.state.walkEdges {
+				var iterators []NodeIterator
+
+				if c.state.walkChildren {
+					iterators = append(iterators, c.state.current.ChildrenIterator())
+				}
+
+				if c.state.walkEdges {
+					iterators = append(iterators, c.edgeTargets(c.state.current))
+				}
 
-			if c.state.walkChildren {
 				st := cursorState{
 					depth:    c.state.depth + 1,
-					iterator: c.state.current.ChildrenIterator(),
+					iterator: AppendNodeIterator(iterators...),
 
 					walkChildren: c.walkChildren,
 					walkEdges:    c.walkEdges,
@@ -208,3 +229,39 @@ func (c *cursor) Walk(n Node, walkFn WalkFunc) (err error) {
 
 	return nil
 }
+
+// edgeTargets returns an iterator over the nodes n has edges to, filtered by the edge kinds of the cursor.
+// Edges are sorted by key so walks are deterministic.
+func (c *cursor) edgeTargets(n Node) NodeIterator {
+	var edges []Edge
+
+	for it := n.Edges(); it.Next(); {
+		e := it.Edge()
+
+		if e.To() == nil || !c.followsEdge(e.Kind()) {
+			continue
+		}
+
+		edges = append(edges, e)
+	}
+
+	sort.Slice(edges, func(i, j int) bool {
+		return edges[i].Key().GetKey().String() < edges[j].Key().GetKey().String()
+	})
+
+	return &edgeTargetIterator{edges: edges}
+}
+
+func (c *cursor) followsEdge(kind EdgeKind) bool {
+	if len(c.edgeKinds) == 0 {
+		return kind != EdgeKindChild
+	}
+
+	for _, k := range c.edgeKinds {
+		if k == kind {
+			return true
+		}
+	}
+
+	return false
+}
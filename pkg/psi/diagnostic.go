@@ -0,0 +1,202 @@
+package psi
+
+import (
+	"fmt"
+	"sort"
+)
+
+// EdgeKindDiagnostic links a node to the diagnostics published about it. Edges are named after the
+// source of the diagnostics.
+const EdgeKindDiagnostic EdgeKind = "diagnostic"
+
+// Sources of the diagnostics published by language implementations.
+const (
+	DiagnosticSourceParser    = "parser"
+	DiagnosticSourceTypeCheck = "typecheck"
+)
+
+// DiagnosticSeverity uses the values of the Language Server Protocol.
+type DiagnosticSeverity int
+
+const (
+	SeverityError   DiagnosticSeverity = 1
+	SeverityWarning DiagnosticSeverity = 2
+	SeverityInfo    DiagnosticSeverity = 3
+	SeverityHint    DiagnosticSeverity = 4
+)
+
+func (s DiagnosticSeverity) String() string {
+	switch s {
+	case SeverityError:
+		return "error"
+	case SeverityWarning:
+		return "warning"
+	case SeverityInfo:
+		return "info"
+	case SeverityHint:
+		return "hint"
+	}
+
+	return fmt.Sprintf("severity(%d)", int(s))
+}
+
+// Diagnostic is an error or warning about a range of source code, published by a language
+// implementation (parse and type errors) or a build step. Diagnostics are not part of the tree of
+// the code they are about: they are attached to the deepest node containing them through
+// EdgeKindDiagnostic edges. See PublishDiagnostics. Being outside of the tree, diagnostics are not
+// frozen with it: they are published again when the code is parsed or checked.
+type Diagnostic struct {
+	NodeBase
+
+	Severity DiagnosticSeverity `json:"severity"`
+	Message  string             `json:"message"`
+	// Source names the publisher of the diagnostic, e.g. "parser" or "typecheck".
+	Source string `json:"source"`
+	// Range is the range of code the diagnostic is about. It may be empty or invalid if the
+	// publisher has no precise location.
+	Range SourceRange `json:"range"`
+}
+
+var DiagnosticType = RegisterNodeType[*Diagnostic]("psi.Diagnostic")
+
+func NewDiagnostic(source string, severity DiagnosticSeverity, rng SourceRange, message string) *Diagnostic {
+	d := &Diagnostic{
+		Severity: severity,
+		Message:  message,
+		Source:   source,
+		Range:    rng,
+	}
+
+	d.Init(d, "")
+
+	return d
+}
+
+// Error formats the diagnostic like compiler errors, so diagnostics can be reported as errors.
+func (d *Diagnostic) Error() string {
+	if !d.Range.IsValid() {
+		if d.Range.File != "" {
+			return fmt.Sprintf("%s: %s: %s", d.Range.File, d.Severity, d.Message)
+		}
+
+		return fmt.Sprintf("%s: %s", d.Severity, d.Message)
+	}
+
+	return fmt.Sprintf("%s:%d:%d: %s: %s", d.Range.File, d.Range.Start.Line, d.Range.Start.Column, d.Severity, d.Message)
+}
+
+func (d *Diagnostic) String() string { return d.Error() }
+
+// NodeDiagnostics returns the diagnostics attached to n.
+func NodeDiagnostics(n Node) (result []*Diagnostic) {
+	for it := n.Edges(); it.Next(); {
+		e := it.Edge()
+
+		if e.Key().GetKey().Kind != EdgeKindDiagnostic {
+			continue
+		}
+
+		if d, ok := e.To().(*Diagnostic); ok {
+			result = append(result, d)
+		}
+	}
+
+	sortDiagnostics(result)
+
+	return
+}
+
+// CollectDiagnostics returns the diagnostics attached to root and its descendants, ordered by
+// file and position.
+func CollectDiagnostics(root Node) (result []*Diagnostic) {
+	walkDiagnosticTree(root, func(n Node) {
+		result = append(result, NodeDiagnostics(n)...)
+	})
+
+	sortDiagnostics(result)
+
+	return
+}
+
+// PublishDiagnostics replaces the diagnostics published by source in the tree of root. Each diagnostic
+// is attached to the deepest node of the tree whose source range contains its start, or to root.
+func PublishDiagnostics(root Node, source string, diagnostics []*Diagnostic) {
+	ClearDiagnostics(root, source)
+
+	counts := map[Node]int64{}
+
+	for _, d := range diagnostics {
+		target := root
+
+		if d.Range.IsValid() {
+			target = nodeAtOffset(root, d.Range.Start.Offset)
+		}
+
+		target.SetEdge(EdgeKey{Kind: EdgeKindDiagnostic, Name: source, Index: counts[target]}, d)
+		counts[target]++
+	}
+}
+
+// ClearDiagnostics removes the diagnostics published by source in the tree of root.
+func ClearDiagnostics(root Node, source string) {
+	walkDiagnosticTree(root, func(n Node) {
+		var keys []EdgeKey
+
+		for it := n.Edges(); it.Next(); {
+			key := it.Edge().Key().GetKey()
+
+			if key.Kind == EdgeKindDiagnostic && key.Name == source {
+				keys = append(keys, key)
+			}
+		}
+
+		for _, key := range keys {
+			n.UnsetEdge(key)
+		}
+	})
+}
+
+// walkDiagnosticTree calls fn for n and its descendants, skipping diagnostics themselves.
+func walkDiagnosticTree(n Node, fn func(n Node)) {
+	if _, ok := n.(*Diagnostic); ok {
+		return
+	}
+
+	fn(n)
+
+	for _, child := range n.Children() {
+		walkDiagnosticTree(child, fn)
+	}
+}
+
+// nodeAtOffset returns the deepest descendant of root whose source range contains offset.
+func nodeAtOffset(root Node, offset int) Node {
+	for {
+		var next Node
+
+		for _, child := range root.Children() {
+			if rng, ok := NodeSourceRange(child); ok && rng.Contains(offset) {
+				next = child
+				break
+			}
+		}
+
+		if next == nil {
+			return root
+		}
+
+		root = next
+	}
+}
+
+func sortDiagnostics(diagnostics []*Diagnostic) {
+	sort.SliceStable(diagnostics, func(i, j int) bool {
+		a, b := diagnostics[i].Range, diagnostics[j].Range
+
+		if a.File != b.File {
+			return a.File < b.File
+		}
+
+		return a.Start.Offset < b.Start.Offset
+	})
+}
@@ -0,0 +1,47 @@
+package psi
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func offsetRange(start, end int) SourceRange {
+	return SourceRange{
+		File:  "test.go",
+		Start: SourcePosition{Offset: start, Line: 1, Column: start + 1},
+		End:   SourcePosition{Offset: end, Line: 1, Column: end + 1},
+	}
+}
+
+func TestPublishDiagnostics(t *testing.T) {
+	file := &rangeTestFile{ranges: map[Node]SourceRange{}}
+	file.Init(file, "")
+
+	a := newFrozenTestNode(file, "a")
+	b := newFrozenTestNode(a, "b")
+	c := newFrozenTestNode(file, "c")
+
+	file.ranges[a] = offsetRange(0, 10)
+	file.ranges[b] = offsetRange(2, 5)
+	file.ranges[c] = offsetRange(10, 20)
+
+	inB := NewDiagnostic("typecheck", SeverityError, offsetRange(3, 4), "undefined: x")
+	inA := NewDiagnostic("typecheck", SeverityWarning, offsetRange(7, 8), "unused")
+	nowhere := NewDiagnostic("typecheck", SeverityError, SourceRange{}, "missing package")
+	parse := NewDiagnostic("parser", SeverityError, offsetRange(12, 12), "expected ';'")
+
+	PublishDiagnostics(file, "typecheck", []*Diagnostic{inB, inA, nowhere})
+	PublishDiagnostics(file, "parser", []*Diagnostic{parse})
+
+	require.Equal(t, []*Diagnostic{inB}, NodeDiagnostics(b))
+	require.Equal(t, []*Diagnostic{inA}, NodeDiagnostics(a))
+	require.Equal(t, []*Diagnostic{parse}, NodeDiagnostics(c))
+	require.Equal(t, []*Diagnostic{nowhere}, NodeDiagnostics(file))
+	require.Len(t, CollectDiagnostics(file), 4)
+	require.Equal(t, "test.go:1:4: error: undefined: x", inB.Error())
+
+	PublishDiagnostics(file, "typecheck", nil)
+
+	require.Equal(t, []*Diagnostic{parse}, CollectDiagnostics(file))
+}
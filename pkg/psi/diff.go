@@ -0,0 +1,321 @@
+package psi
+
+import (
+	"encoding/json"
+
+	"github.com/ipfs/go-cid"
+	"github.com/pkg/errors"
+)
+
+// Snapshot is a tree of nodes frozen in a Freezer.
+type Snapshot struct {
+	Freezer *Freezer
+	Root    cid.Cid
+}
+
+// Freeze freezes root and its descendants in a new Freezer.
+func Freeze(root Node) (Snapshot, error) {
+	f := NewFreezer()
+
+	entry, err := f.Add(root)
+
+	if err != nil {
+		return Snapshot{}, err
+	}
+
+	return Snapshot{Freezer: f, Root: entry.PhysAddr}, nil
+}
+
+// Thaw rebuilds the live tree of nodes of the snapshot. See Freezer.Thaw.
+func (s Snapshot) Thaw() (Node, error) {
+	return s.Freezer.Thaw(s.Root)
+}
+
+type NodeChangeKind string
+
+const (
+	// NodeAdded is a node of the new snapshot missing from the old one.
+	NodeAdded NodeChangeKind = "added"
+	// NodeRemoved is a node of the old snapshot missing from the new one.
+	NodeRemoved NodeChangeKind = "removed"
+	// NodeMoved is a node whose parent changed.
+	NodeMoved NodeChangeKind = "moved"
+	// NodeChanged is a node whose type, name, attributes or edges changed, or whose
+	// remaining children were reordered.
+	NodeChanged NodeChangeKind = "changed"
+)
+
+// NodeChange is a structural change between two snapshots.
+type NodeChange struct {
+	Kind NodeChangeKind `json:"kind"`
+	UUID string         `json:"uuid"`
+	Type string         `json:"type"`
+
+	// Path is the path of the node in the new snapshot, or in the old one for removed nodes.
+	Path Path `json:"path"`
+	// PreviousPath is the path of moved nodes in the old snapshot.
+	PreviousPath Path `json:"previousPath,omitempty"`
+}
+
+func (c NodeChange) String() string {
+	if c.Kind == NodeMoved {
+		return string(c.Kind) + " " + c.Type + " " + c.PreviousPath.String() + " -> " + c.Path.String()
+	}
+
+	return string(c.Kind) + " " + c.Type + " " + c.Path.String()
+}
+
+// Diff compares two snapshots of a tree and returns the nodes added, moved and changed in b, in
+// pre-order, followed by the nodes of a removed from b. Nodes are matched by UUID, then by path and
+// type, since some languages recreate the nodes of a source file, with new UUIDs, whenever it is
+// reloaded. Nodes frozen at the same address in both snapshots are unchanged.
+func Diff(a, b Snapshot) ([]NodeChange, error) {
+	before, err := collectSnapshot(a)
+
+	if err != nil {
+		return nil, err
+	}
+
+	after, err := collectSnapshot(b)
+
+	if err != nil {
+		return nil, err
+	}
+
+	m := matchSnapshots(before, after)
+
+	var changes []NodeChange
+
+	for _, n := range after.order {
+		old := m.toBefore[n]
+
+		change := NodeChange{
+			UUID: n.raw.UUID,
+			Type: n.raw.Type,
+			Path: n.path,
+		}
+
+		if old == nil {
+			change.Kind = NodeAdded
+			changes = append(changes, change)
+			continue
+		}
+
+		if m.movedFrom(old, n) {
+			moved := change
+			moved.Kind = NodeMoved
+			moved.PreviousPath = old.path
+			changes = append(changes, moved)
+		}
+
+		if old.addr.Equals(n.addr) {
+			continue
+		}
+
+		if !m.sameContent(old, n) || !m.sameChildOrder(old, n) {
+			change.Kind = NodeChanged
+			changes = append(changes, change)
+		}
+	}
+
+	for _, n := range before.order {
+		if m.toAfter[n] != nil {
+			continue
+		}
+
+		changes = append(changes, NodeChange{
+			Kind: NodeRemoved,
+			UUID: n.raw.UUID,
+			Type: n.raw.Type,
+			Path: n.path,
+		})
+	}
+
+	return changes, nil
+}
+
+type snapshotNode struct {
+	raw    RawNode
+	addr   cid.Cid
+	parent *snapshotNode
+	path   Path
+	// key identifies the node by type and by path relative to the root of the snapshot.
+	key string
+}
+
+type snapshotIndex struct {
+	nodes map[NodeID]*snapshotNode
+	keys  map[string]*snapshotNode
+	order []*snapshotNode
+}
+
+// collectSnapshot indexes the nodes of a snapshot by UUID and by path and type, computing their paths
+// like NodeBase.CanonicalPath.
+func collectSnapshot(s Snapshot) (*snapshotIndex, error) {
+	idx := &snapshotIndex{
+		nodes: map[NodeID]*snapshotNode{},
+		keys:  map[string]*snapshotNode{},
+	}
+
+	var visit func(addr cid.Cid, parent *snapshotNode, index int) error
+
+	visit = func(addr cid.Cid, parent *snapshotNode, index int) error {
+		raw, ok := s.Freezer.Get(addr)
+
+		if !ok {
+			return errors.Wrapf(ErrNodeNotFound, "frozen node %s", addr)
+		}
+
+		n := &snapshotNode{raw: raw, addr: addr, parent: parent}
+
+		var element PathElement
+
+		if parent == nil {
+			n.path = PathFromComponents(PathElement{Kind: EdgeKindChild, Name: raw.UUID})
+		} else {
+			if raw.Name != "" {
+				element = PathElement{Kind: EdgeKindChild, Name: raw.Name}
+			} else {
+				element = PathElement{Kind: EdgeKindChild, Index: int64(index), HasIndex: true}
+			}
+
+			n.path = parent.path.Child(element)
+			n.key = parent.key + "/" + element.String()
+		}
+
+		idx.nodes[raw.UUID] = n
+		idx.order = append(idx.order, n)
+
+		if _, ok := idx.keys[raw.Type+n.key]; !ok {
+			idx.keys[raw.Type+n.key] = n
+		}
+
+		for i, child := range raw.Children {
+			if err := visit(child.PhysAddr, n, i); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	if err := visit(s.Root, nil, 0); err != nil {
+		return nil, err
+	}
+
+	return idx, nil
+}
+
+// snapshotMatching pairs the nodes of two snapshots.
+type snapshotMatching struct {
+	before, after *snapshotIndex
+
+	toBefore map[*snapshotNode]*snapshotNode
+	toAfter  map[*snapshotNode]*snapshotNode
+}
+
+// matchSnapshots pairs the nodes with the same UUID, then the remaining nodes with the same path and type.
+func matchSnapshots(before, after *snapshotIndex) *snapshotMatching {
+	m := &snapshotMatching{
+		before:   before,
+		after:    after,
+		toBefore: map[*snapshotNode]*snapshotNode{},
+		toAfter:  map[*snapshotNode]*snapshotNode{},
+	}
+
+	for _, n := range after.order {
+		if old, ok := before.nodes[n.raw.UUID]; ok {
+			m.pair(old, n)
+		}
+	}
+
+	for _, n := range after.order {
+		if m.toBefore[n] != nil {
+			continue
+		}
+
+		if old, ok := before.keys[n.raw.Type+n.key]; ok && m.toAfter[old] == nil && after.nodes[old.raw.UUID] == nil {
+			m.pair(old, n)
+		}
+	}
+
+	return m
+}
+
+func (m *snapshotMatching) pair(old, n *snapshotNode) {
+	m.toBefore[n] = old
+	m.toAfter[old] = n
+}
+
+// movedFrom reports whether the parent of n is not the match of the parent of old.
+func (m *snapshotMatching) movedFrom(old, n *snapshotNode) bool {
+	if old.parent == nil || n.parent == nil {
+		return old.parent != n.parent
+	}
+
+	return m.toAfter[old.parent] != n.parent
+}
+
+// beforeUUID returns the UUID of the node matching the node of the new snapshot with the given UUID.
+// Nodes outside of the snapshot keep their UUID.
+func (m *snapshotMatching) beforeUUID(id NodeID) NodeID {
+	if n, ok := m.after.nodes[id]; ok {
+		if old := m.toBefore[n]; old != nil {
+			return old.raw.UUID
+		}
+	}
+
+	return id
+}
+
+// sameContent compares the nodes, ignoring their children.
+func (m *snapshotMatching) sameContent(old, n *snapshotNode) bool {
+	a, b := old.raw, n.raw
+
+	if a.Type != b.Type || a.Name != b.Name || len(a.Edges) != len(b.Edges) {
+		return false
+	}
+
+	for i := range a.Edges {
+		if a.Edges[i].Key != b.Edges[i].Key || a.Edges[i].ToUUID != m.beforeUUID(b.Edges[i].ToUUID) {
+			return false
+		}
+	}
+
+	attrsA, errA := json.Marshal(a.Attributes)
+	attrsB, errB := json.Marshal(b.Attributes)
+
+	return errA == nil && errB == nil && string(attrsA) == string(attrsB)
+}
+
+// sameChildOrder reports whether the children kept by n are in the same order as in old.
+// Added, removed and moved children are reported on their own.
+func (m *snapshotMatching) sameChildOrder(old, n *snapshotNode) bool {
+	var kept []*snapshotNode
+
+	for _, child := range old.raw.Children {
+		c := m.before.nodes[child.UUID]
+
+		if match := m.toAfter[c]; match != nil && match.parent == n {
+			kept = append(kept, c)
+		}
+	}
+
+	i := 0
+
+	for _, child := range n.raw.Children {
+		match := m.toBefore[m.after.nodes[child.UUID]]
+
+		if match == nil || match.parent != old {
+			continue
+		}
+
+		if i >= len(kept) || kept[i] != match {
+			return false
+		}
+
+		i++
+	}
+
+	return i == len(kept)
+}
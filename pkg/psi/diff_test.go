@@ -0,0 +1,161 @@
+package psi
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type frozenTestNode struct {
+	NodeBase
+
+	name string
+}
+
+var frozenTestNodeType = RegisterNodeType[*frozenTestNode]("test.Frozen")
+
+func (n *frozenTestNode) PsiNodeName() string { return n.name }
+
+func (n *frozenTestNode) ThawNode(raw RawNode) error {
+	n.name = raw.Name
+
+	return nil
+}
+
+func newFrozenTestNode(parent Node, name string) *frozenTestNode {
+	n := &frozenTestNode{name: name}
+	n.typ = frozenTestNodeType
+	n.Init(n, "")
+
+	if parent != nil {
+		n.SetParent(parent)
+	}
+
+	return n
+}
+
+func TestFreezerThaw(t *testing.T) {
+	root := newFrozenTestNode(nil, "root")
+	a := newFrozenTestNode(root, "a")
+	b := newFrozenTestNode(root, "b")
+	c := newFrozenTestNode(b, "c")
+
+	a.SetAttribute("answer", "42")
+	c.SetEdge(EdgeKey{Kind: EdgeKindReference, Name: "a"}, a)
+
+	s, err := Freeze(root)
+	require.NoError(t, err)
+
+	again, err := Freeze(root)
+	require.NoError(t, err)
+	require.Equal(t, s.Root, again.Root)
+
+	thawed, err := s.Thaw()
+	require.NoError(t, err)
+
+	require.IsType(t, &frozenTestNode{}, thawed)
+	require.Equal(t, root.UUID(), thawed.UUID())
+	require.Equal(t, "test.Frozen", NodeTypeName(thawed))
+	require.Len(t, thawed.Children(), 2)
+
+	ta := thawed.Children()[0].(*frozenTestNode)
+	tc := thawed.Children()[1].Children()[0].(*frozenTestNode)
+
+	require.Equal(t, "a", ta.name)
+	require.Equal(t, "c", tc.name)
+
+	v, ok := ta.GetAttribute("answer")
+	require.True(t, ok)
+	require.Equal(t, "42", v)
+
+	e := tc.GetEdge(EdgeKey{Kind: EdgeKindReference, Name: "a"})
+	require.NotNil(t, e)
+	require.Equal(t, ta, e.To())
+}
+
+func TestDiff(t *testing.T) {
+	root := newFrozenTestNode(nil, "root")
+	a := newFrozenTestNode(root, "a")
+	b := newFrozenTestNode(root, "b")
+	c := newFrozenTestNode(b, "c")
+
+	before, err := Freeze(root)
+	require.NoError(t, err)
+
+	changes, err := Diff(before, before)
+	require.NoError(t, err)
+	require.Empty(t, changes)
+
+	a.SetAttribute("answer", "42")
+	c.SetParent(a)
+	d := newFrozenTestNode(root, "d")
+	root.RemoveChildNode(b)
+
+	after, err := Freeze(root)
+	require.NoError(t, err)
+
+	changes, err = Diff(before, after)
+	require.NoError(t, err)
+
+	type change struct {
+		Kind NodeChangeKind
+		UUID string
+		Path string
+	}
+
+	var got []change
+
+	for _, ch := range changes {
+		got = append(got, change{ch.Kind, ch.UUID, ch.Path.String()})
+	}
+
+	rootPath := "/#" + root.UUID()
+
+	require.Equal(t, []change{
+		{NodeChanged, a.UUID(), rootPath + "/#a"},
+		{NodeMoved, c.UUID(), rootPath + "/#a/#c"},
+		{NodeAdded, d.UUID(), rootPath + "/#d"},
+		{NodeRemoved, b.UUID(), rootPath + "/#b"},
+	}, got)
+
+	require.Equal(t, rootPath+"/#b/#c", changes[1].PreviousPath.String())
+}
+
+func TestDiffRecreatedNodes(t *testing.T) {
+	root := newFrozenTestNode(nil, "root")
+	a := newFrozenTestNode(root, "a")
+	b := newFrozenTestNode(root, "b")
+	newFrozenTestNode(a, "c")
+
+	a.SetAttribute("answer", "42")
+	b.SetEdge(EdgeKey{Kind: EdgeKindReference, Name: "a"}, a)
+
+	before, err := Freeze(root)
+	require.NoError(t, err)
+
+	// Recreate a and its child with new UUIDs, like reloading a source file does.
+	root.RemoveChildNode(a)
+	a2 := newFrozenTestNode(nil, "a")
+	a2.SetAttribute("answer", "42")
+	newFrozenTestNode(a2, "c")
+	root.InsertChildrenAt(0, a2)
+	b.SetEdge(EdgeKey{Kind: EdgeKindReference, Name: "a"}, a2)
+
+	after, err := Freeze(root)
+	require.NoError(t, err)
+
+	changes, err := Diff(before, after)
+	require.NoError(t, err)
+	require.Empty(t, changes)
+
+	d := newFrozenTestNode(a2, "d")
+
+	after, err = Freeze(root)
+	require.NoError(t, err)
+
+	changes, err = Diff(before, after)
+	require.NoError(t, err)
+	require.Len(t, changes, 1)
+	require.Equal(t, NodeAdded, changes[0].Kind)
+	require.Equal(t, d.UUID(), changes[0].UUID)
+}
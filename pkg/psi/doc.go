@@ -23,6 +23,20 @@ The PSI package provides several node types that can be used to represent differ
 - The *psi.Container type represents a container node in the PSI graph. It is used to represent code elements that can contain other code elements, like functions, classes, etc.
 - The *psi.Leaf type represents a leaf node in the PSI graph. It is used to represent code elements that cannot contain other code elements, like variables, constants, etc.
 
+Concurrency:
+Nodes do not lock by themselves. Each graph has a readers-writer lock guarding every node attached to it:
+goroutines mutating nodes of a graph must hold its write lock (Graph.Lock, or UpdateGraph), and goroutines
+only reading them must hold its read lock (Graph.RLock, or ReadGraph). The lock is not reentrant. Nodes that
+are not attached to a graph yet belong to the goroutine building them.
+
+Graph notifications (OnNodeInvalidated, OnNodeUpdated, OnTransactionCommitted) and the observers of node
+properties are called by the writer with the write lock held, so they must not try to acquire the lock.
+Children, Edges and Attributes return snapshots, so a node can be mutated while iterating over them.
+Iterators must still be consumed while holding the lock.
+
+Subscribers of Graph.Subscribe receive events on buffered channels instead, so they can take the read
+lock to inspect the changed nodes. A subscriber falling too far behind has its channel closed.
+
 Examples:
 Here are some examples of how to use the PSI package:
 
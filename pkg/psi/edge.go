@@ -4,8 +4,6 @@ import (
 	"fmt"
 
 	"gonum.org/v1/gonum/graph"
-
-	"github.com/greenboxal/agibootstrap/pkg/platform/stdlib/obsfx/collectionsfx"
 )
 
 type EdgeID int64
@@ -165,27 +163,24 @@ type EdgeIterator interface {
 	Edge() Edge
 }
 
-type nodeEdgeIterator struct {
-	n       *NodeBase
-	it      collectionsfx.MapIterator[EdgeKey, Edge]
+// edgeSliceIterator iterates over a snapshot of the edges of a node, so the node can be mutated while iterating.
+type edgeSliceIterator struct {
+	edges   []Edge
 	current Edge
 }
 
-func (e *nodeEdgeIterator) Next() bool {
-	if e.it == nil {
-		e.it = e.n.edges.MapIterator()
-	}
-
-	if !e.it.Next() {
+func (e *edgeSliceIterator) Next() bool {
+	if len(e.edges) == 0 {
 		return false
 	}
 
-	e.current = e.it.Value()
+	e.current = e.edges[0]
+	e.edges = e.edges[1:]
 
 	return true
 }
 
-func (e *nodeEdgeIterator) Edge() Edge {
+func (e *edgeSliceIterator) Edge() Edge {
 	return e.current
 }
 
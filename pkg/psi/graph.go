@@ -2,12 +2,26 @@ package psi
 
 import (
 	"reflect"
+	"sync"
 	"sync/atomic"
 
 	"gonum.org/v1/gonum/graph/multi"
 )
 
+// Graph is a graph of nodes.
+//
+// A graph is guarded by a readers-writer lock, which covers every node attached to it.
+// See the Concurrency section of the package documentation.
 type Graph interface {
+	// Lock acquires the write lock of the graph.
+	Lock()
+	// Unlock releases the write lock of the graph.
+	Unlock()
+	// RLock acquires the read lock of the graph.
+	RLock()
+	// RUnlock releases the read lock of the graph.
+	RUnlock()
+
 	Add(n Node)
 	Remove(n Node)
 	Replace(old, new Node)
@@ -23,18 +37,55 @@ type Graph interface {
 
 	OnNodeUpdated(n Node)
 	OnNodeInvalidated(n Node)
+
+	// BeginTransaction starts a transaction batching the mutations of the nodes of the graph.
+	// Only one transaction can be in progress at a time. Transactions, Undo and Redo must be used
+	// with the write lock held.
+	BeginTransaction() (*Transaction, error)
+	// CurrentTransaction returns the transaction in progress, or nil.
+	CurrentTransaction() *Transaction
+	// Undo restores the nodes mutated by the last committed transaction to their state before it.
+	Undo() error
+	// Redo applies the last undone transaction again.
+	Redo() error
+
+	// OnTransactionCommitted is called once per committed, undone or redone transaction,
+	// instead of OnNodeInvalidated and OnNodeUpdated for each mutation in the transaction.
+	OnTransactionCommitted(tx *Transaction)
+
+	// Subscribe returns a channel receiving the events of the graph that pass filter. Events of a
+	// transaction are delivered when it is committed, undone or redone. The channel is closed by
+	// Unsubscribe, or when the subscriber falls SubscriptionBufferSize events behind.
+	Subscribe(filter GraphEventFilter) <-chan GraphEvent
+	// Unsubscribe stops the delivery of events to a channel returned by Subscribe and closes it.
+	Unsubscribe(ch <-chan GraphEvent)
+
+	publishEvent(ev GraphEvent)
 }
 
 type BaseGraph struct {
 	self Graph
 
+	mu sync.RWMutex
+
 	g         *multi.DirectedGraph
 	nodeIdMap map[NodeID]int64
 
 	nodeIdCounter atomic.Int64
 	edgeIdCounter atomic.Int64
+
+	tx         *Transaction
+	journal    []*Transaction
+	journalPos int
+
+	subscriptions subscriptionHub
 }
 
+func (g *BaseGraph) Lock()    { g.mu.Lock() }
+func (g *BaseGraph) Unlock()  { g.mu.Unlock() }
+func (g *BaseGraph) RLock()   { g.mu.RLock() }
+func (g *BaseGraph) RUnlock() { g.mu.RUnlock() }
+
 func (g *BaseGraph) Nodes() NodeIterator {
 	return &graphNodeIterator{g: g}
 }
@@ -101,6 +152,120 @@ func (g *BaseGraph) Replace(old, new Node) {
 func (g *BaseGraph) OnNodeInvalidated(n Node) {}
 func (g *BaseGraph) OnNodeUpdated(n Node)     {}
 
+// OnTransactionCommitted notifies the graph of each node updated by the transaction through OnNodeUpdated.
+func (g *BaseGraph) OnTransactionCommitted(tx *Transaction) {
+	for _, n := range tx.UpdatedNodes() {
+		g.self.OnNodeUpdated(n)
+	}
+}
+
+func (g *BaseGraph) Subscribe(filter GraphEventFilter) <-chan GraphEvent {
+	return g.subscriptions.subscribe(filter)
+}
+
+func (g *BaseGraph) Unsubscribe(ch <-chan GraphEvent) {
+	g.subscriptions.unsubscribe(ch)
+}
+
+func (g *BaseGraph) publishEvent(ev GraphEvent) {
+	g.subscriptions.publish(ev)
+}
+
+func (g *BaseGraph) BeginTransaction() (*Transaction, error) {
+	if g.tx != nil {
+		return nil, ErrTransactionInProgress
+	}
+
+	g.tx = newTransaction(g)
+
+	return g.tx, nil
+}
+
+func (g *BaseGraph) CurrentTransaction() *Transaction { return g.tx }
+
+// CanUndo returns true if there is a committed transaction to undo.
+func (g *BaseGraph) CanUndo() bool { return g.journalPos > 0 }
+
+// CanRedo returns true if there is an undone transaction to redo.
+func (g *BaseGraph) CanRedo() bool { return g.journalPos < len(g.journal) }
+
+func (g *BaseGraph) Undo() error {
+	if g.tx != nil {
+		return ErrTransactionInProgress
+	}
+
+	if !g.CanUndo() {
+		return ErrNothingToUndo
+	}
+
+	g.journalPos--
+
+	tx := g.journal[g.journalPos]
+	tx.replay(tx.before, func() {
+		for i := len(tx.undos) - 1; i >= 0; i-- {
+			tx.undos[i]()
+		}
+	})
+
+	return nil
+}
+
+func (g *BaseGraph) Redo() error {
+	if g.tx != nil {
+		return ErrTransactionInProgress
+	}
+
+	if !g.CanRedo() {
+		return ErrNothingToRedo
+	}
+
+	tx := g.journal[g.journalPos]
+	tx.replay(tx.after, func() {
+		for _, fn := range tx.redos {
+			fn()
+		}
+	})
+
+	g.journalPos++
+
+	return nil
+}
+
+// appendJournal records a committed transaction, discarding the transactions that were undone.
+func (g *BaseGraph) appendJournal(tx *Transaction) {
+	g.journal = append(g.journal[:g.journalPos], tx)
+
+	if len(g.journal) > MaxJournalLength {
+		g.journal = g.journal[len(g.journal)-MaxJournalLength:]
+	}
+
+	g.journalPos = len(g.journal)
+}
+
+// ReadGraph runs fn with the read lock of g held. If g is nil, fn runs without locking.
+func ReadGraph(g Graph, fn func() error) error {
+	if g == nil {
+		return fn()
+	}
+
+	g.RLock()
+	defer g.RUnlock()
+
+	return fn()
+}
+
+// UpdateGraph runs fn with the write lock of g held. If g is nil, fn runs without locking.
+func UpdateGraph(g Graph, fn func() error) error {
+	if g == nil {
+		return fn()
+	}
+
+	g.Lock()
+	defer g.Unlock()
+
+	return fn()
+}
+
 type graphNodeIterator struct {
 	g       *BaseGraph
 	current Node
@@ -0,0 +1,99 @@
+package psi
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestGraphConcurrency mutates a graph from a writer while readers walk and query it,
+// following the locking rules of the package. Run with -race.
+func TestGraphConcurrency(t *testing.T) {
+	g := &transactionTestGraph{}
+	g.Init(g)
+
+	root := newQueryTestNode(nil, "root")
+	root.attachToGraph(g)
+
+	const iterations = 200
+
+	var wg sync.WaitGroup
+
+	wg.Add(1)
+
+	go func() {
+		defer wg.Done()
+
+		for i := 0; i < iterations; i++ {
+			err := UpdateGraph(g, func() error {
+				return RunInTransaction(g, func(tx *Transaction) error {
+					n := newQueryTestNode(nil, fmt.Sprintf("n%d", i), "TODO")
+					n.SetParent(root)
+					n.SetAttribute("index", i)
+					root.SetEdge(EdgeKey{Kind: EdgeKindReference, Name: n.name}, n)
+
+					if children := root.Children(); len(children) > 10 {
+						old := children[0]
+						old.SetParent(nil)
+						root.UnsetEdge(EdgeKey{Kind: EdgeKindReference, Name: old.(*queryTestNode).name})
+					}
+
+					return nil
+				})
+			})
+
+			require.NoError(t, err)
+
+			if i%10 == 0 {
+				require.NoError(t, UpdateGraph(g, g.Undo))
+			}
+		}
+	}()
+
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for i := 0; i < iterations; i++ {
+				err := ReadGraph(g, func() error {
+					count := 0
+
+					if err := Walk(root, func(cursor Cursor, entering bool) error {
+						if entering {
+							count++
+							_ = cursor.Node().Attributes()
+						}
+
+						return nil
+					}, WithWalkEdges()); err != nil {
+						return err
+					}
+
+					it, err := Query(root, "//*[comment~=TODO]")
+
+					if err != nil {
+						return err
+					}
+
+					for it.Next() {
+						_ = it.Node().CanonicalPath()
+					}
+
+					require.Greater(t, count, 0)
+
+					return nil
+				})
+
+				require.NoError(t, err)
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	require.LessOrEqual(t, len(root.Children()), 11)
+}
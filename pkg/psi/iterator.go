@@ -95,6 +95,14 @@ func (n *nestedNodeIterator) Next() bool {
 	return true
 }
 
+func (n *nestedNodeIterator) Edge() EdgeReference {
+	if it, ok := n.current.(edgeFollowingIterator); ok {
+		return it.Edge()
+	}
+
+	return nil
+}
+
 func (n *nestedNodeIterator) Prepend(iterator NodeIterator) NodeIterator {
 	return &nestedNodeIterator{iterators: []NodeIterator{iterator, n}}
 }
@@ -103,6 +111,45 @@ func (n *nestedNodeIterator) Append(iterator NodeIterator) NodeIterator {
 	return &nestedNodeIterator{iterators: []NodeIterator{n, iterator}}
 }
 
+// edgeFollowingIterator is implemented by iterators that can tell the edge followed to reach the current node.
+type edgeFollowingIterator interface {
+	Edge() EdgeReference
+}
+
+type edgeTargetIterator struct {
+	current Edge
+	edges   []Edge
+}
+
+func (e *edgeTargetIterator) Value() Node { return e.Node() }
+
+func (e *edgeTargetIterator) Node() Node {
+	if e.current == nil {
+		return nil
+	}
+
+	return e.current.To()
+}
+
+func (e *edgeTargetIterator) Edge() EdgeReference {
+	if e.current == nil {
+		return nil
+	}
+
+	return e.current.Key()
+}
+
+func (e *edgeTargetIterator) Next() bool {
+	if len(e.edges) == 0 {
+		return false
+	}
+
+	e.current = e.edges[0]
+	e.edges = e.edges[1:]
+
+	return true
+}
+
 func AppendNodeIterator(iterators ...NodeIterator) NodeIterator {
 	return &nestedNodeIterator{iterators: iterators}
 }
@@ -2,7 +2,6 @@ package antlrbridge
 
 import (
 	"context"
-	"strings"
 
 	"github.com/antlr4-go/antlr/v4"
 
@@ -64,7 +63,7 @@ func (nb *NodeBase[T]) Update(context.Context) error {
 	nb.NodeBase.Update(nil)
 
 	if nb.IsContainer() {
-		for i := 0; i < nb.node.GetChildCount(); i++ {
+		for nb.node.GetChildCount() > 0 {
 			nb.Ast().RemoveLastChild()
 		}
 
@@ -90,15 +89,24 @@ func NewNodeFor[T antlr.ParseTree](sf *SourceFile, node T) *NodeBase[T] {
 }
 
 type astConversionContext struct {
-	parentStack []Node
+	parentStack []*NodeBase[antlr.ParserRuleContext]
 	result      Node
 	sf          *SourceFile
+	// claimed are the indexes of the tokens whose comments were attached to a node.
+	claimed map[int]bool
 }
 
 func (a *astConversionContext) VisitTerminal(node antlr.TerminalNode) {
 	n := NewNodeFor(a.sf, node)
 	n.isTerminal = true
 
+	// Comments before a token starting no rule, like the comments at the end of a block, belong to
+	// the rule containing it, except for the root of the file.
+	if len(a.parentStack) > 1 {
+		parent := a.parentStack[len(a.parentStack)-1]
+		parent.comments = append(parent.comments, a.claimComments(node.GetSymbol())...)
+	}
+
 	a.addToParent(n)
 }
 
@@ -109,28 +117,30 @@ func (a *astConversionContext) EnterEveryRule(ctx antlr.ParserRuleContext) {
 	n := NewNodeFor(a.sf, ctx)
 	n.isTerminal = false
 
-	if a.sf != nil {
-		hidden := a.sf.tokens.GetHiddenTokensToLeft(ctx.GetStart().GetTokenIndex(), 1)
+	// Comments belong to the outermost rule starting after them, except for the root of the file.
+	if len(a.parentStack) > 0 {
+		n.comments = a.claimComments(ctx.GetStart())
+	}
 
-		for _, tk := range hidden {
-			if tk.GetChannel() == 1 {
-				txt := tk.GetText()
-				txt = strings.TrimSpace(txt)
+	a.parentStack = append(a.parentStack, n)
+}
 
-				if txt != "" {
-					if strings.HasPrefix(txt, "# TODO:") {
-						txt = strings.Replace(txt, "# TODO:", "// TODO:", 1)
-					}
+// claimComments returns the comments before start, unless they were already claimed.
+func (a *astConversionContext) claimComments(start antlr.Token) (comments []string) {
+	if a.sf == nil || !OwnsToken(a.sf.tokens, start) || a.claimed[start.GetTokenIndex()] {
+		return nil
+	}
 
-					n.comments = append(n.comments, txt)
-				}
-			}
+	index := start.GetTokenIndex()
+	a.claimed[index] = true
+
+	for i := a.sf.declarations().LeadStart(index); i < index; i++ {
+		if txt, ok := a.sf.l.grammar.Comment(a.sf.tokens.Get(i)); ok {
+			comments = append(comments, txt)
 		}
 	}
 
-	a.addToParent(n)
-
-	a.parentStack = append(a.parentStack, n)
+	return
 }
 
 func (a *astConversionContext) addToParent(n psi.Node) {
@@ -140,13 +150,17 @@ func (a *astConversionContext) addToParent(n psi.Node) {
 	}
 }
 
+// ExitEveryRule adds rules to their parent once their children are converted, since adding a node
+// updates its parse tree from its children.
 func (a *astConversionContext) ExitEveryRule(ctx antlr.ParserRuleContext) {
 	a.result = a.parentStack[len(a.parentStack)-1]
 	a.parentStack = a.parentStack[:len(a.parentStack)-1]
+
+	a.addToParent(a.result)
 }
 
 func AstToPsi(sf *SourceFile, parsed antlr.ParserRuleContext) psi.Node {
-	ctx := &astConversionContext{sf: sf}
+	ctx := &astConversionContext{sf: sf, claimed: map[int]bool{}}
 
 	walker := antlr.NewParseTreeWalker()
 	walker.Walk(ctx, parsed)
@@ -0,0 +1,82 @@
+package antlrbridge
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"github.com/antlr4-go/antlr/v4"
+
+	"github.com/greenboxal/agibootstrap/pkg/psi"
+)
+
+// SyntaxErrorListener collects the syntax errors reported by the lexer and the parser as diagnostics.
+type SyntaxErrorListener struct {
+	*antlr.DefaultErrorListener
+
+	name string
+	text string
+
+	diagnostics []*psi.Diagnostic
+}
+
+// NewSyntaxErrorListener returns a SyntaxErrorListener for the file name, whose text is text.
+func NewSyntaxErrorListener(name, text string) *SyntaxErrorListener {
+	return &SyntaxErrorListener{
+		DefaultErrorListener: antlr.NewDefaultErrorListener(),
+
+		name: name,
+		text: text,
+	}
+}
+
+func (l *SyntaxErrorListener) SyntaxError(_ antlr.Recognizer, _ interface{}, line, column int, msg string, _ antlr.RecognitionException) {
+	pos := textPosition(l.text, line, column)
+
+	l.diagnostics = append(l.diagnostics, psi.NewDiagnostic(
+		psi.DiagnosticSourceParser,
+		psi.SeverityError,
+		psi.SourceRange{File: l.name, Start: pos, End: pos},
+		msg,
+	))
+}
+
+// Publish replaces the parser diagnostics of sf with the syntax errors collected, or with err if
+// the parse failed without reporting any.
+func (l *SyntaxErrorListener) Publish(sf psi.Node, err error) {
+	diagnostics := l.diagnostics
+
+	if len(diagnostics) == 0 && err != nil {
+		diagnostics = append(diagnostics, psi.NewDiagnostic(
+			psi.DiagnosticSourceParser,
+			psi.SeverityError,
+			psi.SourceRange{File: l.name},
+			err.Error(),
+		))
+	}
+
+	psi.PublishDiagnostics(sf, psi.DiagnosticSourceParser, diagnostics)
+}
+
+// textPosition converts an ANTLR position, whose column counts characters from 0, to a position in text.
+func textPosition(text string, line, column int) psi.SourcePosition {
+	offset := 0
+
+	for i := 1; i < line; i++ {
+		next := strings.IndexByte(text[offset:], '\n')
+
+		if next == -1 {
+			break
+		}
+
+		offset += next + 1
+	}
+
+	lineStart := offset
+
+	for i := 0; i < column && offset < len(text); i++ {
+		_, size := utf8.DecodeRuneInString(text[offset:])
+		offset += size
+	}
+
+	return psi.SourcePosition{Offset: offset, Line: line, Column: offset - lineStart + 1}
+}
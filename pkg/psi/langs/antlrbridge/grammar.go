@@ -0,0 +1,26 @@
+package antlrbridge
+
+import (
+	"github.com/antlr4-go/antlr/v4"
+)
+
+// Grammar adapts an ANTLR grammar, generated or written on the ANTLR runtime, to a Language.
+type Grammar interface {
+	// NewLexer returns the lexer of the grammar for input, reporting syntax errors to listener.
+	NewLexer(input antlr.CharStream, listener antlr.ErrorListener) antlr.Lexer
+
+	// Parse parses a file from the start rule of the grammar, reporting syntax errors to listener.
+	// The error returned, if any, fails the parse.
+	Parse(tokens *antlr.CommonTokenStream, listener antlr.ErrorListener) (antlr.ParserRuleContext, error)
+
+	// IsTrivia returns true for the hidden tokens kept with the code following them: whitespace and
+	// comments.
+	IsTrivia(tk antlr.Token) bool
+
+	// Comment returns the text of a comment token. TODO comments are returned as "// TODO: ...",
+	// whatever the comment syntax of the language.
+	Comment(tk antlr.Token) (string, bool)
+
+	// Declarations returns the declarations of a parsed file, merged by kind and name.
+	Declarations(tokens *antlr.CommonTokenStream, root antlr.ParserRuleContext) []*Declaration
+}
@@ -7,14 +7,18 @@ import (
 	"github.com/greenboxal/agibootstrap/pkg/psi"
 )
 
+// Language implements psi.Language for a generated ANTLR grammar. Languages embed it and call Init
+// with their Grammar.
 type Language struct {
 	self    psi.Language
 	project project.Project
+	grammar Grammar
 }
 
-func (l *Language) Init(self psi.Language, p project.Project) {
+func (l *Language) Init(self psi.Language, p project.Project, g Grammar) {
 	l.project = p
 	l.self = self
+	l.grammar = g
 }
 
 func (l *Language) CreateSourceFile(fileName string, fileHandle repofs.FileHandle) psi.SourceFile {
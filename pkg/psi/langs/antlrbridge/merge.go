@@ -0,0 +1,394 @@
+package antlrbridge
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/antlr4-go/antlr/v4"
+)
+
+// DeclarationKindImport is the kind of import declarations, like C includes. Imports are named after
+// what they import, and are added to the files missing them instead of being replaced.
+const DeclarationKindImport = "import"
+
+// Declaration is a named declaration of a parsed file, located by the indexes of its tokens.
+// Declarations are merged by kind and name, see Edits.
+type Declaration struct {
+	Kind string
+	Name string
+
+	// Start and Stop are the indexes of the first and last tokens of the declaration.
+	Start, Stop int
+
+	// Members are the declarations of the body of a class-like declaration. Declarations with a body
+	// are merged member by member. BodyStop is the index of the token closing the body, or 0 for
+	// declarations without a body. Bodies without a closing token, like Python's, stop before the
+	// first token of the line after them.
+	Members  []*Declaration
+	BodyStop int
+	// BodyIndent, when set, is the indentation of the members added to a body without members.
+	BodyIndent string
+
+	key string
+}
+
+// Key identifies the declaration in its file, by the kinds and names of the declarations containing it.
+func (d *Declaration) Key() string { return d.key }
+
+// DeclarationFile is the token stream of a parsed file with its declarations.
+type DeclarationFile struct {
+	Tokens       *antlr.CommonTokenStream
+	Declarations []*Declaration
+
+	// End is the index of the token before which declarations are added to a file without any, like
+	// the EOF token, or the #endif closing the include guard of a C header.
+	End int
+
+	// IsTrivia returns true for the hidden tokens kept with the declaration following them: whitespace
+	// and comments.
+	IsTrivia func(tk antlr.Token) bool
+
+	byKey map[string]*Declaration
+}
+
+// Lookup returns the declaration with the given key.
+func (f *DeclarationFile) Lookup(key string) *Declaration {
+	if f.byKey == nil {
+		f.byKey = map[string]*Declaration{}
+
+		var index func(parent string, decls []*Declaration)
+
+		index = func(parent string, decls []*Declaration) {
+			for _, d := range decls {
+				d.key = parent + "/" + d.Kind + " " + d.Name
+				f.byKey[d.key] = d
+
+				index(d.key, d.Members)
+			}
+		}
+
+		index("", f.Declarations)
+	}
+
+	return f.byKey[key]
+}
+
+// Text returns the parsed text of the tokens from start to stop.
+func (f *DeclarationFile) Text(start, stop int) string {
+	return f.Tokens.GetTextFromInterval(antlr.NewInterval(start, stop))
+}
+
+// LeadStart returns the index of the first token of the comments and blank lines before the token at
+// start. Trivia on the line of the previous token belongs to that line.
+func (f *DeclarationFile) LeadStart(start int) int {
+	lead := start
+
+	for lead > 0 && f.IsTrivia(f.Tokens.Get(lead-1)) {
+		lead--
+	}
+
+	if lead == 0 {
+		return 0
+	}
+
+	for i := lead; i < start; i++ {
+		if strings.Contains(f.Tokens.Get(i).GetText(), "\n") {
+			return i + 1
+		}
+	}
+
+	return start
+}
+
+// CommentStart returns the index of the first token of the line of the first comment between the
+// tokens at lead and start, or of the line of start if there are none.
+func (f *DeclarationFile) CommentStart(lead, start int) int {
+	line := lead
+
+	for i := lead; i < start; i++ {
+		text := f.Tokens.Get(i).GetText()
+
+		if strings.TrimSpace(text) != "" {
+			return line
+		}
+
+		if strings.Contains(text, "\n") {
+			line = i + 1
+		}
+	}
+
+	return line
+}
+
+// Indent returns the indentation of the line of the token at index, or an empty string if the token
+// does not start its line.
+func (f *DeclarationFile) Indent(index int) string {
+	var indent string
+
+	for i := index - 1; i >= 0; i-- {
+		text := f.Tokens.Get(i).GetText()
+
+		if nl := strings.LastIndexByte(text, '\n'); nl >= 0 {
+			text = text[nl+1:]
+
+			if strings.TrimLeft(text, " \t") != "" {
+				return ""
+			}
+
+			return text + indent
+		}
+
+		if strings.TrimLeft(text, " \t") != "" {
+			return ""
+		}
+
+		indent = text + indent
+	}
+
+	return indent
+}
+
+// Edits are the changes merged into a file. They are kept across merges, so later merges override
+// earlier ones, and replayed on the token stream of the file by Apply.
+type Edits struct {
+	// replacements maps the keys of the declarations of the file to the text replacing them.
+	replacements map[string]replacement
+	insertions   []*insertion
+}
+
+// replacement is the text replacing a declaration. It replaces the blank lines before the declaration
+// only if it has its own, otherwise it starts at the line of its first comment.
+type replacement struct {
+	text       string
+	withBlanks bool
+}
+
+// insertion is a declaration inserted before the token at index.
+type insertion struct {
+	key   string
+	index int
+	text  string
+}
+
+// Merge merges the declarations of src into dst by kind and name:
+//
+//   - declarations with a body found in both, like classes, are merged member by member,
+//   - other declarations found in both are replaced,
+//   - new declarations are added after the last declaration of dst, or of the body they belong to,
+//   - missing imports are added after the last import of dst.
+//
+// Declarations of src matching a member of scope, when set, are merged into scope. Declarations keep
+// the comments before them and are reindented to their new place.
+func (e *Edits) Merge(dst, src *DeclarationFile, scope *Declaration) {
+	src.Lookup("")
+
+	for _, nd := range src.Declarations {
+		if nd.Kind == DeclarationKindImport {
+			e.MergeImport(dst, src, nd)
+			continue
+		}
+
+		if scope != nil && dst.Lookup(scope.key+"/"+nd.Kind+" "+nd.Name) != nil {
+			e.MergeDeclaration(dst, src, nd, scope)
+			continue
+		}
+
+		e.MergeDeclaration(dst, src, nd, nil)
+	}
+}
+
+// MergeDeclaration merges nd, a declaration of src, into the body of parent, a declaration of dst, or
+// at the top level of dst if parent is nil. See Merge.
+func (e *Edits) MergeDeclaration(dst, src *DeclarationFile, nd *Declaration, parent *Declaration) {
+	key := "/" + nd.Kind + " " + nd.Name
+	lead := src.LeadStart(nd.Start)
+	text := src.Text(lead, nd.Stop)
+	indent := src.Indent(nd.Start)
+
+	if parent != nil {
+		key = parent.key + key
+	}
+
+	if existing := dst.Lookup(key); existing != nil {
+		if existing.BodyStop > 0 && nd.BodyStop > 0 {
+			for _, member := range nd.Members {
+				e.MergeDeclaration(dst, src, member, existing)
+			}
+
+			return
+		}
+
+		withBlanks := lead < src.CommentStart(lead, nd.Start)
+
+		if !withBlanks {
+			text = src.Text(src.CommentStart(lead, nd.Start), nd.Stop)
+		}
+
+		e.replace(key, Reindent(text, indent, dst.Indent(existing.Start)), withBlanks)
+
+		return
+	}
+
+	if parent == nil {
+		e.Append(dst, key, Reindent(text, indent, ""))
+		return
+	}
+
+	if len(parent.Members) == 0 {
+		text = strings.TrimLeft(text, "\r\n")
+
+		if parent.BodyIndent != "" {
+			text = Reindent(text, indent, parent.BodyIndent)
+		}
+
+		e.insert(key, parent.BodyStop, text+"\n")
+		return
+	}
+
+	last := parent.Members[len(parent.Members)-1]
+
+	e.insert(key, last.Stop+1, "\n"+Reindent(text, indent, dst.Indent(parent.Members[0].Start)))
+}
+
+// Append adds a top-level declaration to dst, after its last declaration.
+func (e *Edits) Append(dst *DeclarationFile, key, text string) {
+	text = strings.TrimLeft(text, "\r\n")
+
+	for i := len(dst.Declarations) - 1; i >= 0; i-- {
+		if d := dst.Declarations[i]; d.Kind != DeclarationKindImport {
+			e.insert(key, d.Stop+1, "\n\n"+text)
+			return
+		}
+	}
+
+	e.insert(key, dst.End, text+"\n")
+}
+
+// MergeImport adds the import nd of src after the last import of dst, unless dst already has it.
+func (e *Edits) MergeImport(dst, src *DeclarationFile, nd *Declaration) {
+	key := "/" + nd.Kind + " " + nd.Name
+
+	if dst.Lookup(key) != nil || e.inserted(key) {
+		return
+	}
+
+	text := strings.TrimRight(src.Text(nd.Start, nd.Stop), "\r\n")
+
+	var last, first *Declaration
+
+	for _, d := range dst.Declarations {
+		if first == nil {
+			first = d
+		}
+
+		if d.Kind == DeclarationKindImport {
+			last = d
+		}
+	}
+
+	switch {
+	case last != nil:
+		// Some imports end with their line, like C includes.
+		if strings.HasSuffix(dst.Text(last.Start, last.Stop), "\n") {
+			e.insert(key, last.Stop+1, text+"\n")
+		} else {
+			e.insert(key, last.Stop+1, "\n"+text)
+		}
+	case first != nil:
+		e.insert(key, dst.LeadStart(first.Start), text+"\n\n")
+	default:
+		e.insert(key, dst.End, text+"\n")
+	}
+}
+
+func (e *Edits) replace(key, text string, withBlanks bool) {
+	if e.replacements == nil {
+		e.replacements = map[string]replacement{}
+	}
+
+	e.replacements[key] = replacement{text: text, withBlanks: withBlanks}
+}
+
+// insert inserts a declaration, or replaces the text of a declaration inserted by a previous merge.
+func (e *Edits) insert(key string, index int, text string) {
+	for _, ins := range e.insertions {
+		if ins.key == key {
+			ins.text = text
+			return
+		}
+	}
+
+	e.insertions = append(e.insertions, &insertion{key: key, index: index, text: text})
+}
+
+func (e *Edits) inserted(key string) bool {
+	for _, ins := range e.insertions {
+		if ins.key == key {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Apply replays the edits on a new rewriter of the token stream of dst.
+func (e *Edits) Apply(dst *DeclarationFile) *antlr.TokenStreamRewriter {
+	rewriter := antlr.NewTokenStreamRewriter(dst.Tokens)
+
+	for key, r := range e.replacements {
+		if d := dst.Lookup(key); d != nil {
+			start := dst.LeadStart(d.Start)
+
+			if !r.withBlanks {
+				start = dst.CommentStart(start, d.Start)
+			}
+
+			rewriter.ReplaceDefault(start, d.Stop, r.text)
+		}
+	}
+
+	// Insertions at the same index are combined in order, since the rewriter would reverse them.
+	texts := map[int]string{}
+
+	for _, ins := range e.insertions {
+		texts[ins.index] += ins.text
+	}
+
+	indexes := make([]int, 0, len(texts))
+
+	for index := range texts {
+		indexes = append(indexes, index)
+	}
+
+	sort.Ints(indexes)
+
+	for _, index := range indexes {
+		rewriter.InsertBeforeDefault(index, texts[index])
+	}
+
+	return rewriter
+}
+
+// Reindent replaces the indentation from of the lines of text with to. Lines indented less than from
+// are indented with to.
+func Reindent(text, from, to string) string {
+	if from == to {
+		return text
+	}
+
+	lines := strings.Split(text, "\n")
+
+	for i, line := range lines {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		if strings.HasPrefix(line, from) {
+			lines[i] = to + line[len(from):]
+		} else {
+			lines[i] = to + strings.TrimLeft(line, " \t")
+		}
+	}
+
+	return strings.Join(lines, "\n")
+}
@@ -3,19 +3,17 @@ package antlrbridge
 import (
 	"bytes"
 	"context"
-	"fmt"
 	"go/token"
 	"io"
+	"strings"
+	"unicode/utf8"
 
 	"github.com/antlr4-go/antlr/v4"
 	"github.com/pkg/errors"
 
-	"github.com/greenboxal/agibootstrap/pkg/langs/pylang/pyparser"
-
 	"github.com/greenboxal/agibootstrap/pkg/platform/mdutils"
 	"github.com/greenboxal/agibootstrap/pkg/platform/vfs/repofs"
 	"github.com/greenboxal/agibootstrap/pkg/psi"
-	"github.com/greenboxal/agibootstrap/pkg/psi/rendering"
 )
 
 type SourceFile struct {
@@ -34,6 +32,9 @@ type SourceFile struct {
 	file     *token.File
 	tokens   *antlr.CommonTokenStream
 	rewriter *antlr.TokenStreamRewriter
+
+	decls *DeclarationFile
+	edits Edits
 }
 
 func NewSourceFile(l *Language, name string, handle repofs.FileHandle) *SourceFile {
@@ -100,6 +101,8 @@ func (sf *SourceFile) SetRoot(node antlr.ParserRuleContext, original string, tok
 	sf.parsed = node
 	sf.tokens = tokens
 	sf.rewriter = antlr.NewTokenStreamRewriter(tokens)
+	sf.decls = nil
+	sf.edits = Edits{}
 
 	sf.file = sf.l.project.FileSet().AddFile(sf.name, -1, len(sf.original))
 	sf.file.SetLinesForContent([]byte(original))
@@ -123,128 +126,213 @@ func (sf *SourceFile) Parse(filename string, sourceCode string) (result psi.Node
 		}
 	}()
 
+	// Only the parse of the file itself publishes diagnostics, not the parse of snippets.
+	isRoot := sf.root == nil
+	listener := NewSyntaxErrorListener(filename, sourceCode)
+
+	g := sf.l.grammar
 	stream := antlr.NewInputStream(sourceCode)
-	lexer := pyparser.NewPython3Lexer(stream)
-	tokens := antlr.NewCommonTokenStream(lexer, 0)
-	parser := pyparser.NewPython3Parser(tokens)
-	parsed := parser.File_input()
+	tokens := antlr.NewCommonTokenStream(g.NewLexer(stream, listener), 0)
+	parsed, err := g.Parse(tokens, listener)
 
-	if parser.HasError() {
-		sf.err = fmt.Errorf("%s", parser.GetError())
+	if err != nil {
+		sf.err = err
 
-		return nil, sf.err
-	}
+		if isRoot {
+			listener.Publish(sf, sf.err)
+		}
 
-	for _, tk := range tokens.GetAllTokens() {
-		fmt.Printf("%s\n", tk)
+		return nil, sf.err
 	}
 
-	if sf.root == nil {
+	if isRoot {
 		if err := sf.SetRoot(parsed, sourceCode, tokens); err != nil {
 			return nil, err
 		}
 
+		listener.Publish(sf, nil)
+
 		return sf.root, nil
 	}
 
 	return AstToPsi(sf, parsed), nil
 }
 
-type CharTokenizer struct {
-}
+// ToCode renders node with the changes merged into the file. The file and its root are rendered in
+// full. Other nodes are rendered with the comments before them, and unindented.
+func (sf *SourceFile) ToCode(node psi.Node) (mdutils.CodeBlock, error) {
+	if sf.rewriter == nil {
+		return mdutils.CodeBlock{}, errors.Errorf("cannot render %s: the file was not parsed", sf.name)
+	}
+
+	var code string
+
+	if node == sf || node == sf.root {
+		code = sf.rewriter.GetTextDefault()
+	} else {
+		n, ok := node.(Node)
+
+		if !ok || n.Tree() == nil {
+			return mdutils.CodeBlock{}, errors.Errorf("cannot render %T: not a %s node", node, sf.l.self.Name())
+		}
+
+		interval := n.Tree().GetSourceInterval()
 
-func (c CharTokenizer) Count(text string) (int, error) {
-	return len(text), nil
+		if interval.Start >= 0 && interval.Start <= interval.Stop {
+			decls := sf.declarations()
+			code = sf.rewriter.GetText(antlr.DefaultProgramName, antlr.NewInterval(decls.LeadStart(interval.Start), interval.Stop))
+			code = Reindent(strings.TrimLeft(code, "\r\n"), decls.Indent(interval.Start), "")
+		}
+	}
+
+	return mdutils.CodeBlock{
+		Language: string(sf.l.self.Name()),
+		Code:     code,
+		Filename: sf.Name(),
+	}, nil
 }
 
-func (sf *SourceFile) ToCode(node psi.Node) (mdutils.CodeBlock, error) {
-	var renderer *rendering.PruningRenderer
-	n := node.(Node)
+// MergeCompletionResults merges the declarations of newAst into the file by kind and name, see Edits.Merge.
+// Declarations matching a member of the declaration enclosing the scope are merged into it. The changes
+// are recorded in the token stream rewriter of the file, and rendered by ToCode, until the file is loaded
+// again.
+func (sf *SourceFile) MergeCompletionResults(ctx context.Context, scope psi.Scope, cursor psi.Cursor, newSource psi.SourceFile, newAst psi.Node) error {
+	newSf, ok := newSource.(*SourceFile)
 
-	renderer = &rendering.PruningRenderer{
-		Tokenizer: &CharTokenizer{},
-		Weight: func(state *rendering.NodeState, node psi.Node) float32 {
-			return 1
-		},
-		Write: func(w *rendering.TokenBuffer, node psi.Node) (total int, err error) {
-			n := node.(Node)
+	if !ok || newSf.parsed == nil || sf.parsed == nil {
+		return errors.Errorf("cannot merge %s: not a parsed %s source file", newSource.Name(), sf.l.self.Name())
+	}
 
-			hidden := sf.tokens.GetHiddenTokensToLeft(n.Tree().GetSourceInterval().Start, 1)
+	var enclosing *Declaration
 
-			for _, tk := range hidden {
-				n, err := w.Write([]byte(tk.GetText()))
+	if scope != nil && scope.Root() != nil {
+		enclosing = sf.enclosingDeclaration(scope.Root())
+	}
 
-				if err != nil {
-					return total, err
-				}
+	sf.edits.Merge(sf.declarations(), newSf.declarations(), enclosing)
+	sf.rewriter = sf.edits.Apply(sf.declarations())
 
-				total += n
-			}
+	return nil
+}
+
+// declarations returns the declarations of the file, as parsed by the last Load.
+func (sf *SourceFile) declarations() *DeclarationFile {
+	if sf.decls == nil {
+		sf.decls = &DeclarationFile{
+			Tokens:       sf.tokens,
+			Declarations: sf.l.grammar.Declarations(sf.tokens, sf.parsed),
+			End:          sf.tokens.Size() - 1,
+			IsTrivia:     sf.l.grammar.IsTrivia,
+		}
+	}
 
-			if n.IsContainer() {
-				for _, child := range n.Children() {
-					num, err := w.WriteNode(renderer, child)
+	return sf.decls
+}
 
-					if err != nil {
-						return total, err
-					}
+// enclosingDeclaration returns the innermost declaration with a body containing n.
+func (sf *SourceFile) enclosingDeclaration(n psi.Node) (result *Declaration) {
+	bn, ok := n.(Node)
 
-					total += num
-				}
-			} else {
-				t := n.Token()
-				str := t.GetText()
+	if !ok || bn.Tree() == nil {
+		return nil
+	}
 
-				return w.Write([]byte(str))
+	interval := bn.Tree().GetSourceInterval()
+	decls := sf.declarations()
+
+	// Keys are assigned by the first lookup.
+	decls.Lookup("")
+
+	for current := decls.Declarations; ; {
+		var next *Declaration
+
+		for _, d := range current {
+			if d.Start <= interval.Start && interval.Stop <= d.Stop {
+				next = d
+				break
 			}
+		}
 
-			return
-		},
+		if next == nil {
+			return result
+		}
+
+		if next.BodyStop > 0 {
+			result = next
+		}
+
+		current = next.Members
 	}
+}
 
-	buf := bytes.NewBuffer(nil)
-	_, err := renderer.Render(n, buf)
+// NodeSourceRange implements psi.SourceRangeProvider from the tokens the node was parsed from.
+func (sf *SourceFile) NodeSourceRange(n psi.Node) (psi.SourceRange, bool) {
+	pn, ok := n.(Node)
 
-	if err != nil {
-		panic(err)
+	if !ok {
+		return psi.SourceRange{}, false
 	}
 
-	txt := buf.String()
-	txt = n.Tree().GetText()
+	start, stop := pn.Token(), pn.Token()
 
-	return mdutils.CodeBlock{
-		Language: string(sf.l.self.Name()),
-		Code:     txt,
-		Filename: sf.Name(),
-	}, nil
+	if ast := pn.Ast(); ast != nil {
+		start, stop = ast.GetStart(), ast.GetStop()
+	}
+
+	return TokenRange(sf.file, sf.original, sf.tokens, start, stop)
 }
 
-func (sf *SourceFile) GetAdjustedTokenInterval(n Node) antlr.Interval {
-	interval := n.Tree().GetSourceInterval()
-	hiddenTokens := sf.tokens.GetHiddenTokensToLeft(interval.Start, 1)
+// TokenRange returns the range in file from the start of start to the end of stop, if both tokens
+// belong to tokens, lexed from text.
+func TokenRange(file *token.File, text string, tokens *antlr.CommonTokenStream, start, stop antlr.Token) (psi.SourceRange, bool) {
+	if file == nil || !OwnsToken(tokens, start) || !OwnsToken(tokens, stop) {
+		return psi.SourceRange{}, false
+	}
+
+	startOffset := tokenOffset(file, text, start)
+	endOffset := tokenOffset(file, text, stop)
+
+	if stop.GetTokenType() != antlr.TokenEOF {
+		endOffset += len(stop.GetText())
+	}
+
+	// Rules matching no token stop before they start.
+	if endOffset < startOffset {
+		endOffset = startOffset
+	}
 
-	if len(hiddenTokens) > 0 {
-		interval.Start = hiddenTokens[0].GetStart()
+	if endOffset > len(text) {
+		endOffset = len(text)
 	}
 
-	return interval
+	return psi.SourceRangeFromOffsets(file, startOffset, endOffset), true
 }
 
-func (sf *SourceFile) MergeCompletionResults(ctx context.Context, scope psi.Scope, cursor psi.Cursor, newSource psi.SourceFile, newAst psi.Node) error {
+// OwnsToken returns true if tk is one of tokens, and not a token of another file or a synthetic token.
+func OwnsToken(tokens *antlr.CommonTokenStream, tk antlr.Token) bool {
+	if tk == nil || tokens == nil {
+		return false
+	}
 
-	return nil
+	index := tk.GetTokenIndex()
+
+	return index >= 0 && index < tokens.Size() && tokens.Get(index) == tk
 }
 
-func (sf *SourceFile) getRange(txt string, start antlr.Token, end antlr.Token) string {
-	startLinePos := sf.file.LineStart(start.GetLine())
-	startLineOffset := sf.file.Offset(startLinePos) + start.GetColumn()
+// tokenOffset returns the byte offset of tk in text. ANTLR columns count characters, not bytes.
+func tokenOffset(file *token.File, text string, tk antlr.Token) int {
+	line := tk.GetLine()
 
-	if end == nil {
-		return sf.original[startLineOffset:]
+	if line < 1 || line > file.LineCount() {
+		return tk.GetStart()
 	}
 
-	endLinePos := sf.file.LineStart(end.GetLine())
-	endLineOffset := sf.file.Offset(endLinePos) + end.GetColumn()
+	offset := file.Offset(file.LineStart(line))
+
+	for i := 0; i < tk.GetColumn() && offset < len(text); i++ {
+		_, size := utf8.DecodeRuneInString(text[offset:])
+		offset += size
+	}
 
-	return txt[startLineOffset:endLineOffset]
+	return offset
 }
@@ -7,6 +7,8 @@ import (
 	"github.com/antlr4-go/antlr/v4"
 
 	"github.com/greenboxal/agibootstrap/pkg/psi"
+	"github.com/greenboxal/agibootstrap/pkg/psi/langs/antlrbridge"
+	"github.com/greenboxal/agibootstrap/pkg/psi/langs/clang/cparser"
 )
 
 type Node interface {
@@ -60,6 +62,8 @@ type astConversionContext struct {
 
 	parentStack []Node
 	result      Node
+	// claimed are the indexes of the tokens whose comments were attached to a node.
+	claimed map[int]bool
 }
 
 func (a *astConversionContext) VisitTerminal(node antlr.TerminalNode) {
@@ -71,14 +75,11 @@ func (a *astConversionContext) VisitErrorNode(node antlr.ErrorNode) {
 func (a *astConversionContext) EnterEveryRule(ctx antlr.ParserRuleContext) {
 	n := NewNodeFor(ctx)
 
-	if a.sf != nil {
-		hidden := a.sf.tokens.GetHiddenTokensToLeft(ctx.GetStart().GetTokenIndex(), 2)
-
-		for _, tk := range hidden {
-			if tk.GetChannel() == 2 {
-				n.comments = append(n.comments, tk.GetText())
-			}
-		}
+	// Comments belong to the outermost rule starting after them, except for the rules of the whole file.
+	switch ctx.(type) {
+	case *cparser.CompilationUnitContext, *cparser.TranslationUnitContext:
+	default:
+		a.collectComments(n, ctx)
 	}
 
 	if len(a.parentStack) > 0 {
@@ -89,6 +90,27 @@ func (a *astConversionContext) EnterEveryRule(ctx antlr.ParserRuleContext) {
 	a.parentStack = append(a.parentStack, n)
 }
 
+func (a *astConversionContext) collectComments(n *NodeBase[antlr.ParserRuleContext], ctx antlr.ParserRuleContext) {
+	if a.sf == nil || !antlrbridge.OwnsToken(a.sf.tokens, ctx.GetStart()) {
+		return
+	}
+
+	start := ctx.GetStart().GetTokenIndex()
+
+	if a.claimed[start] {
+		return
+	}
+
+	a.claimed[start] = true
+
+	for i := a.sf.declarations().LeadStart(start); i < start; i++ {
+		switch tk := a.sf.tokens.Get(i); tk.GetTokenType() {
+		case cparser.CLexerBlockComment, cparser.CLexerLineComment:
+			n.comments = append(n.comments, tk.GetText())
+		}
+	}
+}
+
 func (a *astConversionContext) ExitEveryRule(ctx antlr.ParserRuleContext) {
 	a.result = a.parentStack[len(a.parentStack)-1]
 	a.parentStack = a.parentStack[:len(a.parentStack)-1]
@@ -96,7 +118,8 @@ func (a *astConversionContext) ExitEveryRule(ctx antlr.ParserRuleContext) {
 
 func AstToPsi(sf *SourceFile, parsed antlr.ParserRuleContext) psi.Node {
 	ctx := &astConversionContext{
-		sf: sf,
+		sf:      sf,
+		claimed: map[int]bool{},
 	}
 
 	walker := antlr.NewParseTreeWalker()
@@ -0,0 +1,206 @@
+package clang
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/antlr4-go/antlr/v4"
+
+	"github.com/greenboxal/agibootstrap/pkg/psi/langs/antlrbridge"
+	"github.com/greenboxal/agibootstrap/pkg/psi/langs/clang/cparser"
+)
+
+// Kinds of the declarations of C files. Struct, union and enum definitions are of the kind of their
+// keyword, e.g. "struct". Includes are antlrbridge.DeclarationKindImport declarations named after the
+// included path with its quotes, e.g. `"foo.h"` or `<stdio.h>`.
+const (
+	DeclarationKindFunction  = "function"
+	DeclarationKindPrototype = "prototype"
+	DeclarationKindVariable  = "variable"
+	DeclarationKindTypedef   = "typedef"
+)
+
+// declarations returns the declarations of the file, as parsed by the last Load.
+func (sf *SourceFile) declarations() *antlrbridge.DeclarationFile {
+	if sf.decls == nil {
+		sf.decls = &antlrbridge.DeclarationFile{
+			Tokens:   sf.tokens,
+			End:      sf.tokens.Size() - 1,
+			IsTrivia: isTrivia,
+		}
+
+		if unit, ok := sf.parsed.(*cparser.CompilationUnitContext); ok && unit.TranslationUnit() != nil {
+			for _, ed := range unit.TranslationUnit().AllExternalDeclaration() {
+				if d := externalDeclaration(ed); d != nil {
+					sf.decls.Declarations = append(sf.decls.Declarations, d)
+				}
+			}
+		}
+
+		last := -1
+
+		if n := len(sf.decls.Declarations); n > 0 {
+			last = sf.decls.Declarations[n-1].Stop
+		}
+
+		for i := 0; i < sf.tokens.Size(); i++ {
+			tk := sf.tokens.Get(i)
+
+			switch {
+			case tk.GetTokenType() == cparser.CLexerIncludeDirective:
+				sf.decls.Declarations = append(sf.decls.Declarations, &antlrbridge.Declaration{
+					Kind:  antlrbridge.DeclarationKindImport,
+					Name:  includePath(tk.GetText()),
+					Start: i,
+					Stop:  i,
+				})
+
+			// New declarations of headers go before the #endif of their include guard.
+			case i > last && tk.GetTokenType() == cparser.CLexerComplexDefine && isEndif(tk.GetText()):
+				sf.decls.End = i
+			}
+		}
+
+		sort.SliceStable(sf.decls.Declarations, func(i, j int) bool {
+			return sf.decls.Declarations[i].Start < sf.decls.Declarations[j].Start
+		})
+	}
+
+	return sf.decls
+}
+
+func externalDeclaration(ed cparser.IExternalDeclarationContext) *antlrbridge.Declaration {
+	d := &antlrbridge.Declaration{
+		Start: ed.GetStart().GetTokenIndex(),
+		Stop:  ed.GetStop().GetTokenIndex(),
+	}
+
+	if fn := ed.FunctionDefinition(); fn != nil {
+		d.Kind = DeclarationKindFunction
+		d.Name = declaratorName(fn.Declarator())
+
+		return d
+	}
+
+	decl := ed.Declaration()
+
+	if decl == nil || decl.DeclarationSpecifiers() == nil {
+		return nil
+	}
+
+	specifiers := decl.DeclarationSpecifiers().AllDeclarationSpecifier()
+	isTypedef := false
+
+	for _, spec := range specifiers {
+		if sc := spec.StorageClassSpecifier(); sc != nil && sc.Typedef() != nil {
+			isTypedef = true
+		}
+	}
+
+	if list := decl.InitDeclaratorList(); list != nil {
+		declarator := list.InitDeclarator(0).Declarator()
+
+		switch {
+		case isTypedef:
+			d.Kind = DeclarationKindTypedef
+		case isFunctionDeclarator(declarator):
+			d.Kind = DeclarationKindPrototype
+		default:
+			d.Kind = DeclarationKindVariable
+		}
+
+		d.Name = declaratorName(declarator)
+
+		return d
+	}
+
+	// Declarations without declarators, like "struct point { ... };". The name of a typedef is
+	// parsed as the last of its specifiers.
+	for _, spec := range specifiers {
+		ts := spec.TypeSpecifier()
+
+		if ts == nil {
+			continue
+		}
+
+		switch {
+		case isTypedef && ts.TypedefName() != nil:
+			d.Kind = DeclarationKindTypedef
+			d.Name = ts.TypedefName().GetText()
+		case !isTypedef && ts.StructOrUnionSpecifier() != nil && ts.StructOrUnionSpecifier().Identifier() != nil:
+			d.Kind = ts.StructOrUnionSpecifier().StructOrUnion().GetText()
+			d.Name = ts.StructOrUnionSpecifier().Identifier().GetText()
+		case !isTypedef && ts.EnumSpecifier() != nil && ts.EnumSpecifier().Identifier() != nil:
+			d.Kind = "enum"
+			d.Name = ts.EnumSpecifier().Identifier().GetText()
+		}
+	}
+
+	if d.Name == "" {
+		return nil
+	}
+
+	return d
+}
+
+// declaratorName returns the identifier declared by a declarator.
+func declaratorName(declarator cparser.IDeclaratorContext) string {
+	for dd := declarator.DirectDeclarator(); dd != nil; dd = dd.DirectDeclarator() {
+		if id := dd.Identifier(); id != nil {
+			return id.GetText()
+		}
+
+		if inner := dd.Declarator(); inner != nil {
+			return declaratorName(inner)
+		}
+	}
+
+	return ""
+}
+
+// isFunctionDeclarator returns true for the declarators of functions, and false for pointers to functions.
+func isFunctionDeclarator(declarator cparser.IDeclaratorContext) bool {
+	dd := declarator.DirectDeclarator()
+
+	return dd != nil && dd.LeftParen() != nil && dd.DirectDeclarator() != nil && dd.DirectDeclarator().Declarator() == nil
+}
+
+// isTrivia returns true for whitespace and comments.
+func isTrivia(tk antlr.Token) bool {
+	switch tk.GetTokenType() {
+	case cparser.CLexerWhitespace, cparser.CLexerNewline, cparser.CLexerBlockComment, cparser.CLexerLineComment:
+		return true
+	}
+
+	return false
+}
+
+// includePath returns the included path of an include directive, with its quotes.
+func includePath(directive string) string {
+	start := strings.IndexAny(directive, "<\"")
+
+	if start == -1 {
+		return strings.TrimSpace(directive)
+	}
+
+	closing := "\""
+
+	if directive[start] == '<' {
+		closing = ">"
+	}
+
+	end := strings.Index(directive[start+1:], closing)
+
+	if end == -1 {
+		return strings.TrimSpace(directive[start:])
+	}
+
+	return directive[start : start+end+2]
+}
+
+func isEndif(directive string) bool {
+	name := strings.TrimLeft(strings.TrimPrefix(strings.TrimSpace(directive), "#"), " \t")
+	rest := strings.TrimPrefix(name, "endif")
+
+	return rest != name && (rest == "" || strings.IndexAny(rest[:1], " \t/") == 0)
+}
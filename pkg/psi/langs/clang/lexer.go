@@ -0,0 +1,104 @@
+package clang
+
+import (
+	"strings"
+
+	"github.com/antlr4-go/antlr/v4"
+
+	"github.com/greenboxal/agibootstrap/pkg/psi/langs/clang/cparser"
+)
+
+// conditionalDirectives are the preprocessor directives the C grammar has no rule for.
+var conditionalDirectives = []string{"ifndef", "ifdef", "if", "elif", "else", "endif", "undef", "error", "warning"}
+
+// directiveLexer lexes the preprocessor directives missing from the C grammar, like include guards,
+// as hidden tokens of type CLexerComplexDefine. Other tokens are lexed by the C lexer.
+type directiveLexer struct {
+	*cparser.CLexer
+
+	atLineStart bool
+}
+
+func newDirectiveLexer(input antlr.CharStream) *directiveLexer {
+	return &directiveLexer{CLexer: cparser.NewCLexer(input), atLineStart: true}
+}
+
+func (l *directiveLexer) NextToken() antlr.Token {
+	var tk antlr.Token
+
+	if l.atLineStart && l.isConditionalDirective() {
+		tk = l.lexDirective()
+	} else {
+		tk = l.CLexer.NextToken()
+	}
+
+	switch tk.GetTokenType() {
+	case cparser.CLexerWhitespace:
+	case cparser.CLexerNewline:
+		l.atLineStart = true
+	default:
+		l.atLineStart = strings.HasSuffix(tk.GetText(), "\n")
+	}
+
+	return tk
+}
+
+func (l *directiveLexer) isConditionalDirective() bool {
+	input := l.GetInputStream()
+
+	if input.LA(1) != '#' {
+		return false
+	}
+
+	i := 2
+
+	for input.LA(i) == ' ' || input.LA(i) == '\t' {
+		i++
+	}
+
+	var name strings.Builder
+
+	for c := input.LA(i); c >= 'a' && c <= 'z'; c = input.LA(i) {
+		name.WriteRune(rune(c))
+		i++
+	}
+
+	for _, d := range conditionalDirectives {
+		if name.String() == d {
+			return true
+		}
+	}
+
+	return false
+}
+
+// lexDirective lexes a directive up to the end of its line, following line continuations.
+func (l *directiveLexer) lexDirective() antlr.Token {
+	input := l.GetInputStream()
+	start, line, column := input.Index(), l.GetLine(), l.GetCharPositionInLine()
+
+	for c := input.LA(1); c != antlr.TokenEOF && c != '\n' && c != '\r'; c = input.LA(1) {
+		if c == '\\' && (input.LA(2) == '\n' || input.LA(2) == '\r') {
+			l.Interpreter.Consume(input)
+
+			if input.LA(1) == '\r' && input.LA(2) == '\n' {
+				l.Interpreter.Consume(input)
+			}
+		}
+
+		l.Interpreter.Consume(input)
+	}
+
+	stop := input.Index() - 1
+
+	return antlr.CommonTokenFactoryDEFAULT.Create(
+		l.GetTokenSourceCharStreamPair(),
+		cparser.CLexerComplexDefine,
+		input.GetText(start, stop),
+		2,
+		start,
+		stop,
+		line,
+		column,
+	)
+}
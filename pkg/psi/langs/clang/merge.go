@@ -0,0 +1,108 @@
+package clang
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/greenboxal/agibootstrap/pkg/psi"
+	"github.com/greenboxal/agibootstrap/pkg/psi/langs/clang/cparser"
+)
+
+// MergeCompletionResults merges the declarations of newAst into the file by kind and name, see
+// antlrbridge.Edits.Merge: function definitions, prototypes, variables, typedefs, structs, unions and
+// enums found in both are replaced, new ones are appended, and missing includes are added after the
+// existing ones. The changes are recorded in the token stream rewriter of the file, and rendered by
+// ToCode, until the file is loaded again.
+//
+// When merging into foo.c, the prototypes of the new non-static functions are added to foo.h, if the
+// project has it and it does not declare them yet. The header is then returned by EditedFiles, to be
+// written with the file.
+func (sf *SourceFile) MergeCompletionResults(ctx context.Context, scope psi.Scope, cursor psi.Cursor, newSource psi.SourceFile, newAst psi.Node) error {
+	newSf, ok := newSource.(*SourceFile)
+
+	if !ok || newSf.parsed == nil || sf.parsed == nil {
+		return errors.Errorf("cannot merge %s: not a parsed C source file", newSource.Name())
+	}
+
+	sf.edits.Merge(sf.declarations(), newSf.declarations(), nil)
+	sf.rewriter = sf.edits.Apply(sf.declarations())
+
+	if filepath.Ext(sf.name) == ".c" {
+		sf.addPrototypes(newSf)
+	}
+
+	return nil
+}
+
+// addPrototypes records the prototypes of the non-static functions defined by newSf in the header of
+// the file.
+func (sf *SourceFile) addPrototypes(newSf *SourceFile) {
+	path := strings.TrimSuffix(sf.name, ".c") + ".h"
+	found, err := sf.l.project.GetSourceFile(path)
+
+	if err != nil {
+		// Not every implementation has a header.
+		return
+	}
+
+	// Paths missing from the file tree resolve to the source file of their closest parent.
+	header, ok := found.(*SourceFile)
+
+	if !ok || header.name != path || header.parsed == nil {
+		return
+	}
+
+	decls := header.declarations()
+
+	for _, d := range newSf.declarations().Declarations {
+		if d.Kind != DeclarationKindFunction {
+			continue
+		}
+
+		key := "/" + DeclarationKindPrototype + " " + d.Name
+		prototype, static := newSf.prototype(d.Start, d.Stop)
+
+		if static || decls.Lookup(key) != nil {
+			continue
+		}
+
+		header.edits.Append(decls, key, prototype)
+		header.rewriter = header.edits.Apply(decls)
+
+		sf.edited(header)
+	}
+}
+
+// EditedFiles implements psi.EditingSourceFile.
+func (sf *SourceFile) EditedFiles() []psi.SourceFile {
+	return sf.editedFiles
+}
+
+func (sf *SourceFile) edited(other *SourceFile) {
+	for _, f := range sf.editedFiles {
+		if f == other {
+			return
+		}
+	}
+
+	sf.editedFiles = append(sf.editedFiles, other)
+}
+
+// prototype returns the prototype of the function defined by the tokens from start to stop, with the
+// comments before it, and whether the function is static.
+func (sf *SourceFile) prototype(start, stop int) (prototype string, static bool) {
+	body := start
+
+	for ; body < stop && sf.tokens.Get(body).GetTokenType() != cparser.CLexerLeftBrace; body++ {
+		if sf.tokens.Get(body).GetTokenType() == cparser.CLexerStatic {
+			static = true
+		}
+	}
+
+	text := sf.declarations().Text(sf.declarations().LeadStart(start), body-1)
+
+	return strings.TrimRight(text, " \t\r\n") + ";", static
+}
@@ -0,0 +1,66 @@
+package clang
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+
+	"github.com/greenboxal/agibootstrap/pkg/psi"
+	"github.com/greenboxal/agibootstrap/pkg/psi/langs/antlrbridge"
+)
+
+// ResolveReferences links the given C source files to the files they include with psi.EdgeKindInclude
+// edges, named after the included path. Quoted includes are looked up relative to the including file,
+// then to the root of the project. System includes, and includes of files not given, are not linked.
+func (l *Language) ResolveReferences(ctx context.Context, files []psi.SourceFile) error {
+	byPath := map[string]*SourceFile{}
+
+	for _, f := range files {
+		if sf, ok := f.(*SourceFile); ok {
+			byPath[filepath.Clean(sf.name)] = sf
+		}
+	}
+
+	for _, sf := range byPath {
+		clearIncludes(sf)
+
+		if sf.parsed == nil {
+			continue
+		}
+
+		for _, d := range sf.declarations().Declarations {
+			if d.Kind != antlrbridge.DeclarationKindImport || !strings.HasPrefix(d.Name, `"`) {
+				continue
+			}
+
+			path := strings.Trim(d.Name, `"`)
+
+			for _, candidate := range []string{
+				filepath.Join(filepath.Dir(sf.name), path),
+				filepath.Join(l.project.RootPath(), path),
+			} {
+				if included := byPath[candidate]; included != nil {
+					sf.SetEdge(psi.EdgeKey{Kind: psi.EdgeKindInclude, Name: path}, included)
+					break
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// clearIncludes removes the edges set by a previous resolution.
+func clearIncludes(sf *SourceFile) {
+	var keys []psi.EdgeKey
+
+	for it := sf.Edges(); it.Next(); {
+		if it.Edge().Kind() == psi.EdgeKindInclude {
+			keys = append(keys, it.Edge().Key().GetKey())
+		}
+	}
+
+	for _, k := range keys {
+		sf.UnsetEdge(k)
+	}
+}
@@ -2,14 +2,15 @@ package clang
 
 import (
 	"bytes"
-	"context"
 	"fmt"
 	"go/token"
 	"io"
+	"strings"
 
 	"github.com/antlr4-go/antlr/v4"
 	"github.com/pkg/errors"
 
+	"github.com/greenboxal/agibootstrap/pkg/psi/langs/antlrbridge"
 	"github.com/greenboxal/agibootstrap/pkg/psi/langs/clang/cparser"
 
 	"github.com/greenboxal/agibootstrap/pkg/platform/mdutils"
@@ -32,6 +33,12 @@ type SourceFile struct {
 	original string
 	file     *token.File
 	tokens   *antlr.CommonTokenStream
+	rewriter *antlr.TokenStreamRewriter
+
+	decls *antlrbridge.DeclarationFile
+	edits antlrbridge.Edits
+	// editedFiles are the other files changed by the merges into the file, see EditedFiles.
+	editedFiles []psi.SourceFile
 }
 
 func NewSourceFile(l *Language, name string, handle repofs.FileHandle) *SourceFile {
@@ -97,6 +104,10 @@ func (sf *SourceFile) SetRoot(node antlr.ParserRuleContext, original string, tok
 	sf.original = original
 	sf.parsed = node
 	sf.tokens = tokens
+	sf.rewriter = antlr.NewTokenStreamRewriter(tokens)
+	sf.decls = nil
+	sf.edits = antlrbridge.Edits{}
+	sf.editedFiles = nil
 
 	sf.file = sf.l.project.FileSet().AddFile(sf.name, -1, len(sf.original))
 	sf.file.SetLinesForContent([]byte(original))
@@ -120,78 +131,85 @@ func (sf *SourceFile) Parse(filename string, sourceCode string) (result psi.Node
 		}
 	}()
 
+	// Only the parse of the file itself publishes diagnostics, not the parse of snippets.
+	isRoot := sf.root == nil
+	listener := antlrbridge.NewSyntaxErrorListener(filename, sourceCode)
+
 	reader := bytes.NewBufferString(sourceCode)
 	stream := antlr.NewIoStream(reader)
-	lexer := cparser.NewCLexer(stream)
+	lexer := newDirectiveLexer(stream)
+	lexer.AddErrorListener(listener)
 	tokens := antlr.NewCommonTokenStream(lexer, 0)
 	parser := cparser.NewCParser(tokens)
+	parser.AddErrorListener(listener)
 	parsed := parser.CompilationUnit()
 
 	if parser.HasError() {
 		sf.err = fmt.Errorf("%s", parser.GetError())
 
+		if isRoot {
+			listener.Publish(sf, sf.err)
+		}
+
 		return nil, sf.err
 	}
 
-	if sf.root == nil {
+	if isRoot {
 		if err := sf.SetRoot(parsed, sourceCode, tokens); err != nil {
 			return nil, err
 		}
 
+		listener.Publish(sf, nil)
+
 		return sf.root, nil
 	}
 
 	return AstToPsi(sf, parsed), nil
 }
 
+// ToCode renders node with the changes merged into the file. The file and its root are rendered in
+// full. Other nodes are rendered with the comments before them.
 func (sf *SourceFile) ToCode(node psi.Node) (mdutils.CodeBlock, error) {
-	var start, end antlr.Token
-	n := node.(Node)
+	if sf.rewriter == nil {
+		return mdutils.CodeBlock{}, errors.Errorf("cannot render %s: the file was not parsed", sf.name)
+	}
 
-	start = n.Ast().GetStart()
-	end = n.Ast().GetStop()
+	var code string
 
-	hiddenStart := sf.tokens.GetHiddenTokensToLeft(start.GetTokenIndex(), 2)
+	if node == sf || node == sf.root {
+		code = sf.rewriter.GetTextDefault()
+	} else {
+		n, ok := node.(Node)
 
-	if len(hiddenStart) > 0 {
-		start = hiddenStart[0]
-	}
+		if !ok || n.Ast() == nil {
+			return mdutils.CodeBlock{}, errors.Errorf("cannot render %T: not a C node", node)
+		}
 
-	ns := n.NextSibling()
+		start, stop := n.Ast().GetStart().GetTokenIndex(), n.Ast().GetStop().GetTokenIndex()
 
-	if ns != nil {
-		if ns, ok := ns.(Node); ok {
-			end = ns.Ast().GetStart()
+		if start >= 0 && start <= stop {
+			decls := sf.declarations()
+			code = sf.rewriter.GetText(antlr.DefaultProgramName, antlr.NewInterval(decls.LeadStart(start), stop))
+			code = antlrbridge.Reindent(strings.TrimLeft(code, "\r\n"), decls.Indent(start), "")
 		}
-	} else {
-		end = nil
 	}
 
-	txt := sf.getRange(start, end)
-
 	return mdutils.CodeBlock{
 		Language: string(LanguageID),
-		Code:     txt,
+		Code:     code,
 		Filename: sf.Name(),
 	}, nil
 }
 
-func (sf *SourceFile) MergeCompletionResults(ctx context.Context, scope psi.Scope, cursor psi.Cursor, newSource psi.SourceFile, newAst psi.Node) error {
-	cursor.Replace(newAst)
-
-	return nil
-}
-
-func (sf *SourceFile) getRange(start antlr.Token, end antlr.Token) string {
-	startLinePos := sf.file.LineStart(start.GetLine())
-	startLineOffset := sf.file.Offset(startLinePos) + start.GetColumn()
+// NodeSourceRange implements psi.SourceRangeProvider from the tokens the node was parsed from.
+func (sf *SourceFile) NodeSourceRange(n psi.Node) (psi.SourceRange, bool) {
+	cn, ok := n.(Node)
 
-	if end == nil {
-		return sf.original[startLineOffset:]
+	if !ok || cn.Ast() == nil {
+		return psi.SourceRange{}, false
 	}
 
-	endLinePos := sf.file.LineStart(end.GetLine())
-	endLineOffset := sf.file.Offset(endLinePos) + end.GetColumn()
+	start, stop := cn.Ast().GetStart(), cn.Ast().GetStop()
 
-	return sf.original[startLineOffset:endLineOffset]
+	return antlrbridge.TokenRange(sf.file, sf.original, sf.tokens, start, stop)
 }
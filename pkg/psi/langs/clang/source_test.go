@@ -0,0 +1,191 @@
+package clang
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/greenboxal/agibootstrap/pkg/build/codegen"
+	"github.com/greenboxal/agibootstrap/pkg/codex"
+	"github.com/greenboxal/agibootstrap/pkg/platform/vfs/repofs"
+	"github.com/greenboxal/agibootstrap/pkg/psi"
+)
+
+const testCodeHeader = `#ifndef MATH_H
+#define MATH_H
+
+int add(int a, int b);
+
+#endif
+`
+
+const testCodeSource = `#include "math.h"
+
+struct pair {
+	int a;
+};
+
+int add(int a, int b) {
+	// TODO: Add a and b
+	return 0;
+}
+`
+
+const testCodeGenerated = `#include <stdio.h>
+#include "math.h"
+
+struct pair {
+	int a;
+	int b;
+};
+
+// add returns the sum of a and b.
+int add(int a, int b) {
+	return a + b;
+}
+
+static void trace(int n) {
+	printf("%d\n", n);
+}
+
+int sub(int a, int b) {
+	return a - b;
+}
+`
+
+// openTestProject writes files to a temporary directory and opens it as a project, with the files loaded.
+func openTestProject(t *testing.T, files map[string]string) *codex.Project {
+	root := t.TempDir()
+
+	for name, content := range files {
+		require.NoError(t, os.WriteFile(filepath.Join(root, name), []byte(content), 0644))
+	}
+
+	p, err := codex.NewProject(context.Background(), root)
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, p.Close()) })
+
+	require.NoError(t, psi.UpdateGraph(p.Graph(), p.LoadSourceFiles))
+
+	return p
+}
+
+func getSourceFile(t *testing.T, p *codex.Project, name string) *SourceFile {
+	sf, err := p.GetSourceFile(filepath.Join(p.RootPath(), name))
+	require.NoError(t, err)
+	require.NoError(t, sf.Error())
+
+	return sf.(*SourceFile)
+}
+
+func mergeCode(t *testing.T, sf *SourceFile, code string) {
+	gen := NewSourceFile(sf.l, "gen.c", repofs.String(code))
+	require.NoError(t, gen.Load())
+
+	c := psi.NewCursor()
+	c.SetCurrent(sf.Root())
+
+	require.NoError(t, sf.MergeCompletionResults(context.Background(), &codegen.NodeScope{Node: sf.Root()}, c, gen, gen.Root()))
+}
+
+func TestSourceMerge(t *testing.T) {
+	p := openTestProject(t, map[string]string{"math.c": testCodeSource})
+	sf := getSourceFile(t, p, "math.c")
+
+	mergeCode(t, sf, testCodeGenerated)
+
+	code, err := sf.ToCode(sf.Root())
+	require.NoError(t, err)
+	require.Equal(t, "c", code.Language)
+	require.Equal(t, `#include "math.h"
+#include <stdio.h>
+
+struct pair {
+	int a;
+	int b;
+};
+
+// add returns the sum of a and b.
+int add(int a, int b) {
+	return a + b;
+}
+
+static void trace(int n) {
+	printf("%d\n", n);
+}
+
+int sub(int a, int b) {
+	return a - b;
+}
+`, code.Code)
+
+	// Without a header, only the file itself is edited.
+	require.Empty(t, sf.EditedFiles())
+}
+
+func TestSourceMergeHeaderPrototypes(t *testing.T) {
+	p := openTestProject(t, map[string]string{
+		"math.h": testCodeHeader,
+		"math.c": testCodeSource,
+	})
+
+	sf := getSourceFile(t, p, "math.c")
+	header := getSourceFile(t, p, "math.h")
+
+	mergeCode(t, sf, testCodeGenerated)
+
+	require.Equal(t, []psi.SourceFile{header}, sf.EditedFiles())
+
+	// Prototypes of new non-static functions go before the #endif of the include guard.
+	code, err := header.ToCode(header.Root())
+	require.NoError(t, err)
+	require.Equal(t, `#ifndef MATH_H
+#define MATH_H
+
+int add(int a, int b);
+
+int sub(int a, int b);
+
+#endif
+`, code.Code)
+
+	// The header is written by the caller, with the file.
+	data, err := os.ReadFile(filepath.Join(p.RootPath(), "math.h"))
+	require.NoError(t, err)
+	require.Equal(t, testCodeHeader, string(data))
+}
+
+func TestResolveReferences(t *testing.T) {
+	p := openTestProject(t, map[string]string{
+		"math.h": testCodeHeader,
+		"math.c": testCodeSource,
+		"main.c": "#include <stdio.h>\n#include \"math.h\"\n\nint main() {\n\treturn add(1, 2);\n}\n",
+	})
+
+	main := getSourceFile(t, p, "main.c")
+	source := getSourceFile(t, p, "math.c")
+	header := getSourceFile(t, p, "math.h")
+
+	require.NoError(t, psi.UpdateGraph(p.Graph(), func() error {
+		return p.ResolveReferences(context.Background())
+	}))
+
+	for _, sf := range []*SourceFile{main, source} {
+		var includes []string
+
+		for it := sf.Edges(); it.Next(); {
+			if it.Edge().Kind() == psi.EdgeKindInclude {
+				require.Equal(t, "math.h", it.Edge().Key().GetKey().Name)
+				require.Same(t, header, it.Edge().To())
+
+				includes = append(includes, it.Edge().Key().GetKey().Name)
+			}
+		}
+
+		// System includes are not linked.
+		require.Equal(t, []string{"math.h"}, includes, sf.Name())
+	}
+}
@@ -0,0 +1,179 @@
+package datalang
+
+import (
+	"github.com/greenboxal/agibootstrap/pkg/psi"
+)
+
+var (
+	DocumentType = psi.RegisterNodeType[*DocumentNode]("data.Document", psi.WithNodeClass(psi.NodeClassDocument))
+	KeyType      = psi.RegisterNodeType[*Key]("data.Key", psi.WithNodeClass(psi.NodeClassDocument))
+	ItemType     = psi.RegisterNodeType[*Item]("data.Item", psi.WithNodeClass(psi.NodeClassDocument))
+)
+
+// Node is an entry of a data file: a Key or an Item.
+type Node interface {
+	psi.Node
+
+	// Entry returns the entry the node was parsed from.
+	Entry() *Entry
+	// Document returns the document the node was parsed from.
+	Document() *Document
+}
+
+// DocumentNode is the root of a data file. Its children are the entries of the value of the file, or
+// an Item for each document of YAML streams with several.
+type DocumentNode struct {
+	psi.NodeBase
+
+	doc *Document
+}
+
+func (n *DocumentNode) Document() *Document { return n.doc }
+
+// EntryBase is the base of the nodes of entries.
+type EntryBase struct {
+	psi.NodeBase
+
+	doc   *Document
+	entry *Entry
+}
+
+func (n *EntryBase) Entry() *Entry       { return n.entry }
+func (n *EntryBase) Document() *Document { return n.doc }
+func (n *EntryBase) Comments() []string  { return n.entry.Comments }
+func (n *EntryBase) IsContainer() bool {
+	return n.entry.Value != nil && n.entry.Value.Kind != KindScalar
+}
+func (n *EntryBase) IsLeaf() bool { return !n.IsContainer() }
+
+// Key is a member of an object, named after its key.
+type Key struct {
+	EntryBase
+}
+
+func (n *Key) PsiNodeName() string { return n.entry.Key }
+
+// Item is an item of an array, addressed by its index.
+type Item struct {
+	EntryBase
+}
+
+// AstToPsi converts a parsed document to PSI nodes.
+func AstToPsi(doc *Document) *DocumentNode {
+	root := &DocumentNode{doc: doc}
+	root.Init(root, "")
+
+	if len(doc.Values) == 1 {
+		addEntries(root, doc, doc.Values[0])
+	} else {
+		for _, v := range doc.Values {
+			item := &Item{EntryBase{doc: doc, entry: &Entry{Value: v, Start: v.Start, End: v.End, LeadStart: v.Start}}}
+			item.Init(item, "")
+			item.SetParent(root)
+
+			addEntries(item, doc, v)
+		}
+	}
+
+	return root
+}
+
+func addEntries(parent psi.Node, doc *Document, v *Value) {
+	for _, e := range v.Entries {
+		var n psi.Node
+
+		if v.Kind == KindObject {
+			k := &Key{EntryBase{doc: doc, entry: e}}
+			k.Init(k, "")
+			n = k
+		} else {
+			item := &Item{EntryBase{doc: doc, entry: e}}
+			item.Init(item, "")
+			n = item
+		}
+
+		n.SetParent(parent)
+
+		if e.Value != nil {
+			addEntries(n, doc, e.Value)
+		}
+	}
+}
+
+// step is a step of the path of a node from the root of its file: a key, or the index of an item.
+type step struct {
+	key    string
+	index  int
+	isItem bool
+}
+
+// nodePath returns the path of n from the root of its file.
+func nodePath(n psi.Node) (result []step) {
+	for ; n != nil; n = n.Parent() {
+		p := n.Parent()
+
+		if p == nil {
+			return nil
+		}
+
+		switch n := n.(type) {
+		case *Key:
+			result = append([]step{{key: n.entry.Key}}, result...)
+
+		case *Item:
+			result = append([]step{{index: p.PsiNodeBase().IndexOfChild(n), isItem: true}}, result...)
+
+		case *DocumentNode:
+			return result
+
+		default:
+			return nil
+		}
+	}
+
+	return nil
+}
+
+// lookup returns the entry at path in doc, where the first step indexes the documents of YAML streams
+// with several.
+func lookup(doc *Document, path []step) *Entry {
+	var v *Value
+
+	switch {
+	case len(doc.Values) == 1:
+		v = doc.Values[0]
+
+	case len(path) > 0 && path[0].isItem && path[0].index < len(doc.Values):
+		v = doc.Values[path[0].index]
+		path = path[1:]
+
+		if len(path) == 0 {
+			return &Entry{Value: v, Start: v.Start, End: v.End, LeadStart: v.Start}
+		}
+
+	default:
+		return nil
+	}
+
+	var e *Entry
+
+	for _, s := range path {
+		if v == nil {
+			return nil
+		}
+
+		if s.isItem {
+			if v.Kind != KindArray || s.index >= len(v.Entries) {
+				return nil
+			}
+
+			e = v.Entries[s.index]
+		} else if e = v.Lookup(s.key); e == nil {
+			return nil
+		}
+
+		v = e.Value
+	}
+
+	return e
+}
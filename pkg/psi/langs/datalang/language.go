@@ -0,0 +1,98 @@
+// Package datalang implements psi.Language for structured data files, like JSON, YAML and TOML.
+//
+// The PSI nodes of data files are the members of objects, named after their keys, and the items of
+// arrays, addressed by their index. The replicas of a Kubernetes manifest can be resolved with the
+// path elements "#spec/#replicas" from its root. Languages embed Language, and implement a Format to
+// parse and merge their files.
+package datalang
+
+import (
+	"fmt"
+
+	"github.com/greenboxal/agibootstrap/pkg/platform/mdutils"
+	"github.com/greenboxal/agibootstrap/pkg/platform/project"
+	"github.com/greenboxal/agibootstrap/pkg/platform/vfs/repofs"
+	"github.com/greenboxal/agibootstrap/pkg/psi"
+)
+
+// Format parses and merges the files of a data language.
+type Format interface {
+	// Parse parses text into a Document. Syntax errors are returned as *SyntaxError.
+	Parse(text string) (*Document, error)
+
+	// Merge returns the text of dst with the values of src merged into it, key by key. Members of src
+	// not found in dst but found in the object at the key path scope, when set, are merged into that
+	// object. Conflicting changes of the same part of dst fail the merge.
+	Merge(dst, src *Document, scope []string) (string, error)
+
+	// Indent returns the indentation of the lines of the entry starting at offset of doc.
+	Indent(doc *Document, offset int) string
+}
+
+// FileFormat is implemented by the Formats of languages whose syntax depends on the file, like JSON,
+// where only some files accept comments. Files are parsed and merged into with the format returned by
+// ForFile, and generated code blocks are parsed with the Format itself.
+type FileFormat interface {
+	Format
+
+	// ForFile returns the format of the file name.
+	ForFile(name string) Format
+}
+
+// SyntaxError is a syntax error at an offset of the text parsed.
+type SyntaxError struct {
+	Offset int
+	Msg    string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("offset %d: %s", e.Offset, e.Msg)
+}
+
+// Language implements psi.Language for a data format. Languages embed it and call Init with their
+// Format.
+type Language struct {
+	self    psi.Language
+	project project.Project
+	format  Format
+}
+
+func (l *Language) Init(self psi.Language, p project.Project, f Format) {
+	l.project = p
+	l.self = self
+	l.format = f
+}
+
+func (l *Language) CreateSourceFile(fileName string, fileHandle repofs.FileHandle) psi.SourceFile {
+	return NewSourceFile(l, fileName, fileHandle)
+}
+
+func (l *Language) Parse(fileName string, code string) (psi.SourceFile, error) {
+	f := l.CreateSourceFile(fileName, repofs.String(code))
+
+	if err := f.Load(); err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+func (l *Language) ParseCodeBlock(blockName string, block mdutils.CodeBlock) (psi.SourceFile, error) {
+	f := NewSourceFile(l, blockName, repofs.String(block.Code))
+	f.format = l.format
+
+	if err := f.Load(); err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// formatFor returns the format of the file name.
+func (l *Language) formatFor(name string) Format {
+	if ff, ok := l.format.(FileFormat); ok {
+		return ff.ForFile(name)
+	}
+
+	return l.format
+}
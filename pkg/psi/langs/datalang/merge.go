@@ -0,0 +1,146 @@
+package datalang
+
+import (
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+// TextEdits are replacements of ranges of a text, applied at once.
+type TextEdits struct {
+	edits []textEdit
+}
+
+type textEdit struct {
+	start, end int
+	text       string
+}
+
+// Replace replaces the text from start to end.
+func (e *TextEdits) Replace(start, end int, text string) {
+	e.edits = append(e.edits, textEdit{start: start, end: end, text: text})
+}
+
+// Insert inserts text at offset. Insertions at the same offset are kept in order.
+func (e *TextEdits) Insert(offset int, text string) {
+	e.Replace(offset, offset, text)
+}
+
+// Apply returns text with the edits applied, or an error if an edit overlaps an earlier one, since
+// both change the same part of the text.
+func (e *TextEdits) Apply(text string) (string, error) {
+	edits := append([]textEdit(nil), e.edits...)
+
+	sort.SliceStable(edits, func(i, j int) bool {
+		return edits[i].start < edits[j].start
+	})
+
+	var result []byte
+
+	last := 0
+
+	for _, ed := range edits {
+		if ed.start < last {
+			return "", errors.Errorf("conflicting edits at offset %d", ed.start)
+		}
+
+		result = append(result, text[last:ed.start]...)
+		result = append(result, ed.text...)
+		last = ed.end
+	}
+
+	return string(append(result, text[last:]...)), nil
+}
+
+// ObjectMerger merges objects by key, for the formats nesting objects by syntax, like JSON and YAML.
+type ObjectMerger struct {
+	Dst, Src *Document
+	Edits    TextEdits
+
+	// Indent returns the indentation of the entry at offset of doc, see Document.Indent and
+	// Document.Column.
+	Indent func(doc *Document, offset int) string
+
+	// Insert inserts new entries of Src into the object dst of Dst.
+	Insert func(m *ObjectMerger, dst *Value, entries []*Entry)
+}
+
+// MergeDocument merges the values of Src into the values of Dst, the first into the first and so on,
+// see Merge. Values of Dst which are not mergeable objects are replaced. Empty documents are
+// replaced by Src.
+//
+// Members of the first value of Src not found in the first value of Dst but found in the object at the
+// key path scope, when set, are merged into that object. Conflicting edits fail the merge.
+func (m *ObjectMerger) MergeDocument(scope []string) (string, error) {
+	if len(m.Dst.Values) == 0 {
+		return m.Src.Text, nil
+	}
+
+	for i, src := range m.Src.Values {
+		if i >= len(m.Dst.Values) {
+			break
+		}
+
+		dst := m.Dst.Values[i]
+
+		if !dst.IsMergeable() || !src.IsMergeable() {
+			m.Edits.Replace(dst.Start, dst.End, Reindent(m.Src.Text[src.Start:src.End], m.Indent(m.Src, src.Start), m.Indent(m.Dst, dst.Start)))
+			continue
+		}
+
+		if scoped := m.Dst.Lookup(scope); i == 0 && scoped != nil && scoped.Value.IsMergeable() {
+			root, inScope := &Value{Kind: KindObject}, &Value{Kind: KindObject}
+
+			for _, e := range src.Entries {
+				if dst.Lookup(e.Key) == nil && scoped.Value.Lookup(e.Key) != nil {
+					inScope.Entries = append(inScope.Entries, e)
+				} else {
+					root.Entries = append(root.Entries, e)
+				}
+			}
+
+			m.Merge(scoped.Value, inScope)
+			src = root
+		}
+
+		m.Merge(dst, src)
+	}
+
+	return m.Edits.Apply(m.Dst.Text)
+}
+
+// Merge merges the members of the object src into the object dst by key:
+//
+//   - objects found in both are merged member by member,
+//   - other members found in both are replaced, with the comments above them in src if any,
+//   - new members are inserted after the last member of dst, by Insert.
+func (m *ObjectMerger) Merge(dst, src *Value) {
+	var added []*Entry
+
+	for _, se := range src.Entries {
+		de := dst.Lookup(se.Key)
+
+		switch {
+		case de == nil:
+			added = append(added, se)
+
+		case de.Value.IsMergeable() && se.Value.IsMergeable():
+			m.Merge(de.Value, se.Value)
+
+		case se.LeadStart < se.Start:
+			m.Edits.Replace(de.LeadStart, de.End, m.EntryText(se, m.Indent(m.Dst, de.Start)))
+
+		default:
+			m.Edits.Replace(de.Start, de.End, m.EntryText(se, m.Indent(m.Dst, de.Start)))
+		}
+	}
+
+	if len(added) > 0 {
+		m.Insert(m, dst, added)
+	}
+}
+
+// EntryText returns the text of an entry of Src with the comments above it, reindented to indent.
+func (m *ObjectMerger) EntryText(e *Entry, indent string) string {
+	return Reindent(m.Src.Text[e.LeadStart:e.End], m.Indent(m.Src, e.Start), indent)
+}
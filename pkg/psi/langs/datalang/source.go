@@ -0,0 +1,323 @@
+package datalang
+
+import (
+	"bytes"
+	"context"
+	"go/token"
+	"io"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/greenboxal/agibootstrap/pkg/platform/mdutils"
+	"github.com/greenboxal/agibootstrap/pkg/platform/vfs/repofs"
+	"github.com/greenboxal/agibootstrap/pkg/psi"
+)
+
+type SourceFile struct {
+	psi.NodeBase
+
+	name   string
+	handle repofs.FileHandle
+
+	l      *Language
+	format Format
+
+	root *DocumentNode
+	err  error
+
+	original string
+	file     *token.File
+	parsed   *Document
+
+	// merged is the document with the changes merged into the file, until it is loaded again.
+	merged *Document
+}
+
+func NewSourceFile(l *Language, name string, handle repofs.FileHandle) *SourceFile {
+	sf := &SourceFile{
+		l: l,
+
+		name:   name,
+		handle: handle,
+		format: l.formatFor(name),
+	}
+
+	sf.Init(sf, sf.name)
+
+	return sf
+}
+
+func (sf *SourceFile) Name() string           { return sf.name }
+func (sf *SourceFile) Language() psi.Language { return sf.l.self }
+func (sf *SourceFile) Path() string           { return sf.name }
+func (sf *SourceFile) OriginalText() string   { return sf.original }
+func (sf *SourceFile) Root() psi.Node         { return sf.root }
+func (sf *SourceFile) Error() error           { return sf.err }
+
+func (sf *SourceFile) Load() error {
+	file, err := sf.handle.Get()
+
+	if err != nil {
+		return err
+	}
+
+	data, err := io.ReadAll(file)
+
+	if err != nil {
+		return err
+	}
+
+	sf.root = nil
+	sf.parsed = nil
+	sf.merged = nil
+	sf.original = ""
+	sf.err = nil
+
+	_, err = sf.Parse(sf.name, string(data))
+
+	sf.err = err
+
+	return err
+}
+
+func (sf *SourceFile) Replace(code string) error {
+	if code == sf.original {
+		return nil
+	}
+
+	err := sf.handle.Put(bytes.NewBufferString(code))
+
+	if err != nil {
+		return err
+	}
+
+	return sf.Load()
+}
+
+func (sf *SourceFile) SetRoot(doc *Document) error {
+	sf.original = doc.Text
+	sf.parsed = doc
+	sf.merged = doc
+
+	sf.file = sf.l.project.FileSet().AddFile(sf.name, -1, len(sf.original))
+	sf.file.SetLinesForContent([]byte(sf.original))
+
+	sf.root = AstToPsi(doc)
+	sf.root.SetParent(sf)
+
+	return nil
+}
+
+func (sf *SourceFile) Parse(filename string, sourceCode string) (result psi.Node, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			if e, ok := r.(error); ok {
+				err = e
+			} else {
+				err = errors.Errorf("%v", r)
+			}
+
+			err = errors.Wrap(err, "panic while parsing file: "+filename)
+		}
+	}()
+
+	// Only the parse of the file itself publishes diagnostics, not the parse of snippets.
+	isRoot := sf.root == nil
+
+	doc, err := sf.format.Parse(sourceCode)
+
+	if err != nil {
+		sf.err = err
+
+		if isRoot {
+			psi.PublishDiagnostics(sf, psi.DiagnosticSourceParser, []*psi.Diagnostic{
+				syntaxDiagnostic(filename, sourceCode, err),
+			})
+		}
+
+		return nil, sf.err
+	}
+
+	if isRoot {
+		if err := sf.SetRoot(doc); err != nil {
+			return nil, err
+		}
+
+		psi.PublishDiagnostics(sf, psi.DiagnosticSourceParser, nil)
+
+		return sf.root, nil
+	}
+
+	return AstToPsi(doc), nil
+}
+
+// ToCode renders node with the changes merged into the file. The file and its root are rendered in
+// full. Keys and items are rendered with the comments above them, and unindented.
+func (sf *SourceFile) ToCode(node psi.Node) (mdutils.CodeBlock, error) {
+	if sf.merged == nil {
+		return mdutils.CodeBlock{}, errors.Errorf("cannot render %s: the file was not parsed", sf.name)
+	}
+
+	var code string
+
+	switch n := node.(type) {
+	case *SourceFile:
+		if n != sf {
+			return n.ToCode(n)
+		}
+
+		code = sf.merged.Text
+
+	case *DocumentNode:
+		code = n.doc.Text
+
+		if n == sf.root {
+			code = sf.merged.Text
+		}
+
+	case Node:
+		doc, e := sf.merged, lookup(sf.merged, nodePath(n))
+
+		// Nodes removed by a merge, or parsed from other text, are rendered as parsed.
+		if e == nil || n.Document() != sf.parsed {
+			doc, e = n.Document(), n.Entry()
+		}
+
+		code = Reindent(doc.Text[e.LeadStart:e.End], sf.format.Indent(doc, e.Start), "")
+
+	default:
+		return mdutils.CodeBlock{}, errors.Errorf("cannot render %T: not a %s node", node, sf.l.self.Name())
+	}
+
+	return mdutils.CodeBlock{
+		Language: string(sf.l.self.Name()),
+		Code:     code,
+		Filename: sf.Name(),
+	}, nil
+}
+
+// MergeCompletionResults merges the values of newSource into the file key by key, see Format.Merge.
+// Members matching a member of the object enclosing the scope are merged into it. The merge is
+// rejected if it changes the same part of the file twice, or if its result does not parse. The changes
+// are rendered by ToCode, until the file is loaded again.
+func (sf *SourceFile) MergeCompletionResults(ctx context.Context, scope psi.Scope, cursor psi.Cursor, newSource psi.SourceFile, newAst psi.Node) error {
+	newSf, ok := newSource.(*SourceFile)
+
+	if !ok || newSf.parsed == nil || sf.merged == nil {
+		return errors.Errorf("cannot merge %s: not a parsed %s source file", newSource.Name(), sf.l.self.Name())
+	}
+
+	var enclosing []string
+
+	if scope != nil && scope.Root() != nil {
+		enclosing = sf.enclosingObject(scope.Root())
+	}
+
+	text, err := sf.format.Merge(sf.merged, newSf.merged, enclosing)
+
+	if err != nil {
+		return errors.Wrapf(err, "cannot merge %s into %s", newSource.Name(), sf.name)
+	}
+
+	merged, err := sf.format.Parse(text)
+
+	if err != nil {
+		return errors.Wrapf(err, "cannot merge %s into %s", newSource.Name(), sf.name)
+	}
+
+	sf.merged = merged
+
+	return nil
+}
+
+// enclosingObject returns the key path of the innermost object of the first document containing n,
+// up to the first array.
+func (sf *SourceFile) enclosingObject(n psi.Node) []string {
+	if !isDescendant(sf.root, n) {
+		return nil
+	}
+
+	var keys []string
+
+	for i, s := range nodePath(n) {
+		if s.isItem {
+			// The first document of YAML streams.
+			if i == 0 && s.index == 0 && len(sf.merged.Values) > 1 {
+				continue
+			}
+
+			break
+		}
+
+		keys = append(keys, s.key)
+	}
+
+	for ; len(keys) > 0; keys = keys[:len(keys)-1] {
+		if e := sf.merged.Lookup(keys); e != nil && e.Value.IsMergeable() {
+			break
+		}
+	}
+
+	return keys
+}
+
+func isDescendant(root, n psi.Node) bool {
+	for ; n != nil; n = n.Parent() {
+		if n == root {
+			return true
+		}
+	}
+
+	return false
+}
+
+// NodeSourceRange implements psi.SourceRangeProvider from the offsets of the entries.
+func (sf *SourceFile) NodeSourceRange(n psi.Node) (psi.SourceRange, bool) {
+	if sf.file == nil {
+		return psi.SourceRange{}, false
+	}
+
+	switch n := n.(type) {
+	case *DocumentNode:
+		if n == sf.root {
+			return psi.SourceRangeFromOffsets(sf.file, 0, len(sf.original)), true
+		}
+
+	case Node:
+		if n.Document() == sf.parsed {
+			return psi.SourceRangeFromOffsets(sf.file, n.Entry().Start, n.Entry().End), true
+		}
+	}
+
+	return psi.SourceRange{}, false
+}
+
+// syntaxDiagnostic returns err as a diagnostic, at its offset if it is a *SyntaxError.
+func syntaxDiagnostic(name, text string, err error) *psi.Diagnostic {
+	rng := psi.SourceRange{File: name}
+	msg := err.Error()
+
+	if se, ok := err.(*SyntaxError); ok {
+		pos := textPosition(text, se.Offset)
+		rng.Start, rng.End = pos, pos
+		msg = se.Msg
+	}
+
+	return psi.NewDiagnostic(psi.DiagnosticSourceParser, psi.SeverityError, rng, msg)
+}
+
+// textPosition returns the position of offset in text.
+func textPosition(text string, offset int) psi.SourcePosition {
+	if offset > len(text) {
+		offset = len(text)
+	}
+
+	lineStart := strings.LastIndexByte(text[:offset], '\n') + 1
+
+	return psi.SourcePosition{
+		Offset: offset,
+		Line:   strings.Count(text[:offset], "\n") + 1,
+		Column: offset - lineStart + 1,
+	}
+}
@@ -0,0 +1,197 @@
+package datalang
+
+import (
+	"strings"
+)
+
+// Kind is the kind of a Value.
+type Kind int
+
+const (
+	KindScalar Kind = iota
+	KindObject
+	KindArray
+)
+
+// Value is a value of a structured data file, located by byte offsets in the text of its Document.
+type Value struct {
+	Kind Kind
+
+	// Entries are the members of objects, and the items of arrays.
+	Entries []*Entry
+
+	// Start and End are the offsets of the text of the value.
+	Start, End int
+
+	// Inline is set for collections written as a single value, like YAML flow collections and TOML
+	// inline tables, which are merged as a whole.
+	Inline bool
+}
+
+// Lookup returns the member of an object with the given key.
+func (v *Value) Lookup(key string) *Entry {
+	if v == nil || v.Kind != KindObject {
+		return nil
+	}
+
+	for _, e := range v.Entries {
+		if e.Key == key {
+			return e
+		}
+	}
+
+	return nil
+}
+
+// IsMergeable returns true for the objects merged member by member.
+func (v *Value) IsMergeable() bool {
+	return v != nil && v.Kind == KindObject && !v.Inline
+}
+
+// Entry is a member of an object, or an item of an array.
+type Entry struct {
+	// Key is the key of object members, and empty for array items.
+	Key   string
+	Value *Value
+
+	// Start and End are the offsets of the text of the entry, from its key, or from the dash of YAML
+	// items, to the end of its value.
+	Start, End int
+
+	// LeadStart is the offset of the first comment of the lines right above the entry, or Start if
+	// there are none.
+	LeadStart int
+
+	// Comments are the comments of the entry, see Comment.
+	Comments []string
+}
+
+// Document is a parsed structured data file.
+type Document struct {
+	Text string
+
+	// Values are the values of the documents of the file: one, several for YAML streams, or none for
+	// empty files.
+	Values []*Value
+}
+
+// Lookup returns the entry at the given key path from the first value of the document.
+func (d *Document) Lookup(path []string) *Entry {
+	if len(d.Values) == 0 || len(path) == 0 {
+		return nil
+	}
+
+	e := d.Values[0].Lookup(path[0])
+
+	for _, key := range path[1:] {
+		if e == nil {
+			return nil
+		}
+
+		e = e.Value.Lookup(key)
+	}
+
+	return e
+}
+
+// LineStart returns the offset of the start of the line of offset.
+func (d *Document) LineStart(offset int) int {
+	return strings.LastIndexByte(d.Text[:offset], '\n') + 1
+}
+
+// Indent returns the leading whitespace of the line of offset.
+func (d *Document) Indent(offset int) string {
+	start := d.LineStart(offset)
+	line := d.Text[start:]
+
+	return line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+}
+
+// Column returns the text of the line of offset before it as spaces, which is the indentation of
+// YAML entries following a dash.
+func (d *Document) Column(offset int) string {
+	return strings.Repeat(" ", offset-d.LineStart(offset))
+}
+
+// LeadStart returns the offset of the first comment of the lines right above the line of offset, or
+// offset if there are none. Lines are comments if they start with one of the markers.
+func (d *Document) LeadStart(offset int, markers ...string) int {
+	lead := offset
+
+	for start := d.LineStart(offset); start > 0; {
+		prev := d.LineStart(start - 1)
+		line := strings.TrimLeft(d.Text[prev:start-1], " \t")
+
+		if !hasAnyPrefix(line, markers) {
+			break
+		}
+
+		lead = prev + len(d.Indent(prev))
+		start = prev
+	}
+
+	return lead
+}
+
+// Comments returns the comments of the lines from start to the line of offset, see Comment.
+func (d *Document) Comments(start, offset int, marker string) []string {
+	var result []string
+
+	if start >= d.LineStart(offset) {
+		return nil
+	}
+
+	for _, line := range strings.Split(d.Text[start:d.LineStart(offset)], "\n") {
+		if line = strings.TrimSpace(line); strings.HasPrefix(line, marker) {
+			result = append(result, Comment(marker, line))
+		}
+	}
+
+	return result
+}
+
+// Comment returns the text of a comment starting with marker. TODO comments are returned as
+// "// TODO: ...", whatever the comment syntax of the format.
+func Comment(marker, text string) string {
+	body := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(text), marker))
+
+	if strings.HasPrefix(body, "TODO") {
+		return "// " + body
+	}
+
+	return strings.TrimSpace(text)
+}
+
+// Reindent replaces the indentation from of the lines of text after the first with to. Lines indented
+// less than from are indented with to.
+func Reindent(text, from, to string) string {
+	if from == to {
+		return text
+	}
+
+	lines := strings.Split(text, "\n")
+
+	for i, line := range lines {
+		if i == 0 || strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		if strings.HasPrefix(line, from) {
+			lines[i] = to + line[len(from):]
+		} else {
+			lines[i] = to + strings.TrimLeft(line, " \t")
+		}
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, p := range prefixes {
+		if strings.HasPrefix(s, p) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -3,7 +3,9 @@ package golang
 import (
 	"context"
 	"fmt"
+	"reflect"
 	"strconv"
+	"sync"
 
 	"github.com/dave/dst"
 	"github.com/dave/dst/dstutil"
@@ -33,6 +35,15 @@ func (nb *NodeBase[T]) String() string {
 	return fmt.Sprintf("%T(%d, %s)", nb.node, nb.ID(), nb.UUID())
 }
 
+// PsiNodeType returns the node type of the wrapped AST node, named after its Go type.
+func (nb *NodeBase[T]) PsiNodeType() psi.NodeType {
+	if any(nb.node) == nil {
+		return nb.NodeBase.PsiNodeType()
+	}
+
+	return nodeTypeFor(nb.node)
+}
+
 func (nb *NodeBase[T]) Initialize(self Node) {
 	nb.NodeBase.Init(self, "")
 }
@@ -195,3 +206,30 @@ func NewLeaf(node dst.Node) *Leaf {
 
 	return l
 }
+
+var nodeTypesMu sync.Mutex
+var nodeTypes = map[reflect.Type]psi.NodeType{}
+
+// nodeTypeFor returns the node type shared by the Go AST nodes of the same Go type as node,
+// registering it on first use, e.g. "go.FuncDecl".
+func nodeTypeFor(node dst.Node) psi.NodeType {
+	t := reflect.TypeOf(node)
+
+	nodeTypesMu.Lock()
+	defer nodeTypesMu.Unlock()
+
+	if nt, ok := nodeTypes[t]; ok {
+		return nt
+	}
+
+	name := t.Name()
+
+	if t.Kind() == reflect.Pointer {
+		name = t.Elem().Name()
+	}
+
+	nt := psi.RegisterNodeType[Node]("go."+name, psi.WithNodeClass(psi.NodeClassCode))
+	nodeTypes[t] = nt
+
+	return nt
+}
@@ -0,0 +1,75 @@
+package golang
+
+import (
+	"go/scanner"
+	"go/token"
+	"go/types"
+
+	"github.com/pkg/errors"
+
+	"github.com/greenboxal/agibootstrap/pkg/psi"
+)
+
+// publishParseDiagnostics replaces the parser diagnostics of the file with the errors of its last parse.
+func (sf *SourceFile) publishParseDiagnostics(err error) {
+	var diagnostics []*psi.Diagnostic
+	var list scanner.ErrorList
+
+	if errors.As(err, &list) {
+		for _, e := range list {
+			diagnostics = append(diagnostics, psi.NewDiagnostic(
+				psi.DiagnosticSourceParser,
+				psi.SeverityError,
+				psi.SourceRangeFromPositions(e.Pos, e.Pos),
+				e.Msg,
+			))
+		}
+	} else if err != nil {
+		diagnostics = append(diagnostics, psi.NewDiagnostic(
+			psi.DiagnosticSourceParser,
+			psi.SeverityError,
+			psi.SourceRange{File: sf.name},
+			err.Error(),
+		))
+	}
+
+	psi.PublishDiagnostics(sf, psi.DiagnosticSourceParser, diagnostics)
+}
+
+// publishTypeDiagnostics publishes the type errors of a package on the files they were found in.
+// Soft errors, such as unused variables and imports, are published as warnings. Errors without a
+// position are published on the first file of the package.
+func publishTypeDiagnostics(fset *token.FileSet, pkg *resolvedPackage, typeErrors []types.Error) {
+	byFile := make([][]*psi.Diagnostic, len(pkg.files))
+
+	for _, te := range typeErrors {
+		index := 0
+		severity := psi.SeverityError
+
+		if te.Soft {
+			severity = psi.SeverityWarning
+		}
+
+		if f := fset.File(te.Pos); f != nil {
+			for i, astFile := range pkg.astFiles {
+				if fset.File(astFile.Pos()) == f {
+					index = i
+					break
+				}
+			}
+		}
+
+		pos := fset.Position(te.Pos)
+
+		byFile[index] = append(byFile[index], psi.NewDiagnostic(
+			psi.DiagnosticSourceTypeCheck,
+			severity,
+			psi.SourceRangeFromPositions(pos, pos),
+			te.Msg,
+		))
+	}
+
+	for i, sf := range pkg.files {
+		psi.PublishDiagnostics(sf, psi.DiagnosticSourceTypeCheck, byFile[i])
+	}
+}
@@ -0,0 +1,590 @@
+package golang
+
+import (
+	"bytes"
+	"fmt"
+	"go/parser"
+	"go/token"
+	"strings"
+
+	"github.com/dave/dst"
+	"github.com/dave/dst/decorator"
+	"github.com/pkg/errors"
+	"golang.org/x/exp/slices"
+
+	"github.com/greenboxal/agibootstrap/pkg/psi"
+)
+
+// MergeSources merges the changes made to base by ours and theirs, as Go source code.
+// See Merge3Files.
+func MergeSources(name, base, ours, theirs string, opts psi.MergeOptions) (string, []*psi.MergeConflict, error) {
+	parse := func(side, src string) (*dst.File, error) {
+		f, err := decorator.ParseFile(token.NewFileSet(), name, src, parser.ParseComments)
+
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to parse %s version of %s", side, name)
+		}
+
+		return f, nil
+	}
+
+	baseFile, err := parse("base", base)
+
+	if err != nil {
+		return "", nil, err
+	}
+
+	oursFile, err := parse("current", ours)
+
+	if err != nil {
+		return "", nil, err
+	}
+
+	theirsFile, err := parse("generated", theirs)
+
+	if err != nil {
+		return "", nil, err
+	}
+
+	merged, conflicts := Merge3Files(name, baseFile, oursFile, theirsFile, opts)
+
+	var buf bytes.Buffer
+
+	if err := decorator.Fprint(&buf, merged); err != nil {
+		return "", nil, err
+	}
+
+	return buf.String(), conflicts, nil
+}
+
+// Merge3Files merges the changes made to base by ours and theirs into ours, which is returned.
+//
+// Declarations are matched by what they declare. A declaration changed on one side only takes that
+// change, and functions changed on both sides are merged statement by statement. Imports are merged
+// as a set. Conflicting changes keep ours, and theirs too with opts.ConflictMarkers, and are returned.
+func Merge3Files(name string, base, ours, theirs *dst.File, opts psi.MergeOptions) (*dst.File, []*psi.MergeConflict) {
+	m := &fileMerger{file: name, opts: opts}
+
+	baseDecls := indexDecls(base)
+	oursDecls := indexDecls(ours)
+	theirsDecls := indexDecls(theirs)
+
+	result := m.mergeImports(base, ours, theirs)
+	importCount := len(result)
+
+	// placed holds the merged declarations by key, to anchor the declarations missing from ours.
+	placed := map[string]dst.Decl{}
+
+	for _, o := range oursDecls.order {
+		for _, decl := range m.mergeDecl(o.key, baseDecls.byKey[o.key], o, theirsDecls.byKey[o.key]) {
+			placed[o.key] = decl
+			result = append(result, decl)
+		}
+	}
+
+	// Insert the declarations missing from ours after the declaration preceding them in theirs.
+	for i, t := range theirsDecls.order {
+		if oursDecls.byKey[t.key] != nil {
+			continue
+		}
+
+		if b := baseDecls.byKey[t.key]; b != nil {
+			if b.code == t.code {
+				continue
+			}
+
+			m.conflict(t.key, "", t.code)
+
+			if !m.opts.ConflictMarkers {
+				continue
+			}
+
+			markConflict(t.decl, t.decl, "", false)
+		}
+
+		at := importCount
+
+		for j := i - 1; j >= 0; j-- {
+			if anchor, ok := placed[theirsDecls.order[j].key]; ok {
+				at = slices.Index(result, anchor) + 1
+				break
+			}
+		}
+
+		placed[t.key] = t.decl
+		result = slices.Insert(result, at, t.decl)
+	}
+
+	ours.Decls = result
+
+	return ours, m.conflicts
+}
+
+type fileMerger struct {
+	file      string
+	opts      psi.MergeOptions
+	conflicts []*psi.MergeConflict
+}
+
+func (m *fileMerger) conflict(declaration, ours, theirs string) {
+	m.conflicts = append(m.conflicts, &psi.MergeConflict{
+		File:        m.file,
+		Declaration: declaration,
+		Ours:        ours,
+		Theirs:      theirs,
+	})
+}
+
+// mergeDecl merges a declaration of ours with the same declaration of base and theirs, if any.
+func (m *fileMerger) mergeDecl(key string, b, o, t *declEntry) []dst.Decl {
+	switch {
+	case t == nil && b == nil:
+		return []dst.Decl{o.decl}
+
+	case t == nil && b.code == o.code:
+		return nil
+
+	case t == nil:
+		m.conflict(key, o.code, "")
+
+		if m.opts.ConflictMarkers {
+			markConflict(o.decl, o.decl, "", true)
+		}
+
+		return []dst.Decl{o.decl}
+
+	case o.code == t.code:
+		return []dst.Decl{o.decl}
+
+	case b != nil && b.code == o.code:
+		return []dst.Decl{t.decl}
+
+	case b != nil && b.code == t.code:
+		return []dst.Decl{o.decl}
+	}
+
+	if b != nil {
+		bf, bok := b.decl.(*dst.FuncDecl)
+		of, ook := o.decl.(*dst.FuncDecl)
+		tf, tok := t.decl.(*dst.FuncDecl)
+
+		if bok && ook && tok {
+			if merged := m.mergeFunc(key, bf, of, tf); merged != nil {
+				return []dst.Decl{merged}
+			}
+		}
+	}
+
+	m.conflict(key, o.code, t.code)
+
+	if m.opts.ConflictMarkers {
+		markConflict(o.decl, o.decl, t.code, true)
+	}
+
+	return []dst.Decl{o.decl}
+}
+
+// mergeFunc merges the bodies of a function changed on both sides, statement by statement.
+// It returns nil if the signatures conflict.
+func (m *fileMerger) mergeFunc(key string, b, o, t *dst.FuncDecl) *dst.FuncDecl {
+	if b.Body == nil || o.Body == nil || t.Body == nil {
+		return nil
+	}
+
+	bh, oh, th := funcHeaderCode(b), funcHeaderCode(o), funcHeaderCode(t)
+
+	header := o
+
+	switch {
+	case oh == th || bh == th:
+	case bh == oh:
+		header = t
+	default:
+		return nil
+	}
+
+	merged := dst.Clone(header).(*dst.FuncDecl)
+	merged.Body = o.Body
+	merged.Body.List = m.mergeStmts(key, b.Body.List, o.Body.List, t.Body.List)
+
+	return merged
+}
+
+// mergeStmts merges two lists of statements derived from base, like diff3: the statements left
+// unchanged on both sides split the lists into chunks, which are merged as a whole.
+func (m *fileMerger) mergeStmts(key string, base, ours, theirs []dst.Stmt) []dst.Stmt {
+	baseCode := stmtsCode(base)
+	oursCode := stmtsCode(ours)
+	theirsCode := stmtsCode(theirs)
+
+	oursMatch := matchLines(baseCode, oursCode)
+	theirsMatch := matchLines(baseCode, theirsCode)
+
+	var result []dst.Stmt
+
+	i, j, k := 0, 0, 0
+
+	for {
+		next := i
+
+		for next < len(base) && (oursMatch[next] == -1 || theirsMatch[next] == -1) {
+			next++
+		}
+
+		nextOurs, nextTheirs := len(ours), len(theirs)
+
+		if next < len(base) {
+			nextOurs, nextTheirs = oursMatch[next], theirsMatch[next]
+		}
+
+		b := baseCode[i:next]
+		o := oursCode[j:nextOurs]
+		t := theirsCode[k:nextTheirs]
+
+		switch {
+		case slices.Equal(o, t) || slices.Equal(b, t):
+			result = append(result, ours[j:nextOurs]...)
+
+		case slices.Equal(b, o):
+			result = append(result, theirs[k:nextTheirs]...)
+
+		default:
+			oc, tc := strings.Join(o, "\n"), strings.Join(t, "\n")
+
+			m.conflict(key, oc, tc)
+
+			switch {
+			case !m.opts.ConflictMarkers:
+				result = append(result, ours[j:nextOurs]...)
+
+			case len(o) > 0:
+				markConflict(ours[j], ours[nextOurs-1], tc, true)
+				result = append(result, ours[j:nextOurs]...)
+
+			case len(t) > 0:
+				markConflict(theirs[k], theirs[nextTheirs-1], "", false)
+				result = append(result, theirs[k:nextTheirs]...)
+			}
+		}
+
+		if next == len(base) {
+			break
+		}
+
+		result = append(result, ours[nextOurs])
+
+		i, j, k = next+1, nextOurs+1, nextTheirs+1
+	}
+
+	return result
+}
+
+// mergeImports merges the imports of ours and theirs: imports removed by theirs are removed from
+// ours, and imports added by theirs are added to the first import declaration of ours.
+func (m *fileMerger) mergeImports(base, ours, theirs *dst.File) []dst.Decl {
+	baseImports := importSpecs(base)
+	oursImports := importSpecs(ours)
+	theirsImports := importSpecs(theirs)
+
+	var result []dst.Decl
+
+	for _, decl := range ours.Decls {
+		gd, ok := decl.(*dst.GenDecl)
+
+		if !ok || gd.Tok != token.IMPORT {
+			continue
+		}
+
+		specs := gd.Specs[:0]
+
+		for _, spec := range gd.Specs {
+			key := importKey(spec.(*dst.ImportSpec))
+
+			if baseImports[key] != nil && theirsImports[key] == nil {
+				continue
+			}
+
+			specs = append(specs, spec)
+		}
+
+		gd.Specs = specs
+
+		if len(specs) > 0 {
+			result = append(result, gd)
+		}
+	}
+
+	var added []dst.Spec
+
+	for _, decl := range theirs.Decls {
+		gd, ok := decl.(*dst.GenDecl)
+
+		if !ok || gd.Tok != token.IMPORT {
+			continue
+		}
+
+		for _, spec := range gd.Specs {
+			key := importKey(spec.(*dst.ImportSpec))
+
+			if baseImports[key] == nil && oursImports[key] == nil {
+				spec.Decorations().Before = dst.NewLine
+				added = append(added, spec)
+			}
+		}
+	}
+
+	if len(added) == 0 {
+		return result
+	}
+
+	if len(result) == 0 {
+		result = append(result, &dst.GenDecl{Tok: token.IMPORT})
+	}
+
+	gd := result[0].(*dst.GenDecl)
+
+	if len(gd.Specs) > 0 {
+		gd.Specs[len(gd.Specs)-1].Decorations().After = dst.NewLine
+	}
+
+	gd.Specs = append(gd.Specs, added...)
+	gd.Lparen = gd.Lparen || len(gd.Specs) > 1
+	gd.Rparen = gd.Lparen
+
+	return result
+}
+
+// declEntry is a declaration of a file, other than imports.
+type declEntry struct {
+	key  string
+	decl dst.Decl
+	code string
+}
+
+type declIndex struct {
+	order []*declEntry
+	byKey map[string]*declEntry
+}
+
+func indexDecls(f *dst.File) *declIndex {
+	idx := &declIndex{byKey: map[string]*declEntry{}}
+	counts := map[string]int{}
+
+	for _, decl := range f.Decls {
+		if gd, ok := decl.(*dst.GenDecl); ok && gd.Tok == token.IMPORT {
+			continue
+		}
+
+		key := declKey(decl)
+
+		// Disambiguate declarations such as init functions and blank variables.
+		if n := counts[key]; n > 0 {
+			counts[key]++
+			key = fmt.Sprintf("%s#%d", key, n)
+		} else {
+			counts[key] = 1
+		}
+
+		entry := &declEntry{key: key, decl: decl, code: declCode(decl)}
+
+		idx.order = append(idx.order, entry)
+		idx.byKey[key] = entry
+	}
+
+	return idx
+}
+
+// declKey identifies a declaration by what it declares, e.g. "func (*T).Run" or "type T".
+func declKey(decl dst.Decl) string {
+	switch d := decl.(type) {
+	case *dst.FuncDecl:
+		if d.Recv != nil && len(d.Recv.List) > 0 {
+			return fmt.Sprintf("func (%s).%s", receiverTypeName(d.Recv.List[0].Type), d.Name.Name)
+		}
+
+		return "func " + d.Name.Name
+
+	case *dst.GenDecl:
+		var names []string
+
+		for _, spec := range d.Specs {
+			switch s := spec.(type) {
+			case *dst.ValueSpec:
+				for _, name := range s.Names {
+					names = append(names, name.Name)
+				}
+
+			case *dst.TypeSpec:
+				names = append(names, s.Name.Name)
+			}
+		}
+
+		return d.Tok.String() + " " + strings.Join(names, ", ")
+	}
+
+	return fmt.Sprintf("%T", decl)
+}
+
+func receiverTypeName(expr dst.Expr) string {
+	switch e := expr.(type) {
+	case *dst.StarExpr:
+		return "*" + receiverTypeName(e.X)
+	case *dst.IndexExpr:
+		return receiverTypeName(e.X)
+	case *dst.IndexListExpr:
+		return receiverTypeName(e.X)
+	case *dst.Ident:
+		return e.Name
+	}
+
+	return fmt.Sprintf("%T", expr)
+}
+
+func importSpecs(f *dst.File) map[string]*dst.ImportSpec {
+	result := map[string]*dst.ImportSpec{}
+
+	for _, decl := range f.Decls {
+		if gd, ok := decl.(*dst.GenDecl); ok && gd.Tok == token.IMPORT {
+			for _, spec := range gd.Specs {
+				result[importKey(spec.(*dst.ImportSpec))] = spec.(*dst.ImportSpec)
+			}
+		}
+	}
+
+	return result
+}
+
+func importKey(spec *dst.ImportSpec) string {
+	if spec.Name != nil {
+		return spec.Name.Name + " " + spec.Path.Value
+	}
+
+	return spec.Path.Value
+}
+
+// declCode prints a declaration, ignoring the spacing around it.
+func declCode(decl dst.Decl) string {
+	c := dst.Clone(decl).(dst.Decl)
+	c.Decorations().Before = dst.None
+	c.Decorations().After = dst.None
+
+	return printDecls(c)
+}
+
+// funcHeaderCode prints a function declaration without its body.
+func funcHeaderCode(fn *dst.FuncDecl) string {
+	c := dst.Clone(fn).(*dst.FuncDecl)
+	c.Body = nil
+	c.Decs.Before = dst.None
+	c.Decs.After = dst.None
+
+	return printDecls(c)
+}
+
+// stmtsCode prints each statement, ignoring the spacing around it.
+func stmtsCode(stmts []dst.Stmt) []string {
+	result := make([]string, len(stmts))
+
+	for i, stmt := range stmts {
+		c := dst.Clone(stmt).(dst.Stmt)
+		c.Decorations().Before = dst.None
+		c.Decorations().After = dst.None
+
+		result[i] = strings.TrimSpace(printDecls(&dst.FuncDecl{
+			Name: dst.NewIdent("_"),
+			Type: &dst.FuncType{Params: &dst.FieldList{}},
+			Body: &dst.BlockStmt{List: []dst.Stmt{c}},
+		}))
+
+		result[i] = strings.TrimSuffix(strings.TrimPrefix(result[i], "func _() {"), "}")
+		result[i] = strings.TrimSpace(result[i])
+	}
+
+	return result
+}
+
+// printDecls prints declarations without their package clause. Declarations that cannot be
+// printed are told apart by their address, so they never compare equal.
+func printDecls(decls ...dst.Decl) string {
+	var buf bytes.Buffer
+
+	f := &dst.File{Name: dst.NewIdent("_"), Decls: decls}
+
+	if err := decorator.Fprint(&buf, f); err != nil {
+		return fmt.Sprintf("%p", decls[0])
+	}
+
+	return strings.TrimSpace(strings.TrimPrefix(buf.String(), "package _"))
+}
+
+// matchLines matches the lines of a with the lines of b, using their longest common subsequence.
+// The result holds the index in b of each line of a, or -1.
+func matchLines(a, b []string) []int {
+	lcs := make([][]int, len(a)+1)
+
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	result := make([]int, len(a))
+
+	for i := range result {
+		result[i] = -1
+	}
+
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		switch {
+		case a[i] == b[j]:
+			result[i] = j
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			i++
+		default:
+			j++
+		}
+	}
+
+	return result
+}
+
+// markConflict surrounds the nodes from first to last with conflict marker comments. The nodes are
+// ours if isOurs is set, and theirs otherwise; other is the code of the other side, which is
+// commented out.
+func markConflict(first, last dst.Node, other string, isOurs bool) {
+	start := []string{"// <<<<<<< ours"}
+	end := []string{"// >>>>>>> theirs"}
+
+	if isOurs {
+		var middle []string
+
+		middle = append(middle, "// =======")
+
+		if other != "" {
+			for _, line := range strings.Split(other, "\n") {
+				middle = append(middle, strings.TrimRight("// "+line, " "))
+			}
+		}
+
+		end = append(middle, end...)
+	} else {
+		start = append(start, "// =======")
+	}
+
+	first.Decorations().Before = dst.NewLine
+	first.Decorations().Start.Prepend(start...)
+	last.Decorations().End.Append("\n")
+	last.Decorations().End.Append(end...)
+}
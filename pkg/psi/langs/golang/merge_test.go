@@ -0,0 +1,96 @@
+package golang
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/greenboxal/agibootstrap/pkg/psi"
+)
+
+var testMergeBase = `package main
+
+import "fmt"
+
+func hello() {
+	fmt.Println("hello")
+	fmt.Println(",")
+	fmt.Println("world")
+}
+
+func answer() int { return 41 }
+`
+
+var testMergeOurs = `package main
+
+import "fmt"
+
+func hello() {
+	fmt.Println("hello, human")
+	fmt.Println(",")
+	fmt.Println("world")
+}
+
+func answer() int { return 42 }
+
+func human() {}
+`
+
+var testMergeTheirs = `package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+func hello() {
+	fmt.Println("hello")
+	fmt.Println(",")
+	fmt.Println(strings.ToUpper("world"))
+}
+
+func answer() int { return 43 }
+
+func generated() {}
+`
+
+func TestMergeSources(t *testing.T) {
+	merged, conflicts, err := MergeSources("main.go", testMergeBase, testMergeOurs, testMergeTheirs, psi.MergeOptions{})
+	require.NoError(t, err)
+
+	require.Equal(t, `package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+func hello() {
+	fmt.Println("hello, human")
+	fmt.Println(",")
+	fmt.Println(strings.ToUpper("world"))
+}
+
+func answer() int { return 42 }
+
+func generated() {}
+
+func human() {}
+`, merged)
+
+	require.Len(t, conflicts, 1)
+	require.Equal(t, "func answer", conflicts[0].Declaration)
+	require.Equal(t, "return 42", conflicts[0].Ours)
+	require.Equal(t, "return 43", conflicts[0].Theirs)
+
+	merged, _, err = MergeSources("main.go", testMergeBase, testMergeOurs, testMergeTheirs, psi.MergeOptions{ConflictMarkers: true})
+	require.NoError(t, err)
+
+	require.Contains(t, merged, `func answer() int {
+	// <<<<<<< ours
+	return 42
+	// =======
+	// return 43
+	// >>>>>>> theirs
+}`)
+}
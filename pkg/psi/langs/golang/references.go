@@ -0,0 +1,387 @@
+package golang
+
+import (
+	"context"
+	"go/ast"
+	"go/importer"
+	"go/token"
+	"go/types"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"golang.org/x/mod/modfile"
+
+	"github.com/greenboxal/agibootstrap/pkg/psi"
+)
+
+// ResolveReferences type-checks the given Go source files with go/types and links their nodes:
+//
+//   - identifiers to the declaration of the object they use (psi.EdgeKindDeclaration),
+//   - call sites to the declaration of their callee (psi.EdgeKindReference),
+//   - interfaces to the types implementing them (psi.EdgeKindImplementation).
+//
+// Files are grouped into packages by directory and package name. Packages of the project module
+// are imported from the given files when they are part of them, so references across packages are
+// resolved as well. Other imports are type-checked from source. Type errors do not stop the
+// resolution: they are published as psi.DiagnosticSourceTypeCheck diagnostics on the files they
+// were found in. Only declarations found in the given files are linked.
+func (l *Language) ResolveReferences(ctx context.Context, files []psi.SourceFile) error {
+	r := &referenceResolver{
+		l:        l,
+		fset:     l.project.FileSet(),
+		packages: map[string]*resolvedPackage{},
+		decls:    map[token.Pos]psi.Node{},
+		fallback: importer.ForCompiler(l.project.FileSet(), "source", nil).(types.ImporterFrom),
+	}
+
+	r.modulePath = readModulePath(l.project.RootPath())
+
+	for _, f := range files {
+		sf, ok := f.(*SourceFile)
+
+		if !ok || sf.parsed == nil || sf.dec == nil || sf.root == nil {
+			continue
+		}
+
+		astFile, ok := sf.dec.Ast.Nodes[sf.parsed].(*ast.File)
+
+		if !ok {
+			continue
+		}
+
+		importPath := r.importPathOf(sf)
+		pkg := r.packages[importPath]
+
+		if pkg == nil {
+			pkg = &resolvedPackage{path: importPath, name: astFile.Name.Name}
+			r.packages[importPath] = pkg
+		}
+
+		// Test packages and stray files of another package are checked on their own.
+		if pkg.name != astFile.Name.Name {
+			importPath += "#" + astFile.Name.Name
+			pkg = r.packages[importPath]
+
+			if pkg == nil {
+				pkg = &resolvedPackage{path: importPath, name: astFile.Name.Name}
+				r.packages[importPath] = pkg
+			}
+		}
+
+		pkg.files = append(pkg.files, sf)
+		pkg.astFiles = append(pkg.astFiles, astFile)
+	}
+
+	paths := make([]string, 0, len(r.packages))
+
+	for p := range r.packages {
+		paths = append(paths, p)
+	}
+
+	sort.Strings(paths)
+
+	for _, p := range paths {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		r.check(r.packages[p])
+	}
+
+	for _, p := range paths {
+		r.indexDeclarations(r.packages[p])
+	}
+
+	for _, p := range paths {
+		r.linkReferences(r.packages[p])
+	}
+
+	r.linkImplementations(paths)
+
+	return nil
+}
+
+type resolvedPackage struct {
+	path string
+	name string
+
+	files    []*SourceFile
+	astFiles []*ast.File
+
+	checking bool
+	pkg      *types.Package
+	info     *types.Info
+
+	// nodes maps the AST nodes of the package files to their PSI nodes.
+	nodes map[ast.Node]psi.Node
+}
+
+type referenceResolver struct {
+	l          *Language
+	fset       *token.FileSet
+	modulePath string
+
+	packages map[string]*resolvedPackage
+	fallback types.ImporterFrom
+
+	// decls maps the position of every declared object to the PSI node declaring it.
+	decls map[token.Pos]psi.Node
+}
+
+func (r *referenceResolver) importPathOf(sf *SourceFile) string {
+	dir := filepath.Dir(sf.absolutePath())
+	rel, err := filepath.Rel(r.l.project.RootPath(), dir)
+
+	if err != nil || r.modulePath == "" || strings.HasPrefix(rel, "..") {
+		return dir
+	}
+
+	if rel == "." {
+		return r.modulePath
+	}
+
+	return r.modulePath + "/" + filepath.ToSlash(rel)
+}
+
+func (r *referenceResolver) Import(path string) (*types.Package, error) {
+	return r.ImportFrom(path, "", 0)
+}
+
+func (r *referenceResolver) ImportFrom(path, dir string, mode types.ImportMode) (*types.Package, error) {
+	if pkg := r.packages[path]; pkg != nil && !pkg.checking {
+		r.check(pkg)
+
+		return pkg.pkg, nil
+	}
+
+	return r.fallback.ImportFrom(path, dir, mode)
+}
+
+func (r *referenceResolver) check(pkg *resolvedPackage) {
+	if pkg.info != nil || pkg.checking {
+		return
+	}
+
+	pkg.checking = true
+	defer func() { pkg.checking = false }()
+
+	pkg.info = &types.Info{
+		Defs: map[*ast.Ident]types.Object{},
+		Uses: map[*ast.Ident]types.Object{},
+	}
+
+	var typeErrors []types.Error
+
+	cfg := &types.Config{
+		Importer:    r,
+		FakeImportC: true,
+		Error: func(err error) {
+			if te, ok := err.(types.Error); ok {
+				typeErrors = append(typeErrors, te)
+			}
+		},
+	}
+
+	pkg.pkg, _ = cfg.Check(pkg.path, r.fset, pkg.astFiles, pkg.info)
+
+	publishTypeDiagnostics(r.fset, pkg, typeErrors)
+
+	pkg.nodes = map[ast.Node]psi.Node{}
+
+	for _, sf := range pkg.files {
+		_ = psi.Walk(sf.root, func(cursor psi.Cursor, entering bool) error {
+			if !entering {
+				return nil
+			}
+
+			if gn, ok := cursor.Node().(Node); ok {
+				clearReferences(gn)
+
+				if astNode := sf.dec.Ast.Nodes[gn.Ast()]; astNode != nil {
+					pkg.nodes[astNode] = gn
+				}
+			}
+
+			cursor.WalkChildren()
+
+			return nil
+		})
+	}
+}
+
+// indexDeclarations records the node declaring each object defined in the package, which is the
+// container (FuncDecl, TypeSpec, ValueSpec, ...) enclosing the defining identifier.
+func (r *referenceResolver) indexDeclarations(pkg *resolvedPackage) {
+	for id, obj := range pkg.info.Defs {
+		if obj == nil {
+			continue
+		}
+
+		n := pkg.nodes[id]
+
+		if n == nil {
+			continue
+		}
+
+		if parent := n.Parent(); parent != nil {
+			n = parent
+		}
+
+		r.decls[obj.Pos()] = n
+	}
+}
+
+func (r *referenceResolver) linkReferences(pkg *resolvedPackage) {
+	for id, obj := range pkg.info.Uses {
+		n := pkg.nodes[id]
+		decl := r.decls[obj.Pos()]
+
+		// Uses inside the declaring container, such as local variables, are not linked.
+		if n == nil || decl == nil || decl == n.Parent() {
+			continue
+		}
+
+		n.SetEdge(psi.EdgeKey{Kind: psi.EdgeKindDeclaration}, decl)
+	}
+
+	for _, f := range pkg.astFiles {
+		ast.Inspect(f, func(node ast.Node) bool {
+			call, ok := node.(*ast.CallExpr)
+
+			if !ok {
+				return true
+			}
+
+			n := pkg.nodes[call]
+			callee := calleeOf(pkg.info, call)
+
+			if n == nil || callee == nil {
+				return true
+			}
+
+			if decl := r.decls[callee.Pos()]; decl != nil {
+				n.SetEdge(psi.EdgeKey{Kind: psi.EdgeKindReference}, decl)
+			}
+
+			return true
+		})
+	}
+}
+
+// linkImplementations links every interface declared in the packages to the named types implementing it.
+func (r *referenceResolver) linkImplementations(paths []string) {
+	var named []*types.TypeName
+
+	for _, p := range paths {
+		pkg := r.packages[p]
+
+		for _, obj := range pkg.info.Defs {
+			tn, ok := obj.(*types.TypeName)
+
+			if !ok || tn.IsAlias() || tn.Parent() != tn.Pkg().Scope() {
+				continue
+			}
+
+			if t, ok := tn.Type().(*types.Named); ok && t.TypeParams().Len() == 0 {
+				named = append(named, tn)
+			}
+		}
+	}
+
+	sort.Slice(named, func(i, j int) bool { return named[i].Pos() < named[j].Pos() })
+
+	for _, iface := range named {
+		it, ok := iface.Type().Underlying().(*types.Interface)
+
+		if !ok || it.Empty() || !it.IsMethodSet() {
+			continue
+		}
+
+		ifaceNode := r.decls[iface.Pos()]
+
+		if ifaceNode == nil {
+			continue
+		}
+
+		for _, impl := range named {
+			if impl == iface || types.IsInterface(impl.Type()) {
+				continue
+			}
+
+			if !types.Implements(impl.Type(), it) && !types.Implements(types.NewPointer(impl.Type()), it) {
+				continue
+			}
+
+			if implNode := r.decls[impl.Pos()]; implNode != nil {
+				key := psi.EdgeKey{Kind: psi.EdgeKindImplementation, Name: impl.Pkg().Path() + "." + impl.Name()}
+
+				ifaceNode.SetEdge(key, implNode)
+			}
+		}
+	}
+}
+
+// calleeOf returns the function called by call, if it is statically known.
+func calleeOf(info *types.Info, call *ast.CallExpr) types.Object {
+	var id *ast.Ident
+
+	fun := call.Fun
+
+	for {
+		paren, ok := fun.(*ast.ParenExpr)
+
+		if !ok {
+			break
+		}
+
+		fun = paren.X
+	}
+
+	switch fun := fun.(type) {
+	case *ast.Ident:
+		id = fun
+	case *ast.SelectorExpr:
+		id = fun.Sel
+	case *ast.IndexExpr:
+		return calleeOf(info, &ast.CallExpr{Fun: fun.X})
+	case *ast.IndexListExpr:
+		return calleeOf(info, &ast.CallExpr{Fun: fun.X})
+	default:
+		return nil
+	}
+
+	if fn, ok := info.Uses[id].(*types.Func); ok {
+		return fn
+	}
+
+	return nil
+}
+
+// clearReferences removes the edges set by a previous resolution.
+func clearReferences(n psi.Node) {
+	var keys []psi.EdgeKey
+
+	for it := n.Edges(); it.Next(); {
+		switch it.Edge().Kind() {
+		case psi.EdgeKindDeclaration, psi.EdgeKindReference, psi.EdgeKindImplementation:
+			keys = append(keys, it.Edge().Key().GetKey())
+		}
+	}
+
+	for _, k := range keys {
+		n.UnsetEdge(k)
+	}
+}
+
+// readModulePath returns the module path declared by the go.mod file at the root of the project.
+func readModulePath(rootPath string) string {
+	data, err := os.ReadFile(filepath.Join(rootPath, "go.mod"))
+
+	if err != nil {
+		return ""
+	}
+
+	return modfile.ModulePath(data)
+}
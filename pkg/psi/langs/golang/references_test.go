@@ -0,0 +1,217 @@
+package golang
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dave/dst"
+	"github.com/stretchr/testify/require"
+
+	"github.com/greenboxal/agibootstrap/pkg/codex"
+	"github.com/greenboxal/agibootstrap/pkg/psi"
+)
+
+var testReferencesFixture = map[string]string{
+	"go.mod": "module example.com/geo\n\ngo 1.20\n",
+
+	"shapes/shapes.go": `package shapes
+
+type Shape interface {
+	Area() float64
+}
+
+type Square struct {
+	Side float64
+}
+
+func (s Square) Area() float64 {
+	return s.Side * s.Side
+}
+
+type Circle struct {
+	Radius float64
+}
+
+func NewSquare(side float64) Square {
+	return Square{Side: side}
+}
+`,
+
+	"main.go": `package main
+
+import "example.com/geo/shapes"
+
+func describe(s shapes.Shape) float64 {
+	return s.Area()
+}
+
+func main() {
+	sq := shapes.NewSquare(2)
+
+	println(describe(sq), sq.Area())
+}
+`,
+}
+
+// findNode returns the first node of root, in depth-first order, whose AST node satisfies pred.
+func findNode(root psi.Node, pred func(n dst.Node) bool) psi.Node {
+	var result psi.Node
+
+	_ = psi.Walk(root, func(cursor psi.Cursor, entering bool) error {
+		if !entering || result != nil {
+			return nil
+		}
+
+		if gn, ok := cursor.Node().(Node); ok && pred(gn.Ast()) {
+			result = gn
+			return nil
+		}
+
+		cursor.WalkChildren()
+
+		return nil
+	})
+
+	return result
+}
+
+func isFuncDecl(name string) func(n dst.Node) bool {
+	return func(n dst.Node) bool {
+		d, ok := n.(*dst.FuncDecl)
+
+		return ok && d.Name.Name == name
+	}
+}
+
+func isTypeSpec(name string) func(n dst.Node) bool {
+	return func(n dst.Node) bool {
+		d, ok := n.(*dst.TypeSpec)
+
+		return ok && d.Name.Name == name
+	}
+}
+
+func isSelectorCall(sel string) func(n dst.Node) bool {
+	return func(n dst.Node) bool {
+		call, ok := n.(*dst.CallExpr)
+
+		if !ok {
+			return false
+		}
+
+		fun, ok := call.Fun.(*dst.SelectorExpr)
+
+		return ok && fun.Sel.Name == sel
+	}
+}
+
+func edgeTarget(n psi.Node, key psi.EdgeKey) psi.Node {
+	e := n.GetEdge(key)
+
+	if e == nil {
+		return nil
+	}
+
+	return e.To()
+}
+
+func TestResolveReferences(t *testing.T) {
+	root := t.TempDir()
+
+	for name, content := range testReferencesFixture {
+		require.NoError(t, os.MkdirAll(filepath.Join(root, filepath.Dir(name)), 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(root, name), []byte(content), 0644))
+	}
+
+	ctx := context.Background()
+
+	p, err := codex.NewProject(ctx, root, codex.WithOverlayFS())
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, p.Close()) })
+
+	var shapesFile, mainFile psi.SourceFile
+
+	err = psi.UpdateGraph(p.Graph(), func() error {
+		if err := p.LoadSourceFiles(); err != nil {
+			return err
+		}
+
+		if err := p.ResolveReferences(ctx); err != nil {
+			return err
+		}
+
+		if shapesFile, err = p.GetSourceFile(filepath.Join(root, "shapes", "shapes.go")); err != nil {
+			return err
+		}
+
+		mainFile, err = p.GetSourceFile(filepath.Join(root, "main.go"))
+
+		return err
+	})
+	require.NoError(t, err)
+
+	require.Empty(t, psi.CollectDiagnostics(shapesFile))
+	require.Empty(t, psi.CollectDiagnostics(mainFile))
+
+	newSquare := findNode(shapesFile.Root(), isFuncDecl("NewSquare"))
+	area := findNode(shapesFile.Root(), isFuncDecl("Area"))
+	describe := findNode(mainFile.Root(), isFuncDecl("describe"))
+	mainFn := findNode(mainFile.Root(), isFuncDecl("main"))
+	require.NotNil(t, newSquare)
+	require.NotNil(t, area)
+
+	// Call sites point to the declaration of their callee, across packages.
+	newSquareCall := findNode(mainFn, isSelectorCall("NewSquare"))
+	require.NotNil(t, newSquareCall)
+	require.Same(t, newSquare, edgeTarget(newSquareCall, psi.EdgeKey{Kind: psi.EdgeKindReference}))
+
+	areaCall := findNode(mainFn, isSelectorCall("Area"))
+	require.NotNil(t, areaCall)
+	require.Same(t, area, edgeTarget(areaCall, psi.EdgeKey{Kind: psi.EdgeKindReference}))
+
+	// Identifiers point to the declaration of the object they use. Like every leaf,
+	// they are children of their enclosing container.
+	newSquareIdent := findNode(mainFn, func(n dst.Node) bool {
+		id, ok := n.(*dst.Ident)
+
+		return ok && id.Name == "NewSquare"
+	})
+	require.NotNil(t, newSquareIdent)
+	require.Same(t, newSquare, edgeTarget(newSquareIdent, psi.EdgeKey{Kind: psi.EdgeKindDeclaration}))
+
+	shapeIdent := findNode(describe, func(n dst.Node) bool {
+		id, ok := n.(*dst.Ident)
+
+		return ok && id.Name == "Shape"
+	})
+	require.NotNil(t, shapeIdent)
+
+	shape := findNode(shapesFile.Root(), isTypeSpec("Shape"))
+	require.Same(t, shape, edgeTarget(shapeIdent, psi.EdgeKey{Kind: psi.EdgeKindDeclaration}))
+
+	// Calls through an interface point to the interface declaring the method.
+	require.Same(t, shape, edgeTarget(findNode(describe, isSelectorCall("Area")), psi.EdgeKey{Kind: psi.EdgeKindReference}))
+
+	// Interfaces point to the types implementing them.
+	square := findNode(shapesFile.Root(), isTypeSpec("Square"))
+	require.Same(t, square, edgeTarget(shape, psi.EdgeKey{Kind: psi.EdgeKindImplementation, Name: "example.com/geo/shapes.Square"}))
+	require.Nil(t, edgeTarget(shape, psi.EdgeKey{Kind: psi.EdgeKindImplementation, Name: "example.com/geo/shapes.Circle"}))
+
+	// Resolving again replaces the edges instead of accumulating them.
+	err = psi.UpdateGraph(p.Graph(), func() error {
+		return p.ResolveReferences(ctx)
+	})
+	require.NoError(t, err)
+
+	count := 0
+
+	for it := shape.Edges(); it.Next(); {
+		if it.Edge().Kind() == psi.EdgeKindImplementation {
+			count++
+		}
+	}
+
+	require.Equal(t, 1, count)
+}
@@ -61,28 +61,57 @@ func (sf *SourceFile) OriginalText() string            { return sf.original }
 func (sf *SourceFile) Root() psi.Node                  { return sf.root }
 func (sf *SourceFile) Error() error                    { return sf.err }
 
-func (sf *SourceFile) Load() error {
-	file, err := sf.handle.Get()
+// NodeRange returns the source positions of n, as parsed by the last Load.
+// Nodes created after parsing, such as merged completion results, have no position.
+func (sf *SourceFile) NodeRange(n psi.Node) (start, end token.Position, ok bool) {
+	gn, isGoNode := n.(Node)
 
-	if err != nil {
-		return err
+	if !isGoNode || sf.dec == nil {
+		return
 	}
 
-	data, err := io.ReadAll(file)
+	astNode := sf.dec.Ast.Nodes[gn.Ast()]
+
+	if astNode == nil || !astNode.Pos().IsValid() {
+		return
+	}
+
+	return sf.fset.Position(astNode.Pos()), sf.fset.Position(astNode.End()), true
+}
+
+// NodeSourceRange implements psi.SourceRangeProvider through NodeRange.
+func (sf *SourceFile) NodeSourceRange(n psi.Node) (psi.SourceRange, bool) {
+	start, end, ok := sf.NodeRange(n)
+
+	if !ok {
+		return psi.SourceRange{}, false
+	}
+
+	return psi.SourceRangeFromPositions(start, end), true
+}
+
+func (sf *SourceFile) Load() error {
+	data, err := sf.readFile()
 
 	if err != nil {
 		return err
 	}
 
+	if sf.root != nil {
+		sf.root.SetParent(nil)
+	}
+
 	sf.root = nil
 	sf.parsed = nil
 	sf.err = nil
-	sf.original = string(data)
+	sf.original = data
 
-	_, err = sf.Parse(sf.name, string(data))
+	_, err = sf.Parse(sf.name, data)
 
 	sf.err = err
 
+	sf.publishParseDiagnostics(err)
+
 	return err
 }
 
@@ -100,6 +129,66 @@ func (sf *SourceFile) Replace(code string) error {
 	return sf.Load()
 }
 
+// MergeReplace merges code, derived from the text of the file when it was loaded, with the changes
+// made to the file since then. See MergeSources.
+func (sf *SourceFile) MergeReplace(code string, opts psi.MergeOptions) ([]*psi.MergeConflict, error) {
+	current, merged, conflicts, err := sf.mergeWithCurrent(code, opts)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if current == sf.original {
+		return nil, sf.Replace(code)
+	}
+
+	if merged != current {
+		if err := sf.handle.Put(bytes.NewBufferString(merged)); err != nil {
+			return nil, err
+		}
+	}
+
+	return conflicts, sf.Load()
+}
+
+// mergeWithCurrent returns the current contents of the file, and code merged with the changes made
+// to them since the file was loaded, as MergeReplace writes it.
+func (sf *SourceFile) mergeWithCurrent(code string, opts psi.MergeOptions) (current, merged string, conflicts []*psi.MergeConflict, err error) {
+	current, err = sf.readFile()
+
+	if err != nil {
+		return "", "", nil, err
+	}
+
+	if current == sf.original {
+		return current, code, nil, nil
+	}
+
+	merged, conflicts, err = MergeSources(sf.name, sf.original, current, code, opts)
+
+	if err != nil {
+		return "", "", nil, err
+	}
+
+	return current, merged, conflicts, nil
+}
+
+func (sf *SourceFile) readFile() (string, error) {
+	file, err := sf.handle.Get()
+
+	if err != nil {
+		return "", err
+	}
+
+	data, err := io.ReadAll(file)
+
+	if err != nil {
+		return "", err
+	}
+
+	return string(data), nil
+}
+
 func (sf *SourceFile) SetRoot(node *dst.File) error {
 	sf.parsed = node
 	sf.root = AstToPsi(sf.parsed)
@@ -121,7 +210,8 @@ func (sf *SourceFile) Parse(filename string, sourceCode string) (result psi.Node
 		}
 	}()
 
-	parsed, err := decorator.ParseFile(sf.fset, filename, sourceCode, parser.ParseComments)
+	dec := decorator.NewDecorator(sf.fset)
+	parsed, err := dec.ParseFile(filename, sourceCode, parser.ParseComments)
 
 	sf.parsed = parsed
 	sf.err = err
@@ -133,6 +223,9 @@ func (sf *SourceFile) Parse(filename string, sourceCode string) (result psi.Node
 	node := AstToPsi(parsed)
 
 	if sf.root == nil {
+		// Keep the decorator of the root so the positions of its nodes can be recovered.
+		sf.dec = dec
+
 		if err := sf.SetRoot(parsed); err != nil {
 			return nil, err
 		}
@@ -215,17 +308,61 @@ func (sf *SourceFile) ToCode(node psi.Node) (mdutils.CodeBlock, error) {
 // 3. Check if the declaration is a function and if its name matches the name of the current scope's function.
 // 4. If the declaration matches, replace the current declaration at the cursor position with the new declaration by calling the ReplaceDeclarationAt function.
 // 5. If the declaration doesn't match, merge the new declaration with the existing declarations by calling the MergeDeclarations function.
-// 6. Return nil, indicating that there were no errors during the merging process.
-func (sf *SourceFile) MergeCompletionResults(ctx context.Context, scope psi.Scope, cursor psi.Cursor, newSource psi.SourceFile, newAst psi.Node) error {
-	MergeFiles(sf.root.(Node).Ast().(*dst.File), newAst.(Node).Ast().(*dst.File))
+//
+// MergeFiles mutates the AST of the file in place, outside of the graph. If the merge fails, the AST is
+// restored before returning the error. If a transaction is in progress, the AST is also restored when
+// the transaction is rolled back, so a failed merge of a later code block doesn't leave it half-mutated,
+// and when the committed transaction is undone or redone.
+func (sf *SourceFile) MergeCompletionResults(ctx context.Context, scope psi.Scope, cursor psi.Cursor, newSource psi.SourceFile, newAst psi.Node) (err error) {
+	newNode, ok := newAst.(Node)
+
+	if !ok {
+		return errors.Errorf("cannot merge %T into a Go file", newAst)
+	}
+
+	newFile, ok := newNode.Ast().(*dst.File)
+
+	if !ok {
+		return errors.Errorf("cannot merge %T into a Go file", newNode.Ast())
+	}
+
+	file := sf.root.(Node).Ast().(*dst.File)
+	snapshot := snapshotFile(file)
+
+	var tx *psi.Transaction
+
+	if g := sf.l.project.Graph(); g != nil {
+		if tx = g.CurrentTransaction(); tx != nil {
+			tx.OnRollback(snapshot.restore)
+		}
+	}
+
+	defer func() {
+		if err != nil {
+			snapshot.restore()
+		} else if tx != nil {
+			tx.OnUndo(snapshot.restore)
+			tx.OnRedo(snapshotFile(file).restore)
+		}
+	}()
 
-	scopeRootFn, hasScopeRootFn := scope.Root().(Node).Ast().(*dst.FuncDecl)
+	MergeFiles(file, newFile)
+
+	var scopeRootFn *dst.FuncDecl
+
+	if root, ok := scope.Root().(Node); ok {
+		scopeRootFn, _ = root.Ast().(*dst.FuncDecl)
+	}
 
 	for _, decl := range newAst.Children() {
-		if funcType, ok := decl.(Node).Ast().(*dst.FuncDecl); ok && hasScopeRootFn && funcType.Name.Name == scopeRootFn.Name.Name {
-			sf.ReplaceDeclarationAt(cursor, decl, funcType.Name.Name)
+		if funcType, ok := decl.(Node).Ast().(*dst.FuncDecl); ok && scopeRootFn != nil && funcType.Name.Name == scopeRootFn.Name.Name {
+			err = sf.ReplaceDeclarationAt(cursor, decl, funcType.Name.Name)
 		} else {
-			sf.MergeDeclarations(cursor, decl)
+			err = sf.MergeDeclarations(cursor, decl)
+		}
+
+		if err != nil {
+			return err
 		}
 	}
 
@@ -233,7 +370,7 @@ func (sf *SourceFile) MergeCompletionResults(ctx context.Context, scope psi.Scop
 }
 
 // MergeDeclarations merges the declarations of a node into the NodeProcessor.
-// It takes a psi.Cursor and a psi.Node representing the current node, and returns a boolean value indicating whether the merging was successful or not.
+// It takes a psi.Cursor and a psi.Node representing the current node, and returns an error if a declaration could not be found in the file after merging.
 //
 // The process of merging declarations involves the following steps:
 // 1. Retrieve all declaration names from the node using the getDeclarationNames function.
@@ -242,14 +379,16 @@ func (sf *SourceFile) MergeCompletionResults(ctx context.Context, scope psi.Scop
 // 4. Update the existing declaration with the current node's index, name, and AST representation using the setExistingDeclaration function.
 //
 // The purpose of MergeDeclarations is to ensure that all declarations within a node are properly merged into the NodeProcessor, allowing further processing and code generation to be performed accurately.
-func (sf *SourceFile) MergeDeclarations(cursor psi.Cursor, node psi.Node) bool {
+func (sf *SourceFile) MergeDeclarations(cursor psi.Cursor, node psi.Node) error {
 	names := getDeclarationNames(node)
 
 	for _, name := range names {
 		previous := sf.Root().GetEdge(psi.EdgeKey{Kind: EdgeKindDeclarations, Name: name})
 
 		if previous == nil {
-			sf.InsertDeclarationAt(cursor, name, node)
+			if err := sf.InsertDeclarationAt(cursor, name, node); err != nil {
+				return err
+			}
 		} else {
 			if cursor.Node().(Node).Ast() == previous.To().(Node).Ast() {
 				cursor.Replace(node)
@@ -259,21 +398,21 @@ func (sf *SourceFile) MergeDeclarations(cursor psi.Cursor, node psi.Node) bool {
 		}
 	}
 
-	return true
+	return nil
 }
 
 // InsertDeclarationAt inserts a declaration after the given cursor.
 // It takes a psi.Cursor, a name string, and a decl psi.Node.
 // The process involves the following steps:
 // 1. Calling the InsertAfter method of the cursor and passing decl.Ast() to insert the declaration after the cursor.
-// 2. Getting the current index of decl in the root file's declarations using the slices.Index method.
+// 2. Getting the current index of decl in the root file's declarations using the declarationIndex function.
 // 3. Calling the setExistingDeclaration method of the NodeProcessor to update the existing declaration information.
 //
 // The purpose of InsertDeclarationAt is to insert a declaration at a specific position in the AST and update the declaration information in the NodeProcessor for further processing and code generation.
-func (sf *SourceFile) InsertDeclarationAt(cursor psi.Cursor, name string, decl psi.Node) {
+func (sf *SourceFile) InsertDeclarationAt(cursor psi.Cursor, name string, decl psi.Node) error {
 	cursor.InsertAfter(decl)
-	index := slices.Index(sf.root.(Node).Ast().(*dst.File).Decls, decl.(Node).Ast().(dst.Decl))
-	sf.setExistingDeclaration(index, name, decl)
+
+	return sf.updateDeclaration(name, decl)
 }
 
 // ReplaceDeclarationAt method replaces a declaration at a specific cursor position with a new declaration.
@@ -282,14 +421,73 @@ func (sf *SourceFile) InsertDeclarationAt(cursor psi.Cursor, name string, decl p
 //
 // The steps involved in the ReplaceDeclarationAt method are as follows:
 // 1. The method replaces the declaration node at the cursor position with the new declaration node using the cursor.Replace method.
-// 2. It gets the index of the new declaration in the root file's declarations using the slices.Index method.
+// 2. It gets the index of the new declaration in the root file's declarations using the declarationIndex function.
 // 3. It updates the existing declaration information in the NodeProcessor by calling the setExistingDeclaration method.
 //
 // The purpose of the ReplaceDeclarationAt method is to provide a mechanism for replacing a declaration at a specific position in the AST and updating the declaration information in the NodeProcessor for further processing and code generation.
-func (sf *SourceFile) ReplaceDeclarationAt(cursor psi.Cursor, decl psi.Node, name string) {
+func (sf *SourceFile) ReplaceDeclarationAt(cursor psi.Cursor, decl psi.Node, name string) error {
 	cursor.Replace(decl)
-	index := slices.Index(sf.root.(Node).Ast().(*dst.File).Decls, decl.(Node).Ast().(dst.Decl))
+
+	return sf.updateDeclaration(name, decl)
+}
+
+// updateDeclaration records decl as the declaration of name, at its index in the root file.
+func (sf *SourceFile) updateDeclaration(name string, decl psi.Node) error {
+	index := declarationIndex(sf.root.(Node).Ast().(*dst.File), decl.(Node).Ast().(dst.Decl), name)
+
+	if index == -1 {
+		return errors.Errorf("declaration %s not found in %s after merging", name, sf.name)
+	}
+
 	sf.setExistingDeclaration(index, name, decl)
+
+	return nil
+}
+
+// declarationIndex returns the index of decl in the declarations of f. MergeFiles appends a copy of
+// the declarations whose specs are only partially replaced, so those are found by name.
+func declarationIndex(f *dst.File, decl dst.Decl, name string) int {
+	if index := slices.Index(f.Decls, decl); index != -1 {
+		return index
+	}
+
+	return slices.IndexFunc(f.Decls, func(d dst.Decl) bool {
+		return slices.Contains(declNames(d), name)
+	})
+}
+
+// fileSnapshot is the state of a *dst.File mutated by MergeFiles: its declarations and the specs of its
+// GenDecls.
+type fileSnapshot struct {
+	file  *dst.File
+	decls []dst.Decl
+	specs map[*dst.GenDecl][]dst.Spec
+}
+
+func snapshotFile(f *dst.File) *fileSnapshot {
+	s := &fileSnapshot{
+		file:  f,
+		decls: slices.Clone(f.Decls),
+		specs: map[*dst.GenDecl][]dst.Spec{},
+	}
+
+	for _, decl := range f.Decls {
+		if gd, ok := decl.(*dst.GenDecl); ok {
+			s.specs[gd] = slices.Clone(gd.Specs)
+		}
+	}
+
+	return s
+}
+
+// restore sets the file back to the snapshot. The slices are copied, so it can be restored again
+// after further mutations.
+func (s *fileSnapshot) restore() {
+	s.file.Decls = slices.Clone(s.decls)
+
+	for gd, specs := range s.specs {
+		gd.Specs = slices.Clone(specs)
+	}
 }
 
 // setExistingDeclaration updates the information about an existing declaration.
@@ -305,62 +503,93 @@ func (sf *SourceFile) setExistingDeclaration(index int, name string, node psi.No
 	sf.Root().SetEdge(psi.EdgeKey{Kind: EdgeKindDeclarations, Name: name, Index: int64(index)}, node)
 }
 
+// MergeFiles merges the declarations of file2 into file1, replacing the functions, types and values
+// of file1 declared with the same name and appending the others.
 func MergeFiles(file1, file2 *dst.File) *dst.File {
-	mergedFile := file1
 	newDecls := make([]dst.Decl, 0)
 
 	for _, decl2 := range file2.Decls {
-		found := false
 		switch decl2 := decl2.(type) {
 		case *dst.FuncDecl:
-			for i, decl1 := range mergedFile.Decls {
-				if decl1, ok := decl1.(*dst.FuncDecl); ok && decl1.Name.Name == decl2.Name.Name {
-					mergedFile.Decls[i] = decl2
-					found = true
-					break
-				}
+			if !replaceFuncDecl(file1, decl2) {
+				newDecls = append(newDecls, decl2)
 			}
+
 		case *dst.GenDecl:
+			var remaining []dst.Spec
+
 			for _, spec2 := range decl2.Specs {
-				switch spec2 := spec2.(type) {
-				case *dst.TypeSpec:
-					for i, decl1 := range mergedFile.Decls {
-						if decl1, ok := decl1.(*dst.GenDecl); ok {
-							for j, spec1 := range decl1.Specs {
-								if spec1, ok := spec1.(*dst.TypeSpec); ok && spec1.Name.Name == spec2.Name.Name {
-									decl1.Specs[j] = spec2
-									found = true
-									break
-								}
-							}
-						}
-						mergedFile.Decls[i] = decl1
-					}
-				case *dst.ValueSpec:
-					for i, decl1 := range mergedFile.Decls {
-						if decl1, ok := decl1.(*dst.GenDecl); ok {
-							for j, spec1 := range decl1.Specs {
-								if spec1, ok := spec1.(*dst.ValueSpec); ok && spec1.Names[0].Name == spec2.Names[0].Name {
-									decl1.Specs[j] = spec2
-									found = true
-									break
-								}
-							}
-						}
-						mergedFile.Decls[i] = decl1
-					}
+				if !replaceSpec(file1, spec2) {
+					remaining = append(remaining, spec2)
 				}
 			}
-		}
 
-		if !found {
+			if len(remaining) == len(decl2.Specs) {
+				newDecls = append(newDecls, decl2)
+			} else if len(remaining) > 0 {
+				rest := dst.Clone(decl2).(*dst.GenDecl)
+				rest.Specs = remaining
+				newDecls = append(newDecls, rest)
+			}
+
+		default:
 			newDecls = append(newDecls, decl2)
 		}
 	}
 
-	mergedFile.Decls = append(mergedFile.Decls, newDecls...)
+	file1.Decls = append(file1.Decls, newDecls...)
+
+	return file1
+}
+
+// replaceFuncDecl replaces the function of f with the same name and receiver as fn.
+func replaceFuncDecl(f *dst.File, fn *dst.FuncDecl) bool {
+	for i, decl := range f.Decls {
+		if decl, ok := decl.(*dst.FuncDecl); ok && declKey(decl) == declKey(fn) {
+			f.Decls[i] = fn
+			return true
+		}
+	}
+
+	return false
+}
+
+// replaceSpec replaces the type or value spec of f declaring the same name as spec.
+// Imports are never replaced.
+func replaceSpec(f *dst.File, spec dst.Spec) bool {
+	name := specName(spec)
+
+	if name == "" {
+		return false
+	}
+
+	for _, decl := range f.Decls {
+		gd, ok := decl.(*dst.GenDecl)
+
+		if !ok || gd.Tok == token.IMPORT {
+			continue
+		}
+
+		for j, existing := range gd.Specs {
+			if specName(existing) == name {
+				gd.Specs[j] = spec
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func specName(spec dst.Spec) string {
+	switch s := spec.(type) {
+	case *dst.TypeSpec:
+		return s.Name.Name
+	case *dst.ValueSpec:
+		return s.Names[0].Name
+	}
 
-	return mergedFile
+	return ""
 }
 
 // getDeclarationNames returns a slice of strings representing the declaration names in the given PSI node.
@@ -368,9 +597,20 @@ func MergeFiles(file1, file2 *dst.File) *dst.File {
 // The function iterates through the AST node and extracts the names of declarations such as constants, variables, types, and functions.
 // The extracted names are then appended to the names slice and returned.
 func getDeclarationNames(node psi.Node) []string {
+	decl, ok := node.(Node).Ast().(dst.Decl)
+
+	if !ok {
+		return nil
+	}
+
+	return declNames(decl)
+}
+
+// declNames returns the names declared by decl.
+func declNames(decl dst.Decl) []string {
 	var names []string
 
-	switch d := node.(Node).Ast().(type) {
+	switch d := decl.(type) {
 	case *dst.GenDecl:
 		for _, spec := range d.Specs {
 			switch s := spec.(type) {
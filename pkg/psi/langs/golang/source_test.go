@@ -2,6 +2,8 @@ package golang
 
 import (
 	"context"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -61,8 +63,10 @@ func newHelloFn() {
 `
 
 func setupTestProject(t *testing.T) testEnv {
-	p, err := codex.NewProject(context.Background(), ".")
+	p, err := codex.NewProject(context.Background(), t.TempDir())
 	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, p.Close()) })
+
 	lang := NewLanguage(p)
 
 	return testEnv{
@@ -129,3 +133,149 @@ func TestSourceMerge(t *testing.T) {
 	require.Equal(t, "test.go", code.Filename)
 	require.Equal(t, testCodeMerge, code.Code)
 }
+
+func TestSourceMergeRollback(t *testing.T) {
+	env := setupTestProject(t)
+	ctx := context.Background()
+
+	path := filepath.Join(env.Project.RootPath(), "test.go")
+	require.NoError(t, os.WriteFile(path, []byte(testCodeSimple), 0644))
+
+	src2 := NewSourceFile(env.Language, "merge.go", repofs.String(testCodeMerge))
+	require.NoError(t, src2.Load())
+
+	err := psi.UpdateGraph(env.Project.Graph(), func() error {
+		sf, err := env.Project.GetSourceFile(path)
+		require.NoError(t, err)
+
+		src1 := sf.(*SourceFile)
+		children := src1.Root().Children()
+
+		c := psi.NewCursor()
+		c.SetCurrent(src1.Root())
+
+		scope := &codegen.NodeScope{
+			Node: src1.Root(),
+		}
+
+		err = psi.RunInTransaction(env.Project.Graph(), func(tx *psi.Transaction) error {
+			require.NoError(t, src1.MergeCompletionResults(ctx, scope, c, src2, src2.Root()))
+
+			code, err := src1.ToCode(src1.Root())
+			require.NoError(t, err)
+			require.Equal(t, testCodeMerge, code.Code)
+
+			// The second code block is not a file, so the merge fails after the first one was merged.
+			return src1.MergeCompletionResults(ctx, scope, c, src2, src2.Root().Children()[0])
+		})
+		require.Error(t, err)
+
+		code, err := src1.ToCode(src1.Root())
+		require.NoError(t, err)
+		require.Equal(t, testCodeSimple, code.Code)
+		require.Equal(t, children, src1.Root().Children())
+
+		return nil
+	})
+	require.NoError(t, err)
+}
+
+func TestSourceMergeUndo(t *testing.T) {
+	env := setupTestProject(t)
+	ctx := context.Background()
+	g := env.Project.Graph()
+
+	path := filepath.Join(env.Project.RootPath(), "test.go")
+	require.NoError(t, os.WriteFile(path, []byte(testCodeSimple), 0644))
+
+	src2 := NewSourceFile(env.Language, "merge.go", repofs.String(testCodeMerge))
+	require.NoError(t, src2.Load())
+
+	err := psi.UpdateGraph(g, func() error {
+		sf, err := env.Project.GetSourceFile(path)
+		require.NoError(t, err)
+
+		src1 := sf.(*SourceFile)
+		children := src1.Root().Children()
+
+		c := psi.NewCursor()
+		c.SetCurrent(src1.Root())
+
+		scope := &codegen.NodeScope{
+			Node: src1.Root(),
+		}
+
+		require.NoError(t, psi.RunInTransaction(g, func(tx *psi.Transaction) error {
+			return src1.MergeCompletionResults(ctx, scope, c, src2, src2.Root())
+		}))
+
+		requireCode := func(expected string) {
+			code, err := src1.ToCode(src1.Root())
+			require.NoError(t, err)
+			require.Equal(t, expected, code.Code)
+		}
+
+		requireCode(testCodeMerge)
+
+		require.NoError(t, g.Undo())
+		requireCode(testCodeSimple)
+		require.Equal(t, children, src1.Root().Children())
+
+		require.NoError(t, g.Redo())
+		requireCode(testCodeMerge)
+
+		return nil
+	})
+	require.NoError(t, err)
+}
+
+func TestSourceReloadDiff(t *testing.T) {
+	env := setupTestProject(t)
+
+	path := filepath.Join(env.Project.RootPath(), "test.go")
+	require.NoError(t, os.WriteFile(path, []byte(testCodeSimple), 0644))
+
+	err := psi.UpdateGraph(env.Project.Graph(), func() error {
+		sf, err := env.Project.GetSourceFile(path)
+		require.NoError(t, err)
+
+		before, err := psi.Freeze(sf)
+		require.NoError(t, err)
+
+		// Loading the file again recreates its nodes with new UUIDs.
+		uuid := sf.Root().UUID()
+		require.NoError(t, sf.Load())
+		require.NotEqual(t, uuid, sf.Root().UUID())
+
+		after, err := psi.Freeze(sf)
+		require.NoError(t, err)
+
+		changes, err := psi.Diff(before, after)
+		require.NoError(t, err)
+		require.Empty(t, changes)
+
+		require.NoError(t, os.WriteFile(path, []byte(testCodeSimple+"\nfunc doAgain() {}\n"), 0644))
+		require.NoError(t, sf.Load())
+
+		after, err = psi.Freeze(sf)
+		require.NoError(t, err)
+
+		changes, err = psi.Diff(before, after)
+		require.NoError(t, err)
+
+		var added []string
+
+		for _, change := range changes {
+			require.NotEqual(t, psi.NodeRemoved, change.Kind, change.String())
+
+			if change.Kind == psi.NodeAdded {
+				added = append(added, change.Type)
+			}
+		}
+
+		require.Contains(t, added, "go.FuncDecl")
+
+		return nil
+	})
+	require.NoError(t, err)
+}
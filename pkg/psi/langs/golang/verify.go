@@ -0,0 +1,174 @@
+package golang
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"go/parser"
+	"go/token"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/dave/dst"
+	"github.com/dave/dst/decorator"
+	"github.com/hashicorp/go-multierror"
+	"github.com/pkg/errors"
+	"golang.org/x/tools/go/packages"
+	"golang.org/x/tools/imports"
+
+	"github.com/greenboxal/agibootstrap/pkg/psi"
+)
+
+// maxTestOutputLines bounds the test output reported back as diagnostics.
+const maxTestOutputLines = 50
+
+// VerifyCompletionResults merges the completion results into a scratch copy of the file, as
+// MergeCompletionResults does, and merges the result with the changes made to the file since it was
+// loaded, as MergeReplace does, so the code verified is the code that would be written. It then fixes
+// its imports and type-checks the resulting package with go/packages. If opts.RunTests is set, the
+// package tests are run against the merged file as well.
+// The source file itself is left untouched.
+func (sf *SourceFile) VerifyCompletionResults(ctx context.Context, root psi.Node, newSources []psi.SourceFile, opts psi.VerifyOptions) error {
+	current, err := sf.ToCode(root)
+
+	if err != nil {
+		return err
+	}
+
+	merged, err := decorator.ParseFile(token.NewFileSet(), sf.name, current.Code, parser.ParseComments)
+
+	if err != nil {
+		return err
+	}
+
+	for _, src := range newSources {
+		gsf, ok := src.(*SourceFile)
+
+		if !ok || gsf.parsed == nil {
+			return fmt.Errorf("cannot verify %s: not a parsed Go source file", src.Name())
+		}
+
+		MergeFiles(merged, dst.Clone(gsf.parsed).(*dst.File))
+	}
+
+	var buf bytes.Buffer
+
+	if err := decorator.Fprint(&buf, merged); err != nil {
+		return err
+	}
+
+	_, written, _, err := sf.mergeWithCurrent(buf.String(), opts.Merge)
+
+	if err != nil {
+		return err
+	}
+
+	filePath := sf.absolutePath()
+
+	code, err := imports.Process(filePath, []byte(written), &imports.Options{
+		AllErrors: true,
+		Comments:  true,
+		TabIndent: true,
+		TabWidth:  4,
+	})
+
+	if err != nil {
+		return err
+	}
+
+	if err := typeCheckOverlay(ctx, filePath, code); err != nil {
+		return err
+	}
+
+	if opts.RunTests {
+		return runTestsOverlay(ctx, filePath, code)
+	}
+
+	return nil
+}
+
+func (sf *SourceFile) absolutePath() string {
+	if filepath.IsAbs(sf.name) {
+		return sf.name
+	}
+
+	return filepath.Join(sf.l.project.RootPath(), sf.name)
+}
+
+// typeCheckOverlay type-checks the package containing filePath, with code as the file contents.
+func typeCheckOverlay(ctx context.Context, filePath string, code []byte) error {
+	cfg := &packages.Config{
+		Context: ctx,
+		Mode:    packages.NeedName | packages.NeedFiles | packages.NeedSyntax | packages.NeedTypes | packages.NeedTypesInfo,
+		Dir:     filepath.Dir(filePath),
+		Tests:   strings.HasSuffix(filePath, "_test.go"),
+		Overlay: map[string][]byte{filePath: code},
+	}
+
+	pkgs, err := packages.Load(cfg, "file="+filePath)
+
+	if err != nil {
+		return errors.Wrap(err, "failed to load package")
+	}
+
+	var merr error
+
+	for _, pkg := range pkgs {
+		for _, e := range pkg.Errors {
+			merr = multierror.Append(merr, errors.New(e.Error()))
+		}
+	}
+
+	return merr
+}
+
+// runTestsOverlay runs the tests of the package containing filePath, with code as the file contents.
+func runTestsOverlay(ctx context.Context, filePath string, code []byte) error {
+	tmpDir, err := os.MkdirTemp("", "agib-verify-")
+
+	if err != nil {
+		return err
+	}
+
+	defer os.RemoveAll(tmpDir)
+
+	replacement := filepath.Join(tmpDir, filepath.Base(filePath))
+
+	if err := os.WriteFile(replacement, code, 0644); err != nil {
+		return err
+	}
+
+	overlay, err := json.Marshal(map[string]any{
+		"Replace": map[string]string{filePath: replacement},
+	})
+
+	if err != nil {
+		return err
+	}
+
+	overlayPath := filepath.Join(tmpDir, "overlay.json")
+
+	if err := os.WriteFile(overlayPath, overlay, 0644); err != nil {
+		return err
+	}
+
+	cmd := exec.CommandContext(ctx, "go", "test", "-overlay", overlayPath, ".")
+	cmd.Dir = filepath.Dir(filePath)
+
+	out, err := cmd.CombinedOutput()
+
+	if err != nil {
+		lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+
+		if len(lines) > maxTestOutputLines {
+			lines = lines[len(lines)-maxTestOutputLines:]
+		}
+
+		return fmt.Errorf("tests failed: %w\n%s", err, strings.Join(lines, "\n"))
+	}
+
+	return nil
+}
@@ -0,0 +1,213 @@
+package jsonlang
+
+import (
+	"path"
+	"strings"
+
+	"github.com/greenboxal/agibootstrap/pkg/psi/langs/datalang"
+)
+
+// format is the datalang.Format of JSON. Strict formats reject comments and trailing commas, and strip
+// them from the values merged in.
+type format struct {
+	strict bool
+}
+
+// jsoncFiles are the names of the .json files known to accept comments.
+var jsoncFiles = []string{
+	"tsconfig.json",
+	"tsconfig.*.json",
+	"jsconfig.json",
+	"jsconfig.*.json",
+	".eslintrc.json",
+	"devcontainer.json",
+	".devcontainer.json",
+}
+
+// ForFile returns a strict format, unless name is a .jsonc file or a .json file known to accept
+// comments.
+func (format) ForFile(name string) datalang.Format {
+	if path.Ext(name) == ".jsonc" || isJSONCFile(name) {
+		return format{}
+	}
+
+	return format{strict: true}
+}
+
+func isJSONCFile(name string) bool {
+	name = path.Clean("/" + strings.ReplaceAll(name, "\\", "/"))
+
+	if path.Base(path.Dir(name)) == ".vscode" {
+		return true
+	}
+
+	for _, pattern := range jsoncFiles {
+		if ok, _ := path.Match(pattern, path.Base(name)); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (f format) Parse(text string) (*datalang.Document, error) {
+	return parse(text, f.strict)
+}
+
+func (f format) Merge(dst, src *datalang.Document, scope []string) (string, error) {
+	if f.strict {
+		stripped, err := parse(stripComments(src.Text), false)
+
+		if err != nil {
+			return "", err
+		}
+
+		src = stripped
+	}
+
+	m := &datalang.ObjectMerger{
+		Dst:    dst,
+		Src:    src,
+		Indent: indent,
+		Insert: insert,
+	}
+
+	return m.MergeDocument(scope)
+}
+
+func (format) Indent(doc *datalang.Document, offset int) string {
+	return indent(doc, offset)
+}
+
+func indent(doc *datalang.Document, offset int) string {
+	return doc.Indent(offset)
+}
+
+// insert inserts entries after the last member of dst, on lines of their own unless dst is written on
+// a single line.
+func insert(m *datalang.ObjectMerger, dst *datalang.Value, entries []*datalang.Entry) {
+	if len(dst.Entries) == 0 {
+		parent := indent(m.Dst, dst.Start)
+		member := parent + "  "
+		texts := make([]string, len(entries))
+
+		for i, e := range entries {
+			texts[i] = m.EntryText(e, member)
+		}
+
+		m.Edits.Replace(dst.Start+1, dst.End-1, "\n"+member+strings.Join(texts, ",\n"+member)+"\n"+parent)
+
+		return
+	}
+
+	last := dst.Entries[len(dst.Entries)-1]
+	singleLine := !strings.Contains(m.Dst.Text[dst.Start:dst.End], "\n")
+	member := indent(m.Dst, last.Start)
+
+	for _, e := range entries {
+		if singleLine {
+			m.Edits.Insert(last.End, ", "+m.Src.Text[e.Start:e.End])
+		} else {
+			m.Edits.Insert(last.End, ",\n"+member+m.EntryText(e, member))
+		}
+	}
+}
+
+// stripComments returns text without its comments and trailing commas. Lines left empty by a removed
+// comment are removed too.
+func stripComments(text string) string {
+	out := make([]byte, 0, len(text))
+	lineStart := 0
+	lastComma := -1
+	hadComment := false
+
+	// comment drops the blanks before the comment ending at end.
+	comment := func(end int) int {
+		out = []byte(strings.TrimRight(string(out), " \t"))
+		hadComment = true
+
+		return end
+	}
+
+	for i := 0; i < len(text); {
+		c := text[i]
+
+		switch {
+		case c == '"':
+			j := i + 1
+
+			for j < len(text) && text[j] != '"' {
+				if text[j] == '\\' && j+1 < len(text) {
+					j++
+				}
+
+				j++
+			}
+
+			if j < len(text) {
+				j++
+			}
+
+			out = append(out, text[i:j]...)
+			lastComma = -1
+			i = j
+
+		case strings.HasPrefix(text[i:], "//"):
+			end := len(text)
+
+			if j := strings.IndexByte(text[i:], '\n'); j >= 0 {
+				end = i + len(strings.TrimSuffix(text[i:i+j], "\r"))
+			}
+
+			i = comment(end)
+
+		case strings.HasPrefix(text[i:], "/*"):
+			end := len(text)
+
+			if j := strings.Index(text[i+2:], "*/"); j >= 0 {
+				end = i + j + 4
+			}
+
+			i = comment(end)
+
+		case c == '\n':
+			if hadComment && strings.TrimSpace(string(out[lineStart:])) == "" {
+				out = out[:lineStart]
+			} else {
+				out = append(out, c)
+			}
+
+			lineStart = len(out)
+			hadComment = false
+			i++
+
+		case c == ',':
+			lastComma = len(out)
+			out = append(out, c)
+			i++
+
+		case c == '}' || c == ']':
+			if lastComma >= 0 {
+				out = append(out[:lastComma], out[lastComma+1:]...)
+
+				if lastComma < lineStart {
+					lineStart--
+				}
+			}
+
+			lastComma = -1
+			out = append(out, c)
+			i++
+
+		default:
+			if c != ' ' && c != '\t' && c != '\r' {
+				lastComma = -1
+			}
+
+			out = append(out, c)
+			i++
+		}
+	}
+
+	return string(out)
+}
@@ -0,0 +1,36 @@
+package jsonlang
+
+import (
+	project2 "github.com/greenboxal/agibootstrap/pkg/platform/project"
+	"github.com/greenboxal/agibootstrap/pkg/psi"
+	"github.com/greenboxal/agibootstrap/pkg/psi/langs/datalang"
+)
+
+const LanguageID psi.LanguageID = "json"
+
+func init() {
+	project2.RegisterLanguage(LanguageID, NewLanguage)
+}
+
+// Language implements psi.Language for JSON. Comments and trailing commas are accepted in .jsonc files
+// and in the .json files known to be JSON with comments, like tsconfig.json. Other .json files are
+// strict, and comments are stripped from the values merged into them.
+type Language struct {
+	datalang.Language
+}
+
+func NewLanguage(p project2.Project) psi.Language {
+	l := &Language{}
+
+	l.Init(l, p, format{})
+
+	return l
+}
+
+func (l *Language) Name() psi.LanguageID {
+	return LanguageID
+}
+
+func (l *Language) Extensions() []string {
+	return []string{".json", ".jsonc"}
+}
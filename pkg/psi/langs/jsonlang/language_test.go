@@ -0,0 +1,291 @@
+package jsonlang
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/greenboxal/agibootstrap/pkg/build/codegen"
+	"github.com/greenboxal/agibootstrap/pkg/codex"
+	"github.com/greenboxal/agibootstrap/pkg/platform/mdutils"
+	"github.com/greenboxal/agibootstrap/pkg/psi"
+)
+
+const testManifest = `{
+  "kind": "Deployment",
+  // TODO: Scale up
+  "spec": {
+    "replicas": 1,
+    "containers": [
+      {"name": "app", "image": "app:1"}
+    ]
+  }
+}
+`
+
+func setupTestLanguage(t *testing.T) psi.Language {
+	p, err := codex.NewProject(context.Background(), t.TempDir())
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, p.Close()) })
+
+	return NewLanguage(p)
+}
+
+func TestParse(t *testing.T) {
+	testCases := []struct {
+		name string
+		code string
+		// path is resolved from the root of the file, and expected is the code of the node found.
+		path     string
+		expected string
+		err      bool
+	}{
+		{name: "key", code: testManifest, path: "#kind", expected: `"kind": "Deployment"`},
+		{name: "nested key", code: testManifest, path: "#spec/#replicas", expected: `"replicas": 1`},
+		{name: "item", code: testManifest, path: "#spec/#containers/@0/#image", expected: `"image": "app:1"`},
+		{name: "comments", code: testManifest, path: "#spec", expected: "// TODO: Scale up\n\"spec\": {\n  \"replicas\": 1,\n  \"containers\": [\n    {\"name\": \"app\", \"image\": \"app:1\"}\n  ]\n}"},
+		{name: "trailing comma", code: `{"a": [1, 2,],}`, path: "#a/@1", expected: "2"},
+		{name: "missing value", code: `{"a": }`, err: true},
+		{name: "unterminated object", code: `{"a": 1`, err: true},
+		{name: "invalid scalar", code: `{"a": yes}`, err: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			sf, err := setupTestLanguage(t).Parse("test.jsonc", tc.code)
+
+			if tc.err {
+				require.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+
+			n, err := psi.ResolvePath(sf.Root(), psi.MustParsePath(tc.path))
+			require.NoError(t, err)
+
+			code, err := sf.ToCode(n)
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, code.Code)
+		})
+	}
+}
+
+func TestParseStrict(t *testing.T) {
+	testCases := []struct {
+		name string
+		file string
+		code string
+		err  bool
+	}{
+		{name: "json", file: "test.json", code: `{"a": [1, 2]}`},
+		{name: "line comment", file: "test.json", code: "{\n  // A.\n  \"a\": 1\n}", err: true},
+		{name: "block comment", file: "test.json", code: `{"a": /* A. */ 1}`, err: true},
+		{name: "trailing comma", file: "test.json", code: `{"a": [1, 2,]}`, err: true},
+		{name: "trailing comma in object", file: "test.json", code: `{"a": 1,}`, err: true},
+		{name: "comment in string", file: "test.json", code: `{"url": "http://example.com/*"}`},
+		{name: "jsonc", file: "test.jsonc", code: testManifest},
+		{name: "tsconfig", file: "tsconfig.json", code: testManifest},
+		{name: "tsconfig variant", file: "web/tsconfig.build.json", code: testManifest},
+		{name: "vscode settings", file: ".vscode/settings.json", code: testManifest},
+		{name: "devcontainer", file: ".devcontainer/devcontainer.json", code: testManifest},
+		{name: "package", file: "package.json", code: testManifest, err: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := setupTestLanguage(t).Parse(tc.file, tc.code)
+
+			if tc.err {
+				require.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestTodos(t *testing.T) {
+	sf, err := setupTestLanguage(t).Parse("test.jsonc", testManifest)
+	require.NoError(t, err)
+
+	n, err := psi.ResolvePath(sf.Root(), psi.MustParsePath("#spec"))
+	require.NoError(t, err)
+	require.Equal(t, []string{"// TODO: Scale up"}, n.Comments())
+}
+
+func TestMerge(t *testing.T) {
+	testCases := []struct {
+		name      string
+		generated string
+		// scope is the path of the node the code was generated for, the root of the file if empty.
+		scope    string
+		expected string
+		err      bool
+	}{
+		{
+			name:      "nested",
+			generated: `{"spec": {"replicas": 3}}`,
+			expected: `{
+  "kind": "Deployment",
+  // TODO: Scale up
+  "spec": {
+    "replicas": 3,
+    "containers": [
+      {"name": "app", "image": "app:1"}
+    ]
+  }
+}
+`,
+		},
+		{
+			name:      "replace",
+			generated: "{\n  // Kind of the object.\n  \"kind\": \"StatefulSet\",\n  \"spec\": {\"containers\": []}\n}",
+			expected: `{
+  // Kind of the object.
+  "kind": "StatefulSet",
+  // TODO: Scale up
+  "spec": {
+    "replicas": 1,
+    "containers": []
+  }
+}
+`,
+		},
+		{
+			name:      "append",
+			generated: `{"apiVersion": "apps/v1", "spec": {"paused": false}}`,
+			expected: `{
+  "kind": "Deployment",
+  // TODO: Scale up
+  "spec": {
+    "replicas": 1,
+    "containers": [
+      {"name": "app", "image": "app:1"}
+    ],
+    "paused": false
+  },
+  "apiVersion": "apps/v1"
+}
+`,
+		},
+		{
+			name:      "scope",
+			generated: `{"replicas": 3}`,
+			scope:     "#spec/#replicas",
+			expected: `{
+  "kind": "Deployment",
+  // TODO: Scale up
+  "spec": {
+    "replicas": 3,
+    "containers": [
+      {"name": "app", "image": "app:1"}
+    ]
+  }
+}
+`,
+		},
+		{
+			name:      "conflicting changes",
+			generated: `{"spec": null, "replicas": 3}`,
+			scope:     "#spec",
+			err:       true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			lang := setupTestLanguage(t)
+
+			sf, err := lang.Parse("test.jsonc", testManifest)
+			require.NoError(t, err)
+
+			gen, err := lang.Parse("gen.jsonc", tc.generated)
+			require.NoError(t, err)
+
+			scope := &codegen.NodeScope{Node: sf.Root()}
+
+			if tc.scope != "" {
+				scope.Node, err = psi.ResolvePath(sf.Root(), psi.MustParsePath(tc.scope))
+				require.NoError(t, err)
+			}
+
+			err = sf.MergeCompletionResults(context.Background(), scope, psi.NewCursor(), gen, gen.Root())
+
+			code, codeErr := sf.ToCode(sf.Root())
+			require.NoError(t, codeErr)
+
+			if tc.err {
+				// Rejected merges leave the file as it was.
+				require.Error(t, err)
+				require.Equal(t, testManifest, code.Code)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, code.Code)
+		})
+	}
+}
+
+func TestMergeStrict(t *testing.T) {
+	const manifest = `{
+  "kind": "Deployment",
+  "spec": {
+    "replicas": 1
+  }
+}
+`
+
+	testCases := []struct {
+		name      string
+		generated string
+		expected  string
+	}{
+		{
+			name:      "comments",
+			generated: "{\n  // Kind of the object.\n  \"kind\": \"StatefulSet\", // Replaces Deployment.\n  /* Scaled. */\n  \"spec\": {\"replicas\": 3}\n}",
+			expected: `{
+  "kind": "StatefulSet",
+  "spec": {
+    "replicas": 3
+  }
+}
+`,
+		},
+		{
+			name:      "trailing commas",
+			generated: "{\n  \"spec\": {\n    \"replicas\": 3,\n    \"args\": [\"a\", \"b\",],\n  },\n}",
+			expected: `{
+  "kind": "Deployment",
+  "spec": {
+    "replicas": 3,
+    "args": ["a", "b"]
+  }
+}
+`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			lang := setupTestLanguage(t)
+
+			sf, err := lang.Parse("test.json", manifest)
+			require.NoError(t, err)
+
+			// Generated code blocks accept comments, whatever file they are merged into.
+			gen, err := lang.(*Language).ParseCodeBlock("gen.json", mdutils.CodeBlock{Language: "json", Code: tc.generated})
+			require.NoError(t, err)
+
+			err = sf.MergeCompletionResults(context.Background(), &codegen.NodeScope{Node: sf.Root()}, psi.NewCursor(), gen, gen.Root())
+			require.NoError(t, err)
+
+			code, err := sf.ToCode(sf.Root())
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, code.Code)
+		})
+	}
+}
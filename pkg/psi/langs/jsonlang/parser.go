@@ -0,0 +1,298 @@
+package jsonlang
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/greenboxal/agibootstrap/pkg/psi/langs/datalang"
+)
+
+// parser parses JSON into values located by offsets, which encoding/json does not report. Comments
+// and trailing commas are accepted unless strict is set.
+type parser struct {
+	text   string
+	pos    int
+	strict bool
+
+	// err is the first comment found in strict mode, returned once the text is parsed.
+	err error
+}
+
+// comment is the range of a comment skipped by the parser.
+type comment struct {
+	start, end int
+}
+
+func parse(text string, strict bool) (*datalang.Document, error) {
+	p := &parser{text: text, strict: strict}
+	doc := &datalang.Document{Text: text}
+
+	if p.skip(); p.pos >= len(text) {
+		return doc, p.err
+	}
+
+	v, err := p.value()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if p.skip(); p.pos < len(text) {
+		return nil, p.errorf("unexpected %q after the value", text[p.pos])
+	}
+
+	if p.err != nil {
+		return nil, p.err
+	}
+
+	doc.Values = append(doc.Values, v)
+
+	return doc, nil
+}
+
+func (p *parser) value() (*datalang.Value, error) {
+	if p.pos >= len(p.text) {
+		return nil, p.errorf("unexpected end of input")
+	}
+
+	switch c := p.text[p.pos]; {
+	case c == '{' || c == '[':
+		return p.collection()
+
+	case c == '"':
+		start := p.pos
+
+		if _, err := p.string(); err != nil {
+			return nil, err
+		}
+
+		return &datalang.Value{Kind: datalang.KindScalar, Start: start, End: p.pos}, nil
+
+	default:
+		start := p.pos
+
+		for p.pos < len(p.text) && !strings.ContainsRune(" \t\r\n,:]}/", rune(p.text[p.pos])) {
+			p.pos++
+		}
+
+		if raw := p.text[start:p.pos]; !json.Valid([]byte(raw)) {
+			p.pos = start
+
+			return nil, p.errorf("invalid value %q", raw)
+		}
+
+		return &datalang.Value{Kind: datalang.KindScalar, Start: start, End: p.pos}, nil
+	}
+}
+
+// collection parses an object or an array. Members are located with the comments on the lines above
+// them.
+func (p *parser) collection() (*datalang.Value, error) {
+	v := &datalang.Value{Kind: datalang.KindObject, Start: p.pos}
+	closing := byte('}')
+
+	if p.text[p.pos] == '[' {
+		v.Kind = datalang.KindArray
+		closing = ']'
+	}
+
+	p.pos++
+
+	for comma := false; ; {
+		prev := p.pos
+		comments := p.skip()
+
+		if p.pos >= len(p.text) {
+			return nil, p.errorf("unexpected end of input, expected %q", closing)
+		}
+
+		if p.text[p.pos] == closing {
+			if p.strict && comma {
+				return nil, p.errorf("trailing comma before %q", closing)
+			}
+
+			p.pos++
+			v.End = p.pos
+
+			return v, nil
+		}
+
+		e := &datalang.Entry{Start: p.pos}
+
+		// Comments on the line of the previous member are its own.
+		nl := strings.IndexByte(p.text[prev:p.pos], '\n')
+
+		for _, c := range comments {
+			switch {
+			case nl == -1 || c.start < prev+nl:
+				if len(v.Entries) > 0 {
+					p.addComment(v.Entries[len(v.Entries)-1], c)
+				}
+
+			default:
+				if len(e.Comments) == 0 {
+					e.LeadStart = c.start
+				}
+
+				p.addComment(e, c)
+			}
+		}
+
+		if len(e.Comments) == 0 {
+			e.LeadStart = e.Start
+		}
+
+		if v.Kind == datalang.KindObject {
+			if p.text[p.pos] != '"' {
+				return nil, p.errorf("expected a key")
+			}
+
+			key, err := p.string()
+
+			if err != nil {
+				return nil, err
+			}
+
+			e.Key = key
+
+			if p.skip(); p.pos >= len(p.text) || p.text[p.pos] != ':' {
+				return nil, p.errorf("expected ':' after key %q", key)
+			}
+
+			p.pos++
+			p.skip()
+		}
+
+		value, err := p.value()
+
+		if err != nil {
+			return nil, err
+		}
+
+		e.Value = value
+		e.End = value.End
+		v.Entries = append(v.Entries, e)
+
+		for _, c := range p.skip() {
+			p.addComment(e, c)
+		}
+
+		if comma = p.pos < len(p.text) && p.text[p.pos] == ','; comma {
+			p.pos++
+		} else if p.pos >= len(p.text) || p.text[p.pos] != closing {
+			return nil, p.errorf("expected ',' or %q", closing)
+		}
+	}
+}
+
+// string parses a string and returns its value.
+func (p *parser) string() (string, error) {
+	start := p.pos
+
+	for p.pos++; p.pos < len(p.text); p.pos++ {
+		switch p.text[p.pos] {
+		case '\\':
+			p.pos++
+
+		case '\n':
+			p.pos = start
+
+			return "", p.errorf("unterminated string")
+
+		case '"':
+			p.pos++
+
+			var s string
+
+			if err := json.Unmarshal([]byte(p.text[start:p.pos]), &s); err != nil {
+				p.pos = start
+
+				return "", p.errorf("invalid string: %s", err)
+			}
+
+			return s, nil
+		}
+	}
+
+	p.pos = start
+
+	return "", p.errorf("unterminated string")
+}
+
+// skip skips whitespace and comments, and returns the comments.
+func (p *parser) skip() (comments []comment) {
+	for p.pos < len(p.text) {
+		switch {
+		case strings.ContainsRune(" \t\r\n", rune(p.text[p.pos])):
+			p.pos++
+
+		// Byte order marks.
+		case strings.HasPrefix(p.text[p.pos:], "\ufeff"):
+			p.pos += len("\ufeff")
+
+		case strings.HasPrefix(p.text[p.pos:], "//"):
+			c := comment{start: p.pos}
+
+			if end := strings.IndexByte(p.text[p.pos:], '\n'); end != -1 {
+				p.pos += end
+			} else {
+				p.pos = len(p.text)
+			}
+
+			c.end = len(strings.TrimRight(p.text[:p.pos], "\r"))
+			comments = append(comments, c)
+			p.disallowComment(c)
+
+		case strings.HasPrefix(p.text[p.pos:], "/*"):
+			c := comment{start: p.pos}
+
+			if end := strings.Index(p.text[p.pos+2:], "*/"); end != -1 {
+				p.pos += 2 + end + 2
+			} else {
+				p.pos = len(p.text)
+			}
+
+			c.end = p.pos
+			comments = append(comments, c)
+			p.disallowComment(c)
+
+		default:
+			return comments
+		}
+	}
+
+	return comments
+}
+
+// disallowComment records c as the error of the parse in strict mode, unless an earlier comment was.
+func (p *parser) disallowComment(c comment) {
+	if p.strict && p.err == nil {
+		p.err = &datalang.SyntaxError{Offset: c.start, Msg: "comments are not allowed in JSON files"}
+	}
+}
+
+func (p *parser) addComment(e *datalang.Entry, c comment) {
+	e.Comments = append(e.Comments, commentText(p.text[c.start:c.end]))
+}
+
+func (p *parser) errorf(format string, args ...any) error {
+	return &datalang.SyntaxError{Offset: p.pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+// commentText returns line comments as they are. Block comments starting with TODO are returned as
+// "// TODO: ...", and other block comments as they are.
+func commentText(text string) string {
+	if strings.HasPrefix(text, "//") {
+		return datalang.Comment("//", text)
+	}
+
+	body := strings.TrimSuffix(strings.TrimPrefix(text, "/*"), "*/")
+	body = strings.TrimSpace(strings.TrimLeft(body, "*"))
+
+	if strings.HasPrefix(body, "TODO") {
+		return "// " + strings.Join(strings.Fields(body), " ")
+	}
+
+	return text
+}
@@ -3,6 +3,8 @@ package mdlang
 import (
 	"context"
 	"fmt"
+	"reflect"
+	"sync"
 
 	"github.com/gomarkdown/markdown/ast"
 	"github.com/samber/lo"
@@ -34,6 +36,15 @@ func (nb *NodeBase[T]) String() string {
 	return fmt.Sprintf("%T(%d, %s)", nb.node, nb.ID(), nb.UUID())
 }
 
+// PsiNodeType returns the node type of the wrapped AST node, named after its Go type.
+func (nb *NodeBase[T]) PsiNodeType() psi.NodeType {
+	if any(nb.node) == nil {
+		return nb.NodeBase.PsiNodeType()
+	}
+
+	return nodeTypeFor(nb.node)
+}
+
 func (nb *NodeBase[T]) Initialize(self Node) {
 	nb.NodeBase.Init(self, "")
 }
@@ -93,3 +104,30 @@ func AstToPsi(node ast.Node) (result psi.Node) {
 
 	return
 }
+
+var nodeTypesMu sync.Mutex
+var nodeTypes = map[reflect.Type]psi.NodeType{}
+
+// nodeTypeFor returns the node type shared by the Markdown AST nodes of the same Go type as node,
+// registering it on first use, e.g. "markdown.Heading".
+func nodeTypeFor(node ast.Node) psi.NodeType {
+	t := reflect.TypeOf(node)
+
+	nodeTypesMu.Lock()
+	defer nodeTypesMu.Unlock()
+
+	if nt, ok := nodeTypes[t]; ok {
+		return nt
+	}
+
+	name := t.Name()
+
+	if t.Kind() == reflect.Pointer {
+		name = t.Elem().Name()
+	}
+
+	nt := psi.RegisterNodeType[Node]("markdown."+name, psi.WithNodeClass(psi.NodeClassDocument))
+	nodeTypes[t] = nt
+
+	return nt
+}
@@ -8,6 +8,8 @@ import (
 	"github.com/antlr4-go/antlr/v4"
 
 	"github.com/greenboxal/agibootstrap/pkg/psi"
+	"github.com/greenboxal/agibootstrap/pkg/psi/langs/antlrbridge"
+	"github.com/greenboxal/agibootstrap/pkg/psi/langs/pylang/pyparser"
 )
 
 type Node interface {
@@ -69,7 +71,7 @@ func (nb *NodeBase[T]) Update(context.Context) error {
 	nb.NodeBase.Update(nil)
 
 	if nb.IsContainer() {
-		for i := 0; i < nb.node.GetChildCount(); i++ {
+		for nb.node.GetChildCount() > 0 {
 			nb.Ast().RemoveLastChild()
 		}
 
@@ -98,6 +100,8 @@ type astConversionContext struct {
 	parentStack []Node
 	result      Node
 	sf          *SourceFile
+	// claimed are the indexes of the tokens whose comments were attached to a node.
+	claimed map[int]bool
 }
 
 func (a *astConversionContext) VisitTerminal(node antlr.TerminalNode) {
@@ -114,27 +118,24 @@ func (a *astConversionContext) EnterEveryRule(ctx antlr.ParserRuleContext) {
 	n := NewNodeFor(a.sf, ctx)
 	n.isTerminal = false
 
-	if a.sf != nil {
-		hidden := a.sf.tokens.GetHiddenTokensToLeft(ctx.GetStart().GetTokenIndex(), 1)
+	// Comments belong to the outermost rule starting after them. The file itself starts with the
+	// first statement, which gets the comments before it.
+	if _, isFile := ctx.(*pyparser.File_inputContext); !isFile && a.sf != nil && antlrbridge.OwnsToken(a.sf.tokens, ctx.GetStart()) {
+		start := a.sf.nextCodeToken(ctx.GetStart().GetTokenIndex())
 
-		for _, tk := range hidden {
-			if tk.GetChannel() == 1 {
-				txt := tk.GetText()
-				txt = strings.TrimSpace(txt)
+		if start >= 0 && !a.claimed[start] {
+			a.claimed[start] = true
 
-				if txt != "" {
-					if strings.HasPrefix(txt, "# TODO:") {
-						txt = strings.Replace(txt, "# TODO:", "// TODO:", 1)
-					}
-
-					n.comments = append(n.comments, txt)
+			for _, txt := range a.sf.leadingComments(start) {
+				if strings.HasPrefix(txt, "# TODO:") {
+					txt = strings.Replace(txt, "# TODO:", "// TODO:", 1)
 				}
+
+				n.comments = append(n.comments, txt)
 			}
 		}
 	}
 
-	a.addToParent(n)
-
 	a.parentStack = append(a.parentStack, n)
 }
 
@@ -145,13 +146,17 @@ func (a *astConversionContext) addToParent(n psi.Node) {
 	}
 }
 
+// ExitEveryRule adds rules to their parent once their children are converted, since adding a node
+// updates its parse tree from its children.
 func (a *astConversionContext) ExitEveryRule(ctx antlr.ParserRuleContext) {
 	a.result = a.parentStack[len(a.parentStack)-1]
 	a.parentStack = a.parentStack[:len(a.parentStack)-1]
+
+	a.addToParent(a.result)
 }
 
 func AstToPsi(sf *SourceFile, parsed antlr.ParserRuleContext) psi.Node {
-	ctx := &astConversionContext{sf: sf}
+	ctx := &astConversionContext{sf: sf, claimed: map[int]bool{}}
 
 	walker := antlr.NewParseTreeWalker()
 	walker.Walk(ctx, parsed)
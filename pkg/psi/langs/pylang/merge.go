@@ -0,0 +1,240 @@
+package pylang
+
+import (
+	"context"
+	"strings"
+
+	"github.com/antlr4-go/antlr/v4"
+	"github.com/pkg/errors"
+
+	"github.com/greenboxal/agibootstrap/pkg/psi"
+	"github.com/greenboxal/agibootstrap/pkg/psi/langs/antlrbridge"
+	"github.com/greenboxal/agibootstrap/pkg/psi/langs/pylang/pyparser"
+)
+
+// Kinds of the declarations of Python files. Methods are functions of the members of their class.
+// Top-level import statements are antlrbridge.DeclarationKindImport declarations named after their
+// normalized text.
+const (
+	DeclarationKindClass    = "class"
+	DeclarationKindFunction = "function"
+)
+
+// declarationIndex is the antlrbridge.DeclarationFile of a Python file.
+type declarationIndex struct {
+	*antlrbridge.DeclarationFile
+
+	// firstParameters maps the functions of the file to the name of their first parameter.
+	firstParameters map[*antlrbridge.Declaration]string
+}
+
+// MergeCompletionResults merges the declarations of newAst into the file by qualified name, see
+// antlrbridge.Edits.Merge:
+//
+//   - functions, and methods of classes declared in both, replace the declarations with the same name,
+//   - classes declared in both are merged member by member, keeping the other statements of their body,
+//   - new declarations are appended to the file, or to the class body they belong to,
+//   - missing imports are added after the existing ones.
+//
+// Top-level functions are first looked up in the class enclosing the scope, so a method generated on
+// its own replaces the method being processed. New functions taking self or cls are added to that class.
+//
+// Declarations keep the comments before them and are reindented to their new place. Other statements of
+// newAst are ignored. The changes are recorded in the token stream rewriter of the file, and rendered by
+// ToCode, until the file is loaded again.
+func (sf *SourceFile) MergeCompletionResults(ctx context.Context, scope psi.Scope, cursor psi.Cursor, newSource psi.SourceFile, newAst psi.Node) error {
+	newSf, ok := newSource.(*SourceFile)
+
+	if !ok || newSf.parsed == nil || sf.parsed == nil {
+		return errors.Errorf("cannot merge %s: not a parsed Python source file", newSource.Name())
+	}
+
+	var class *antlrbridge.Declaration
+
+	if scope != nil && scope.Root() != nil {
+		class = sf.enclosingClass(scope.Root())
+	}
+
+	dst, src := sf.declarations(), newSf.declarations()
+
+	for _, nd := range src.Declarations {
+		if nd.Kind == antlrbridge.DeclarationKindImport {
+			sf.edits.MergeImport(dst.DeclarationFile, src.DeclarationFile, nd)
+			continue
+		}
+
+		var into *antlrbridge.Declaration
+
+		if class != nil {
+			inClass := dst.Lookup(class.Key()+"/"+nd.Kind+" "+nd.Name) != nil
+			topLevel := dst.Lookup("/"+nd.Kind+" "+nd.Name) != nil
+			first := src.firstParameters[nd]
+
+			if inClass || (!topLevel && nd.Kind == DeclarationKindFunction && (first == "self" || first == "cls")) {
+				into = class
+			}
+		}
+
+		sf.edits.MergeDeclaration(dst.DeclarationFile, src.DeclarationFile, nd, into)
+	}
+
+	sf.rewriter = sf.edits.Apply(dst.DeclarationFile)
+
+	return nil
+}
+
+// enclosingClass returns the innermost class declaration containing n.
+func (sf *SourceFile) enclosingClass(n psi.Node) (class *antlrbridge.Declaration) {
+	pn, ok := n.(Node)
+
+	if !ok || pn.Tree() == nil {
+		return nil
+	}
+
+	interval := pn.Tree().GetSourceInterval()
+
+	for decls := sf.declarations().Declarations; ; {
+		var next *antlrbridge.Declaration
+
+		for _, d := range decls {
+			if d.Start <= interval.Start && interval.Stop <= d.Stop {
+				next = d
+				break
+			}
+		}
+
+		if next == nil {
+			return class
+		}
+
+		if next.Kind == DeclarationKindClass {
+			class = next
+		}
+
+		decls = next.Members
+	}
+}
+
+// declarations returns the index of the declarations of the file, as parsed by the last Load.
+func (sf *SourceFile) declarations() *declarationIndex {
+	if sf.decls == nil {
+		sf.decls = &declarationIndex{
+			DeclarationFile: &antlrbridge.DeclarationFile{
+				Tokens:   sf.tokens,
+				End:      sf.tokens.Size() - 1,
+				IsTrivia: func(tk antlr.Token) bool { return !isCode(tk) },
+			},
+			firstParameters: map[*antlrbridge.Declaration]string{},
+		}
+
+		if file, ok := sf.parsed.(*pyparser.File_inputContext); ok {
+			sf.decls.Declarations = sf.indexStatements(sf.decls, file.AllStmt(), true)
+		}
+
+		// Keys are assigned by the first lookup.
+		sf.decls.Lookup("")
+	}
+
+	return sf.decls
+}
+
+func (sf *SourceFile) indexStatements(idx *declarationIndex, stmts []pyparser.IStmtContext, topLevel bool) (result []*antlrbridge.Declaration) {
+	for _, stmt := range stmts {
+		d := &antlrbridge.Declaration{
+			Start: stmt.GetStart().GetTokenIndex(),
+			Stop:  sf.lastCodeToken(stmt.GetStop().GetTokenIndex()),
+		}
+
+		if def, ok := stmt.Compound_stmt().(*pyparser.Class_or_func_def_stmtContext); ok {
+			if fn := def.Funcdef(); fn != nil {
+				d.Kind = DeclarationKindFunction
+				d.Name = fn.Name().GetText()
+
+				if args := fn.Typedargslist(); args != nil {
+					first := strings.Split(args.GetText(), ",")[0]
+					idx.firstParameters[d] = strings.TrimSpace(strings.FieldsFunc(first, func(r rune) bool { return r == ':' || r == '=' })[0])
+				}
+			} else if cd := def.Classdef(); cd != nil {
+				body := cd.Suite().AllStmt()
+
+				d.Kind = DeclarationKindClass
+				d.Name = cd.Name().GetText()
+				d.Members = sf.indexStatements(idx, body, false)
+				d.BodyStop = sf.nextLine(d.Stop)
+				d.BodyIndent = idx.Indent(d.Start) + "    "
+
+				if len(body) > 0 {
+					d.BodyIndent = idx.Indent(body[0].GetStart().GetTokenIndex())
+				}
+			} else {
+				continue
+			}
+		} else if simple := stmt.Simple_stmt(); simple != nil && topLevel && isImport(simple) {
+			d.Kind = antlrbridge.DeclarationKindImport
+			d.Name = strings.Join(strings.Fields(idx.Text(d.Start, d.Stop)), " ")
+		} else {
+			continue
+		}
+
+		result = append(result, d)
+	}
+
+	return
+}
+
+func isImport(stmt pyparser.ISimple_stmtContext) bool {
+	for _, small := range stmt.AllSmall_stmt() {
+		switch small.(type) {
+		case *pyparser.Import_stmtContext, *pyparser.From_stmtContext:
+			return true
+		}
+	}
+
+	return false
+}
+
+// isCode returns true if tk is part of the code, and not a hidden, synthetic or EOF token.
+func isCode(tk antlr.Token) bool {
+	return tk.GetChannel() == antlr.TokenDefaultChannel && tk.GetTokenType() != antlr.TokenEOF && tk.GetText() != ""
+}
+
+// lastCodeToken returns the index of the last code token at or before index, or -1.
+func (sf *SourceFile) lastCodeToken(index int) int {
+	for ; index >= 0 && !isCode(sf.tokens.Get(index)); index-- {
+	}
+
+	return index
+}
+
+// nextCodeToken returns the index of the first code token at or after index, or -1.
+func (sf *SourceFile) nextCodeToken(index int) int {
+	for ; index < sf.tokens.Size(); index++ {
+		if isCode(sf.tokens.Get(index)) {
+			return index
+		}
+	}
+
+	return -1
+}
+
+// nextLine returns the index of the first token of the line after the token at index.
+func (sf *SourceFile) nextLine(index int) int {
+	for index++; index < sf.tokens.Size()-1; index++ {
+		if sf.tokens.Get(index).GetTokenType() == pyparser.Python3LexerNEWLINE {
+			return index + 1
+		}
+	}
+
+	return sf.tokens.Size() - 1
+}
+
+// leadingComments returns the comments before the token at start.
+func (sf *SourceFile) leadingComments(start int) (comments []string) {
+	for i := sf.declarations().LeadStart(start); i < start; i++ {
+		if tk := sf.tokens.Get(i); tk.GetTokenType() == pyparser.Python3LexerCOMMENT {
+			comments = append(comments, tk.GetText())
+		}
+	}
+
+	return
+}
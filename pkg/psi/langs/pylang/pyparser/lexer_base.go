@@ -1,6 +1,8 @@
 package pyparser
 
 import (
+	"unicode/utf8"
+
 	"github.com/antlr4-go/antlr/v4"
 )
 
@@ -27,7 +29,7 @@ func (l *Python3LexerBase) NextToken() antlr.Token {
 
 	// Check if the end-of-file is ahead and there are still some DEDENTS expected.
 	if input.LA(1) == -1 && len(l.indents) > 0 {
-		if len(l.buffer) == 0 || l.buffer[len(l.buffer)].GetTokenType() != Python3LexerLINE_BREAK {
+		if l.lastToken == nil || l.lastToken.GetTokenType() != Python3LexerLINE_BREAK {
 			// First emit an extra line break that serves as the end of the statement.
 			l.emit(Python3LexerLINE_BREAK, antlr.TokenDefaultChannel, "")
 		}
@@ -40,7 +42,15 @@ func (l *Python3LexerBase) NextToken() antlr.Token {
 	}
 
 	if len(l.buffer) == 0 {
-		return l.BaseLexer.NextToken()
+		token := l.BaseLexer.NextToken()
+
+		// Tokens emitted by actions are buffered, and the base lexer returns the last of them
+		// instead of emitting a token for the rule.
+		if len(l.buffer) == 0 {
+			l.lastToken = token
+
+			return token
+		}
 	}
 
 	result := l.buffer[0]
@@ -102,14 +112,33 @@ func (l *Python3LexerBase) HandleSpaces() {
 	l.emit(Python3LexerWS, antlr.TokenHiddenChannel, l.GetText())
 }
 
+// emit emits a token with the given text. Tokens with text are the text of the current rule, and
+// empty tokens are positioned at the current character.
 func (l *Python3LexerBase) emit(tokenType, channel int, text string) {
-	charIndex := l.GetCharIndex()
-	token := antlr.NewCommonToken(l.GetTokenSourceCharStreamPair(), tokenType, channel, charIndex-len(text), charIndex-1)
-	token.SetText(text)
+	start, line, column := l.GetCharIndex(), l.GetLine(), l.GetCharPositionInLine()
+
+	if text != "" {
+		start, line, column = l.TokenStartCharIndex, l.TokenStartLine, l.TokenStartColumn
+	}
+
+	stop := start + utf8.RuneCountInString(text) - 1
+	token := antlr.CommonTokenFactoryDEFAULT.Create(l.GetTokenSourceCharStreamPair(), tokenType, text, channel, start, stop, line, column)
+
+	if text == "" {
+		token = emptyToken{token.(*antlr.CommonToken)}
+	}
 
 	l.EmitToken(token)
 }
 
+// emptyToken is a token without text. Common tokens without text read it from the input, which
+// renders "<EOF>" at the end of the input.
+type emptyToken struct {
+	*antlr.CommonToken
+}
+
+func (emptyToken) GetText() string { return "" }
+
 func (l *Python3LexerBase) IsNotNewLineOrComment(next int) bool {
 	return l.opened == 0 && next != '\r' && next != '\n' && next != '\f' && next != '#'
 }
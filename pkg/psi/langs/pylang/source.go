@@ -2,21 +2,20 @@ package pylang
 
 import (
 	"bytes"
-	"context"
 	"fmt"
 	"go/token"
 	"io"
+	"strings"
 
 	"github.com/antlr4-go/antlr/v4"
-	"github.com/greenboxal/aip/aip-langchain/pkg/tokenizers"
 	"github.com/pkg/errors"
 
+	"github.com/greenboxal/agibootstrap/pkg/psi/langs/antlrbridge"
 	"github.com/greenboxal/agibootstrap/pkg/psi/langs/pylang/pyparser"
 
 	"github.com/greenboxal/agibootstrap/pkg/platform/mdutils"
 	"github.com/greenboxal/agibootstrap/pkg/platform/vfs/repofs"
 	"github.com/greenboxal/agibootstrap/pkg/psi"
-	"github.com/greenboxal/agibootstrap/pkg/psi/rendering"
 )
 
 type SourceFile struct {
@@ -35,6 +34,9 @@ type SourceFile struct {
 	file     *token.File
 	tokens   *antlr.CommonTokenStream
 	rewriter *antlr.TokenStreamRewriter
+
+	decls *declarationIndex
+	edits antlrbridge.Edits
 }
 
 func NewSourceFile(l *Language, name string, handle repofs.FileHandle) *SourceFile {
@@ -101,6 +103,8 @@ func (sf *SourceFile) SetRoot(node antlr.ParserRuleContext, original string, tok
 	sf.parsed = node
 	sf.tokens = tokens
 	sf.rewriter = antlr.NewTokenStreamRewriter(tokens)
+	sf.decls = nil
+	sf.edits = antlrbridge.Edits{}
 
 	sf.file = sf.l.project.FileSet().AddFile(sf.name, -1, len(sf.original))
 	sf.file.SetLinesForContent([]byte(original))
@@ -124,129 +128,104 @@ func (sf *SourceFile) Parse(filename string, sourceCode string) (result psi.Node
 		}
 	}()
 
+	// Only the parse of the file itself publishes diagnostics, not the parse of snippets.
+	isRoot := sf.root == nil
+	listener := antlrbridge.NewSyntaxErrorListener(filename, sourceCode)
+
 	stream := antlr.NewInputStream(sourceCode)
 	lexer := pyparser.NewPython3Lexer(stream)
+	lexer.AddErrorListener(listener)
 	tokens := antlr.NewCommonTokenStream(lexer, 0)
 	parser := pyparser.NewPython3Parser(tokens)
+	parser.AddErrorListener(listener)
 	parsed := parser.File_input()
 
 	if parser.HasError() {
 		sf.err = fmt.Errorf("%s", parser.GetError())
 
+		if isRoot {
+			listener.Publish(sf, sf.err)
+		}
+
 		return nil, sf.err
 	}
 
-	if sf.root == nil {
+	if isRoot {
 		if err := sf.SetRoot(parsed, sourceCode, tokens); err != nil {
 			return nil, err
 		}
 
+		listener.Publish(sf, nil)
+
 		return sf.root, nil
 	}
 
 	return AstToPsi(sf, parsed), nil
 }
 
-type CharTokenizer struct {
-}
-
-func (c CharTokenizer) GetTokens(bytes []byte) (tokenizers.Tokens, error) {
-	//TODO implement me
-	panic("implement me")
-}
-
-func (c CharTokenizer) Count(text string) (int, error) {
-	return len(text), nil
-}
-
+// ToCode renders node with the changes merged into the file. The file and its root are rendered in
+// full. Other nodes are rendered with the comments before them, and unindented.
 func (sf *SourceFile) ToCode(node psi.Node) (mdutils.CodeBlock, error) {
-	var renderer *rendering.PruningRenderer
-	n := node.(Node)
-
-	renderer = &rendering.PruningRenderer{
-		Tokenizer: &CharTokenizer{},
-		Weight: func(state *rendering.NodeState, node psi.Node) float32 {
-			return 1
-		},
-		Write: func(w *rendering.TokenBuffer, node psi.Node) (total int, err error) {
-			n := node.(Node)
-
-			hidden := sf.tokens.GetHiddenTokensToLeft(n.Tree().GetSourceInterval().Start, 1)
-
-			for _, tk := range hidden {
-				n, err := w.Write([]byte(tk.GetText()))
-
-				if err != nil {
-					return total, err
-				}
-
-				total += n
-			}
-
-			if n.IsContainer() {
-				for _, child := range n.Children() {
-					num, err := w.WriteNode(renderer, child)
+	if sf.rewriter == nil {
+		return mdutils.CodeBlock{}, errors.Errorf("cannot render %s: the file was not parsed", sf.name)
+	}
 
-					if err != nil {
-						return total, err
-					}
+	var code string
 
-					total += num
-				}
-			} else {
-				t := n.Token()
-				str := t.GetText()
+	if node == sf || node == sf.root {
+		code = sf.rewriter.GetTextDefault()
+	} else {
+		n, ok := node.(Node)
 
-				return w.Write([]byte(str))
-			}
+		if !ok || n.Tree() == nil {
+			return mdutils.CodeBlock{}, errors.Errorf("cannot render %T: not a Python node", node)
+		}
 
-			return
-		},
-	}
+		interval := n.Tree().GetSourceInterval()
+		start := sf.nextCodeToken(interval.Start)
+		stop := sf.lastCodeToken(interval.Stop)
 
-	buf := bytes.NewBuffer(nil)
-	_, err := renderer.Render(n, buf)
+		if start >= 0 && start <= stop {
+			decls := sf.declarations()
 
-	if err != nil {
-		panic(err)
+			code = sf.rewriter.GetText(antlr.DefaultProgramName, antlr.NewInterval(decls.LeadStart(start), stop))
+			code = antlrbridge.Reindent(trimBlankLines(code), decls.Indent(start), "")
+		}
 	}
 
-	txt := buf.String()
-	txt = n.Tree().GetText()
-
 	return mdutils.CodeBlock{
 		Language: string(LanguageID),
-		Code:     txt,
+		Code:     code,
 		Filename: sf.Name(),
 	}, nil
 }
 
-func (sf *SourceFile) GetAdjustedTokenInterval(n Node) antlr.Interval {
-	interval := n.Tree().GetSourceInterval()
-	hiddenTokens := sf.tokens.GetHiddenTokensToLeft(interval.Start, 1)
+// trimBlankLines removes the blank lines at the start of text.
+func trimBlankLines(text string) string {
+	for {
+		line, rest, ok := strings.Cut(text, "\n")
 
-	if len(hiddenTokens) > 0 {
-		interval.Start = hiddenTokens[0].GetStart()
-	}
+		if !ok || strings.TrimSpace(line) != "" {
+			return text
+		}
 
-	return interval
+		text = rest
+	}
 }
 
-func (sf *SourceFile) MergeCompletionResults(ctx context.Context, scope psi.Scope, cursor psi.Cursor, newSource psi.SourceFile, newAst psi.Node) error {
+// NodeSourceRange implements psi.SourceRangeProvider from the tokens the node was parsed from.
+func (sf *SourceFile) NodeSourceRange(n psi.Node) (psi.SourceRange, bool) {
+	pn, ok := n.(Node)
 
-	return nil
-}
+	if !ok {
+		return psi.SourceRange{}, false
+	}
 
-func (sf *SourceFile) getRange(txt string, start antlr.Token, end antlr.Token) string {
-	startLinePos := sf.file.LineStart(start.GetLine())
-	startLineOffset := sf.file.Offset(startLinePos) + start.GetColumn()
+	start, stop := pn.Token(), pn.Token()
 
-	if end == nil {
-		return sf.original[startLineOffset:]
+	if ast := pn.Ast(); ast != nil {
+		start, stop = ast.GetStart(), ast.GetStop()
 	}
 
-	endLinePos := sf.file.LineStart(end.GetLine())
-	endLineOffset := sf.file.Offset(endLinePos) + end.GetColumn()
-
-	return txt[startLineOffset:endLineOffset]
+	return antlrbridge.TokenRange(sf.file, sf.original, sf.tokens, start, stop)
 }
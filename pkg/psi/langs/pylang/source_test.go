@@ -11,6 +11,7 @@ import (
 	"github.com/greenboxal/agibootstrap/pkg/platform/project"
 	"github.com/greenboxal/agibootstrap/pkg/platform/vfs/repofs"
 	"github.com/greenboxal/agibootstrap/pkg/psi"
+	"github.com/greenboxal/agibootstrap/pkg/psi/langs/pylang/pyparser"
 )
 
 type testEnv struct {
@@ -54,8 +55,10 @@ def newHelloFn(self):
 `
 
 func setupTestProject(t *testing.T) testEnv {
-	p, err := codex.NewProject(context.Background(), ".")
+	p, err := codex.NewProject(context.Background(), t.TempDir())
 	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, p.Close()) })
+
 	lang := NewLanguage(p)
 
 	return testEnv{
@@ -122,3 +125,215 @@ func TestSourceMerge(t *testing.T) {
 	require.Equal(t, "test.py", code.Filename)
 	require.Equal(t, testCodeMerge, code.Code)
 }
+
+const testCodeClass = `import os
+
+# Greeter greets.
+class Greeter:
+    greeting = "Hello"
+
+    def hello(self, name):
+        # TODO: Greet name
+        pass
+
+    def bye(self):
+        print("Bye")
+
+
+def main():
+    Greeter().hello("world")
+`
+
+func TestSourceMergeDeclarations(t *testing.T) {
+	testCases := []struct {
+		name string
+		// code is the merged file, testCodeClass if empty.
+		code      string
+		generated string
+		// scope is the name of the function enclosing the merge scope, or empty for the file.
+		scope    string
+		expected string
+	}{
+		{
+			name: "class members",
+			generated: `class Greeter:
+    # hello greets name.
+    def hello(self, name):
+        print(self.greeting, name)
+
+    def wave(self):
+        print("o/")
+`,
+			expected: `import os
+
+# Greeter greets.
+class Greeter:
+    greeting = "Hello"
+
+    # hello greets name.
+    def hello(self, name):
+        print(self.greeting, name)
+
+    def bye(self):
+        print("Bye")
+
+    def wave(self):
+        print("o/")
+
+
+def main():
+    Greeter().hello("world")
+`,
+		},
+		{
+			name:  "enclosing class",
+			scope: "hello",
+			generated: `def hello(self, name):
+  print(self.greeting, name)
+  self.wave()
+
+def wave(self):
+  print("o/")
+
+def shout(text):
+  return text.upper()
+`,
+			expected: `import os
+
+# Greeter greets.
+class Greeter:
+    greeting = "Hello"
+
+    def hello(self, name):
+      print(self.greeting, name)
+      self.wave()
+
+    def bye(self):
+        print("Bye")
+
+    def wave(self):
+      print("o/")
+
+
+def main():
+    Greeter().hello("world")
+
+def shout(text):
+  return text.upper()
+`,
+		},
+		{
+			name: "imports",
+			generated: `import os
+from sys import argv
+
+def main():
+    Greeter().hello(argv[1])
+`,
+			expected: `import os
+from sys import argv
+
+# Greeter greets.
+class Greeter:
+    greeting = "Hello"
+
+    def hello(self, name):
+        # TODO: Greet name
+        pass
+
+    def bye(self):
+        print("Bye")
+
+def main():
+    Greeter().hello(argv[1])
+`,
+		},
+		{
+			name: "class without methods",
+			code: "class Point:\n    x = 0\n\n\ndef main():\n    pass\n",
+			generated: `class Point:
+	def move(self, dx):
+		self.x += dx
+`,
+			expected: "class Point:\n    x = 0\n    def move(self, dx):\n    \tself.x += dx\n\n\ndef main():\n    pass\n",
+		},
+		{
+			name: "reindent",
+			generated: `class Greeter:
+	def bye(self):
+		if self.greeting:
+			print("Bye")
+`,
+			expected: `import os
+
+# Greeter greets.
+class Greeter:
+    greeting = "Hello"
+
+    def hello(self, name):
+        # TODO: Greet name
+        pass
+
+    def bye(self):
+    	if self.greeting:
+    		print("Bye")
+
+
+def main():
+    Greeter().hello("world")
+`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			env := setupTestProject(t)
+
+			original := tc.code
+
+			if original == "" {
+				original = testCodeClass
+			}
+
+			src := NewSourceFile(env.Language, "test.py", repofs.String(original))
+			gen := NewSourceFile(env.Language, "gen.py", repofs.String(tc.generated))
+
+			require.NoError(t, src.Load())
+			require.NoError(t, gen.Load())
+
+			scope := &codegen.NodeScope{Node: src.Root()}
+
+			if tc.scope != "" {
+				scope.Node = findFunction(src.Root(), tc.scope)
+				require.NotNil(t, scope.Node)
+			}
+
+			c := psi.NewCursor()
+			c.SetCurrent(scope.Node)
+
+			require.NoError(t, src.MergeCompletionResults(context.Background(), scope, c, gen, gen.Root()))
+
+			code, err := src.ToCode(src.Root())
+
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, code.Code)
+		})
+	}
+}
+
+// findFunction returns the node of the first function named name under n.
+func findFunction(n psi.Node, name string) psi.Node {
+	if pn, ok := n.(Node); ok {
+		if fn, ok := pn.Tree().(*pyparser.FuncdefContext); ok && fn.Name().GetText() == name {
+			return n
+		}
+	}
+
+	for _, c := range n.Children() {
+		if found := findFunction(c, name); found != nil {
+			return found
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,266 @@
+package tomllang
+
+import (
+	"strings"
+
+	"github.com/greenboxal/agibootstrap/pkg/psi/langs/datalang"
+)
+
+type format struct{}
+
+func (format) Parse(text string) (*datalang.Document, error) {
+	return parse(text)
+}
+
+func (format) Merge(dst, src *datalang.Document, scope []string) (string, error) {
+	d, err := scan(dst.Text)
+
+	if err != nil {
+		return "", err
+	}
+
+	s, err := scan(src.Text)
+
+	if err != nil {
+		return "", err
+	}
+
+	m := &merger{dst: d, src: s, doc: dst, scope: scope}
+
+	return m.merge()
+}
+
+func (format) Indent(doc *datalang.Document, offset int) string {
+	return doc.Indent(offset)
+}
+
+// merger merges TOML files by the full paths of their keys, since the same table can be written with
+// headers, dotted keys or both. Pairs and tables which would conflict with the shape of the tables of
+// dst are skipped, so the result stays valid.
+type merger struct {
+	dst, src *file
+	doc      *datalang.Document
+	scope    []string
+	edits    datalang.TextEdits
+}
+
+// merge merges src into dst:
+//
+//   - pairs found in dst have their value replaced, and their comments if src has some,
+//   - new pairs are added after the last pair of the closest table of dst, with dotted keys if needed,
+//   - new tables are appended with their header,
+//   - arrays of tables replace the arrays of dst item by item.
+func (m *merger) merge() (string, error) {
+	arrays := map[string]bool{}
+
+	for _, sec := range m.src.sections {
+		switch {
+		case sec.owner != -1:
+			// Merged with the item of their array.
+
+		case sec.array:
+			if key := dottedKey(sec.path); !arrays[key] {
+				arrays[key] = true
+				m.mergeArray(sec.path)
+			}
+
+		case len(sec.path) > 0 && !m.isDefined(sec.path):
+			if !m.conflicts(sec.path) {
+				m.append(m.src.text[sec.leadStart:sec.end])
+			}
+
+		default:
+			for _, p := range sec.pairs {
+				m.mergePair(sec, p)
+			}
+		}
+	}
+
+	return m.edits.Apply(m.dst.text)
+}
+
+func (m *merger) mergePair(sec *section, p *pair) {
+	full := sec.fullPath(p)
+
+	if len(sec.path) == 0 && len(m.scope) > 0 && m.lookupPair(full) == nil {
+		if scoped := append(append([]string(nil), m.scope...), full...); m.lookupPair(scoped) != nil {
+			full = scoped
+		}
+	}
+
+	value := m.src.text[p.value.Start:p.value.End]
+
+	if dp := m.lookupPair(full); dp != nil {
+		m.edits.Replace(dp.value.Start, dp.value.End, value)
+
+		if p.leadStart < p.start {
+			m.edits.Replace(dp.leadStart, dp.start, m.src.text[p.leadStart:p.start])
+		}
+
+		return
+	}
+
+	if m.conflicts(full) || m.isDefined(full) {
+		return
+	}
+
+	target := m.dst.sections[0]
+
+	for _, s := range m.dst.sections {
+		if s.owner == -1 && !s.array && len(s.path) > len(target.path) && len(s.path) < len(full) && hasPrefix(full, s.path) {
+			target = s
+		}
+	}
+
+	m.insertPair(target, m.src.text[p.leadStart:p.start]+dottedKey(full[len(target.path):])+" = "+value)
+}
+
+// insertPair inserts text at the end of the pairs of sec.
+func (m *merger) insertPair(sec *section, text string) {
+	switch {
+	case len(sec.pairs) > 0:
+		last := sec.pairs[len(sec.pairs)-1]
+
+		m.edits.Insert(m.lineEnd(last.end), "\n"+m.doc.Indent(last.start)+text)
+
+	case len(sec.path) > 0:
+		m.edits.Insert(m.lineEnd(sec.end), "\n"+text)
+
+	case len(m.dst.sections) > 1:
+		m.edits.Insert(m.dst.sections[1].leadStart, text+"\n\n")
+
+	default:
+		m.append(text)
+	}
+}
+
+// mergeArray replaces the items of the array of tables at path with the items of src.
+func (m *merger) mergeArray(path []string) {
+	srcItems := m.src.arrayItems(path)
+	dstItems := m.dst.arrayItems(path)
+
+	if len(dstItems) == 0 {
+		if !m.isDefined(path) && !m.conflicts(path) {
+			for _, item := range srcItems {
+				m.append(m.src.text[item[0]:item[1]])
+			}
+		}
+
+		return
+	}
+
+	for i, item := range dstItems {
+		text := ""
+
+		if i < len(srcItems) {
+			text = m.src.text[srcItems[i][0]:srcItems[i][1]]
+		}
+
+		if i == len(dstItems)-1 && len(srcItems) > len(dstItems) {
+			for _, extra := range srcItems[len(dstItems):] {
+				text += "\n\n" + m.src.text[extra[0]:extra[1]]
+			}
+		}
+
+		// Removed items are removed with the blank lines before them.
+		if text == "" {
+			item[0] = len(strings.TrimRight(m.dst.text[:item[0]], " \t\r\n"))
+		}
+
+		m.edits.Replace(item[0], item[1], text)
+	}
+}
+
+// arrayItems returns the ranges of the items of the array of tables at path, with their subtables.
+func (f *file) arrayItems(path []string) (result [][2]int) {
+	for i, sec := range f.sections {
+		if !sec.array || sec.owner != -1 || dottedKey(sec.path) != dottedKey(path) {
+			continue
+		}
+
+		end := sec.end
+
+		for _, sub := range f.sections[i+1:] {
+			if sub.owner == i && sub.end > end {
+				end = sub.end
+			}
+		}
+
+		result = append(result, [2]int{sec.leadStart, end})
+	}
+
+	return result
+}
+
+// lookupPair returns the pair of dst at path, outside of arrays of tables.
+func (m *merger) lookupPair(path []string) *pair {
+	for _, sec := range m.dst.sections {
+		if sec.owner != -1 || sec.array {
+			continue
+		}
+
+		for _, p := range sec.pairs {
+			if full := sec.fullPath(p); len(full) == len(path) && hasPrefix(full, path) {
+				return p
+			}
+		}
+	}
+
+	return nil
+}
+
+// isDefined returns true if dst has a table at path, or keys in it.
+func (m *merger) isDefined(path []string) bool {
+	for _, sec := range m.dst.sections {
+		if len(sec.path) > 0 && hasPrefix(sec.path, path) {
+			return true
+		}
+
+		for _, p := range sec.pairs {
+			if full := sec.fullPath(p); len(full) > len(path) && hasPrefix(full, path) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// conflicts returns true if a value or an array of tables of dst is at path or above it.
+func (m *merger) conflicts(path []string) bool {
+	for _, sec := range m.dst.sections {
+		if sec.array && hasPrefix(path, sec.path) {
+			return true
+		}
+
+		for _, p := range sec.pairs {
+			if hasPrefix(path, sec.fullPath(p)) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// append appends text to the end of dst, after a blank line.
+func (m *merger) append(text string) {
+	prefix := "\n"
+
+	if strings.TrimSpace(m.dst.text) == "" {
+		prefix = ""
+	} else if !strings.HasSuffix(m.dst.text, "\n") {
+		prefix = "\n\n"
+	}
+
+	m.edits.Insert(len(m.dst.text), prefix+text+"\n")
+}
+
+// lineEnd returns the offset of the end of the line of offset.
+func (m *merger) lineEnd(offset int) int {
+	if end := strings.IndexByte(m.dst.text[offset:], '\n'); end != -1 {
+		return offset + len(strings.TrimRight(m.dst.text[offset:offset+end], "\r"))
+	}
+
+	return len(m.dst.text)
+}
@@ -0,0 +1,209 @@
+package tomllang
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/greenboxal/agibootstrap/pkg/build/codegen"
+	"github.com/greenboxal/agibootstrap/pkg/codex"
+	"github.com/greenboxal/agibootstrap/pkg/psi"
+)
+
+const testManifest = `kind = "Deployment"
+
+# TODO: Scale up
+[spec]
+replicas = 1
+
+[[spec.containers]]
+name = "app"
+image = "app:1"
+`
+
+func setupTestLanguage(t *testing.T) psi.Language {
+	p, err := codex.NewProject(context.Background(), t.TempDir())
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, p.Close()) })
+
+	return NewLanguage(p)
+}
+
+func TestParse(t *testing.T) {
+	testCases := []struct {
+		name string
+		code string
+		// path is resolved from the root of the file, and expected is the code of the node found.
+		path     string
+		expected string
+		err      bool
+	}{
+		{name: "key", code: testManifest, path: "#kind", expected: `kind = "Deployment"`},
+		{name: "nested key", code: testManifest, path: "#spec/#replicas", expected: "replicas = 1"},
+		{name: "array of tables", code: testManifest, path: "#spec/#containers/@0/#image", expected: `image = "app:1"`},
+		{name: "dotted key", code: "a.b.c = 1\n", path: "#a/#b/#c", expected: "a.b.c = 1"},
+		{name: "array", code: "a = [1, 2]\n", path: "#a/@1", expected: "2"},
+		{name: "missing value", code: "a =\n", err: true},
+		{name: "duplicate key", code: "a = 1\na = 2\n", err: true},
+		{name: "unterminated header", code: "[a\nb = 1\n", err: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			sf, err := setupTestLanguage(t).Parse("test.toml", tc.code)
+
+			if tc.err {
+				require.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+
+			n, err := psi.ResolvePath(sf.Root(), psi.MustParsePath(tc.path))
+			require.NoError(t, err)
+
+			code, err := sf.ToCode(n)
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, code.Code)
+		})
+	}
+}
+
+func TestTodos(t *testing.T) {
+	sf, err := setupTestLanguage(t).Parse("test.toml", testManifest)
+	require.NoError(t, err)
+
+	n, err := psi.ResolvePath(sf.Root(), psi.MustParsePath("#spec"))
+	require.NoError(t, err)
+	// TODO comments are returned in the syntax of the other languages, so they are processed the same.
+	require.Equal(t, []string{"// TODO: Scale up"}, n.Comments())
+}
+
+func TestMerge(t *testing.T) {
+	testCases := []struct {
+		name      string
+		generated string
+		// scope is the path of the node the code was generated for, the root of the file if empty.
+		scope    string
+		expected string
+		err      bool
+	}{
+		{
+			name:      "nested",
+			generated: "[spec]\nreplicas = 3\n",
+			expected: `kind = "Deployment"
+
+# TODO: Scale up
+[spec]
+replicas = 3
+
+[[spec.containers]]
+name = "app"
+image = "app:1"
+`,
+		},
+		{
+			name:      "dotted key",
+			generated: "spec.replicas = 3\n",
+			expected: `kind = "Deployment"
+
+# TODO: Scale up
+[spec]
+replicas = 3
+
+[[spec.containers]]
+name = "app"
+image = "app:1"
+`,
+		},
+		{
+			name:      "replace",
+			generated: "# Kind of the object.\nkind = \"StatefulSet\"\n\n[[spec.containers]]\nname = \"web\"\n",
+			expected: `# Kind of the object.
+kind = "StatefulSet"
+
+# TODO: Scale up
+[spec]
+replicas = 1
+
+[[spec.containers]]
+name = "web"
+`,
+		},
+		{
+			name:      "append",
+			generated: "apiVersion = \"apps/v1\"\n\n[spec]\npaused = false\n\n[metadata]\nname = \"app\"\n",
+			expected: `kind = "Deployment"
+apiVersion = "apps/v1"
+
+# TODO: Scale up
+[spec]
+replicas = 1
+paused = false
+
+[[spec.containers]]
+name = "app"
+image = "app:1"
+
+[metadata]
+name = "app"
+`,
+		},
+		{
+			name:      "scope",
+			generated: "replicas = 3\n",
+			scope:     "#spec/#replicas",
+			expected: `kind = "Deployment"
+
+# TODO: Scale up
+[spec]
+replicas = 3
+
+[[spec.containers]]
+name = "app"
+image = "app:1"
+`,
+		},
+		{
+			name:      "conflicting changes",
+			generated: "replicas = 2\n\n[spec]\nreplicas = 3\n",
+			scope:     "#spec/#replicas",
+			err:       true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			lang := setupTestLanguage(t)
+
+			sf, err := lang.Parse("test.toml", testManifest)
+			require.NoError(t, err)
+
+			gen, err := lang.Parse("gen.toml", tc.generated)
+			require.NoError(t, err)
+
+			scope := &codegen.NodeScope{Node: sf.Root()}
+
+			if tc.scope != "" {
+				scope.Node, err = psi.ResolvePath(sf.Root(), psi.MustParsePath(tc.scope))
+				require.NoError(t, err)
+			}
+
+			err = sf.MergeCompletionResults(context.Background(), scope, psi.NewCursor(), gen, gen.Root())
+
+			code, codeErr := sf.ToCode(sf.Root())
+			require.NoError(t, codeErr)
+
+			if tc.err {
+				// Rejected merges leave the file as it was.
+				require.Error(t, err)
+				require.Equal(t, testManifest, code.Code)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, code.Code)
+		})
+	}
+}
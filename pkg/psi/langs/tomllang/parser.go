@@ -0,0 +1,636 @@
+package tomllang
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/pelletier/go-toml/v2"
+
+	"github.com/greenboxal/agibootstrap/pkg/psi/langs/datalang"
+)
+
+// file is a TOML file scanned into its sections: the keys before the first header, then the tables
+// and the tables of arrays, in the order of their headers.
+type file struct {
+	text     string
+	sections []*section
+}
+
+// section is the part of a file under a header, or before the first one.
+type section struct {
+	path  []string
+	array bool
+
+	// owner is the index of the section of the array of tables owning this section, which is a
+	// subtable of its last item, or -1.
+	owner int
+
+	// start is the offset of the header, leadStart of the comments above it, and end of the end of the
+	// last pair of the section, or of its header.
+	start, leadStart, end int
+	comments              []string
+
+	pairs []*pair
+}
+
+// pair is a key/value pair, with a dotted key relative to its section.
+type pair struct {
+	key []string
+
+	start, leadStart, end int
+	comments              []string
+
+	value *datalang.Value
+}
+
+// fullPath returns the path of a pair of s from the root table.
+func (s *section) fullPath(p *pair) []string {
+	return append(append([]string(nil), s.path...), p.key...)
+}
+
+// scanner scans TOML for the offsets of its sections, pairs and values, which go-toml does not report.
+// The values themselves are validated by go-toml.
+type scanner struct {
+	text string
+	pos  int
+	doc  *datalang.Document
+}
+
+func parse(text string) (*datalang.Document, error) {
+	f, err := scan(text)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return f.document(), nil
+}
+
+func scan(text string) (*file, error) {
+	var values map[string]any
+
+	if err := toml.Unmarshal([]byte(text), &values); err != nil {
+		return nil, decodeError(text, err)
+	}
+
+	s := &scanner{text: text, doc: &datalang.Document{Text: text}}
+	f := &file{text: text}
+	current := &section{owner: -1}
+	owner := -1
+
+	f.sections = append(f.sections, current)
+
+	for {
+		s.skipSpace()
+
+		if s.pos >= len(text) {
+			return f, nil
+		}
+
+		switch c := text[s.pos]; {
+		case c == '\n' || c == '\r' || c == '#':
+			s.skipLine()
+
+		case c == '[':
+			start := s.pos
+			array := strings.HasPrefix(text[s.pos:], "[[")
+
+			if s.pos++; array {
+				s.pos++
+			}
+
+			path, err := s.key()
+
+			if err != nil {
+				return nil, err
+			}
+
+			if s.skipSpace(); !strings.HasPrefix(text[s.pos:], "]") || (array && !strings.HasPrefix(text[s.pos:], "]]")) {
+				return nil, s.errorf("expected ']' after table header")
+			}
+
+			if s.pos++; array {
+				s.pos++
+			}
+
+			current = &section{path: path, array: array, owner: -1, start: start, end: s.pos}
+			current.leadStart = s.doc.LeadStart(start, "#")
+			current.comments = append(s.doc.Comments(current.leadStart, start, "#"), s.trailingComment()...)
+
+			switch {
+			case owner != -1 && hasPrefix(path, f.sections[owner].path) && (len(path) > len(f.sections[owner].path) || !array):
+				current.owner = owner
+
+			case array:
+				owner = len(f.sections)
+
+			default:
+				owner = -1
+			}
+
+			f.sections = append(f.sections, current)
+
+			if err := s.endLine(); err != nil {
+				return nil, err
+			}
+
+		default:
+			p := &pair{start: s.pos}
+
+			key, err := s.key()
+
+			if err != nil {
+				return nil, err
+			}
+
+			if s.skipSpace(); s.pos >= len(text) || text[s.pos] != '=' {
+				return nil, s.errorf("expected '=' after key")
+			}
+
+			s.pos++
+			s.skipSpace()
+
+			value, err := s.value()
+
+			if err != nil {
+				return nil, err
+			}
+
+			p.key = key
+			p.value = value
+			p.end = value.End
+			p.leadStart = s.doc.LeadStart(p.start, "#")
+			p.comments = append(s.doc.Comments(p.leadStart, p.start, "#"), s.trailingComment()...)
+
+			current.pairs = append(current.pairs, p)
+			current.end = p.end
+
+			if err := s.endLine(); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+// key scans a dotted key.
+func (s *scanner) key() ([]string, error) {
+	var result []string
+
+	for {
+		s.skipSpace()
+
+		if s.pos >= len(s.text) {
+			return nil, s.errorf("expected a key")
+		}
+
+		switch c := s.text[s.pos]; {
+		case c == '"' || c == '\'':
+			start := s.pos
+
+			if err := s.string(); err != nil {
+				return nil, err
+			}
+
+			result = append(result, unquote(s.text[start:s.pos]))
+
+		case isBareKey(c):
+			start := s.pos
+
+			for s.pos < len(s.text) && isBareKey(s.text[s.pos]) {
+				s.pos++
+			}
+
+			result = append(result, s.text[start:s.pos])
+
+		default:
+			return nil, s.errorf("expected a key")
+		}
+
+		if s.skipSpace(); s.pos >= len(s.text) || s.text[s.pos] != '.' {
+			return result, nil
+		}
+
+		s.pos++
+	}
+}
+
+// value scans a value, with the entries of arrays and inline tables.
+func (s *scanner) value() (*datalang.Value, error) {
+	v := &datalang.Value{Kind: datalang.KindScalar, Start: s.pos}
+
+	if s.pos >= len(s.text) {
+		return nil, s.errorf("expected a value")
+	}
+
+	switch c := s.text[s.pos]; c {
+	case '"', '\'':
+		if err := s.string(); err != nil {
+			return nil, err
+		}
+
+	case '[':
+		v.Kind = datalang.KindArray
+		v.Inline = true
+		s.pos++
+
+		for {
+			comments := s.skipBlank()
+
+			if s.pos >= len(s.text) {
+				return nil, s.errorf("unterminated array")
+			}
+
+			if s.text[s.pos] == ']' {
+				s.pos++
+				break
+			}
+
+			e := &datalang.Entry{Start: s.pos, LeadStart: s.pos}
+
+			if len(comments) > 0 {
+				e.LeadStart = s.doc.LeadStart(s.pos, "#")
+				e.Comments = comments
+			}
+
+			item, err := s.value()
+
+			if err != nil {
+				return nil, err
+			}
+
+			e.Value = item
+			e.End = item.End
+			v.Entries = append(v.Entries, e)
+
+			if s.skipBlank(); s.pos < len(s.text) && s.text[s.pos] == ',' {
+				s.pos++
+			}
+		}
+
+	case '{':
+		v.Kind = datalang.KindObject
+		v.Inline = true
+		s.pos++
+
+		for {
+			if s.skipSpace(); s.pos < len(s.text) && s.text[s.pos] == '}' {
+				s.pos++
+				break
+			}
+
+			start := s.pos
+			key, err := s.key()
+
+			if err != nil {
+				return nil, err
+			}
+
+			if s.skipSpace(); s.pos >= len(s.text) || s.text[s.pos] != '=' {
+				return nil, s.errorf("expected '=' after key")
+			}
+
+			s.pos++
+			s.skipSpace()
+
+			item, err := s.value()
+
+			if err != nil {
+				return nil, err
+			}
+
+			addPair(v, key, start, start, item, nil)
+
+			if s.skipSpace(); s.pos < len(s.text) && s.text[s.pos] == ',' {
+				s.pos++
+			}
+		}
+
+	default:
+		for s.pos < len(s.text) && !strings.ContainsRune(" \t\r\n,]}#", rune(s.text[s.pos])) {
+			s.pos++
+		}
+
+		// Date-times separated by a space, e.g. 1979-05-27 07:32:00.
+		if s.pos-v.Start == 10 && s.text[v.Start+4] == '-' && strings.HasPrefix(s.text[s.pos:], " ") && s.pos+1 < len(s.text) && isDigit(s.text[s.pos+1]) {
+			for s.pos++; s.pos < len(s.text) && !strings.ContainsRune(" \t\r\n,]}#", rune(s.text[s.pos])); s.pos++ {
+			}
+		}
+
+		if s.pos == v.Start {
+			return nil, s.errorf("expected a value")
+		}
+	}
+
+	v.End = s.pos
+
+	return v, nil
+}
+
+// string scans a basic, literal or multi-line string.
+func (s *scanner) string() error {
+	quote := s.text[s.pos : s.pos+1]
+	start := s.pos
+
+	if strings.HasPrefix(s.text[s.pos:], quote+quote+quote) {
+		quote = quote + quote + quote
+	}
+
+	for s.pos += len(quote); s.pos < len(s.text); s.pos++ {
+		switch c := s.text[s.pos]; {
+		case c == '\\' && quote[0] == '"':
+			s.pos++
+
+		case c == '\n' && len(quote) == 1:
+			s.pos = start
+
+			return s.errorf("unterminated string")
+
+		case strings.HasPrefix(s.text[s.pos:], quote):
+			s.pos += len(quote)
+
+			// Multi-line strings may end with up to two quotes.
+			for i := 0; i < 2 && len(quote) == 3 && s.pos < len(s.text) && s.text[s.pos] == quote[0]; i++ {
+				s.pos++
+			}
+
+			return nil
+		}
+	}
+
+	s.pos = start
+
+	return s.errorf("unterminated string")
+}
+
+func (s *scanner) skipSpace() {
+	for s.pos < len(s.text) && (s.text[s.pos] == ' ' || s.text[s.pos] == '\t') {
+		s.pos++
+	}
+}
+
+func (s *scanner) skipLine() {
+	if end := strings.IndexByte(s.text[s.pos:], '\n'); end != -1 {
+		s.pos += end + 1
+	} else {
+		s.pos = len(s.text)
+	}
+}
+
+// skipBlank skips whitespace, newlines and comments, and returns the comments.
+func (s *scanner) skipBlank() (comments []string) {
+	for s.skipSpace(); s.pos < len(s.text); s.skipSpace() {
+		switch s.text[s.pos] {
+		case '\r', '\n':
+			s.pos++
+
+		case '#':
+			start := s.pos
+			s.skipLine()
+			comments = append(comments, datalang.Comment("#", s.text[start:s.pos]))
+
+		default:
+			return comments
+		}
+	}
+
+	return comments
+}
+
+// trailingComment returns the comment at the end of the line, if any.
+func (s *scanner) trailingComment() []string {
+	s.skipSpace()
+
+	if s.pos < len(s.text) && s.text[s.pos] == '#' {
+		end := strings.IndexByte(s.text[s.pos:], '\n')
+
+		if end == -1 {
+			end = len(s.text) - s.pos
+		}
+
+		return []string{datalang.Comment("#", s.text[s.pos:s.pos+end])}
+	}
+
+	return nil
+}
+
+// endLine skips the rest of the line, which may only have a comment.
+func (s *scanner) endLine() error {
+	if s.skipSpace(); s.pos < len(s.text) && s.text[s.pos] != '#' && s.text[s.pos] != '\n' && s.text[s.pos] != '\r' {
+		return s.errorf("expected the end of the line")
+	}
+
+	s.skipLine()
+
+	return nil
+}
+
+func (s *scanner) errorf(format string, args ...any) error {
+	return &datalang.SyntaxError{Offset: s.pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+// document returns the tables of f as objects. Tables span from their header, or first key, to the
+// last pair defined in them.
+func (f *file) document() *datalang.Document {
+	root := &datalang.Value{Kind: datalang.KindObject, End: len(f.text)}
+
+	for _, sec := range f.sections {
+		table := root
+		var chain []*datalang.Entry
+
+		if len(sec.path) > 0 {
+			parent, parents := lookupTable(root, sec.path[:len(sec.path)-1], sec.start)
+			chain = parents
+			key := sec.path[len(sec.path)-1]
+
+			e := parent.Lookup(key)
+
+			if e == nil {
+				kind := datalang.KindObject
+
+				if sec.array {
+					kind = datalang.KindArray
+				}
+
+				e = &datalang.Entry{Key: key, Value: &datalang.Value{Kind: kind, Start: sec.start}, Start: sec.start, LeadStart: sec.leadStart}
+
+				if !sec.array {
+					e.Comments = sec.comments
+				}
+
+				parent.Entries = append(parent.Entries, e)
+			}
+
+			chain = append(chain, e)
+
+			if sec.array && e.Value.Kind == datalang.KindArray {
+				item := &datalang.Entry{
+					Value:     &datalang.Value{Kind: datalang.KindObject, Start: sec.start},
+					Start:     sec.start,
+					LeadStart: sec.leadStart,
+					Comments:  sec.comments,
+				}
+
+				e.Value.Entries = append(e.Value.Entries, item)
+				chain = append(chain, item)
+			}
+
+			table = chain[len(chain)-1].Value
+			extend(chain, sec.end)
+		}
+
+		for _, p := range sec.pairs {
+			addPair(table, p.key, p.start, p.leadStart, p.value, p.comments)
+			extend(chain, p.end)
+		}
+	}
+
+	return &datalang.Document{Text: f.text, Values: []*datalang.Value{root}}
+}
+
+// lookupTable returns the table at path from root, through the last items of arrays of tables, and the
+// entries leading to it. Missing tables are created at offset.
+func lookupTable(root *datalang.Value, path []string, offset int) (*datalang.Value, []*datalang.Entry) {
+	var chain []*datalang.Entry
+
+	table := root
+
+	for _, key := range path {
+		e := table.Lookup(key)
+
+		if e == nil {
+			e = &datalang.Entry{Key: key, Value: &datalang.Value{Kind: datalang.KindObject, Start: offset}, Start: offset, LeadStart: offset}
+			table.Entries = append(table.Entries, e)
+		}
+
+		chain = append(chain, e)
+
+		if e.Value.Kind == datalang.KindArray && len(e.Value.Entries) > 0 {
+			e = e.Value.Entries[len(e.Value.Entries)-1]
+			chain = append(chain, e)
+		}
+
+		table = e.Value
+	}
+
+	return table, chain
+}
+
+// addPair adds the pair with the dotted key to table, creating the tables of its key.
+func addPair(table *datalang.Value, key []string, start, leadStart int, value *datalang.Value, comments []string) {
+	parent, chain := lookupTable(table, key[:len(key)-1], start)
+
+	parent.Entries = append(parent.Entries, &datalang.Entry{
+		Key:       key[len(key)-1],
+		Value:     value,
+		Start:     start,
+		End:       value.End,
+		LeadStart: leadStart,
+		Comments:  comments,
+	})
+
+	extend(chain, value.End)
+}
+
+// extend extends the entries of chain, and their values, to end.
+func extend(chain []*datalang.Entry, end int) {
+	for _, e := range chain {
+		if e.End < end {
+			e.End = end
+		}
+
+		if e.Value.Kind != datalang.KindScalar && !e.Value.Inline && e.Value.End < end {
+			e.Value.End = end
+		}
+	}
+}
+
+// decodeError converts an error of go-toml to a *datalang.SyntaxError.
+func decodeError(text string, err error) error {
+	de, ok := err.(*toml.DecodeError)
+
+	if !ok {
+		return err
+	}
+
+	row, column := de.Position()
+	offset := 0
+
+	for i := 1; i < row; i++ {
+		next := strings.IndexByte(text[offset:], '\n')
+
+		if next == -1 {
+			break
+		}
+
+		offset += next + 1
+	}
+
+	for i := 1; i < column && offset < len(text); i++ {
+		_, size := utf8.DecodeRuneInString(text[offset:])
+		offset += size
+	}
+
+	return &datalang.SyntaxError{Offset: offset, Msg: de.Error()}
+}
+
+func unquote(s string) string {
+	if s[0] == '\'' {
+		return s[1 : len(s)-1]
+	}
+
+	if u, err := strconv.Unquote(s); err == nil {
+		return u
+	}
+
+	return s[1 : len(s)-1]
+}
+
+// quoteKey returns key as a bare key if possible, or quoted.
+func quoteKey(key string) string {
+	if key == "" {
+		return `""`
+	}
+
+	for i := 0; i < len(key); i++ {
+		if !isBareKey(key[i]) {
+			return strconv.Quote(key)
+		}
+	}
+
+	return key
+}
+
+// dottedKey returns the TOML dotted key of path.
+func dottedKey(path []string) string {
+	keys := make([]string, len(path))
+
+	for i, k := range path {
+		keys[i] = quoteKey(k)
+	}
+
+	return strings.Join(keys, ".")
+}
+
+func hasPrefix(path, prefix []string) bool {
+	if len(prefix) > len(path) {
+		return false
+	}
+
+	for i, k := range prefix {
+		if path[i] != k {
+			return false
+		}
+	}
+
+	return true
+}
+
+func isBareKey(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || isDigit(c) || c == '_' || c == '-'
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
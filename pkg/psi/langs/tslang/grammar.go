@@ -0,0 +1,171 @@
+package tslang
+
+import (
+	"strings"
+
+	"github.com/antlr4-go/antlr/v4"
+
+	"github.com/greenboxal/agibootstrap/pkg/psi/langs/antlrbridge"
+	"github.com/greenboxal/agibootstrap/pkg/psi/langs/tslang/tsparser"
+)
+
+// Kinds of the declarations of TypeScript files. Imports are antlrbridge.DeclarationKindImport
+// declarations named after their tokens. Exported declarations are of the kind of the declaration
+// exported, and other exports of DeclarationKindExport.
+const (
+	DeclarationKindFunction  = "function"
+	DeclarationKindClass     = "class"
+	DeclarationKindInterface = "interface"
+	DeclarationKindType      = "type"
+	DeclarationKindEnum      = "enum"
+	DeclarationKindNamespace = "namespace"
+	DeclarationKindVariable  = "variable"
+	DeclarationKindExport    = "export"
+
+	// DeclarationKindMember is the kind of the methods and properties of classes and interfaces.
+	DeclarationKindMember = "member"
+)
+
+var declarationKinds = map[int]string{
+	tsparser.RuleImportDeclaration:    antlrbridge.DeclarationKindImport,
+	tsparser.RuleFunctionDeclaration:  DeclarationKindFunction,
+	tsparser.RuleClassDeclaration:     DeclarationKindClass,
+	tsparser.RuleInterfaceDeclaration: DeclarationKindInterface,
+	tsparser.RuleTypeAliasDeclaration: DeclarationKindType,
+	tsparser.RuleEnumDeclaration:      DeclarationKindEnum,
+	tsparser.RuleNamespaceDeclaration: DeclarationKindNamespace,
+	tsparser.RuleVariableStatement:    DeclarationKindVariable,
+}
+
+type grammar struct{}
+
+func (grammar) NewLexer(input antlr.CharStream, listener antlr.ErrorListener) antlr.Lexer {
+	l := tsparser.NewLexer(input)
+	l.AddErrorListener(listener)
+
+	return l
+}
+
+func (grammar) Parse(tokens *antlr.CommonTokenStream, listener antlr.ErrorListener) (antlr.ParserRuleContext, error) {
+	program, err := tsparser.NewParser(tokens, listener).Program()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return program, nil
+}
+
+func (grammar) IsTrivia(tk antlr.Token) bool {
+	switch tk.GetTokenType() {
+	case tsparser.TokenWhitespace, tsparser.TokenNewline, tsparser.TokenLineComment, tsparser.TokenBlockComment:
+		return true
+	}
+
+	return false
+}
+
+// Comment returns line comments as they are. Block comments starting with TODO are returned as
+// "// TODO: ...", and other block comments as they are.
+func (grammar) Comment(tk antlr.Token) (string, bool) {
+	switch tk.GetTokenType() {
+	case tsparser.TokenLineComment:
+		return tk.GetText(), true
+
+	case tsparser.TokenBlockComment:
+		text := strings.TrimSuffix(strings.TrimPrefix(tk.GetText(), "/*"), "*/")
+		text = strings.TrimSpace(strings.TrimLeft(text, "*"))
+
+		if strings.HasPrefix(text, "TODO") {
+			return "// " + strings.Join(strings.Fields(text), " "), true
+		}
+
+		return tk.GetText(), true
+	}
+
+	return "", false
+}
+
+// Declarations returns the top-level declarations of the file. The members of classes and interfaces,
+// and the declarations of namespaces, are their members.
+func (grammar) Declarations(_ *antlr.CommonTokenStream, root antlr.ParserRuleContext) []*antlrbridge.Declaration {
+	return declarations(root.GetChildren())
+}
+
+func declarations(children []antlr.Tree) []*antlrbridge.Declaration {
+	var result []*antlrbridge.Declaration
+
+	for _, child := range children {
+		ctx, ok := child.(*tsparser.Context)
+
+		if !ok {
+			continue
+		}
+
+		if d := declaration(ctx); d != nil {
+			result = append(result, d)
+		}
+	}
+
+	return result
+}
+
+func declaration(ctx *tsparser.Context) *antlrbridge.Declaration {
+	decl := ctx
+
+	if ctx.GetRuleIndex() == tsparser.RuleExportDeclaration {
+		decl = ctx.Declaration()
+	}
+
+	d := &antlrbridge.Declaration{
+		Name:  ctx.Name,
+		Start: ctx.GetStart().GetTokenIndex(),
+		Stop:  ctx.GetStop().GetTokenIndex(),
+	}
+
+	switch {
+	case decl != nil && declarationKinds[decl.GetRuleIndex()] != "":
+		d.Kind = declarationKinds[decl.GetRuleIndex()]
+	case ctx.GetRuleIndex() == tsparser.RuleExportDeclaration:
+		d.Kind = DeclarationKindExport
+	default:
+		return nil
+	}
+
+	if d.Name == "" {
+		return nil
+	}
+
+	if decl == nil || decl.Body == nil {
+		return d
+	}
+
+	if body := decl.Body; body.GetStop().GetText() == "}" {
+		d.BodyStop = body.GetStop().GetTokenIndex()
+
+		if body.GetRuleIndex() == tsparser.RuleClassBody {
+			d.Members = members(body)
+		} else {
+			d.Members = declarations(body.GetChildren())
+		}
+	}
+
+	return d
+}
+
+func members(body *tsparser.Context) []*antlrbridge.Declaration {
+	var result []*antlrbridge.Declaration
+
+	for _, child := range body.GetChildren() {
+		if m, ok := child.(*tsparser.Context); ok && m.Name != "" {
+			result = append(result, &antlrbridge.Declaration{
+				Kind:  DeclarationKindMember,
+				Name:  m.Name,
+				Start: m.GetStart().GetTokenIndex(),
+				Stop:  m.GetStop().GetTokenIndex(),
+			})
+		}
+	}
+
+	return result
+}
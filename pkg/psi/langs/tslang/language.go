@@ -0,0 +1,34 @@
+package tslang
+
+import (
+	project2 "github.com/greenboxal/agibootstrap/pkg/platform/project"
+	"github.com/greenboxal/agibootstrap/pkg/psi"
+	"github.com/greenboxal/agibootstrap/pkg/psi/langs/antlrbridge"
+)
+
+const LanguageID psi.LanguageID = "typescript"
+
+func init() {
+	project2.RegisterLanguage(LanguageID, NewLanguage)
+}
+
+// Language implements psi.Language for TypeScript and JavaScript, which is parsed as TypeScript.
+type Language struct {
+	antlrbridge.Language
+}
+
+func NewLanguage(p project2.Project) psi.Language {
+	l := &Language{}
+
+	l.Init(l, p, grammar{})
+
+	return l
+}
+
+func (l *Language) Name() psi.LanguageID {
+	return LanguageID
+}
+
+func (l *Language) Extensions() []string {
+	return []string{".ts", ".mts", ".cts", ".js", ".mjs", ".cjs"}
+}
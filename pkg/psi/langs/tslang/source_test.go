@@ -0,0 +1,244 @@
+package tslang
+
+import (
+	"context"
+	"testing"
+
+	"github.com/antlr4-go/antlr/v4"
+	"github.com/stretchr/testify/require"
+
+	"github.com/greenboxal/agibootstrap/pkg/build/codegen"
+	"github.com/greenboxal/agibootstrap/pkg/codex"
+	"github.com/greenboxal/agibootstrap/pkg/psi"
+	"github.com/greenboxal/agibootstrap/pkg/psi/langs/antlrbridge"
+)
+
+const testCode = `import { readFile } from "fs";
+
+// Greeter greets people.
+export class Greeter {
+	private greeting: string = "Hello";
+
+	// TODO: Greet name
+	hello(name: string): string {
+		return "";
+	}
+}
+
+export interface Named {
+	name: string;
+}
+
+type Id = string | number;
+
+enum Color { Red, Green }
+
+namespace util {
+	export function trim(s: string) {
+		return s.trim();
+	}
+}
+
+const answer = 42;
+
+export default function main() {
+	/* TODO: Print a greeting */
+}
+`
+
+func setupTestLanguage(t *testing.T) psi.Language {
+	p, err := codex.NewProject(context.Background(), t.TempDir())
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, p.Close()) })
+
+	return NewLanguage(p)
+}
+
+// declarationNames returns the kinds and names of decls and of their members.
+func declarationNames(decls []*antlrbridge.Declaration, parent string) (names []string) {
+	for _, d := range decls {
+		name := parent + d.Kind + " " + d.Name
+
+		names = append(names, name)
+		names = append(names, declarationNames(d.Members, name+"/")...)
+	}
+
+	return
+}
+
+func TestDeclarations(t *testing.T) {
+	g := grammar{}
+	listener := &antlr.DefaultErrorListener{}
+
+	tokens := antlr.NewCommonTokenStream(g.NewLexer(antlr.NewInputStream(testCode), listener), antlr.TokenDefaultChannel)
+	root, err := g.Parse(tokens, listener)
+	require.NoError(t, err)
+
+	require.Equal(t, []string{
+		`import import { readFile } from "fs"`,
+		"class Greeter",
+		"class Greeter/member greeting",
+		"class Greeter/member hello",
+		"interface Named",
+		"interface Named/member name",
+		"type Id",
+		"enum Color",
+		"namespace util",
+		"namespace util/function trim",
+		"variable answer",
+		"function main",
+	}, declarationNames(g.Declarations(tokens, root), ""))
+}
+
+func TestSourceTodos(t *testing.T) {
+	sf, err := setupTestLanguage(t).Parse("test.ts", testCode)
+	require.NoError(t, err)
+
+	var todos []string
+
+	err = psi.Walk(sf.Root(), func(cursor psi.Cursor, entering bool) error {
+		if entering {
+			for _, c := range cursor.Node().Comments() {
+				todos = append(todos, c)
+			}
+		}
+
+		return nil
+	})
+	require.NoError(t, err)
+
+	require.Equal(t, []string{
+		"// Greeter greets people.",
+		"// TODO: Greet name",
+		"// TODO: Print a greeting",
+	}, todos)
+}
+
+func TestSourceMerge(t *testing.T) {
+	testCases := []struct {
+		name      string
+		generated string
+		expected  string
+	}{
+		{
+			name: "class members",
+			generated: `export class Greeter {
+  // hello greets name.
+  hello(name: string): string {
+    return this.greeting + ", " + name;
+  }
+
+  bye(): string {
+    return "Bye";
+  }
+}
+`,
+			expected: `import { readFile } from "fs";
+
+// Greeter greets people.
+export class Greeter {
+	private greeting: string = "Hello";
+
+	// hello greets name.
+	hello(name: string): string {
+	  return this.greeting + ", " + name;
+	}
+
+	bye(): string {
+	  return "Bye";
+	}
+}
+
+export interface Named {
+	name: string;
+}
+
+type Id = string | number;
+
+enum Color { Red, Green }
+
+namespace util {
+	export function trim(s: string) {
+		return s.trim();
+	}
+}
+
+const answer = 42;
+
+export default function main() {
+	/* TODO: Print a greeting */
+}
+`,
+		},
+		{
+			name: "new declarations and imports",
+			generated: `import { join } from "path";
+import { readFile } from "fs";
+
+export function shout(s: string): string {
+	return s.toUpperCase();
+}
+
+const answer = 43;
+`,
+			expected: `import { readFile } from "fs";
+import { join } from "path";
+
+// Greeter greets people.
+export class Greeter {
+	private greeting: string = "Hello";
+
+	// TODO: Greet name
+	hello(name: string): string {
+		return "";
+	}
+}
+
+export interface Named {
+	name: string;
+}
+
+type Id = string | number;
+
+enum Color { Red, Green }
+
+namespace util {
+	export function trim(s: string) {
+		return s.trim();
+	}
+}
+
+const answer = 43;
+
+export default function main() {
+	/* TODO: Print a greeting */
+}
+
+export function shout(s: string): string {
+	return s.toUpperCase();
+}
+`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			lang := setupTestLanguage(t)
+
+			sf, err := lang.Parse("test.ts", testCode)
+			require.NoError(t, err)
+
+			gen, err := lang.Parse("gen.ts", tc.generated)
+			require.NoError(t, err)
+
+			c := psi.NewCursor()
+			c.SetCurrent(sf.Root())
+
+			require.NoError(t, sf.MergeCompletionResults(context.Background(), &codegen.NodeScope{Node: sf.Root()}, c, gen, gen.Root()))
+
+			code, err := sf.ToCode(sf.Root())
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, code.Code)
+		})
+	}
+}
@@ -0,0 +1,431 @@
+// Package tsparser lexes and parses TypeScript and JavaScript into ANTLR tokens and parse trees, so
+// that they can be used with antlrbridge.
+//
+// The parser is written by hand on the ANTLR runtime, rather than generated from a grammar. It only
+// parses the structure of the code: imports, exports, declarations and their bodies, class members
+// and blocks. Expressions and types are kept as flat token sequences of their statements.
+//
+// It is meant to be replaced by a parser generated from the TypeScript grammar of
+// github.com/antlr/grammars-v4, like cparser and pyparser are. tslang only depends on the rule
+// indexes of declarations, so the generated parser needs its rules mapped in declarationKinds
+// there.
+package tsparser
+
+import (
+	"fmt"
+	"unicode"
+
+	"github.com/antlr4-go/antlr/v4"
+)
+
+// Token types of the lexer. Whitespace, newlines and comments are lexed into the hidden channel.
+const (
+	TokenIdentifier = iota + 1
+	TokenNumber
+	TokenString
+	TokenTemplate
+	TokenRegex
+	TokenPunctuator
+	TokenLineComment
+	TokenBlockComment
+	TokenWhitespace
+	TokenNewline
+)
+
+// punctuators are the punctuators of TypeScript, longest first.
+var punctuators = []string{
+	">>>=",
+	"...", "===", "!==", "**=", "<<=", ">>=", ">>>", "&&=", "||=", "??=",
+	"=>", "==", "!=", "<=", ">=", "&&", "||", "??", "?.", "++", "--", "+=", "-=", "*=", "/=", "%=",
+	"&=", "|=", "^=", "<<", ">>", "**",
+	"{", "}", "(", ")", "[", "]", ";", ",", "<", ">", "+", "-", "*", "/", "%", "&", "|", "^", "!", "~",
+	"?", ":", "=", ".", "@",
+}
+
+// regexKeywords are the keywords after which a slash starts a regular expression, not a division.
+var regexKeywords = map[string]bool{
+	"return": true, "typeof": true, "instanceof": true, "in": true, "of": true, "new": true,
+	"delete": true, "void": true, "throw": true, "case": true, "do": true, "else": true,
+	"yield": true, "await": true,
+}
+
+// Lexer lexes TypeScript and JavaScript. Unterminated strings, templates, regular expressions and
+// comments are reported to the error listeners, and lexed up to the end of their line or of the input.
+type Lexer struct {
+	*antlr.BaseLexer
+
+	input antlr.CharStream
+
+	// last is the last token of the default channel, which tells regular expressions from divisions.
+	last antlr.Token
+}
+
+func NewLexer(input antlr.CharStream) *Lexer {
+	l := &Lexer{
+		BaseLexer: antlr.NewBaseLexer(input),
+
+		input: input,
+	}
+
+	// The simulator only tracks lines and columns, since the tokens are not matched from an ATN.
+	l.Interpreter = antlr.NewLexerATNSimulator(l, nil, nil, nil)
+	l.RemoveErrorListeners()
+
+	return l
+}
+
+func (l *Lexer) NextToken() antlr.Token {
+	start, line, column := l.input.Index(), l.GetLine(), l.GetCharPositionInLine()
+	c := l.input.LA(1)
+
+	if c == antlr.TokenEOF {
+		return antlr.CommonTokenFactoryDEFAULT.Create(
+			l.GetTokenSourceCharStreamPair(),
+			antlr.TokenEOF,
+			"<EOF>",
+			antlr.TokenDefaultChannel,
+			start,
+			start-1,
+			line,
+			column,
+		)
+	}
+
+	ttype := l.lex(c)
+	stop := l.input.Index() - 1
+	channel := antlr.TokenDefaultChannel
+
+	switch ttype {
+	case TokenLineComment, TokenBlockComment, TokenWhitespace, TokenNewline:
+		channel = antlr.TokenHiddenChannel
+	}
+
+	tk := antlr.CommonTokenFactoryDEFAULT.Create(
+		l.GetTokenSourceCharStreamPair(),
+		ttype,
+		l.input.GetText(start, stop),
+		channel,
+		start,
+		stop,
+		line,
+		column,
+	)
+
+	if channel == antlr.TokenDefaultChannel {
+		l.last = tk
+	}
+
+	return tk
+}
+
+func (l *Lexer) lex(c int) int {
+	switch {
+	case c == '\n' || c == '\r':
+		l.consume()
+
+		if c == '\r' && l.input.LA(1) == '\n' {
+			l.consume()
+		}
+
+		return TokenNewline
+
+	case isSpace(c):
+		for isSpace(l.input.LA(1)) {
+			l.consume()
+		}
+
+		return TokenWhitespace
+
+	case c == '/' && l.input.LA(2) == '/':
+		for c := l.input.LA(1); c != antlr.TokenEOF && c != '\n' && c != '\r'; c = l.input.LA(1) {
+			l.consume()
+		}
+
+		return TokenLineComment
+
+	case c == '/' && l.input.LA(2) == '*':
+		l.lexBlockComment()
+
+		return TokenBlockComment
+
+	case c == '/' && l.regexAllowed():
+		l.lexRegex()
+
+		return TokenRegex
+
+	case c == '"' || c == '\'':
+		l.lexString()
+
+		return TokenString
+
+	case c == '`':
+		l.lexTemplate()
+
+		return TokenTemplate
+
+	case isDigit(c) || (c == '.' && isDigit(l.input.LA(2))):
+		l.lexNumber()
+
+		return TokenNumber
+
+	case isIdentifierStart(c) || c == '#':
+		l.consume()
+		l.lexIdentifierPart()
+
+		return TokenIdentifier
+	}
+
+	for _, p := range punctuators {
+		if l.lookingAt(p) {
+			for range p {
+				l.consume()
+			}
+
+			return TokenPunctuator
+		}
+	}
+
+	l.error(fmt.Sprintf("unexpected character %q", rune(c)))
+	l.consume()
+
+	return TokenPunctuator
+}
+
+func (l *Lexer) lexBlockComment() {
+	l.consume()
+	l.consume()
+
+	for {
+		switch l.input.LA(1) {
+		case antlr.TokenEOF:
+			l.error("unterminated comment")
+			return
+
+		case '*':
+			l.consume()
+
+			if l.input.LA(1) == '/' {
+				l.consume()
+				return
+			}
+
+		default:
+			l.consume()
+		}
+	}
+}
+
+func (l *Lexer) lexString() {
+	quote := l.input.LA(1)
+
+	l.consume()
+
+	for {
+		switch c := l.input.LA(1); c {
+		case antlr.TokenEOF, '\n', '\r':
+			l.error("unterminated string")
+			return
+
+		case '\\':
+			l.consume()
+
+			if l.input.LA(1) != antlr.TokenEOF {
+				l.consume()
+			}
+
+		default:
+			l.consume()
+
+			if c == quote {
+				return
+			}
+		}
+	}
+}
+
+// lexTemplate lexes a template literal, with the expressions of its substitutions.
+func (l *Lexer) lexTemplate() {
+	l.consume()
+
+	for {
+		switch l.input.LA(1) {
+		case antlr.TokenEOF:
+			l.error("unterminated template")
+			return
+
+		case '\\':
+			l.consume()
+
+			if l.input.LA(1) != antlr.TokenEOF {
+				l.consume()
+			}
+
+		case '`':
+			l.consume()
+			return
+
+		case '$':
+			l.consume()
+
+			if l.input.LA(1) == '{' {
+				l.consume()
+				l.lexSubstitution()
+			}
+
+		default:
+			l.consume()
+		}
+	}
+}
+
+// lexSubstitution lexes the expression of a template substitution, up to its closing brace.
+func (l *Lexer) lexSubstitution() {
+	for depth := 1; depth > 0; {
+		switch l.input.LA(1) {
+		case antlr.TokenEOF:
+			return
+
+		case '{':
+			depth++
+			l.consume()
+
+		case '}':
+			depth--
+			l.consume()
+
+		case '"', '\'':
+			l.lexString()
+
+		case '`':
+			l.lexTemplate()
+
+		default:
+			l.consume()
+		}
+	}
+}
+
+func (l *Lexer) lexRegex() {
+	l.consume()
+
+	for inClass := false; ; {
+		switch c := l.input.LA(1); c {
+		case antlr.TokenEOF, '\n', '\r':
+			l.error("unterminated regular expression")
+			return
+
+		case '\\':
+			l.consume()
+
+			if c := l.input.LA(1); c != antlr.TokenEOF && c != '\n' && c != '\r' {
+				l.consume()
+			}
+
+		default:
+			l.consume()
+
+			switch {
+			case c == '[':
+				inClass = true
+			case c == ']':
+				inClass = false
+			case c == '/' && !inClass:
+				// Flags
+				l.lexIdentifierPart()
+				return
+			}
+		}
+	}
+}
+
+func (l *Lexer) lexNumber() {
+	hex := l.input.LA(1) == '0' && (l.input.LA(2) == 'x' || l.input.LA(2) == 'X')
+
+	for {
+		c := l.input.LA(1)
+
+		switch {
+		case isDigit(c) || isLetter(c) || c == '_' || c == '.':
+			l.consume()
+
+		// Signed exponents, e.g. 1e-9.
+		case (c == '+' || c == '-') && !hex && (l.input.LA(-1) == 'e' || l.input.LA(-1) == 'E'):
+			l.consume()
+
+		default:
+			return
+		}
+	}
+}
+
+func (l *Lexer) lexIdentifierPart() {
+	for {
+		c := l.input.LA(1)
+
+		switch {
+		case isIdentifierStart(c) || isDigit(c) || (c > 0x7f && unicode.IsDigit(rune(c))):
+			l.consume()
+
+		// Unicode escapes, e.g. \u0061.
+		case c == '\\' && l.input.LA(2) == 'u':
+			l.consume()
+			l.consume()
+
+		default:
+			return
+		}
+	}
+}
+
+// regexAllowed returns true if a slash at this point starts a regular expression.
+func (l *Lexer) regexAllowed() bool {
+	if l.last == nil {
+		return true
+	}
+
+	text := l.last.GetText()
+
+	switch l.last.GetTokenType() {
+	case TokenIdentifier:
+		return regexKeywords[text]
+
+	case TokenPunctuator:
+		return text != ")" && text != "]" && text != "}" && text != "++" && text != "--"
+	}
+
+	return false
+}
+
+func (l *Lexer) lookingAt(s string) bool {
+	for i, c := range s {
+		if l.input.LA(i+1) != int(c) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (l *Lexer) consume() {
+	l.Interpreter.Consume(l.input)
+}
+
+func (l *Lexer) error(msg string) {
+	l.GetErrorListenerDispatch().SyntaxError(nil, nil, l.GetLine(), l.GetCharPositionInLine(), msg, nil)
+}
+
+func isSpace(c int) bool {
+	return c == ' ' || c == '\t' || c == '\v' || c == '\f' || c == 0xfeff || (c > 0x7f && unicode.IsSpace(rune(c)) && c != 0x2028 && c != 0x2029)
+}
+
+func isDigit(c int) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isLetter(c int) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isIdentifierStart(c int) bool {
+	return isLetter(c) || c == '_' || c == '$' || (c > 0x7f && unicode.IsLetter(rune(c)))
+}
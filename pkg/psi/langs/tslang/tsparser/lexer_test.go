@@ -0,0 +1,81 @@
+package tsparser
+
+import (
+	"testing"
+
+	"github.com/antlr4-go/antlr/v4"
+	"github.com/stretchr/testify/require"
+)
+
+type testToken struct {
+	Type int
+	Text string
+}
+
+func lex(code string) (result []testToken) {
+	l := NewLexer(antlr.NewInputStream(code))
+
+	for tk := l.NextToken(); tk.GetTokenType() != antlr.TokenEOF; tk = l.NextToken() {
+		result = append(result, testToken{Type: tk.GetTokenType(), Text: tk.GetText()})
+	}
+
+	return
+}
+
+func TestLexer(t *testing.T) {
+	testCases := []struct {
+		name     string
+		code     string
+		expected []testToken
+	}{
+		{
+			name: "declaration",
+			code: "const x = 1.5e3;\n",
+			expected: []testToken{
+				{TokenIdentifier, "const"}, {TokenWhitespace, " "}, {TokenIdentifier, "x"}, {TokenWhitespace, " "},
+				{TokenPunctuator, "="}, {TokenWhitespace, " "}, {TokenNumber, "1.5e3"}, {TokenPunctuator, ";"},
+				{TokenNewline, "\n"},
+			},
+		},
+		{
+			name: "comments",
+			code: "// TODO: Do it\n/* block\n */",
+			expected: []testToken{
+				{TokenLineComment, "// TODO: Do it"}, {TokenNewline, "\n"}, {TokenBlockComment, "/* block\n */"},
+			},
+		},
+		{
+			name: "strings and templates",
+			code: "'a\\'b' + `x ${y + `z`} w`",
+			expected: []testToken{
+				{TokenString, "'a\\'b'"}, {TokenWhitespace, " "}, {TokenPunctuator, "+"}, {TokenWhitespace, " "},
+				{TokenTemplate, "`x ${y + `z`} w`"},
+			},
+		},
+		{
+			name: "regular expressions and divisions",
+			code: "a / b; return /x\\/y/g.test(s)",
+			expected: []testToken{
+				{TokenIdentifier, "a"}, {TokenWhitespace, " "}, {TokenPunctuator, "/"}, {TokenWhitespace, " "},
+				{TokenIdentifier, "b"}, {TokenPunctuator, ";"}, {TokenWhitespace, " "}, {TokenIdentifier, "return"},
+				{TokenWhitespace, " "}, {TokenRegex, "/x\\/y/g"}, {TokenPunctuator, "."}, {TokenIdentifier, "test"},
+				{TokenPunctuator, "("}, {TokenIdentifier, "s"}, {TokenPunctuator, ")"},
+			},
+		},
+		{
+			name: "longest punctuators",
+			code: "a >>>= b?.c ?? d",
+			expected: []testToken{
+				{TokenIdentifier, "a"}, {TokenWhitespace, " "}, {TokenPunctuator, ">>>="}, {TokenWhitespace, " "},
+				{TokenIdentifier, "b"}, {TokenPunctuator, "?."}, {TokenIdentifier, "c"}, {TokenWhitespace, " "},
+				{TokenPunctuator, "??"}, {TokenWhitespace, " "}, {TokenIdentifier, "d"},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.expected, lex(tc.code))
+		})
+	}
+}
@@ -0,0 +1,771 @@
+package tsparser
+
+import (
+	"strings"
+
+	"github.com/antlr4-go/antlr/v4"
+	"github.com/pkg/errors"
+)
+
+// Rules of the parse tree. The rules of declarations go from RuleImportDeclaration to
+// RuleVariableStatement.
+const (
+	RuleProgram = iota
+	RuleStatement
+	RuleBlock
+	RuleDecorator
+	RuleExportDeclaration
+	RuleImportDeclaration
+	RuleFunctionDeclaration
+	RuleClassDeclaration
+	RuleInterfaceDeclaration
+	RuleTypeAliasDeclaration
+	RuleEnumDeclaration
+	RuleNamespaceDeclaration
+	RuleVariableStatement
+	RuleClassBody
+	RuleClassMember
+)
+
+var RuleNames = []string{
+	"program",
+	"statement",
+	"block",
+	"decorator",
+	"exportDeclaration",
+	"importDeclaration",
+	"functionDeclaration",
+	"classDeclaration",
+	"interfaceDeclaration",
+	"typeAliasDeclaration",
+	"enumDeclaration",
+	"namespaceDeclaration",
+	"variableStatement",
+	"classBody",
+	"classMember",
+}
+
+// Context is a node of the parse tree.
+type Context struct {
+	antlr.BaseParserRuleContext
+
+	// Name is the name of declarations and class members, e.g. "foo" for "function foo() {}" and
+	// "get foo" for the getter "get foo() {}". Imports, and exports without a declaration, are named
+	// after their tokens separated by spaces. Default exports of anonymous declarations are named
+	// "default".
+	Name string
+
+	// Body is the body of classes, interfaces and namespaces.
+	Body *Context
+}
+
+func (c *Context) GetRuleContext() antlr.RuleContext { return c }
+
+func (c *Context) ToStringTree(_ []string, recog antlr.Recognizer) string {
+	return antlr.TreesStringTree(c, RuleNames, recog)
+}
+
+// IsDeclaration returns true for the contexts of declarations, not including exports.
+func (c *Context) IsDeclaration() bool {
+	return c.GetRuleIndex() >= RuleImportDeclaration && c.GetRuleIndex() <= RuleVariableStatement
+}
+
+// Declaration returns the declaration exported by an export declaration, if any.
+func (c *Context) Declaration() *Context {
+	for _, child := range c.GetChildren() {
+		if d, ok := child.(*Context); ok && d.IsDeclaration() {
+			return d
+		}
+	}
+
+	return nil
+}
+
+var modifiers = map[string]bool{"declare": true, "abstract": true, "async": true}
+
+var memberModifiers = map[string]bool{
+	"public": true, "private": true, "protected": true, "static": true, "readonly": true, "async": true,
+	"abstract": true, "declare": true, "override": true, "accessor": true, "get": true, "set": true,
+}
+
+// closers are the punctuators which can end a statement at a line break.
+var closers = map[string]bool{")": true, "]": true, "}": true, "++": true, "--": true}
+
+// openers are the punctuators which can start a statement after a line break.
+var openers = map[string]bool{"(": true, "[": true, "!": true, "~": true, "++": true, "--": true, "@": true, ";": true}
+
+// continuations are the keywords which continue a statement after a line break.
+var continuations = map[string]bool{
+	"else": true, "catch": true, "finally": true, "instanceof": true, "in": true, "as": true,
+	"satisfies": true, "extends": true, "implements": true,
+}
+
+// typePrefixes are the tokens after which a brace starts an object type rather than a body.
+var typePrefixes = map[string]bool{":": true, "|": true, "&": true, "=>": true, ",": true, "<": true, "?": true}
+
+// Parser parses the structure of TypeScript and JavaScript code, see the package documentation.
+type Parser struct {
+	listener antlr.ErrorListener
+
+	// tokens are the tokens of the default channel, ending with EOF.
+	tokens []antlr.Token
+	// newline tells whether a line break precedes each token.
+	newline []bool
+
+	pos int
+	err error
+}
+
+func NewParser(tokens *antlr.CommonTokenStream, listener antlr.ErrorListener) *Parser {
+	p := &Parser{listener: listener}
+
+	tokens.Fill()
+
+	newline := false
+
+	for _, tk := range tokens.GetAllTokens() {
+		if tk.GetChannel() != antlr.TokenDefaultChannel {
+			newline = newline || strings.ContainsAny(tk.GetText(), "\r\n")
+			continue
+		}
+
+		p.tokens = append(p.tokens, tk)
+		p.newline = append(p.newline, newline)
+		newline = false
+	}
+
+	return p
+}
+
+// Program parses a file. Syntax errors are reported to the listener, and the first one is returned.
+func (p *Parser) Program() (*Context, error) {
+	ctx := p.open(nil, RuleProgram)
+
+	for !p.atEOF() {
+		if p.is("}") {
+			p.error(p.current(), "unexpected }")
+
+			stray := p.open(ctx, RuleStatement)
+			p.consume(stray)
+			p.close(stray)
+
+			continue
+		}
+
+		p.statement(ctx)
+	}
+
+	p.consume(ctx)
+	ctx.SetStop(p.current())
+
+	if p.err != nil {
+		return nil, p.err
+	}
+
+	return ctx, nil
+}
+
+func (p *Parser) statement(parent *Context) {
+	if p.text(p.skipDecorators(p.pos)) == "export" {
+		p.export(parent)
+		return
+	}
+
+	if rule := p.declarationRule(p.skipDecorators(p.pos)); rule != -1 {
+		p.declaration(parent, rule)
+		return
+	}
+
+	ctx := p.open(parent, RuleStatement)
+	p.rest(ctx)
+	p.close(ctx)
+}
+
+func (p *Parser) export(parent *Context) {
+	ctx := p.open(parent, RuleExportDeclaration)
+	defer p.close(ctx)
+
+	p.decorators(ctx)
+	p.consume(ctx)
+
+	isDefault := p.accept(ctx, "default")
+
+	if rule := p.declarationRule(p.skipDecorators(p.pos)); rule != -1 {
+		ctx.Name = p.declaration(ctx, rule).Name
+
+		if ctx.Name == "" && isDefault {
+			ctx.Name = "default"
+		}
+
+		return
+	}
+
+	start := p.pos
+
+	p.rest(ctx)
+
+	if isDefault {
+		ctx.Name = "default"
+	} else {
+		ctx.Name = p.joinText(start, p.pos)
+	}
+}
+
+// declarationRule returns the rule of the declaration starting at the token i, or -1.
+func (p *Parser) declarationRule(i int) int {
+	for (modifiers[p.text(i)] || (p.text(i) == "const" && p.text(i+1) == "enum")) && p.isWord(i+1) {
+		i++
+	}
+
+	// Keywords used as names, e.g. "type: 'x'" or "import('x')".
+	switch p.text(i + 1) {
+	case ":", ",", "=", ")", ".", "?.", "(":
+		return -1
+	}
+
+	switch p.text(i) {
+	case "import":
+		return RuleImportDeclaration
+	case "function":
+		return RuleFunctionDeclaration
+	case "class":
+		return RuleClassDeclaration
+	case "enum":
+		return RuleEnumDeclaration
+	case "const", "let", "var":
+		return RuleVariableStatement
+	case "interface":
+		if p.isWord(i + 1) {
+			return RuleInterfaceDeclaration
+		}
+	case "type":
+		if p.isWord(i + 1) {
+			return RuleTypeAliasDeclaration
+		}
+	case "namespace", "module":
+		if p.isWord(i+1) || p.tokenType(i+1) == TokenString {
+			return RuleNamespaceDeclaration
+		}
+	}
+
+	return -1
+}
+
+func (p *Parser) declaration(parent *Context, rule int) *Context {
+	ctx := p.open(parent, rule)
+	defer p.close(ctx)
+
+	p.decorators(ctx)
+
+	for (modifiers[p.text(p.pos)] || (p.is("const") && p.text(p.pos+1) == "enum")) && p.isWord(p.pos+1) {
+		p.consume(ctx)
+	}
+
+	start := p.pos
+
+	// The keyword of the declaration.
+	p.consume(ctx)
+
+	switch rule {
+	case RuleImportDeclaration:
+		p.rest(ctx)
+		ctx.Name = p.joinText(start, p.pos)
+
+	case RuleVariableStatement, RuleTypeAliasDeclaration:
+		ctx.Name = p.bindingName()
+		p.rest(ctx)
+
+	case RuleFunctionDeclaration:
+		p.accept(ctx, "*")
+
+		if p.isWord(p.pos) {
+			ctx.Name = p.text(p.pos)
+			p.consume(ctx)
+		}
+
+		p.signature(ctx)
+
+		if p.is("{") {
+			p.block(ctx)
+		} else {
+			// Overloads and ambient declarations.
+			p.accept(ctx, ";")
+		}
+
+	case RuleClassDeclaration, RuleInterfaceDeclaration:
+		if p.isWord(p.pos) && !p.is("extends") && !p.is("implements") {
+			ctx.Name = p.text(p.pos)
+			p.consume(ctx)
+		}
+
+		p.heritage(ctx)
+
+		if p.is("{") {
+			ctx.Body = p.classBody(ctx)
+		}
+
+	case RuleEnumDeclaration:
+		ctx.Name = p.text(p.pos)
+		p.consume(ctx)
+
+		if p.is("{") {
+			p.block(ctx)
+		}
+
+	case RuleNamespaceDeclaration:
+		start := p.pos
+
+		for !p.atEOF() && !p.is("{") && !p.is("}") && !p.is(";") {
+			p.consume(ctx)
+		}
+
+		ctx.Name = strings.ReplaceAll(p.joinText(start, p.pos), " ", "")
+
+		if p.is("{") {
+			ctx.Body = p.block(ctx)
+		} else {
+			p.accept(ctx, ";")
+		}
+	}
+
+	return ctx
+}
+
+// bindingName returns the name declared by a variable or type alias: an identifier, or the text of a
+// destructuring pattern.
+func (p *Parser) bindingName() string {
+	if p.isWord(p.pos) {
+		return p.text(p.pos)
+	}
+
+	if p.is("{") || p.is("[") {
+		return p.joinText(p.pos, p.skipGroup(p.pos))
+	}
+
+	return ""
+}
+
+func (p *Parser) decorators(parent *Context) {
+	for p.is("@") && p.isWord(p.pos+1) {
+		ctx := p.open(parent, RuleDecorator)
+
+		p.consume(ctx)
+		p.consume(ctx)
+
+		for p.is(".") && p.isWord(p.pos+1) {
+			p.consume(ctx)
+			p.consume(ctx)
+		}
+
+		if p.is("(") {
+			p.group(ctx)
+		}
+
+		p.close(ctx)
+	}
+}
+
+// signature consumes the type parameters, parameters and return type of a function, up to its body.
+func (p *Parser) signature(ctx *Context) {
+	for !p.atEOF() && !p.is("}") && !p.is(";") {
+		switch {
+		case p.is("{"):
+			if !typePrefixes[p.text(p.pos-1)] {
+				return
+			}
+
+			p.block(ctx)
+
+		case p.is("<"):
+			p.angles(ctx)
+
+		case p.is("(") || p.is("["):
+			p.group(ctx)
+
+		default:
+			p.consume(ctx)
+		}
+
+		if p.atLineBreak() {
+			return
+		}
+	}
+}
+
+// heritage consumes the type parameters and the extends and implements clauses of a class or interface.
+func (p *Parser) heritage(ctx *Context) {
+	for !p.atEOF() && !p.is("{") && !p.is("}") && !p.is(";") {
+		switch {
+		case p.is("<"):
+			p.angles(ctx)
+
+		case p.is("(") || p.is("["):
+			p.group(ctx)
+
+		default:
+			p.consume(ctx)
+		}
+	}
+}
+
+func (p *Parser) classBody(parent *Context) *Context {
+	ctx := p.open(parent, RuleClassBody)
+	defer p.close(ctx)
+
+	open := p.current()
+	p.consume(ctx)
+
+	for !p.is("}") {
+		if p.atEOF() {
+			p.error(open, "unclosed {")
+			return ctx
+		}
+
+		if p.is(";") || p.is(",") {
+			p.consume(ctx)
+			continue
+		}
+
+		p.member(ctx)
+	}
+
+	p.consume(ctx)
+
+	return ctx
+}
+
+// member parses a member of a class or interface: a method, with its body if any, or a property,
+// with its type and initializer.
+func (p *Parser) member(parent *Context) {
+	ctx := p.open(parent, RuleClassMember)
+	defer p.close(ctx)
+
+	p.decorators(ctx)
+
+	accessor := ""
+
+	for memberModifiers[p.text(p.pos)] && !p.endsMemberName(p.pos+1) {
+		if p.is("get") || p.is("set") {
+			accessor = p.text(p.pos) + " "
+		}
+
+		p.consume(ctx)
+	}
+
+	p.accept(ctx, "*")
+
+	if p.is("[") {
+		start := p.pos
+		p.group(ctx)
+		ctx.Name = p.joinText(start, p.pos)
+	} else if !p.is("}") {
+		ctx.Name = p.text(p.pos)
+		p.consume(ctx)
+	}
+
+	ctx.Name = accessor + ctx.Name
+
+	isMethod, hasType, hasInitializer := false, false, false
+
+	for !p.atEOF() && !p.is("}") {
+		switch {
+		case p.is(";") || p.is(","):
+			p.consume(ctx)
+			return
+
+		case p.is("{"):
+			isBody := isMethod && !typePrefixes[p.text(p.pos-1)]
+
+			p.block(ctx)
+
+			if isBody {
+				return
+			}
+
+		case p.is("<") && !hasInitializer:
+			p.angles(ctx)
+
+		case p.is("(") || p.is("["):
+			if p.is("(") && !hasType && !hasInitializer {
+				isMethod = true
+			}
+
+			p.group(ctx)
+
+		default:
+			hasType = hasType || p.is(":")
+			hasInitializer = hasInitializer || p.is("=")
+
+			p.consume(ctx)
+		}
+
+		if p.atLineBreak() {
+			return
+		}
+	}
+}
+
+// endsMemberName returns true if the token i follows the name of a member, meaning that the token
+// before it is the name rather than a modifier.
+func (p *Parser) endsMemberName(i int) bool {
+	switch p.text(i) {
+	case "(", "<", ":", "=", ";", ",", "?", "!", "}", "":
+		return true
+	}
+
+	return i < len(p.newline) && p.newline[i]
+}
+
+func (p *Parser) block(parent *Context) *Context {
+	ctx := p.open(parent, RuleBlock)
+	defer p.close(ctx)
+
+	open := p.current()
+	p.consume(ctx)
+
+	for !p.is("}") {
+		if p.atEOF() {
+			p.error(open, "unclosed {")
+			return ctx
+		}
+
+		p.statement(ctx)
+	}
+
+	p.consume(ctx)
+
+	return ctx
+}
+
+// group consumes parentheses or brackets with their contents, parsing the braces inside as blocks.
+func (p *Parser) group(ctx *Context) {
+	open := p.current()
+	closing := map[string]string{"(": ")", "[": "]"}[open.GetText()]
+
+	p.consume(ctx)
+
+	for !p.is(closing) {
+		switch {
+		case p.atEOF() || p.is("}"):
+			p.error(open, "unclosed "+open.GetText())
+			return
+
+		case p.is("{"):
+			p.block(ctx)
+
+		case p.is("(") || p.is("["):
+			p.group(ctx)
+
+		default:
+			p.consume(ctx)
+		}
+	}
+
+	p.consume(ctx)
+}
+
+// angles consumes type arguments or parameters.
+func (p *Parser) angles(ctx *Context) {
+	for depth := 0; !p.atEOF() && !p.is(";") && !p.is("}"); {
+		switch t := p.text(p.pos); {
+		case t == "<":
+			depth++
+			p.consume(ctx)
+
+		case t == ">" || t == ">>" || t == ">>>":
+			depth -= len(t)
+			p.consume(ctx)
+
+		case t == "{":
+			p.block(ctx)
+
+		case t == "(" || t == "[":
+			p.group(ctx)
+
+		default:
+			p.consume(ctx)
+		}
+
+		if depth <= 0 {
+			return
+		}
+	}
+}
+
+// rest consumes the tokens of a statement up to its end: a semicolon, the closing brace of the
+// enclosing block, or a line break ending the statement.
+func (p *Parser) rest(ctx *Context) {
+	for !p.atEOF() && !p.is("}") {
+		switch {
+		case p.is(";"):
+			p.consume(ctx)
+			return
+
+		case p.is("{"):
+			p.block(ctx)
+
+		case p.is("(") || p.is("["):
+			p.group(ctx)
+
+		default:
+			p.consume(ctx)
+		}
+
+		if p.atLineBreak() {
+			return
+		}
+	}
+}
+
+// atLineBreak returns true if a statement ends before the current token by automatic semicolon
+// insertion: a line break separates it from the previous token, the previous token can end a
+// statement, and the current one does not continue it.
+func (p *Parser) atLineBreak() bool {
+	if p.atEOF() {
+		return true
+	}
+
+	if p.pos == 0 || !p.newline[p.pos] {
+		return false
+	}
+
+	prev := p.tokens[p.pos-1]
+
+	if prev.GetTokenType() == TokenPunctuator && !closers[prev.GetText()] {
+		return false
+	}
+
+	switch next := p.current(); next.GetTokenType() {
+	case TokenPunctuator:
+		return openers[next.GetText()] || (next.GetText() == "{" && prev.GetText() == "}")
+
+	case TokenIdentifier:
+		return !continuations[next.GetText()]
+	}
+
+	return true
+}
+
+// skipDecorators returns the index of the first token after the decorators starting at the token i.
+func (p *Parser) skipDecorators(i int) int {
+	for p.text(i) == "@" && p.isWord(i+1) {
+		i += 2
+
+		for p.text(i) == "." && p.isWord(i+1) {
+			i += 2
+		}
+
+		if p.text(i) == "(" {
+			i = p.skipGroup(i)
+		}
+	}
+
+	return i
+}
+
+// skipGroup returns the index of the first token after the group of brackets starting at the token i.
+func (p *Parser) skipGroup(i int) int {
+	depth := 0
+
+	for ; i < len(p.tokens)-1; i++ {
+		switch p.text(i) {
+		case "(", "[", "{":
+			depth++
+
+		case ")", "]", "}":
+			depth--
+
+			if depth <= 0 {
+				return i + 1
+			}
+		}
+	}
+
+	return i
+}
+
+func (p *Parser) open(parent *Context, rule int) *Context {
+	ctx := &Context{}
+
+	if parent != nil {
+		antlr.InitBaseParserRuleContext(&ctx.BaseParserRuleContext, parent, -1)
+		parent.AddChild(ctx)
+	} else {
+		antlr.InitBaseParserRuleContext(&ctx.BaseParserRuleContext, nil, -1)
+	}
+
+	ctx.RuleIndex = rule
+	ctx.SetStart(p.current())
+
+	return ctx
+}
+
+// close sets the stop token of ctx to the last token consumed. Contexts consuming no token stop
+// before they start.
+func (p *Parser) close(ctx *Context) {
+	if p.pos > 0 {
+		ctx.SetStop(p.tokens[p.pos-1])
+	}
+}
+
+func (p *Parser) consume(ctx *Context) {
+	ctx.AddTokenNode(p.current())
+
+	if !p.atEOF() {
+		p.pos++
+	}
+}
+
+func (p *Parser) accept(ctx *Context, text string) bool {
+	if !p.is(text) {
+		return false
+	}
+
+	p.consume(ctx)
+
+	return true
+}
+
+func (p *Parser) current() antlr.Token { return p.tokens[p.pos] }
+func (p *Parser) atEOF() bool          { return p.tokenType(p.pos) == antlr.TokenEOF }
+func (p *Parser) is(text string) bool  { return p.text(p.pos) == text }
+func (p *Parser) isWord(i int) bool    { return p.tokenType(i) == TokenIdentifier }
+
+func (p *Parser) tokenType(i int) int {
+	if i < 0 || i >= len(p.tokens) {
+		return antlr.TokenEOF
+	}
+
+	return p.tokens[i].GetTokenType()
+}
+
+// text returns the text of the token i, or "" for EOF.
+func (p *Parser) text(i int) string {
+	if p.tokenType(i) == antlr.TokenEOF {
+		return ""
+	}
+
+	return p.tokens[i].GetText()
+}
+
+// joinText returns the text of the tokens from start up to stop, separated by spaces, without a
+// trailing semicolon.
+func (p *Parser) joinText(start, stop int) string {
+	var parts []string
+
+	for i := start; i < stop && i < len(p.tokens); i++ {
+		if t := p.text(i); t != "" && !(t == ";" && i == stop-1) {
+			parts = append(parts, t)
+		}
+	}
+
+	return strings.Join(parts, " ")
+}
+
+func (p *Parser) error(tk antlr.Token, msg string) {
+	p.listener.SyntaxError(nil, tk, tk.GetLine(), tk.GetColumn(), msg, nil)
+
+	if p.err == nil {
+		p.err = errors.Errorf("%d:%d: %s", tk.GetLine(), tk.GetColumn()+1, msg)
+	}
+}
@@ -0,0 +1,56 @@
+package yamllang
+
+import (
+	"strings"
+
+	"github.com/greenboxal/agibootstrap/pkg/psi/langs/datalang"
+)
+
+type format struct{}
+
+func (format) Parse(text string) (*datalang.Document, error) {
+	return parse(text)
+}
+
+// Merge merges the documents of src into the documents of dst, the first into the first and so on.
+// Documents of src beyond the documents of dst are appended.
+func (format) Merge(dst, src *datalang.Document, scope []string) (string, error) {
+	m := &datalang.ObjectMerger{
+		Dst:    dst,
+		Src:    src,
+		Indent: indent,
+		Insert: insert,
+	}
+
+	result, err := m.MergeDocument(scope)
+
+	if err != nil || len(dst.Values) == 0 || len(src.Values) <= len(dst.Values) {
+		return result, err
+	}
+
+	for _, v := range src.Values[len(dst.Values):] {
+		result = strings.TrimRight(result, "\n") + "\n---\n" + src.Text[v.Start:v.End] + "\n"
+	}
+
+	return result, nil
+}
+
+func (format) Indent(doc *datalang.Document, offset int) string {
+	return indent(doc, offset)
+}
+
+// indent returns the column of offset, since the continuation lines of the first member of a mapping
+// after a dash are indented as the member, not as the dash.
+func indent(doc *datalang.Document, offset int) string {
+	return doc.Column(offset)
+}
+
+// insert inserts entries after the last member of dst, aligned with its first member.
+func insert(m *datalang.ObjectMerger, dst *datalang.Value, entries []*datalang.Entry) {
+	last := dst.Entries[len(dst.Entries)-1]
+	member := indent(m.Dst, dst.Entries[0].Start)
+
+	for _, e := range entries {
+		m.Edits.Insert(last.End, "\n"+member+m.EntryText(e, member))
+	}
+}
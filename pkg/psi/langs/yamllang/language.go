@@ -0,0 +1,35 @@
+package yamllang
+
+import (
+	project2 "github.com/greenboxal/agibootstrap/pkg/platform/project"
+	"github.com/greenboxal/agibootstrap/pkg/psi"
+	"github.com/greenboxal/agibootstrap/pkg/psi/langs/datalang"
+)
+
+const LanguageID psi.LanguageID = "yaml"
+
+func init() {
+	project2.RegisterLanguage(LanguageID, NewLanguage)
+}
+
+// Language implements psi.Language for YAML. Streams of several documents are supported, and merged
+// document by document.
+type Language struct {
+	datalang.Language
+}
+
+func NewLanguage(p project2.Project) psi.Language {
+	l := &Language{}
+
+	l.Init(l, p, format{})
+
+	return l
+}
+
+func (l *Language) Name() psi.LanguageID {
+	return LanguageID
+}
+
+func (l *Language) Extensions() []string {
+	return []string{".yaml", ".yml"}
+}
@@ -0,0 +1,194 @@
+package yamllang
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/greenboxal/agibootstrap/pkg/build/codegen"
+	"github.com/greenboxal/agibootstrap/pkg/codex"
+	"github.com/greenboxal/agibootstrap/pkg/psi"
+)
+
+const testManifest = `kind: Deployment
+# TODO: Scale up
+spec:
+  replicas: 1
+  containers:
+    - name: app
+      image: app:1
+`
+
+func setupTestLanguage(t *testing.T) psi.Language {
+	p, err := codex.NewProject(context.Background(), t.TempDir())
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, p.Close()) })
+
+	return NewLanguage(p)
+}
+
+func TestParse(t *testing.T) {
+	testCases := []struct {
+		name string
+		code string
+		// path is resolved from the root of the file, and expected is the code of the node found.
+		path     string
+		expected string
+		err      bool
+	}{
+		{name: "key", code: testManifest, path: "#kind", expected: "kind: Deployment"},
+		{name: "nested key", code: testManifest, path: "#spec/#replicas", expected: "replicas: 1"},
+		{name: "item", code: testManifest, path: "#spec/#containers/@0/#image", expected: "image: app:1"},
+		{name: "comments", code: testManifest, path: "#spec", expected: "# TODO: Scale up\nspec:\n  replicas: 1\n  containers:\n    - name: app\n      image: app:1"},
+		{name: "flow", code: "a: {b: [1, 2]}\n", path: "#a/#b/@1", expected: "2"},
+		{name: "bad indentation", code: "a:\n  b: 1\n c: 2\n", err: true},
+		{name: "unterminated flow", code: "a: [1, 2\n", err: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			sf, err := setupTestLanguage(t).Parse("test.yaml", tc.code)
+
+			if tc.err {
+				require.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+
+			n, err := psi.ResolvePath(sf.Root(), psi.MustParsePath(tc.path))
+			require.NoError(t, err)
+
+			code, err := sf.ToCode(n)
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, code.Code)
+		})
+	}
+}
+
+func TestTodos(t *testing.T) {
+	sf, err := setupTestLanguage(t).Parse("test.yaml", testManifest)
+	require.NoError(t, err)
+
+	n, err := psi.ResolvePath(sf.Root(), psi.MustParsePath("#spec"))
+	require.NoError(t, err)
+	// TODO comments are returned in the syntax of the other languages, so they are processed the same.
+	require.Equal(t, []string{"// TODO: Scale up"}, n.Comments())
+}
+
+func TestMerge(t *testing.T) {
+	testCases := []struct {
+		name      string
+		generated string
+		// scope is the path of the node the code was generated for, the root of the file if empty.
+		scope    string
+		expected string
+		err      bool
+	}{
+		{
+			name:      "nested",
+			generated: "spec:\n  replicas: 3\n",
+			expected: `kind: Deployment
+# TODO: Scale up
+spec:
+  replicas: 3
+  containers:
+    - name: app
+      image: app:1
+`,
+		},
+		{
+			name:      "replace",
+			generated: "# Kind of the object.\nkind: StatefulSet\nspec:\n  containers: []\n",
+			expected: `# Kind of the object.
+kind: StatefulSet
+# TODO: Scale up
+spec:
+  replicas: 1
+  containers: []
+`,
+		},
+		{
+			name:      "append",
+			generated: "apiVersion: apps/v1\nspec:\n  paused: false\n",
+			expected: `kind: Deployment
+# TODO: Scale up
+spec:
+  replicas: 1
+  containers:
+    - name: app
+      image: app:1
+  paused: false
+apiVersion: apps/v1
+`,
+		},
+		{
+			name:      "documents",
+			generated: "kind: Deployment\n---\nkind: Service\n",
+			expected: `kind: Deployment
+# TODO: Scale up
+spec:
+  replicas: 1
+  containers:
+    - name: app
+      image: app:1
+---
+kind: Service
+`,
+		},
+		{
+			name:      "scope",
+			generated: "replicas: 3\n",
+			scope:     "#spec/#replicas",
+			expected: `kind: Deployment
+# TODO: Scale up
+spec:
+  replicas: 3
+  containers:
+    - name: app
+      image: app:1
+`,
+		},
+		{
+			name:      "conflicting changes",
+			generated: "spec: null\nreplicas: 3\n",
+			scope:     "#spec",
+			err:       true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			lang := setupTestLanguage(t)
+
+			sf, err := lang.Parse("test.yaml", testManifest)
+			require.NoError(t, err)
+
+			gen, err := lang.Parse("gen.yaml", tc.generated)
+			require.NoError(t, err)
+
+			scope := &codegen.NodeScope{Node: sf.Root()}
+
+			if tc.scope != "" {
+				scope.Node, err = psi.ResolvePath(sf.Root(), psi.MustParsePath(tc.scope))
+				require.NoError(t, err)
+			}
+
+			err = sf.MergeCompletionResults(context.Background(), scope, psi.NewCursor(), gen, gen.Root())
+
+			code, codeErr := sf.ToCode(sf.Root())
+			require.NoError(t, codeErr)
+
+			if tc.err {
+				// Rejected merges leave the file as it was.
+				require.Error(t, err)
+				require.Equal(t, testManifest, code.Code)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, code.Code)
+		})
+	}
+}
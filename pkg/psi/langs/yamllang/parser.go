@@ -0,0 +1,284 @@
+package yamllang
+
+import (
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/greenboxal/agibootstrap/pkg/psi/langs/datalang"
+)
+
+var errorLineRegexp = regexp.MustCompile(`line (\d+)`)
+
+// parser locates the values decoded by yaml.v3, which only reports where nodes start. Entries end
+// with the last line indented deeper than their key or dash.
+type parser struct {
+	doc   *datalang.Document
+	lines []int
+}
+
+func parse(text string) (*datalang.Document, error) {
+	p := &parser{doc: &datalang.Document{Text: text}, lines: []int{0}}
+
+	for i := 0; i < len(text); i++ {
+		if text[i] == '\n' {
+			p.lines = append(p.lines, i+1)
+		}
+	}
+
+	dec := yaml.NewDecoder(strings.NewReader(text))
+
+	for {
+		var n yaml.Node
+
+		if err := dec.Decode(&n); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, p.syntaxError(err)
+		}
+
+		if n.Kind != yaml.DocumentNode || len(n.Content) == 0 {
+			continue
+		}
+
+		root := n.Content[0]
+		v := p.value(root, p.entryEnd(root.Line-1, -1, -1, maxLine(root)))
+
+		p.doc.Values = append(p.doc.Values, v)
+	}
+
+	return p.doc, nil
+}
+
+// value locates n, whose text ends at end if it is a block collection or a scalar.
+func (p *parser) value(n *yaml.Node, end int) *datalang.Value {
+	v := &datalang.Value{Kind: datalang.KindScalar, Start: p.offset(n.Line, n.Column), End: end}
+
+	switch n.Kind {
+	case yaml.MappingNode:
+		v.Kind = datalang.KindObject
+
+	case yaml.SequenceNode:
+		v.Kind = datalang.KindArray
+
+	default:
+		return v
+	}
+
+	if n.Style&yaml.FlowStyle != 0 {
+		v.Inline = true
+		v.End = flowEnd(p.doc.Text, v.Start)
+	}
+
+	step := 1
+
+	if v.Kind == datalang.KindObject {
+		step = 2
+	}
+
+	for i := 0; i+step <= len(n.Content); i += step {
+		e := &datalang.Entry{}
+		item := n.Content[i]
+		start := p.offset(item.Line, item.Column)
+
+		if v.Kind == datalang.KindObject {
+			e.Key = item.Value
+			item = n.Content[i+1]
+		} else if !v.Inline {
+			// Items start at their dash.
+			if dash := strings.LastIndexByte(p.doc.Text[p.doc.LineStart(start):start], '-'); dash != -1 {
+				start = p.doc.LineStart(start) + dash
+			}
+		}
+
+		e.Start = start
+
+		if v.Inline {
+			e.End = v.End - 1
+
+			if i+step < len(n.Content) {
+				next := n.Content[i+step]
+				e.End = p.offset(next.Line, next.Column)
+			}
+
+			e.End = start + len(strings.TrimRight(p.doc.Text[start:e.End], " \t\r\n,"))
+		} else {
+			line := p.line(start)
+			column := start - p.lines[line]
+			seqColumn := -1
+
+			// Sequences may be indented as their key.
+			if item.Kind == yaml.SequenceNode && item.Style&yaml.FlowStyle == 0 && len(item.Content) > 0 {
+				first := p.offset(item.Content[0].Line, item.Content[0].Column)
+
+				if dash := strings.LastIndexByte(p.doc.Text[p.doc.LineStart(first):first], '-'); dash == column {
+					seqColumn = column
+				}
+			}
+
+			e.End = p.entryEnd(line, column, seqColumn, maxLine(item))
+		}
+
+		e.LeadStart = start
+
+		if strings.TrimSpace(p.doc.Text[p.doc.LineStart(start):start]) == "" {
+			e.LeadStart = p.doc.LeadStart(start, "#")
+			e.Comments = p.doc.Comments(e.LeadStart, start, "#")
+		}
+
+		if c := n.Content[i].LineComment; c != "" && v.Kind == datalang.KindObject {
+			e.Comments = append(e.Comments, datalang.Comment("#", c))
+		}
+
+		if c := item.LineComment; c != "" && item.Kind == yaml.ScalarNode {
+			e.Comments = append(e.Comments, datalang.Comment("#", c))
+		}
+
+		e.Value = p.value(item, e.End)
+		v.Entries = append(v.Entries, e)
+
+		if !v.Inline {
+			v.End = e.End
+		}
+	}
+
+	return v
+}
+
+// entryEnd returns the end of the entry starting at line, with its key or dash at column: the end of
+// the last line indented deeper than column, or at seqColumn and starting with a dash, and of
+// maxLine. Comments after the entry are left to the next.
+func (p *parser) entryEnd(line, column, seqColumn, maxLine int) int {
+	last := line
+
+	for i := line + 1; i < len(p.lines); i++ {
+		text := p.lineText(i)
+		trimmed := strings.TrimLeft(text, " ")
+		indent := len(text) - len(trimmed)
+
+		if trimmed == "" {
+			continue
+		}
+
+		if indent == 0 && (strings.HasPrefix(trimmed, "---") || strings.HasPrefix(trimmed, "...")) {
+			break
+		}
+
+		if indent > column || (indent == seqColumn && (trimmed == "-" || strings.HasPrefix(trimmed, "- "))) {
+			if !strings.HasPrefix(trimmed, "#") {
+				last = i
+			}
+
+			continue
+		}
+
+		break
+	}
+
+	if maxLine-1 > last && maxLine-1 < len(p.lines) {
+		last = maxLine - 1
+	}
+
+	return p.lines[last] + len(strings.TrimRight(p.lineText(last), " \t"))
+}
+
+func (p *parser) lineText(line int) string {
+	end := len(p.doc.Text)
+
+	if line+1 < len(p.lines) {
+		end = p.lines[line+1] - 1
+	}
+
+	return strings.TrimRight(p.doc.Text[p.lines[line]:end], "\r")
+}
+
+// line returns the line of offset, from 0.
+func (p *parser) line(offset int) int {
+	i := len(p.lines) - 1
+
+	for i > 0 && p.lines[i] > offset {
+		i--
+	}
+
+	return i
+}
+
+// offset returns the offset of a yaml.v3 position, whose line and column count characters from 1.
+func (p *parser) offset(line, column int) int {
+	if line < 1 || line > len(p.lines) {
+		return len(p.doc.Text)
+	}
+
+	offset := p.lines[line-1]
+
+	for i := 1; i < column && offset < len(p.doc.Text); i++ {
+		_, size := utf8.DecodeRuneInString(p.doc.Text[offset:])
+		offset += size
+	}
+
+	return offset
+}
+
+func (p *parser) syntaxError(err error) error {
+	msg := strings.TrimPrefix(err.Error(), "yaml: ")
+	offset := 0
+
+	if m := errorLineRegexp.FindStringSubmatch(msg); m != nil {
+		line, _ := strconv.Atoi(m[1])
+		offset = p.offset(line, 1)
+	}
+
+	return &datalang.SyntaxError{Offset: offset, Msg: msg}
+}
+
+// maxLine returns the last line of n and its descendants.
+func maxLine(n *yaml.Node) int {
+	result := n.Line
+
+	for _, c := range n.Content {
+		if l := maxLine(c); l > result {
+			result = l
+		}
+	}
+
+	return result
+}
+
+// flowEnd returns the end of the flow collection starting at offset.
+func flowEnd(text string, offset int) int {
+	depth := 0
+
+	for i := offset; i < len(text); i++ {
+		switch text[i] {
+		case '[', '{':
+			depth++
+
+		case ']', '}':
+			if depth--; depth == 0 {
+				return i + 1
+			}
+
+		case '"', '\'':
+			quote := text[i]
+
+			for i++; i < len(text) && text[i] != quote; i++ {
+				if text[i] == '\\' && quote == '"' {
+					i++
+				}
+			}
+
+		case '#':
+			if i > 0 && (text[i-1] == ' ' || text[i-1] == '\t') {
+				for i < len(text) && text[i] != '\n' {
+					i++
+				}
+			}
+		}
+	}
+
+	return len(text)
+}
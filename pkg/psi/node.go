@@ -120,8 +120,9 @@ type NodeBase struct {
 	edges      collectionsfx.MutableMap[EdgeKey, Edge]
 	attributes collectionsfx.MutableMap[string, any]
 
-	valid    bool
-	inUpdate bool
+	valid     bool
+	inUpdate  bool
+	restoring bool
 }
 
 // Init initializes the NodeBase struct with the given self node and uid string.
@@ -151,6 +152,10 @@ func (n *NodeBase) Init(self Node, uid string) {
 	}
 
 	obsfx.ObserveChange(&n.parent, func(old, new Node) {
+		if n.restoring {
+			return
+		}
+
 		if old != nil {
 			old.PsiNodeBase().RemoveChildNode(n.self)
 		}
@@ -167,6 +172,10 @@ func (n *NodeBase) Init(self Node, uid string) {
 	})
 
 	collectionsfx.ObserveList(&n.children, func(ev collectionsfx.ListChangeEvent[Node]) {
+		if n.restoring {
+			return
+		}
+
 		for ev.Next() {
 			if ev.WasPermutated() {
 				continue
@@ -179,8 +188,8 @@ func (n *NodeBase) Init(self Node, uid string) {
 					}
 
 					if child.Parent() != n.self {
-						child.attachToGraph(n.g)
 						child.SetParent(n.self)
+						child.attachToGraph(n.g)
 					}
 				}
 			}
@@ -198,6 +207,10 @@ func (n *NodeBase) Init(self Node, uid string) {
 	})
 
 	collectionsfx.ObserveMap(&n.edges, func(ev collectionsfx.MapChangeEvent[EdgeKey, Edge]) {
+		if n.restoring {
+			return
+		}
+
 		if ev.WasAdded {
 			ev.ValueAdded.attachToGraph(n.g)
 		}
@@ -206,6 +219,10 @@ func (n *NodeBase) Init(self Node, uid string) {
 	})
 
 	collectionsfx.ObserveMap(&n.attributes, func(ev collectionsfx.MapChangeEvent[string, any]) {
+		if n.restoring {
+			return
+		}
+
 		n.Invalidate()
 	})
 }
@@ -226,10 +243,14 @@ func (n *NodeBase) CanonicalPath() (res Path)                   { return n.path
 func (n *NodeBase) Parent() Node                                { return n.parent.Value() }
 func (n *NodeBase) ParentProperty() obsfx.ObservableValue[Node] { return &n.parent }
 
-func (n *NodeBase) Children() []Node                                 { return n.children.Slice() }
+func (n *NodeBase) Children() []Node                                 { return n.copyChildren() }
 func (n *NodeBase) ChildrenList() collectionsfx.ObservableList[Node] { return &n.children }
 func (n *NodeBase) ChildrenIterator() NodeIterator                   { return &nodeChildrenIterator{parent: n} }
 
+func (n *NodeBase) copyChildren() []Node {
+	return append([]Node(nil), n.children.Slice()...)
+}
+
 func (n *NodeBase) String() string {
 	return fmt.Sprintf("Node(%T, %d, %s)", n.self, n.id, n.uuid)
 }
@@ -319,6 +340,16 @@ func (n *NodeBase) SetParent(parent Node) {
 		panic("invalid parent (cycle)")
 	}
 
+	if parent != n.Parent() {
+		g := n.g
+
+		if g == nil && parent != nil {
+			g = parent.PsiNodeBase().g
+		}
+
+		n.recordMutation(g)
+	}
+
 	n.parent.SetValue(parent)
 }
 
@@ -336,6 +367,7 @@ func (n *NodeBase) AddChildNode(child Node) {
 		return
 	}
 
+	n.recordMutation(n.g)
 	n.children.Add(child)
 }
 
@@ -345,6 +377,11 @@ func (n *NodeBase) AddChildNode(child Node) {
 // Parameters:
 // - child: The child node to be removed.
 func (n *NodeBase) RemoveChildNode(child Node) {
+	if !n.children.Contains(child) {
+		return
+	}
+
+	n.recordMutation(n.g)
 	n.children.Remove(child)
 }
 
@@ -355,6 +392,8 @@ func (n *NodeBase) InsertChildrenAt(idx int, child Node) {
 		return
 	}
 
+	n.recordMutation(n.g)
+
 	if existingIdx != -1 {
 		if existingIdx >= idx {
 			existingIdx++
@@ -397,6 +436,8 @@ func (n *NodeBase) InsertChildAfter(anchor, node Node) {
 // - old: The old child node to be replaced.
 // - new: The new child node to replace the old child node.
 func (n *NodeBase) ReplaceChildNode(old, new Node) {
+	n.recordMutation(n.g)
+
 	idx := n.children.IndexOf(old)
 
 	if idx != -1 {
@@ -417,11 +458,19 @@ func (n *NodeBase) ReplaceChildNode(old, new Node) {
 	}
 }
 
+// Attributes returns a copy of the attributes of the node.
 func (n *NodeBase) Attributes() map[string]interface{} {
-	return n.attributes.Map()
+	result := make(map[string]interface{}, n.attributes.Len())
+
+	for it := n.attributes.Iterator(); it.Next(); {
+		result[it.Item().Key] = it.Item().Value
+	}
+
+	return result
 }
 
 func (n *NodeBase) SetAttribute(key string, value any) {
+	n.recordMutation(n.g)
 	n.attributes.Set(key, value)
 }
 
@@ -436,15 +485,21 @@ func (n *NodeBase) RemoveAttribute(key string) (value any, ok bool) {
 		return value, false
 	}
 
+	n.recordMutation(n.g)
 	n.attributes.Remove(key)
 
 	return
 }
 
+// Edges returns an iterator over a snapshot of the edges of the node.
 func (n *NodeBase) Edges() EdgeIterator {
-	return &nodeEdgeIterator{
-		n: n,
+	edges := make([]Edge, 0, n.edges.Len())
+
+	for it := n.edges.Iterator(); it.Next(); {
+		edges = append(edges, it.Item().Value)
 	}
+
+	return &edgeSliceIterator{edges: edges}
 }
 
 func (n *NodeBase) SetEdge(key EdgeReference, to Node) {
@@ -464,20 +519,42 @@ func (n *NodeBase) SetEdge(key EdgeReference, to Node) {
 		e = e.ReplaceTo(to)
 	}
 
+	n.recordMutation(n.g)
 	n.edges.Set(e.Key().GetKey(), e)
+
+	n.publishEdgeEvent(GraphEventEdgeSet, e)
 }
 
 func (n *NodeBase) UnsetEdge(key EdgeReference) {
 	k := key.GetKey()
 
-	_, ok := n.edges.Get(k)
+	e, ok := n.edges.Get(k)
 
 	if !ok {
 		return
 	}
 
+	n.recordMutation(n.g)
 	n.edges.Remove(k)
+
+	n.publishEdgeEvent(GraphEventEdgeUnset, e)
 }
+
+func (n *NodeBase) publishEdgeEvent(kind GraphEventKind, e Edge) {
+	if n.g == nil {
+		return
+	}
+
+	publishGraphEvent(n.g, GraphEvent{
+		Kind:       kind,
+		Node:       n.self,
+		Path:       n.CanonicalPath(),
+		Edge:       e,
+		OldVersion: n.version,
+		NewVersion: n.version,
+	})
+}
+
 func (n *NodeBase) GetEdge(key EdgeReference) Edge {
 	v, _ := n.edges.Get(key.GetKey())
 
@@ -510,6 +587,18 @@ func (n *NodeBase) attachToGraph(g Graph) {
 		n.id = g.NextNodeID()
 
 		n.g.Add(n.self)
+
+		// Parents are attached before their children, so the path of the node is known and
+		// subscribers filtering by path receive the event.
+		n.updatePath()
+
+		publishGraphEvent(g, GraphEvent{
+			Kind:       GraphEventNodeAdded,
+			Node:       n.self,
+			Path:       n.CanonicalPath(),
+			OldVersion: n.version,
+			NewVersion: n.version,
+		})
 	}
 
 	for it := n.children.Iterator(); it.Next(); {
@@ -550,6 +639,14 @@ func (n *NodeBase) detachFromGraph(g Graph) {
 
 	oldGraph.Remove(n.self)
 
+	publishGraphEvent(oldGraph, GraphEvent{
+		Kind:       GraphEventNodeRemoved,
+		Node:       n.self,
+		Path:       n.CanonicalPath(),
+		OldVersion: n.version,
+		NewVersion: n.version,
+	})
+
 	n.Invalidate()
 }
 
@@ -586,8 +683,11 @@ func (n *NodeBase) doUpdate(ctx context.Context, skipValidation bool) error {
 
 	n.valid = true
 
+	oldVersion := n.version
+	n.version++
+
 	if n.g != nil {
-		n.g.OnNodeUpdated(n.self)
+		notifyNodeUpdated(n.g, n.self, oldVersion)
 	}
 
 	return nil
@@ -614,8 +714,9 @@ func (n *NodeBase) updatePath() {
 		index := n.Parent().PsiNodeBase().IndexOfChild(n.self)
 
 		self = PathElement{
-			Kind:  EdgeKindChild,
-			Index: int64(index),
+			Kind:     EdgeKindChild,
+			Index:    int64(index),
+			HasIndex: true,
 		}
 	}
 
@@ -633,7 +734,7 @@ func (n *NodeBase) Invalidate() {
 		n.valid = false
 
 		if n.g != nil {
-			n.g.OnNodeInvalidated(n.self)
+			notifyNodeInvalidated(n.g, n.self)
 		}
 
 		if n.Parent() != nil {
@@ -1,62 +1,149 @@
 package psi
 
 import (
-	"fmt"
+	"encoding/json"
 	"strconv"
 	"strings"
 
-	"github.com/hashicorp/go-multierror"
-	"github.com/samber/lo"
+	"github.com/pkg/errors"
 )
 
+// ErrInvalidPath is returned when parsing a malformed path.
+var ErrInvalidPath = errors.New("invalid path")
+
+// pathSpecialChars are the characters escaped with a backslash in the kinds and names of path elements.
+const pathSpecialChars = "/#@:\\"
+
+// EdgeKindParent and EdgeKindSelf are the kinds of the path elements stepping to the parent of a
+// node and staying on it, written ".." and ".". They are not the kinds of edges, so children named
+// ".." or "." are written "#.." and "#.", and are never mistaken for them.
+var (
+	EdgeKindParent = EdgeKind("..")
+	EdgeKindSelf   = EdgeKind(".")
+)
+
+// PathElement is a component of a Path.
+//
+// Elements are written as [":" kind] ["#" name] ["@" index], where a leading bare name is a child
+// name, and the kind is omitted for children. The characters / # @ : and \ are escaped with a
+// backslash in kinds and names, so every element can be written and parsed back. The bare elements
+// ".." and "." are the parent and self steps, see EdgeKindParent.
 type PathElement struct {
 	Kind  EdgeKind
 	Name  string
 	Index int64
+
+	// HasIndex marks Index as present, so index 0 can be told apart from no index. Elements with a
+	// non-zero Index have an index even if HasIndex is not set.
+	HasIndex bool
+}
+
+// IndexPresent returns true if the element has an index.
+func (p PathElement) IndexPresent() bool {
+	return p.HasIndex || p.Index != 0
+}
+
+// Equal compares two elements, treating an empty kind as EdgeKindChild and a non-zero index as present.
+func (p PathElement) Equal(other PathElement) bool {
+	return p.kind() == other.kind() &&
+		p.Name == other.Name &&
+		p.Index == other.Index &&
+		p.IndexPresent() == other.IndexPresent()
+}
+
+func (p PathElement) kind() EdgeKind {
+	if p.Kind == "" {
+		return EdgeKindChild
+	}
+
+	return p.Kind
 }
 
 func (p PathElement) String() string {
-	str := ""
+	switch p.Kind {
+	case EdgeKindParent:
+		return ".."
+	case EdgeKindSelf:
+		return "."
+	}
 
-	if p.Kind != "" && p.Kind != EdgeKindChild {
-		str += fmt.Sprintf(":%s", p.Kind)
+	var sb strings.Builder
+
+	if p.kind() != EdgeKindChild {
+		sb.WriteByte(':')
+		sb.WriteString(escapePathString(string(p.Kind)))
 	}
 
 	if p.Name != "" {
-		str += fmt.Sprintf("#%s", p.Name)
+		sb.WriteByte('#')
+		sb.WriteString(escapePathString(p.Name))
 	}
 
-	if p.Index != 0 {
-		str += fmt.Sprintf("@%d", p.Index)
+	if p.IndexPresent() {
+		sb.WriteByte('@')
+		sb.WriteString(strconv.FormatInt(p.Index, 10))
 	}
 
-	return str
+	return sb.String()
 }
 
 func (p PathElement) IsEmpty() bool {
-	return p.Kind == "" && p.Name == "" && p.Index == 0
+	return p.Kind == "" && p.Name == "" && !p.IndexPresent()
+}
+
+func (p PathElement) MarshalText() ([]byte, error) {
+	return []byte(p.String()), nil
+}
+
+func (p *PathElement) UnmarshalText(text []byte) error {
+	parsed, err := ParsePathComponent(string(text))
+
+	if err != nil {
+		return err
+	}
+
+	*p = parsed
+
+	return nil
 }
 
+// Path is a sequence of path elements, written as the elements separated by slashes. Absolute
+// paths, like canonical paths, start with a slash. Relative paths, like the ones returned by
+// RelativeTo, do not, and may start with ".." elements.
 type Path struct {
 	root       Node
 	components []PathElement
+	relative   bool
 }
 
 //goland:noinspection GoMixedReceiverTypes
 func (p Path) MarshalJSON() ([]byte, error) {
-	return []byte(fmt.Sprintf("\"%s\"", p.String())), nil
+	return json.Marshal(p.String())
 }
 
 //goland:noinspection GoMixedReceiverTypes
 func (p *Path) UnmarshalJSON(data []byte) error {
-	str := string(data)
-
-	if str == "null" {
+	if string(data) == "null" {
 		return nil
 	}
 
-	str = strings.Trim(str, "\"")
-	parsed, err := ParsePath(str)
+	var str string
+
+	if err := json.Unmarshal(data, &str); err != nil {
+		return err
+	}
+
+	return p.UnmarshalText([]byte(str))
+}
+
+//goland:noinspection GoMixedReceiverTypes
+func (p Path) MarshalText() ([]byte, error) {
+	return []byte(p.String()), nil
+}
+
+//goland:noinspection GoMixedReceiverTypes
+func (p *Path) UnmarshalText(text []byte) error {
+	parsed, err := ParsePath(string(text))
 
 	if err != nil {
 		return err
@@ -64,58 +151,99 @@ func (p *Path) UnmarshalJSON(data []byte) error {
 
 	p.root = parsed.root
 	p.components = parsed.components
+	p.relative = parsed.relative
 
 	return nil
 }
 
+// ParsePathComponent parses a single path element. See PathElement.
 func ParsePathComponent(str string) (e PathElement, err error) {
+	switch str {
+	case "..":
+		return PathElement{Kind: EdgeKindParent}, nil
+	case ".":
+		return PathElement{Kind: EdgeKindSelf}, nil
+	}
+
+	var acc strings.Builder
+	var seen string
+
 	state := '#'
-	acc := ""
+	hasAcc := false
 
 	e.Kind = EdgeKindChild
 
-	endState := func() {
-		if acc == "" {
-			return
+	endState := func() error {
+		if !hasAcc && state == '#' {
+			return nil
 		}
 
+		if strings.ContainsRune(seen, state) {
+			return errors.Wrapf(ErrInvalidPath, "%q: duplicate %q", str, state)
+		}
+
+		seen += string(state)
+
 		switch state {
 		case '@':
-			e.Index, err = strconv.ParseInt(acc, 10, 64)
+			index, err := strconv.ParseInt(acc.String(), 10, 64)
 
 			if err != nil {
-				return
+				return errors.Wrapf(ErrInvalidPath, "%q: invalid index %q", str, acc.String())
 			}
+
+			e.Index = index
+			e.HasIndex = true
 		case '#':
-			e.Name += acc
+			e.Name = acc.String()
 		case ':':
-			e.Kind = EdgeKind(acc)
+			e.Kind = EdgeKind(acc.String())
 		}
 
-		acc = ""
+		acc.Reset()
+		hasAcc = false
+
+		return nil
 	}
 
+	escaped := false
+
 	for _, ch := range str {
+		if escaped {
+			acc.WriteRune(ch)
+			hasAcc = true
+			escaped = false
+
+			continue
+		}
+
 		switch ch {
-		case '@':
-			fallthrough
-		case '#':
-			fallthrough
-		case ':':
-			endState()
+		case '\\':
+			escaped = true
 
-			state = ch
+		case '@', '#', ':':
+			if err := endState(); err != nil {
+				return e, err
+			}
 
-			continue
+			state = ch
+			hasAcc = state != '#'
 
 		default:
-			acc += string(ch)
+			acc.WriteRune(ch)
+			hasAcc = true
 		}
 	}
 
-	endState()
+	if escaped {
+		return e, errors.Wrapf(ErrInvalidPath, "%q: trailing escape", str)
+	}
+
+	if err := endState(); err != nil {
+		return e, err
+	}
 
-	return
+	return e, nil
 }
 
 func MustParsePath(path string) Path {
@@ -128,28 +256,90 @@ func MustParsePath(path string) Path {
 	return p
 }
 
+// ParsePath parses a path written by Path.String. Paths starting with a slash are absolute, other
+// paths are relative. Empty elements are ignored.
 func ParsePath(path string) (Path, error) {
-	var err error
+	var components []PathElement
+
+	relative := !strings.HasPrefix(path, "/")
+
+	for _, str := range splitPath(path) {
+		if str == "" {
+			continue
+		}
+
+		c, err := ParsePathComponent(str)
+
+		if err != nil {
+			return Path{}, err
+		}
+
+		components = append(components, c)
+	}
+
+	result := PathFromComponents(components...)
+	result.relative = relative
+
+	return result, nil
+}
+
+// splitPath splits str at unescaped slashes.
+func splitPath(str string) (parts []string) {
+	start := 0
+	escaped := false
+
+	for i, ch := range str {
+		switch {
+		case escaped:
+			escaped = false
+		case ch == '\\':
+			escaped = true
+		case ch == '/':
+			parts = append(parts, str[start:i])
+			start = i + 1
+		}
+	}
+
+	return append(parts, str[start:])
+}
 
-	strs := strings.Split(path, "/")
+// indexUnescaped returns the index of the first unescaped character of chars in str, or -1.
+func indexUnescaped(str, chars string) int {
+	escaped := false
+
+	for i, ch := range str {
+		switch {
+		case escaped:
+			escaped = false
+		case ch == '\\':
+			escaped = true
+		case strings.ContainsRune(chars, ch):
+			return i
+		}
+	}
+
+	return -1
+}
 
-	if strs[0] == "" {
-		strs = strs[1:]
+func escapePathString(str string) string {
+	if !strings.ContainsAny(str, pathSpecialChars) {
+		return str
 	}
 
-	components := lo.Map(strs, func(str string, _ int) PathElement {
-		c, e := ParsePathComponent(str)
+	var sb strings.Builder
 
-		if e != nil {
-			e = multierror.Append(err, e)
+	for _, ch := range str {
+		if strings.ContainsRune(pathSpecialChars, ch) {
+			sb.WriteByte('\\')
 		}
 
-		return c
-	})
+		sb.WriteRune(ch)
+	}
 
-	return PathFromComponents(components...), err
+	return sb.String()
 }
 
+// PathFromComponents returns an absolute path with the given elements.
 func PathFromComponents(components ...PathElement) Path {
 	c := make([]PathElement, 0, len(components))
 	c = append(c, components...)
@@ -158,48 +348,119 @@ func PathFromComponents(components ...PathElement) Path {
 	}
 }
 
+// RelativePathFromComponents returns a relative path with the given elements.
+func RelativePathFromComponents(components ...PathElement) Path {
+	p := PathFromComponents(components...)
+	p.relative = true
+	return p
+}
+
 func (p Path) Parent() Path {
 	if len(p.components) == 0 {
 		return p
 	}
 
-	return PathFromComponents(p.components[:len(p.components)-1]...)
+	return p.withComponents(p.components[:len(p.components)-1]...)
 }
 
 func (p Path) Join(other Path) (res Path) {
 	var components []PathElement
 	components = append(components, p.components...)
 	components = append(components, other.components...)
-	return PathFromComponents(components...)
+	return p.withComponents(components...)
 }
 
 func (p Path) Child(name PathElement) (res Path) {
 	var components []PathElement
 	components = append(components, p.components...)
 	components = append(components, name)
-	return PathFromComponents(components...)
+	return p.withComponents(components...)
+}
+
+// withComponents returns a path with the given elements, absolute or relative like p.
+func (p Path) withComponents(components ...PathElement) Path {
+	result := PathFromComponents(components...)
+	result.relative = p.relative
+	return result
+}
+
+// IsRelative returns true for relative paths.
+func (p Path) IsRelative() bool {
+	return p.relative
+}
+
+// HasPrefix returns true if p is prefix or one of its descendants.
+func (p Path) HasPrefix(prefix Path) bool {
+	if len(prefix.components) > len(p.components) {
+		return false
+	}
+
+	for i, c := range prefix.components {
+		if !p.components[i].Equal(c) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// RelativeTo returns the relative path from base to p, made of ".." elements up to their common
+// ancestor followed by the remaining elements of p. Resolving the result from the node at base
+// yields the node at p. Both paths must be absolute, or both relative, and share a first element.
+func (p Path) RelativeTo(base Path) (Path, error) {
+	if p.relative != base.relative {
+		return Path{}, errors.Wrapf(ErrInvalidPath, "%s is not relative to %s", p, base)
+	}
+
+	common := 0
+
+	for common < len(p.components) && common < len(base.components) && p.components[common].Equal(base.components[common]) {
+		common++
+	}
+
+	if common == 0 && len(p.components) > 0 && len(base.components) > 0 {
+		return Path{}, errors.Wrapf(ErrInvalidPath, "%s and %s have no common root", p, base)
+	}
+
+	components := make([]PathElement, 0, len(base.components)-common+len(p.components)-common)
+
+	for i := common; i < len(base.components); i++ {
+		components = append(components, PathElement{Kind: EdgeKindParent})
+	}
+
+	components = append(components, p.components[common:]...)
+
+	return RelativePathFromComponents(components...), nil
 }
 
 func (p Path) Components() []PathElement {
 	return p.components
 }
 
-func (p Path) String() (res string) {
-	for _, component := range p.components {
-		res += "/" + component.String()
+// String writes the path in the format read by ParsePath.
+func (p Path) String() string {
+	var sb strings.Builder
+
+	for i, component := range p.components {
+		if i > 0 || !p.relative {
+			sb.WriteByte('/')
+		}
+
+		sb.WriteString(component.String())
 	}
 
-	if res == "" {
-		res = "/"
+	if sb.Len() == 0 && !p.relative {
+		return "/"
 	}
 
-	return
+	return sb.String()
 }
 
 func (p Path) WithRoot(root Node) Path {
 	return Path{
 		root:       root,
 		components: p.components,
+		relative:   p.relative,
 	}
 }
 
@@ -249,26 +510,23 @@ func ResolvePath(root Node, path Path) (Node, error) {
 	for i, component := range path.components {
 		if component.IsEmpty() {
 			if i == 0 {
-				component.Name = "/"
-			} else {
-				panic("empty path component")
-			}
-		}
-
-		if component.Kind == EdgeKindChild && component.Index == 0 {
-			if component.Name == "/" {
 				result = root
-			} else if component.Name == "." {
 				continue
-			} else if component.Name == ".." {
-				cn := result.Parent()
+			}
 
-				if cn != nil {
-					result = cn
-				}
+			panic("empty path component")
+		}
 
-				continue
+		switch component.Kind {
+		case EdgeKindSelf:
+			continue
+
+		case EdgeKindParent:
+			if cn := result.Parent(); cn != nil {
+				result = cn
 			}
+
+			continue
 		}
 
 		cn := result.ResolveChild(component)
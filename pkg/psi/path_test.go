@@ -0,0 +1,122 @@
+package psi
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPathRoundTrip(t *testing.T) {
+	p := PathFromComponents(
+		PathElement{Kind: EdgeKindChild, Name: "root"},
+		PathElement{Kind: EdgeKindChild, Name: "a/b#c@d:e\\f"},
+		PathElement{Kind: EdgeKindChild, Index: 0, HasIndex: true},
+		PathElement{Kind: "go:decl", Name: "func (*T).Run", Index: 2},
+	)
+
+	require.Equal(t, `/#root/#a\/b\#c\@d\:e\\f/@0/:go\:decl#func (*T).Run@2`, p.String())
+
+	parsed, err := ParsePath(p.String())
+	require.NoError(t, err)
+	require.Equal(t, p.String(), parsed.String())
+	require.True(t, parsed.HasPrefix(p) && p.HasPrefix(parsed))
+	require.True(t, parsed.Components()[2].IndexPresent())
+	require.False(t, parsed.Components()[1].IndexPresent())
+
+	data, err := json.Marshal(RawEdge{To: p})
+	require.NoError(t, err)
+
+	var edge RawEdge
+	require.NoError(t, json.Unmarshal(data, &edge))
+	require.Equal(t, p.String(), edge.To.String())
+
+	_, err = ParsePath("/#a@x")
+	require.ErrorIs(t, err, ErrInvalidPath)
+
+	_, err = ParsePath("/#a\\")
+	require.ErrorIs(t, err, ErrInvalidPath)
+}
+
+func TestPathRelativeTo(t *testing.T) {
+	base := MustParsePath("/#root/#a/#b")
+	p := MustParsePath("/#root/#c/@1")
+
+	rel, err := p.RelativeTo(base)
+	require.NoError(t, err)
+	require.True(t, rel.IsRelative())
+	require.Equal(t, "../../#c/@1", rel.String())
+
+	parsed, err := ParsePath(rel.String())
+	require.NoError(t, err)
+	require.True(t, parsed.IsRelative())
+	require.Equal(t, rel.String(), parsed.String())
+
+	self, err := base.RelativeTo(base)
+	require.NoError(t, err)
+	require.Equal(t, "", self.String())
+
+	_, err = p.RelativeTo(MustParsePath("/#other"))
+	require.ErrorIs(t, err, ErrInvalidPath)
+
+	root := newFrozenTestNode(nil, "root")
+	require.NoError(t, root.Update(context.Background()))
+
+	a := newFrozenTestNode(root, "a")
+	b := newFrozenTestNode(a, "b")
+	c := newFrozenTestNode(root, "c")
+
+	rel, err = c.CanonicalPath().RelativeTo(b.CanonicalPath())
+	require.NoError(t, err)
+
+	n, err := ResolvePath(b, rel)
+	require.NoError(t, err)
+	require.Equal(t, c, n)
+}
+
+func TestPathNavigationNames(t *testing.T) {
+	root := newFrozenTestNode(nil, "root")
+	require.NoError(t, root.Update(context.Background()))
+
+	a := newFrozenTestNode(root, "a")
+	parent := newFrozenTestNode(a, "..")
+	self := newFrozenTestNode(a, ".")
+	slash := newFrozenTestNode(a, "/")
+
+	testCases := []struct {
+		name     string
+		path     Path
+		str      string
+		expected Node
+	}{
+		{name: "child named ..", path: RelativePathFromComponents(PathElement{Kind: EdgeKindChild, Name: ".."}), str: "#..", expected: parent},
+		{name: "child named .", path: RelativePathFromComponents(PathElement{Kind: EdgeKindChild, Name: "."}), str: "#.", expected: self},
+		{name: "child named /", path: RelativePathFromComponents(PathElement{Kind: EdgeKindChild, Name: "/"}), str: `#\/`, expected: slash},
+		{name: "parent", path: RelativePathFromComponents(PathElement{Kind: EdgeKindParent}), str: "..", expected: root},
+		{name: "self", path: RelativePathFromComponents(PathElement{Kind: EdgeKindSelf}), str: ".", expected: a},
+		{name: "parent of child named ..", path: RelativePathFromComponents(PathElement{Kind: EdgeKindChild, Name: ".."}, PathElement{Kind: EdgeKindParent}), str: "#../..", expected: a},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.str, tc.path.String())
+
+			parsed, err := ParsePath(tc.str)
+			require.NoError(t, err)
+			require.True(t, parsed.HasPrefix(tc.path) && tc.path.HasPrefix(parsed))
+
+			n, err := ResolvePath(a, parsed)
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, n)
+		})
+	}
+
+	rel, err := a.CanonicalPath().RelativeTo(parent.CanonicalPath())
+	require.NoError(t, err)
+	require.Equal(t, "..", rel.String())
+
+	n, err := ResolvePath(parent, MustParsePath(rel.String()))
+	require.NoError(t, err)
+	require.Equal(t, a, n)
+}
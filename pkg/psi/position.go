@@ -0,0 +1,71 @@
+package psi
+
+import (
+	"fmt"
+	"go/token"
+)
+
+// SourcePosition is a position in the text of a source file.
+type SourcePosition struct {
+	// Offset is the byte offset, starting at 0.
+	Offset int `json:"offset"`
+	// Line is the line number, starting at 1.
+	Line int `json:"line"`
+	// Column is the byte offset in the line, starting at 1.
+	Column int `json:"column"`
+}
+
+// SourceRange is the range of text a node was parsed from, ending before End.
+type SourceRange struct {
+	File  string         `json:"file"`
+	Start SourcePosition `json:"start"`
+	End   SourcePosition `json:"end"`
+}
+
+// SourceRangeFromPositions converts a pair of go/token positions to a SourceRange.
+func SourceRangeFromPositions(start, end token.Position) SourceRange {
+	return SourceRange{
+		File:  start.Filename,
+		Start: SourcePosition{Offset: start.Offset, Line: start.Line, Column: start.Column},
+		End:   SourcePosition{Offset: end.Offset, Line: end.Line, Column: end.Column},
+	}
+}
+
+// SourceRangeFromOffsets returns the range between two byte offsets of f.
+func SourceRangeFromOffsets(f *token.File, start, end int) SourceRange {
+	return SourceRangeFromPositions(f.Position(f.Pos(start)), f.Position(f.Pos(end)))
+}
+
+// IsValid returns true if the range has a start position.
+func (r SourceRange) IsValid() bool {
+	return r.Start.Line > 0
+}
+
+// Contains returns true if offset is in the range.
+func (r SourceRange) Contains(offset int) bool {
+	return offset >= r.Start.Offset && offset < r.End.Offset
+}
+
+// String formats the range as file:line:column-line:column.
+func (r SourceRange) String() string {
+	return fmt.Sprintf("%s:%d:%d-%d:%d", r.File, r.Start.Line, r.Start.Column, r.End.Line, r.End.Column)
+}
+
+// SourceRangeProvider is implemented by source files that know where their nodes were parsed from.
+type SourceRangeProvider interface {
+	// NodeSourceRange returns the source range of n, a descendant of the source file. Nodes created
+	// after parsing, or parsed from other text, have no range.
+	NodeSourceRange(n Node) (SourceRange, bool)
+}
+
+// NodeSourceRange returns the source range of n, as known by the closest ancestor of n implementing
+// SourceRangeProvider, usually its source file.
+func NodeSourceRange(n Node) (SourceRange, bool) {
+	for p := n; p != nil; p = p.Parent() {
+		if provider, ok := p.(SourceRangeProvider); ok {
+			return provider.NodeSourceRange(n)
+		}
+	}
+
+	return SourceRange{}, false
+}
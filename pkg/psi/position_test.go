@@ -0,0 +1,47 @@
+package psi
+
+import (
+	"go/token"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type rangeTestFile struct {
+	frozenTestNode
+
+	ranges map[Node]SourceRange
+}
+
+func (f *rangeTestFile) NodeSourceRange(n Node) (SourceRange, bool) {
+	r, ok := f.ranges[n]
+
+	return r, ok
+}
+
+func TestNodeSourceRange(t *testing.T) {
+	text := "package main\n\nfunc main() {}\n"
+
+	file := token.NewFileSet().AddFile("main.go", -1, len(text))
+	file.SetLinesForContent([]byte(text))
+
+	sf := &rangeTestFile{ranges: map[Node]SourceRange{}}
+	sf.Init(sf, "")
+
+	decl := newFrozenTestNode(sf, "main")
+	body := newFrozenTestNode(decl, "body")
+
+	sf.ranges[decl] = SourceRangeFromOffsets(file, 14, 28)
+
+	r, ok := NodeSourceRange(decl)
+	require.True(t, ok)
+	require.Equal(t, "main.go:3:1-3:15", r.String())
+	require.Equal(t, "func main() {}", text[r.Start.Offset:r.End.Offset])
+	require.True(t, r.Contains(20))
+
+	_, ok = NodeSourceRange(body)
+	require.False(t, ok)
+
+	_, ok = NodeSourceRange(newFrozenTestNode(nil, "detached"))
+	require.False(t, ok)
+}
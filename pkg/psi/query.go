@@ -0,0 +1,527 @@
+package psi
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/pkg/errors"
+)
+
+// ErrInvalidQuery is returned when a query expression cannot be parsed.
+var ErrInvalidQuery = errors.New("invalid query")
+
+// QueryAxis selects the nodes a query step is applied to, relative to the current node.
+type QueryAxis int
+
+const (
+	// QueryAxisChild selects the children or edges matching the step path element.
+	QueryAxisChild QueryAxis = iota
+	// QueryAxisAnyChild selects every child.
+	QueryAxisAnyChild
+	// QueryAxisDescendantOrSelf selects the node and all of its descendants.
+	QueryAxisDescendantOrSelf
+	// QueryAxisSelf selects the node itself.
+	QueryAxisSelf
+	// QueryAxisParent selects the parent of the node.
+	QueryAxisParent
+)
+
+// QueryPredicate filters nodes by attribute.
+//
+// Besides the node attributes, the following pseudo attributes can be used when the node
+// has no attribute with the same name: name, type, uuid and comment. A node matches a
+// comment predicate if any of its comments matches.
+type QueryPredicate struct {
+	Attribute string
+	// Op is one of "" (the attribute exists), "=", "!=", "~=" (contains), "^=" (has prefix) or "$=" (has suffix).
+	Op    string
+	Value string
+}
+
+// QueryStep is a single step of a query, separated by "/" in the expression.
+type QueryStep struct {
+	Axis QueryAxis
+	// Element is the child or edge matched by QueryAxisChild. An edge name of "*" matches any edge of its kind.
+	Element PathElement
+	// Type filters nodes by type name. See NodeTypeName.
+	Type       string
+	Predicates []QueryPredicate
+}
+
+// QueryExpr is a parsed query expression.
+//
+// Queries extend paths with wildcards, node type filters and attribute predicates:
+//
+//	/pkg/agents/**/*<FuncDecl>[comment~=TODO]
+//
+// Each step is either a path element (#name, @index, :kind), "*" for any child, "**" for the
+// node and all of its descendants, "." or "..". "//" is a shorthand for "/**/". A step may be
+// followed by a type filter between angle brackets and by any number of predicates between
+// square brackets. Predicate values may be quoted with double or single quotes.
+type QueryExpr struct {
+	Steps []QueryStep
+}
+
+// Query evaluates the expression expr starting at root and returns the matching nodes in
+// depth-first order, without duplicates.
+func Query(root Node, expr string) (NodeIterator, error) {
+	q, err := ParseQuery(expr)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return q.Evaluate(root), nil
+}
+
+// ParseQuery parses a query expression.
+func ParseQuery(expr string) (QueryExpr, error) {
+	var q QueryExpr
+
+	parts, err := splitQuerySteps(expr)
+
+	if err != nil {
+		return q, err
+	}
+
+	for i, part := range parts {
+		if part == "" {
+			// Leading slash, or the empty step of "//".
+			if i == 0 || i == len(parts)-1 {
+				continue
+			}
+
+			q.Steps = append(q.Steps, QueryStep{Axis: QueryAxisDescendantOrSelf})
+
+			continue
+		}
+
+		step, err := parseQueryStep(part)
+
+		if err != nil {
+			return q, errors.Wrapf(ErrInvalidQuery, "%s: %s", expr, err)
+		}
+
+		q.Steps = append(q.Steps, step)
+	}
+
+	return q, nil
+}
+
+// MustParseQuery is like ParseQuery but panics if the expression is invalid.
+func MustParseQuery(expr string) QueryExpr {
+	q, err := ParseQuery(expr)
+
+	if err != nil {
+		panic(err)
+	}
+
+	return q
+}
+
+// Evaluate returns the nodes matched by the query starting at root.
+func (q QueryExpr) Evaluate(root Node) NodeIterator {
+	current := []Node{root}
+
+	for _, step := range q.Steps {
+		seen := map[Node]bool{}
+		next := make([]Node, 0, len(current))
+
+		for _, n := range current {
+			step.collect(n, func(candidate Node) {
+				if seen[candidate] || !step.Match(candidate) {
+					return
+				}
+
+				seen[candidate] = true
+				next = append(next, candidate)
+			})
+		}
+
+		current = next
+	}
+
+	sortDocumentOrder(root, current)
+
+	return &nodeSliceIterator{items: current}
+}
+
+// sortDocumentOrder sorts nodes in depth-first order of the tree under root.
+// Nodes outside of the tree, reached through edges or parents, are kept at the end.
+func sortDocumentOrder(root Node, nodes []Node) {
+	if len(nodes) < 2 {
+		return
+	}
+
+	order := make(map[Node]int, len(nodes))
+
+	for _, n := range nodes {
+		order[n] = -1
+	}
+
+	index := 0
+
+	var walk func(n Node)
+
+	walk = func(n Node) {
+		if _, ok := order[n]; ok {
+			order[n] = index
+		}
+
+		index++
+
+		for _, child := range n.Children() {
+			walk(child)
+		}
+	}
+
+	walk(root)
+
+	sort.SliceStable(nodes, func(i, j int) bool {
+		oi, oj := order[nodes[i]], order[nodes[j]]
+
+		if oi == -1 || oj == -1 {
+			return oj == -1 && oi != -1
+		}
+
+		return oi < oj
+	})
+}
+
+func (s QueryStep) collect(n Node, yield func(Node)) {
+	switch s.Axis {
+	case QueryAxisSelf:
+		yield(n)
+
+	case QueryAxisParent:
+		if p := n.Parent(); p != nil {
+			yield(p)
+		}
+
+	case QueryAxisAnyChild:
+		for _, child := range n.Children() {
+			yield(child)
+		}
+
+	case QueryAxisDescendantOrSelf:
+		var walk func(n Node)
+
+		walk = func(n Node) {
+			yield(n)
+
+			for _, child := range n.Children() {
+				walk(child)
+			}
+		}
+
+		walk(n)
+
+	case QueryAxisChild:
+		if s.Element.Kind != EdgeKindChild && s.Element.Name == "*" {
+			for it := n.Edges(); it.Next(); {
+				if e := it.Edge(); e.Kind() == s.Element.Kind {
+					yield(e.To())
+				}
+			}
+
+			return
+		}
+
+		if child := n.ResolveChild(s.Element); child != nil {
+			yield(child)
+		}
+	}
+}
+
+// Match returns true if n satisfies the type filter and the predicates of the step.
+func (s QueryStep) Match(n Node) bool {
+	if s.Type != "" && !matchNodeTypeName(NodeTypeName(n), s.Type) {
+		return false
+	}
+
+	for _, p := range s.Predicates {
+		if !p.Match(n) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Match returns true if n satisfies the predicate.
+func (p QueryPredicate) Match(n Node) bool {
+	values := queryAttributeValues(n, p.Attribute)
+
+	switch p.Op {
+	case "":
+		return len(values) > 0
+
+	case "!=":
+		return !(QueryPredicate{Attribute: p.Attribute, Op: "=", Value: p.Value}).Match(n)
+	}
+
+	for _, v := range values {
+		var ok bool
+
+		switch p.Op {
+		case "=":
+			ok = v == p.Value
+		case "~=":
+			ok = strings.Contains(v, p.Value)
+		case "^=":
+			ok = strings.HasPrefix(v, p.Value)
+		case "$=":
+			ok = strings.HasSuffix(v, p.Value)
+		}
+
+		if ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+func queryAttributeValues(n Node, name string) []string {
+	if v, ok := n.GetAttribute(name); ok {
+		return []string{fmt.Sprint(v)}
+	}
+
+	switch name {
+	case "name":
+		if named, ok := n.(NamedNode); ok {
+			return []string{named.PsiNodeName()}
+		}
+
+	case "type":
+		return []string{NodeTypeName(n)}
+
+	case "uuid":
+		return []string{n.UUID()}
+
+	case "comment":
+		return n.Comments()
+	}
+
+	return nil
+}
+
+// NodeTypeName returns the name of the type of n, see NodeTypeOf. Nodes without a NodeType are
+// named after their Go type.
+func NodeTypeName(n Node) string {
+	if typ := NodeTypeOf(n); typ != nil {
+		return typ.Name()
+	}
+
+	t := reflect.TypeOf(n)
+
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	return t.Name()
+}
+
+// matchNodeTypeName matches a type name against a filter, which may omit the namespace of the
+// type name, so "FuncDecl" matches "go.FuncDecl".
+func matchNodeTypeName(name, filter string) bool {
+	if name == filter {
+		return true
+	}
+
+	if idx := strings.LastIndexByte(name, '.'); idx >= 0 {
+		return name[idx+1:] == filter
+	}
+
+	return false
+}
+
+// splitQuerySteps splits expr at unescaped slashes outside of predicates.
+func splitQuerySteps(expr string) ([]string, error) {
+	var parts []string
+	var quote rune
+
+	depth := 0
+	start := 0
+	escaped := false
+
+	for i, ch := range expr {
+		switch {
+		case escaped:
+			escaped = false
+
+		case ch == '\\' && quote == 0:
+			escaped = true
+
+		case quote != 0:
+			if ch == quote {
+				quote = 0
+			}
+
+		case ch == '"' || ch == '\'':
+			if depth == 0 {
+				return nil, errors.Wrapf(ErrInvalidQuery, "%s: quote outside of predicate at %d", expr, i)
+			}
+
+			quote = ch
+
+		case ch == '[':
+			depth++
+
+		case ch == ']':
+			if depth == 0 {
+				return nil, errors.Wrapf(ErrInvalidQuery, "%s: unbalanced ']' at %d", expr, i)
+			}
+
+			depth--
+
+		case ch == '/' && depth == 0:
+			parts = append(parts, expr[start:i])
+			start = i + 1
+		}
+	}
+
+	if quote != 0 || depth != 0 {
+		return nil, errors.Wrapf(ErrInvalidQuery, "%s: unterminated predicate", expr)
+	}
+
+	return append(parts, expr[start:]), nil
+}
+
+func parseQueryStep(str string) (step QueryStep, err error) {
+	end := indexUnescaped(str, "<[")
+
+	if end == -1 {
+		end = len(str)
+	}
+
+	selector := str[:end]
+	rest := str[end:]
+
+	switch selector {
+	case "**":
+		step.Axis = QueryAxisDescendantOrSelf
+	case "*", "":
+		step.Axis = QueryAxisAnyChild
+	case ".":
+		step.Axis = QueryAxisSelf
+	case "..":
+		step.Axis = QueryAxisParent
+	default:
+		step.Axis = QueryAxisChild
+		step.Element, err = ParsePathComponent(selector)
+
+		if err != nil {
+			return step, err
+		}
+	}
+
+	if strings.HasPrefix(rest, "<") {
+		closing := strings.IndexByte(rest, '>')
+
+		if closing == -1 {
+			return step, errors.New("unterminated type filter")
+		}
+
+		step.Type = strings.TrimSpace(rest[1:closing])
+		rest = rest[closing+1:]
+
+		if step.Type == "" {
+			return step, errors.New("empty type filter")
+		}
+	}
+
+	for rest != "" {
+		if rest[0] != '[' {
+			return step, errors.Errorf("unexpected %q", rest)
+		}
+
+		var p QueryPredicate
+
+		p, rest, err = parseQueryPredicate(rest[1:])
+
+		if err != nil {
+			return step, err
+		}
+
+		step.Predicates = append(step.Predicates, p)
+	}
+
+	if selector == "" && step.Type == "" && len(step.Predicates) == 0 {
+		return step, errors.New("empty step")
+	}
+
+	return step, nil
+}
+
+var queryPredicateOps = []string{"!=", "~=", "^=", "$=", "="}
+
+// parseQueryPredicate parses a predicate after its opening bracket, returning the remaining input.
+func parseQueryPredicate(str string) (p QueryPredicate, rest string, err error) {
+	end := strings.IndexAny(str, "!~^$=]")
+
+	if end == -1 {
+		return p, "", errors.New("unterminated predicate")
+	}
+
+	p.Attribute = strings.TrimSpace(str[:end])
+	str = str[end:]
+
+	if p.Attribute == "" {
+		return p, "", errors.New("predicate without attribute")
+	}
+
+	if strings.IndexFunc(p.Attribute, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_' && r != '-' && r != '.'
+	}) != -1 {
+		return p, "", errors.Errorf("invalid attribute name %q", p.Attribute)
+	}
+
+	if str[0] == ']' {
+		return p, str[1:], nil
+	}
+
+	for _, op := range queryPredicateOps {
+		if strings.HasPrefix(str, op) {
+			p.Op = op
+			str = str[len(op):]
+			break
+		}
+	}
+
+	if p.Op == "" {
+		return p, "", errors.Errorf("invalid predicate operator in %q", str)
+	}
+
+	str = strings.TrimLeft(str, " ")
+
+	if str != "" && (str[0] == '"' || str[0] == '\'') {
+		closing := strings.IndexByte(str[1:], str[0])
+
+		if closing == -1 {
+			return p, "", errors.New("unterminated string")
+		}
+
+		p.Value = str[1 : closing+1]
+		str = strings.TrimLeft(str[closing+2:], " ")
+
+		if str == "" || str[0] != ']' {
+			return p, "", errors.New("expected ']' after predicate value")
+		}
+
+		return p, str[1:], nil
+	}
+
+	closing := strings.IndexByte(str, ']')
+
+	if closing == -1 {
+		return p, "", errors.New("unterminated predicate")
+	}
+
+	p.Value = strings.TrimSpace(str[:closing])
+
+	return p, str[closing+1:], nil
+}
@@ -0,0 +1,70 @@
+package psi
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type queryTestNode struct {
+	NodeBase
+
+	name     string
+	comments []string
+}
+
+func (n *queryTestNode) PsiNodeName() string { return n.name }
+func (n *queryTestNode) Comments() []string  { return n.comments }
+
+func newQueryTestNode(parent Node, name string, comments ...string) *queryTestNode {
+	n := &queryTestNode{name: name, comments: comments}
+	n.Init(n, "")
+
+	if parent != nil {
+		n.SetParent(parent)
+	}
+
+	return n
+}
+
+func queryNames(t *testing.T, root Node, expr string) []string {
+	it, err := Query(root, expr)
+	require.NoError(t, err)
+
+	var names []string
+
+	for it.Next() {
+		names = append(names, it.Node().(*queryTestNode).name)
+	}
+
+	return names
+}
+
+func TestQuery(t *testing.T) {
+	root := newQueryTestNode(nil, "root")
+	pkg := newQueryTestNode(root, "pkg")
+	agents := newQueryTestNode(pkg, "agents")
+	a := newQueryTestNode(agents, "a", "// TODO: implement")
+	b := newQueryTestNode(agents, "b")
+	other := newQueryTestNode(pkg, "other")
+	c := newQueryTestNode(other, "c", "// TODO: later")
+
+	a.SetAttribute("kind", "func")
+	c.SetAttribute("kind", "func")
+	_ = b
+
+	require.Equal(t, []string{"agents"}, queryNames(t, root, "/pkg/agents"))
+	require.Equal(t, []string{"agents", "other"}, queryNames(t, root, "/pkg/*"))
+	require.Equal(t, []string{"pkg", "agents", "a", "b", "other", "c"}, queryNames(t, root, "/**/*"))
+	require.Equal(t, []string{"a", "c"}, queryNames(t, root, "//*[comment~=TODO]"))
+	require.Equal(t, []string{"a"}, queryNames(t, root, "/pkg/agents/**/*<queryTestNode>[comment~=TODO]"))
+	require.Equal(t, []string{"a", "c"}, queryNames(t, root, "**[kind=func]"))
+	require.Equal(t, []string{"b"}, queryNames(t, root, "/pkg/agents/*[kind!=\"func\"]"))
+	require.Equal(t, []string{"pkg"}, queryNames(t, root, "/pkg/agents/.."))
+	require.Empty(t, queryNames(t, root, "/pkg/*<FuncDecl>"))
+
+	for _, expr := range []string{"/pkg/*[kind", "/pkg/*[kind=x]]", "/pkg/*<x", "/pkg/*[kind>x]"} {
+		_, err := ParseQuery(expr)
+		require.ErrorIs(t, err, ErrInvalidQuery, expr)
+	}
+}
@@ -1,55 +1,43 @@
-//go:build selfwip
-// +build selfwip
-
 package psi
 
-// Reference:
-//type Node interface {
-//	ID() int64
-//	UUID() string
-//	Node() *NodeBase
-//	Parent() Node
-//	Children() []Node
-//
-//	Ast() dst.Node
-//
-//	IsContainer() bool
-//	IsLeaf() bool
-//
-//	Comments() []string
-//
-//	attachToGraph(g *Graph)
-//	detachFromGraph(g *Graph)
-//	SetParent(parent Node)
-//	AddChildNode(node Node)
-//	RemoveChildNode(node Node)
-//}
+import "context"
 
-// Retriever is an interface that can be used to retrieve nodes from a graph
-type Retriever struct {
-}
+// Edge kinds of the G_N_References and G_N_Implementations subgraphs. Languages that can
+// resolve references populate them on the nodes of their source files, see ReferenceResolver.
+const (
+	// EdgeKindDeclaration links the use of an identifier to the declaration it refers to.
+	EdgeKindDeclaration EdgeKind = "Declaration"
+	// EdgeKindReference links a call site to the declaration of its callee.
+	EdgeKindReference EdgeKind = "Reference"
+	// EdgeKindImplementation links an interface to each of its implementations, named after the implementation.
+	EdgeKindImplementation EdgeKind = "Implementation"
+	// EdgeKindInclude links a source file to each file it includes, named after the included path.
+	EdgeKindInclude EdgeKind = "Include"
+)
 
-// NewRetriever creates a new instance of the Retriever
-func NewRetriever() *Retriever {
-	return &Retriever{}
-}
+// ReferenceEdgeKinds are the edge kinds followed by Retriever by default.
+var ReferenceEdgeKinds = []EdgeKind{EdgeKindDeclaration, EdgeKindReference, EdgeKindImplementation, EdgeKindInclude}
 
-// ReferenceIteratorImpl is an implementation of the ReferenceIterator interface
-type ReferenceIteratorImpl struct {
-	root    Node
-	current Reference
+// ReferenceResolver is implemented by languages that can resolve references between nodes.
+// ResolveReferences replaces the reference edges previously set on the nodes of files.
+type ReferenceResolver interface {
+	ResolveReferences(ctx context.Context, files []SourceFile) error
 }
 
 // Reference represents a reference between two nodes
 type Reference struct {
-	// Referer is the node that is referencing the
+	// Referer is the node that is referencing the referee
 	Referer Node
 	// Referee is the node that is being referenced
 	Referee Node
+	// Kind is the kind of the edge between the referer and the referee
+	Kind EdgeKind
 
-	// DeclarationDistance is the distance between the declaration of the referee and the reference
+	// DeclarationDistance is the distance between the declaration of the referee and the reference,
+	// in hops through the tree. It is -1 if they are not in the same tree.
 	DeclarationDistance int
-	// ReferenceDistance is the distance between the reference and the declaration of the referee
+	// ReferenceDistance is the distance between the reference and the declaration of the referee,
+	// in reference edges followed from the retrieval root.
 	ReferenceDistance int
 }
 
@@ -61,15 +49,58 @@ type ReferenceIterator interface {
 	Reference() Reference
 }
 
-// ReferenceIteratorImpl is an implementation of the ReferenceIterator interface
-type referenceIteratorImpl struct {
-	root Node
+// RetrieverOption configures a Retriever.
+type RetrieverOption func(r *Retriever)
+
+// WithMaxReferenceDistance sets how many reference edges are followed from the retrieval root.
+func WithMaxReferenceDistance(distance int) RetrieverOption {
+	return func(r *Retriever) {
+		r.maxReferenceDistance = distance
+	}
+}
 
+// WithReferenceEdgeKinds sets the edge kinds followed by the Retriever.
+func WithReferenceEdgeKinds(kinds ...EdgeKind) RetrieverOption {
+	return func(r *Retriever) {
+		r.edgeKinds = kinds
+	}
+}
+
+// Retriever is an interface that can be used to retrieve nodes from a graph
+type Retriever struct {
+	maxReferenceDistance int
+	edgeKinds            []EdgeKind
+}
+
+// NewRetriever creates a new instance of the Retriever.
+// By default, only the references of the retrieval root subtree are retrieved.
+func NewRetriever(options ...RetrieverOption) *Retriever {
+	r := &Retriever{
+		maxReferenceDistance: 1,
+		edgeKinds:            ReferenceEdgeKinds,
+	}
+
+	for _, opt := range options {
+		opt(r)
+	}
+
+	return r
+}
+
+type referenceIteratorImpl struct {
+	items   []Reference
 	current Reference
 }
 
 func (r *referenceIteratorImpl) Next() bool {
-	return false
+	if len(r.items) == 0 {
+		return false
+	}
+
+	r.current = r.items[0]
+	r.items = r.items[1:]
+
+	return true
 }
 
 // Reference returns the current reference
@@ -79,10 +110,86 @@ func (r *referenceIteratorImpl) Reference() Reference {
 
 // Retrieve returns a reference iterator that can be used to iterate over all references in the graph
 // starting from the given root node.
+//
+// The reference edges of every node in the subtree of root are retrieved with a ReferenceDistance of 1.
+// Up to the maximum reference distance, the edges of the subtrees of the referees are retrieved
+// in turn, one hop further away. Each referee subtree is only visited once.
 func (r *Retriever) Retrieve(root Node) (ReferenceIterator, error) {
-	return &referenceIteratorImpl{
-		root: root,
-	}, nil
+	var result []Reference
+
+	visited := map[Node]bool{root: true}
+	frontier := []Node{root}
+
+	for distance := 1; distance <= r.maxReferenceDistance && len(frontier) > 0; distance++ {
+		var next []Node
+
+		for _, subtree := range frontier {
+			walkSubtree(subtree, func(n Node) {
+				for it := n.Edges(); it.Next(); {
+					e := it.Edge()
+
+					if !r.followsEdge(e.Kind()) || e.To() == nil {
+						continue
+					}
+
+					referee := e.To()
+
+					result = append(result, Reference{
+						Referer:             n,
+						Referee:             referee,
+						Kind:                e.Kind(),
+						DeclarationDistance: DeclarationDistance(n, referee),
+						ReferenceDistance:   distance,
+					})
+
+					if !visited[referee] {
+						visited[referee] = true
+						next = append(next, referee)
+					}
+				}
+			})
+		}
+
+		frontier = next
+	}
+
+	return &referenceIteratorImpl{items: result}, nil
+}
+
+func (r *Retriever) followsEdge(kind EdgeKind) bool {
+	for _, k := range r.edgeKinds {
+		if k == kind {
+			return true
+		}
+	}
+
+	return false
+}
+
+func walkSubtree(n Node, fn func(n Node)) {
+	fn(n)
+
+	for _, child := range n.Children() {
+		walkSubtree(child, fn)
+	}
+}
+
+// DeclarationDistance returns the number of hops between a and b through their closest common
+// ancestor, or -1 if they are not in the same tree.
+func DeclarationDistance(a, b Node) int {
+	depths := map[Node]int{}
+
+	for n, depth := a, 0; n != nil; n, depth = n.Parent(), depth+1 {
+		depths[n] = depth
+	}
+
+	for n, depth := b, 0; n != nil; n, depth = n.Parent(), depth+1 {
+		if d, ok := depths[n]; ok {
+			return d + depth
+		}
+	}
+
+	return -1
 }
 
 // G is a graph network, analogous to a file directory system containing files. For each file that contains code, the Abstract Syntax Tree (AST) for that code is treated as a child node connected to the parent node that represents the file.
@@ -0,0 +1,44 @@
+package psi
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetriever(t *testing.T) {
+	root := newQueryTestNode(nil, "root")
+	file := newQueryTestNode(root, "file")
+	caller := newQueryTestNode(file, "caller")
+	call := newQueryTestNode(caller, "call")
+	callee := newQueryTestNode(file, "callee")
+	use := newQueryTestNode(callee, "use")
+	typ := newQueryTestNode(root, "type")
+
+	call.SetEdge(EdgeKey{Kind: EdgeKindReference}, callee)
+	use.SetEdge(EdgeKey{Kind: EdgeKindDeclaration}, typ)
+
+	collect := func(r *Retriever) []Reference {
+		it, err := r.Retrieve(caller)
+		require.NoError(t, err)
+
+		var refs []Reference
+
+		for it.Next() {
+			refs = append(refs, it.Reference())
+		}
+
+		return refs
+	}
+
+	refs := collect(NewRetriever())
+	require.Len(t, refs, 1)
+	require.Equal(t, Reference{Referer: call, Referee: callee, Kind: EdgeKindReference, DeclarationDistance: 3, ReferenceDistance: 1}, refs[0])
+
+	refs = collect(NewRetriever(WithMaxReferenceDistance(2)))
+	require.Len(t, refs, 2)
+	require.Equal(t, Reference{Referer: use, Referee: typ, Kind: EdgeKindDeclaration, DeclarationDistance: 4, ReferenceDistance: 2}, refs[1])
+
+	require.Empty(t, collect(NewRetriever(WithReferenceEdgeKinds(EdgeKindImplementation))))
+	require.Equal(t, -1, DeclarationDistance(call, newQueryTestNode(nil, "other")))
+}
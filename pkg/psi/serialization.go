@@ -2,9 +2,12 @@ package psi
 
 import (
 	"encoding/json"
+	"reflect"
+	"sort"
 
 	"github.com/ipfs/go-cid"
 	"github.com/multiformats/go-multihash"
+	"github.com/pkg/errors"
 )
 
 type PhysAddr = cid.Cid
@@ -24,6 +27,8 @@ type RawAttribute struct {
 type RawEdge struct {
 	Key EdgeKey `json:"key"`
 	To  Path    `json:"to"`
+	// ToUUID is the UUID of the target node, used to resolve the edge when thawing.
+	ToUUID string `json:"toUUID,omitempty"`
 }
 
 type RawNodeEntry struct {
@@ -33,19 +38,36 @@ type RawNodeEntry struct {
 }
 
 type RawNode struct {
-	ID         int64          `json:"ID"`
-	UUID       string         `json:"UUID"`
+	ID   int64  `json:"ID"`
+	UUID string `json:"UUID"`
+	// Type is the name of the node type, see NodeTypeName.
+	Type string `json:"Type,omitempty"`
+	// Name is the name of NamedNode nodes.
+	Name       string         `json:"Name,omitempty"`
 	Attributes []RawAttribute `json:"Attributes"`
 	Edges      []RawEdge      `json:"Edges"`
 	Children   []RawNodeEntry `json:"Children"`
 }
 
+// Freezer stores frozen nodes in a content-addressed store.
+//
+// A frozen node refers to its children by their address, so the address of a node changes whenever
+// the node or any of its descendants changes, like in a Merkle tree.
 type Freezer struct {
 	Cas   map[cid.Cid][]byte  `json:"Cas"`
 	Cache map[cid.Cid]RawNode `json:"-"`
 	IdMap map[NodeID]cid.Cid  `json:"IdMap"`
 }
 
+// NewFreezer creates an empty Freezer.
+func NewFreezer() *Freezer {
+	return &Freezer{
+		Cas:   map[cid.Cid][]byte{},
+		Cache: map[cid.Cid]RawNode{},
+		IdMap: map[NodeID]cid.Cid{},
+	}
+}
+
 func (f *Freezer) GetByID(id NodeID) (RawNode, bool) {
 	addr, ok := f.IdMap[id]
 
@@ -72,49 +94,217 @@ func (f *Freezer) Get(id cid.Cid) (RawNode, bool) {
 			return RawNode{}, false
 		}
 
+		if f.Cache == nil {
+			f.Cache = map[cid.Cid]RawNode{}
+		}
+
 		f.Cache[id] = node
 	}
 
-	return node, ok
+	return node, true
 }
 
-func (f *Freezer) Add(n Node) (entry RawNodeEntry) {
-	if _, ok := f.IdMap[n.UUID()]; ok {
-		return
+// Add freezes n and its descendants, and returns the entry of n.
+// Nodes are frozen once per Freezer: adding a node again returns the address it was first frozen at,
+// so each version of a tree should be frozen by its own Freezer.
+func (f *Freezer) Add(n Node) (RawNodeEntry, error) {
+	if addr, ok := f.IdMap[n.UUID()]; ok {
+		return RawNodeEntry{UUID: n.UUID(), PhysAddr: addr}, nil
 	}
 
 	frozen := RawNode{}
 	frozen.ID = n.ID()
 	frozen.UUID = n.UUID()
-	frozen.Children = make([]RawNodeEntry, len(n.Children()))
+	frozen.Type = NodeTypeName(n)
+	frozen.Children = make([]RawNodeEntry, 0, len(n.Children()))
 	frozen.Edges = make([]RawEdge, 0)
 	frozen.Attributes = make([]RawAttribute, 0)
 
-	for i, child := range n.Children() {
-		childEntry := f.Add(child)
+	if named, ok := n.(NamedNode); ok {
+		frozen.Name = named.PsiNodeName()
+	}
+
+	for _, child := range n.Children() {
+		childEntry, err := f.Add(child)
+
+		if err != nil {
+			return RawNodeEntry{}, err
+		}
 
-		frozen.Children[i] = childEntry
+		frozen.Children = append(frozen.Children, childEntry)
 	}
 
 	for it := n.Edges(); it.Next(); {
+		to := it.Edge().To()
+
+		if to == nil {
+			continue
+		}
+
 		frozen.Edges = append(frozen.Edges, RawEdge{
-			Key: it.Edge().Key().GetKey(),
-			To:  it.Edge().To().CanonicalPath(),
+			Key:    it.Edge().Key().GetKey(),
+			To:     to.CanonicalPath(),
+			ToUUID: to.UUID(),
 		})
 	}
 
+	sort.Slice(frozen.Edges, func(i, j int) bool {
+		return frozen.Edges[i].Key.String() < frozen.Edges[j].Key.String()
+	})
+
+	for k, v := range n.Attributes() {
+		frozen.Attributes = append(frozen.Attributes, RawAttribute{Key: k, Value: v})
+	}
+
+	sort.Slice(frozen.Attributes, func(i, j int) bool {
+		return frozen.Attributes[i].Key < frozen.Attributes[j].Key
+	})
+
 	data, err := json.Marshal(frozen)
 
 	if err != nil {
-		panic(err)
+		return RawNodeEntry{}, errors.Wrapf(err, "failed to freeze node %s", n.UUID())
 	}
 
 	mh, err := multihash.Sum(data, multihash.SHA2_256, -1)
+
+	if err != nil {
+		return RawNodeEntry{}, err
+	}
+
 	addr := cid.NewCidV1(cid.Raw, mh)
 
 	f.Cas[addr] = data
 	f.Cache[addr] = frozen
 	f.IdMap[n.UUID()] = addr
 
-	return
+	return RawNodeEntry{UUID: n.UUID(), PhysAddr: addr}, nil
+}
+
+// ThawableNode is implemented by nodes that restore state kept outside of their attributes,
+// such as their name, when they are thawed.
+type ThawableNode interface {
+	Node
+
+	// ThawNode is called after the node is initialized and its attributes are set, before its
+	// children are attached.
+	ThawNode(raw RawNode) error
+}
+
+// UnknownNode stands for a thawed node whose type is not registered, or is not a struct type
+// that can be instantiated.
+type UnknownNode struct {
+	NodeBase
+
+	// Raw is the frozen node.
+	Raw RawNode
+}
+
+// namedUnknownNode is an UnknownNode thawed from a NamedNode, so it keeps its path.
+type namedUnknownNode struct {
+	UnknownNode
+}
+
+func (n *namedUnknownNode) PsiNodeName() string { return n.Raw.Name }
+
+// Thaw rebuilds the live tree of nodes frozen at addr. Nodes are instantiated from the node types
+// registered with RegisterNodeType, falling back to UnknownNode, and keep their UUID.
+//
+// Attribute values are thawed as decoded from JSON. Edges are resolved once the whole tree is
+// rebuilt; edges to nodes outside of the tree are dropped.
+func (f *Freezer) Thaw(addr cid.Cid) (Node, error) {
+	thawed := map[NodeID]Node{}
+	edges := map[Node][]RawEdge{}
+
+	root, err := f.thawNode(addr, thawed, edges)
+
+	if err != nil {
+		return nil, err
+	}
+
+	for n, rawEdges := range edges {
+		for _, e := range rawEdges {
+			to, ok := thawed[e.ToUUID]
+
+			if !ok {
+				continue
+			}
+
+			n.SetEdge(e.Key, to)
+		}
+	}
+
+	return root, nil
+}
+
+func (f *Freezer) thawNode(addr cid.Cid, thawed map[NodeID]Node, edges map[Node][]RawEdge) (Node, error) {
+	raw, ok := f.Get(addr)
+
+	if !ok {
+		return nil, errors.Wrapf(ErrNodeNotFound, "frozen node %s", addr)
+	}
+
+	n := newNodeOfType(raw)
+	n.PsiNodeBase().Init(n, raw.UUID)
+
+	for _, attr := range raw.Attributes {
+		n.SetAttribute(attr.Key, attr.Value)
+	}
+
+	if tn, ok := n.(ThawableNode); ok {
+		if err := tn.ThawNode(raw); err != nil {
+			return nil, errors.Wrapf(err, "failed to thaw node %s", raw.UUID)
+		}
+	}
+
+	for _, entry := range raw.Children {
+		child, err := f.thawNode(entry.PhysAddr, thawed, edges)
+
+		if err != nil {
+			return nil, err
+		}
+
+		n.AddChildNode(child)
+	}
+
+	if len(raw.Edges) > 0 {
+		edges[n] = raw.Edges
+	}
+
+	thawed[raw.UUID] = n
+
+	return n, nil
+}
+
+// newNodeOfType instantiates the registered node type of raw, or an UnknownNode.
+func newNodeOfType(raw RawNode) Node {
+	if nt, ok := LookupNodeType(raw.Type); ok {
+		t := nt.RuntimeType()
+
+		if t != nil && t.Kind() == reflect.Pointer && t.Elem().Kind() == reflect.Struct {
+			if n, ok := reflect.New(t.Elem()).Interface().(Node); ok {
+				n.PsiNodeBase().typ = nt
+
+				return n
+			}
+		}
+	}
+
+	var n Node
+
+	if raw.Name != "" {
+		un := &namedUnknownNode{}
+		un.Raw = raw
+		n = un
+	} else {
+		n = &UnknownNode{Raw: raw}
+	}
+
+	n.PsiNodeBase().typ = &nodeType{
+		name:  raw.Type,
+		class: NodeClassGeneric,
+		typ:   reflect.TypeOf(n),
+	}
+
+	return n
 }
@@ -2,6 +2,7 @@ package psi
 
 import (
 	"context"
+	"fmt"
 
 	"github.com/greenboxal/agibootstrap/pkg/platform/mdutils"
 	"github.com/greenboxal/agibootstrap/pkg/platform/vfs/repofs"
@@ -40,3 +41,63 @@ type SourceFile interface {
 type Scope interface {
 	Root() Node
 }
+
+// VerifyOptions controls how completion results are verified.
+type VerifyOptions struct {
+	// RunTests runs the tests of the affected package after type-checking.
+	RunTests bool
+	// Merge are the options of the three-way merge with the changes made to the file since it was
+	// loaded, which the verified code goes through as it would when written.
+	Merge MergeOptions
+}
+
+// CompletionVerifier is implemented by source files that can check completion results
+// before they are merged. root is the current root of the file, and newSources are the
+// parsed completion results, in the order they would be merged.
+type CompletionVerifier interface {
+	VerifyCompletionResults(ctx context.Context, root Node, newSources []SourceFile, opts VerifyOptions) error
+}
+
+// MergeOptions controls how a three-way merge handles conflicts.
+type MergeOptions struct {
+	// ConflictMarkers keeps both sides of conflicting changes, delimited by conflict marker comments,
+	// instead of keeping only the current contents of the file.
+	ConflictMarkers bool
+}
+
+// MergeConflict is a declaration, or part of one, changed differently on both sides of a three-way merge.
+type MergeConflict struct {
+	File string
+	// Declaration identifies the conflicting declaration, e.g. "func (*T).Run".
+	Declaration string
+
+	// Ours is the current code, or empty if it was deleted.
+	Ours string
+	// Theirs is the generated code, or empty if it was deleted.
+	Theirs string
+}
+
+func (c *MergeConflict) Error() string {
+	return fmt.Sprintf("%s: merge conflict in %s", c.File, c.Declaration)
+}
+
+// MergingSourceFile is implemented by source files that merge generated code with the changes
+// made to the file since it was loaded, instead of overwriting them.
+type MergingSourceFile interface {
+	SourceFile
+
+	// MergeReplace merges code, derived from OriginalText, with the current contents of the file,
+	// using OriginalText as the base of a three-way merge, and writes the result.
+	// Conflicting changes are returned after the result is written.
+	MergeReplace(code string, opts MergeOptions) ([]*MergeConflict, error)
+}
+
+// EditingSourceFile is implemented by source files whose merges also change other files of the
+// project, like the header of a C implementation.
+type EditingSourceFile interface {
+	SourceFile
+
+	// EditedFiles returns the other files changed by the merges into the file since it was loaded.
+	// Their changes are rendered by their ToCode, and written by the caller along with the file.
+	EditedFiles() []SourceFile
+}
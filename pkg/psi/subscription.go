@@ -0,0 +1,155 @@
+package psi
+
+import (
+	"sync"
+
+	"github.com/samber/lo"
+)
+
+// SubscriptionBufferSize is the number of events buffered for each subscriber of a graph.
+const SubscriptionBufferSize = 1024
+
+type GraphEventKind string
+
+const (
+	// GraphEventNodeAdded is published when a node is attached to the graph.
+	GraphEventNodeAdded GraphEventKind = "node_added"
+	// GraphEventNodeRemoved is published when a node is detached from the graph.
+	GraphEventNodeRemoved GraphEventKind = "node_removed"
+	// GraphEventNodeInvalidated is published when a node is invalidated, before it is updated.
+	GraphEventNodeInvalidated GraphEventKind = "node_invalidated"
+	// GraphEventNodeUpdated is published when an invalidated node is brought up to date.
+	GraphEventNodeUpdated GraphEventKind = "node_updated"
+	// GraphEventEdgeSet is published when an edge of a node is set or retargeted.
+	GraphEventEdgeSet GraphEventKind = "edge_set"
+	// GraphEventEdgeUnset is published when an edge of a node is removed.
+	GraphEventEdgeUnset GraphEventKind = "edge_unset"
+)
+
+// GraphEvent is a change of a graph delivered to its subscribers.
+type GraphEvent struct {
+	Kind GraphEventKind
+
+	// Node is the node that changed. For edge events, it is the node the edge comes from.
+	Node Node
+	// Path is the canonical path of the node when the event was published. Nodes added have the path
+	// they are attached at. For removed nodes, it is the last path the node had in the graph.
+	Path Path
+	// Edge is the edge set or unset, for edge events.
+	Edge Edge
+
+	// OldVersion and NewVersion are the versions of the node before and after the change. They
+	// only differ for GraphEventNodeUpdated.
+	OldVersion int64
+	NewVersion int64
+}
+
+// GraphEventFilter selects the events delivered to a subscriber. Empty fields match every event.
+type GraphEventFilter struct {
+	// Kinds restricts the events to the given kinds.
+	Kinds []GraphEventKind
+	// PathPrefix restricts the events to nodes at or below the given path.
+	PathPrefix Path
+	// NodeTypes restricts the events to nodes of the given types. Type names may omit their
+	// namespace, like in queries.
+	NodeTypes []string
+	// EdgeKinds restricts the events to edge events of the given edge kinds.
+	EdgeKinds []EdgeKind
+}
+
+// Match returns true if ev passes the filter.
+func (f GraphEventFilter) Match(ev GraphEvent) bool {
+	if len(f.Kinds) > 0 && !lo.Contains(f.Kinds, ev.Kind) {
+		return false
+	}
+
+	if len(f.EdgeKinds) > 0 && (ev.Edge == nil || !lo.Contains(f.EdgeKinds, ev.Edge.Key().GetKey().Kind)) {
+		return false
+	}
+
+	if len(f.NodeTypes) > 0 {
+		name := NodeTypeName(ev.Node)
+
+		if !lo.ContainsBy(f.NodeTypes, func(filter string) bool { return matchNodeTypeName(name, filter) }) {
+			return false
+		}
+	}
+
+	if len(f.PathPrefix.Components()) > 0 && !ev.Path.HasPrefix(f.PathPrefix) {
+		return false
+	}
+
+	return true
+}
+
+type graphSubscription struct {
+	filter GraphEventFilter
+	ch     chan GraphEvent
+}
+
+// subscriptionHub delivers the events of a graph to its subscribers.
+//
+// Events are published with the write lock of the graph held, and subscribers usually take its read
+// lock to inspect the changed nodes, so publishing never blocks: a subscriber whose buffer is full is
+// dropped and its channel closed. Subscribers should resubscribe and resynchronize when that happens.
+type subscriptionHub struct {
+	mu   sync.Mutex
+	subs map[<-chan GraphEvent]*graphSubscription
+}
+
+func (h *subscriptionHub) subscribe(filter GraphEventFilter) <-chan GraphEvent {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.subs == nil {
+		h.subs = map[<-chan GraphEvent]*graphSubscription{}
+	}
+
+	sub := &graphSubscription{
+		filter: filter,
+		ch:     make(chan GraphEvent, SubscriptionBufferSize),
+	}
+
+	h.subs[sub.ch] = sub
+
+	return sub.ch
+}
+
+func (h *subscriptionHub) unsubscribe(ch <-chan GraphEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if sub, ok := h.subs[ch]; ok {
+		delete(h.subs, ch)
+		close(sub.ch)
+	}
+}
+
+func (h *subscriptionHub) publish(ev GraphEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for ch, sub := range h.subs {
+		if !sub.filter.Match(ev) {
+			continue
+		}
+
+		select {
+		case sub.ch <- ev:
+		default:
+			delete(h.subs, ch)
+			close(sub.ch)
+		}
+	}
+}
+
+// publishGraphEvent publishes ev to the subscribers of g, or queues it in the transaction in
+// progress, which publishes it when committed.
+func publishGraphEvent(g Graph, ev GraphEvent) {
+	if tx := g.CurrentTransaction(); tx != nil {
+		tx.events = append(tx.events, ev)
+		return
+	}
+
+	g.publishEvent(ev)
+}
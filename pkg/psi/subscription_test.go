@@ -0,0 +1,118 @@
+package psi
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func drainEvents(ch <-chan GraphEvent) (events []GraphEvent) {
+	for {
+		select {
+		case ev := <-ch:
+			events = append(events, ev)
+		default:
+			return
+		}
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	ctx := context.Background()
+
+	g := &BaseGraph{}
+	g.Init(g)
+
+	root := newFrozenTestNode(nil, "root")
+	a := newFrozenTestNode(root, "a")
+	b := newFrozenTestNode(root, "b")
+	root.attachToGraph(g)
+	require.NoError(t, root.Update(ctx))
+
+	updates := g.Subscribe(GraphEventFilter{
+		Kinds:      []GraphEventKind{GraphEventNodeUpdated},
+		PathPrefix: a.CanonicalPath(),
+	})
+
+	edges := g.Subscribe(GraphEventFilter{
+		EdgeKinds: []EdgeKind{EdgeKindReference},
+		NodeTypes: []string{"Frozen"},
+	})
+
+	version := a.PsiNodeVersion()
+	ref := EdgeKey{Kind: EdgeKindReference, Name: "b"}
+
+	a.SetAttribute("answer", "42")
+	b.SetAttribute("question", "?")
+	a.SetEdge(ref, b)
+	require.NoError(t, root.Update(ctx))
+
+	events := drainEvents(updates)
+	require.Len(t, events, 1)
+	require.Equal(t, a, events[0].Node)
+	require.Equal(t, version, events[0].OldVersion)
+	require.Equal(t, version+1, events[0].NewVersion)
+
+	events = drainEvents(edges)
+	require.Len(t, events, 1)
+	require.Equal(t, GraphEventEdgeSet, events[0].Kind)
+	require.Equal(t, b, events[0].Edge.To())
+
+	err := RunInTransaction(g, func(tx *Transaction) error {
+		a.UnsetEdge(ref)
+
+		require.Empty(t, drainEvents(edges))
+
+		return root.Update(ctx)
+	})
+
+	require.NoError(t, err)
+
+	events = drainEvents(edges)
+	require.Len(t, events, 1)
+	require.Equal(t, GraphEventEdgeUnset, events[0].Kind)
+
+	events = drainEvents(updates)
+	require.Len(t, events, 1)
+	require.Equal(t, version+1, events[0].OldVersion)
+	require.Equal(t, version+2, events[0].NewVersion)
+
+	g.Unsubscribe(edges)
+
+	_, ok := <-edges
+	require.False(t, ok)
+}
+
+func TestSubscribeNodeAdded(t *testing.T) {
+	ctx := context.Background()
+
+	g := &BaseGraph{}
+	g.Init(g)
+
+	root := newFrozenTestNode(nil, "root")
+	a := newFrozenTestNode(root, "a")
+	b := newFrozenTestNode(root, "b")
+	root.attachToGraph(g)
+	require.NoError(t, root.Update(ctx))
+
+	added := g.Subscribe(GraphEventFilter{
+		Kinds:      []GraphEventKind{GraphEventNodeAdded},
+		PathPrefix: a.CanonicalPath(),
+	})
+
+	// Subtrees are attached at once, before they are updated.
+	c := newFrozenTestNode(nil, "c")
+	d := newFrozenTestNode(c, "d")
+	a.AddChildNode(c)
+	b.AddChildNode(newFrozenTestNode(nil, "e"))
+
+	events := drainEvents(added)
+	require.Len(t, events, 2)
+	require.Equal(t, c, events[0].Node)
+	require.Equal(t, d, events[1].Node)
+
+	require.NoError(t, root.Update(ctx))
+	require.Equal(t, c.CanonicalPath(), events[0].Path)
+	require.Equal(t, d.CanonicalPath(), events[1].Path)
+}
@@ -0,0 +1,384 @@
+package psi
+
+import (
+	"context"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/pkg/errors"
+)
+
+// ErrTransactionInProgress is returned when a transaction is started, or the journal is replayed,
+// while another transaction is in progress on the same graph.
+var ErrTransactionInProgress = errors.New("transaction in progress")
+
+// ErrTransactionDone is returned when a transaction is committed or rolled back twice.
+var ErrTransactionDone = errors.New("transaction already committed or rolled back")
+
+// ErrNothingToUndo is returned by Graph.Undo when the journal has no transaction to undo.
+var ErrNothingToUndo = errors.New("nothing to undo")
+
+// ErrNothingToRedo is returned by Graph.Redo when the journal has no transaction to redo.
+var ErrNothingToRedo = errors.New("nothing to redo")
+
+// MaxJournalLength is the number of committed transactions kept in the change journal of a graph.
+const MaxJournalLength = 100
+
+// Transaction batches the mutations of the nodes of a graph.
+//
+// While a transaction is in progress, the state of each node is recorded before its first mutation
+// (SetParent, child changes, SetEdge, UnsetEdge, SetAttribute, RemoveAttribute), and the graph is not
+// notified of node invalidations and updates. Committing the transaction records it in the change
+// journal of the graph and notifies the graph once, with every node mutated by the transaction.
+// Rolling it back restores the recorded state of the nodes, and runs the functions registered with
+// OnRollback to restore the state kept outside of the graph. Likewise, undoing and redoing a committed
+// transaction runs the functions registered with OnUndo and OnRedo.
+type Transaction struct {
+	g *BaseGraph
+
+	nodes  []*NodeBase
+	before map[*NodeBase]*nodeSnapshot
+	after  map[*NodeBase]*nodeSnapshot
+
+	dirty     []Node
+	dirtySeen map[Node]bool
+	versions  map[Node]int64
+	events    []GraphEvent
+	rollbacks []func()
+	undos     []func()
+	redos     []func()
+
+	done bool
+}
+
+func newTransaction(g *BaseGraph) *Transaction {
+	return &Transaction{
+		g:         g,
+		before:    map[*NodeBase]*nodeSnapshot{},
+		dirtySeen: map[Node]bool{},
+		versions:  map[Node]int64{},
+	}
+}
+
+// Nodes returns the nodes mutated by the transaction, in order of first mutation.
+func (tx *Transaction) Nodes() []Node {
+	result := make([]Node, len(tx.nodes))
+
+	for i, n := range tx.nodes {
+		result[i] = n.self
+	}
+
+	return result
+}
+
+// UpdatedNodes returns the nodes invalidated or updated during the transaction, without duplicates.
+func (tx *Transaction) UpdatedNodes() []Node {
+	return tx.dirty
+}
+
+// Commit ends the transaction and records it in the change journal of the graph.
+func (tx *Transaction) Commit() error {
+	if tx.done {
+		return ErrTransactionDone
+	}
+
+	tx.done = true
+	tx.g.tx = nil
+
+	// Transactions mutating no node are not journaled, unless they changed state outside of the graph.
+	if len(tx.nodes) == 0 && len(tx.undos) == 0 && len(tx.redos) == 0 {
+		tx.publishEvents()
+
+		return nil
+	}
+
+	tx.after = make(map[*NodeBase]*nodeSnapshot, len(tx.nodes))
+
+	for _, n := range tx.nodes {
+		tx.after[n] = n.snapshot()
+	}
+
+	tx.g.appendJournal(tx)
+	tx.g.self.OnTransactionCommitted(tx)
+	tx.publishEvents()
+
+	return nil
+}
+
+// Rollback ends the transaction and restores the nodes to their state before the transaction.
+// The graph and its subscribers are not notified, since they were not notified of the rolled back
+// mutations either.
+func (tx *Transaction) Rollback() error {
+	if tx.done {
+		return ErrTransactionDone
+	}
+
+	tx.done = true
+
+	tx.restore(tx.before)
+
+	for i := len(tx.rollbacks) - 1; i >= 0; i-- {
+		tx.rollbacks[i]()
+	}
+
+	tx.g.tx = nil
+
+	return nil
+}
+
+// OnRollback registers fn to be called if the transaction is rolled back, after the nodes are restored.
+// Functions are called in reverse order of registration. Nodes backed by state outside of the graph,
+// such as a parsed AST, use it to undo the mutations of that state.
+func (tx *Transaction) OnRollback(fn func()) {
+	if tx.done {
+		return
+	}
+
+	tx.rollbacks = append(tx.rollbacks, fn)
+}
+
+// OnUndo registers fn to be called when the committed transaction is undone, after the nodes are
+// restored. Functions are called in reverse order of registration, like the ones registered with
+// OnRollback.
+func (tx *Transaction) OnUndo(fn func()) {
+	if tx.done {
+		return
+	}
+
+	tx.undos = append(tx.undos, fn)
+}
+
+// OnRedo registers fn to be called when the undone transaction is redone, after the nodes are
+// restored. Functions are called in order of registration.
+func (tx *Transaction) OnRedo(fn func()) {
+	if tx.done {
+		return
+	}
+
+	tx.redos = append(tx.redos, fn)
+}
+
+// record saves the state of n before its first mutation in the transaction.
+func (tx *Transaction) record(n *NodeBase) {
+	if tx.done {
+		return
+	}
+
+	if _, ok := tx.before[n]; ok {
+		return
+	}
+
+	tx.before[n] = n.snapshot()
+	tx.nodes = append(tx.nodes, n)
+}
+
+func (tx *Transaction) markDirty(n Node) {
+	if tx.dirtySeen[n] {
+		return
+	}
+
+	tx.dirtySeen[n] = true
+	tx.dirty = append(tx.dirty, n)
+	tx.versions[n] = n.PsiNodeVersion()
+}
+
+// publishEvents publishes the events queued during the transaction to the subscribers of the graph,
+// followed by an update event for each node brought up to date, or an invalidation event for each
+// node left invalid.
+func (tx *Transaction) publishEvents() {
+	for _, ev := range tx.events {
+		tx.g.publishEvent(ev)
+	}
+
+	for _, n := range tx.dirty {
+		ev := GraphEvent{
+			Kind:       GraphEventNodeUpdated,
+			Node:       n,
+			Path:       n.CanonicalPath(),
+			OldVersion: tx.versions[n],
+			NewVersion: n.PsiNodeVersion(),
+		}
+
+		if ev.OldVersion == ev.NewVersion {
+			ev.Kind = GraphEventNodeInvalidated
+		}
+
+		tx.g.publishEvent(ev)
+	}
+}
+
+// restore sets the nodes of the transaction to the given snapshots and brings them up to date.
+func (tx *Transaction) restore(snapshots map[*NodeBase]*nodeSnapshot) {
+	for _, n := range tx.nodes {
+		n.restore(snapshots[n])
+	}
+
+	for _, n := range tx.nodes {
+		n.restoreGraph(snapshots[n].g)
+	}
+
+	for _, n := range tx.nodes {
+		n.valid = false
+	}
+
+	for _, n := range tx.nodes {
+		_ = n.Update(context.Background())
+	}
+}
+
+// replay applies a committed transaction again, from its before (undo) or after (redo) state,
+// notifying the graph of the nodes it touches. hooks restores the state kept outside of the graph,
+// before the graph is notified.
+func (tx *Transaction) replay(snapshots map[*NodeBase]*nodeSnapshot, hooks func()) {
+	// The replay only collects notifications, restoring does not record mutations.
+	replay := newTransaction(tx.g)
+	replay.done = true
+	replay.nodes = tx.nodes
+
+	for _, n := range tx.nodes {
+		replay.markDirty(n.self)
+	}
+
+	tx.g.tx = replay
+	tx.restore(snapshots)
+	hooks()
+	tx.g.tx = nil
+
+	tx.g.self.OnTransactionCommitted(replay)
+	replay.publishEvents()
+}
+
+// RunInTransaction runs fn in a transaction of g, which is committed if fn succeeds and rolled back
+// if it returns an error or panics.
+func RunInTransaction(g Graph, fn func(tx *Transaction) error) (err error) {
+	tx, err := g.BeginTransaction()
+
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			_ = tx.Rollback()
+			panic(r)
+		}
+	}()
+
+	if err := fn(tx); err != nil {
+		if rerr := tx.Rollback(); rerr != nil {
+			err = multierror.Append(err, rerr)
+		}
+
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// nodeSnapshot is the state of a node recorded by a transaction.
+type nodeSnapshot struct {
+	g          Graph
+	parent     Node
+	children   []Node
+	edges      map[EdgeKey]Edge
+	attributes map[string]any
+}
+
+func (n *NodeBase) snapshot() *nodeSnapshot {
+	s := &nodeSnapshot{
+		g:          n.g,
+		parent:     n.Parent(),
+		children:   n.copyChildren(),
+		edges:      make(map[EdgeKey]Edge, n.edges.Len()),
+		attributes: n.Attributes(),
+	}
+
+	for it := n.edges.Iterator(); it.Next(); {
+		s.edges[it.Item().Key] = it.Item().Value
+	}
+
+	return s
+}
+
+// restore sets the parent, children, edges and attributes of the node without triggering its observers.
+func (n *NodeBase) restore(s *nodeSnapshot) {
+	n.restoring = true
+
+	defer func() {
+		n.restoring = false
+	}()
+
+	n.parent.SetValue(s.parent)
+	n.children.ReplaceAll(s.children...)
+	n.edges.Clear()
+
+	for k, v := range s.edges {
+		n.edges.Set(k, v)
+	}
+
+	n.attributes.Clear()
+
+	for k, v := range s.attributes {
+		n.attributes.Set(k, v)
+	}
+}
+
+// restoreGraph attaches the node to, or detaches it from, the graph it belonged to in a snapshot.
+func (n *NodeBase) restoreGraph(g Graph) {
+	if n.g == g {
+		return
+	}
+
+	if n.g != nil {
+		n.detachFromGraph(n.g)
+	}
+
+	if g != nil {
+		n.attachToGraph(g)
+	}
+}
+
+// recordMutation records the state of the node in the transaction in progress on g, if any,
+// before it is mutated.
+func (n *NodeBase) recordMutation(g Graph) {
+	if n.restoring || g == nil {
+		return
+	}
+
+	if tx := g.CurrentTransaction(); tx != nil {
+		tx.record(n)
+	}
+}
+
+func notifyNodeInvalidated(g Graph, n Node) {
+	if tx := g.CurrentTransaction(); tx != nil {
+		tx.markDirty(n)
+		return
+	}
+
+	g.OnNodeInvalidated(n)
+
+	g.publishEvent(GraphEvent{
+		Kind:       GraphEventNodeInvalidated,
+		Node:       n,
+		Path:       n.CanonicalPath(),
+		OldVersion: n.PsiNodeVersion(),
+		NewVersion: n.PsiNodeVersion(),
+	})
+}
+
+func notifyNodeUpdated(g Graph, n Node, oldVersion int64) {
+	if tx := g.CurrentTransaction(); tx != nil {
+		tx.markDirty(n)
+		return
+	}
+
+	g.OnNodeUpdated(n)
+
+	g.publishEvent(GraphEvent{
+		Kind:       GraphEventNodeUpdated,
+		Node:       n,
+		Path:       n.CanonicalPath(),
+		OldVersion: oldVersion,
+		NewVersion: n.PsiNodeVersion(),
+	})
+}
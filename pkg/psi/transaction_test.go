@@ -0,0 +1,101 @@
+package psi
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+type transactionTestGraph struct {
+	BaseGraph
+
+	committed [][]Node
+}
+
+func (g *transactionTestGraph) OnTransactionCommitted(tx *Transaction) {
+	g.committed = append(g.committed, tx.UpdatedNodes())
+}
+
+func childNames(n Node) (names []string) {
+	for _, child := range n.Children() {
+		names = append(names, child.(*queryTestNode).name)
+	}
+
+	return
+}
+
+func TestTransaction(t *testing.T) {
+	g := &transactionTestGraph{}
+	g.Init(g)
+
+	root := newQueryTestNode(nil, "root")
+	a := newQueryTestNode(root, "a")
+	root.attachToGraph(g)
+
+	b := newQueryTestNode(nil, "b")
+
+	mutate := func(tx *Transaction) error {
+		b.SetParent(root)
+		a.SetAttribute("answer", 42)
+		a.SetEdge(EdgeKey{Kind: EdgeKindReference}, b)
+
+		return nil
+	}
+
+	var rolledBack []string
+
+	err := RunInTransaction(g, func(tx *Transaction) error {
+		require.NoError(t, mutate(tx))
+
+		tx.OnRollback(func() { rolledBack = append(rolledBack, "first") })
+		tx.OnRollback(func() { rolledBack = append(rolledBack, "second") })
+
+		return errors.New("merge failed")
+	})
+
+	require.Error(t, err)
+	require.Equal(t, []string{"second", "first"}, rolledBack)
+	require.Equal(t, []string{"a"}, childNames(root))
+	require.Nil(t, b.Parent())
+	require.Nil(t, b.PsiNodeBase().g)
+	require.Nil(t, a.GetEdge(EdgeKey{Kind: EdgeKindReference}))
+	_, ok := a.GetAttribute("answer")
+	require.False(t, ok)
+	require.Empty(t, g.committed)
+	require.False(t, g.CanUndo())
+
+	require.NoError(t, RunInTransaction(g, func(tx *Transaction) error {
+		tx.OnRollback(func() { rolledBack = append(rolledBack, "committed") })
+
+		return mutate(tx)
+	}))
+	require.Len(t, rolledBack, 2)
+	require.Equal(t, []string{"a", "b"}, childNames(root))
+	require.Len(t, g.committed, 1)
+	require.Contains(t, g.committed[0], a)
+	require.True(t, g.CanUndo())
+
+	require.NoError(t, g.Undo())
+	require.Equal(t, []string{"a"}, childNames(root))
+	require.Nil(t, b.Parent())
+	require.Nil(t, a.GetEdge(EdgeKey{Kind: EdgeKindReference}))
+	require.Len(t, g.committed, 2)
+	require.ErrorIs(t, g.Undo(), ErrNothingToUndo)
+
+	require.NoError(t, g.Redo())
+	require.Equal(t, []string{"a", "b"}, childNames(root))
+	require.Equal(t, root, b.Parent())
+	require.Equal(t, b, a.GetEdge(EdgeKey{Kind: EdgeKindReference}).To())
+	v, _ := a.GetAttribute("answer")
+	require.Equal(t, 42, v)
+	require.ErrorIs(t, g.Redo(), ErrNothingToRedo)
+
+	tx, err := g.BeginTransaction()
+	require.NoError(t, err)
+	_, err = g.BeginTransaction()
+	require.ErrorIs(t, err, ErrTransactionInProgress)
+	require.ErrorIs(t, g.Undo(), ErrTransactionInProgress)
+	require.NoError(t, tx.Commit())
+	require.ErrorIs(t, tx.Commit(), ErrTransactionDone)
+}
@@ -1,6 +1,11 @@
 package psi
 
-import "reflect"
+import (
+	"reflect"
+	"sync"
+
+	"github.com/pkg/errors"
+)
 
 type NodeClass string
 
@@ -11,7 +16,15 @@ const (
 	NodeClassDocument NodeClass = "document"
 )
 
+// ErrMissingNodeMigration is returned when a node is migrated from a schema version without a migration.
+var ErrMissingNodeMigration = errors.New("missing node migration")
+
+// ErrUnsupportedSchemaVersion is returned when a node was persisted with a schema version newer than its node type.
+var ErrUnsupportedSchemaVersion = errors.New("unsupported schema version")
+
+var nodeTypeRegistryMu sync.RWMutex
 var nodeTypeRegistry = map[string]NodeType{}
+var nodeTypesByRuntimeType = map[reflect.Type]NodeType{}
 
 type NodeTypeOption func(*nodeType)
 
@@ -26,21 +39,94 @@ func RegisterNodeType[T Node](name string, options ...NodeTypeOption) TypedNodeT
 		opt(nt)
 	}
 
+	nodeTypeRegistryMu.Lock()
+	defer nodeTypeRegistryMu.Unlock()
+
 	nodeTypeRegistry[name] = nt
 
+	// Interface types are shared by the node types of a language, e.g. golang.Node.
+	if nt.typ.Kind() != reflect.Interface {
+		nodeTypesByRuntimeType[nt.typ] = nt
+	}
+
 	return nt
 }
 
+// LookupNodeType returns the node type registered with the given name.
+func LookupNodeType(name string) (NodeType, bool) {
+	nodeTypeRegistryMu.RLock()
+	defer nodeTypeRegistryMu.RUnlock()
+
+	nt, ok := nodeTypeRegistry[name]
+
+	return nt, ok
+}
+
+// RegisteredNodeTypes returns the registered node types.
+func RegisteredNodeTypes() []NodeType {
+	nodeTypeRegistryMu.RLock()
+	defer nodeTypeRegistryMu.RUnlock()
+
+	result := make([]NodeType, 0, len(nodeTypeRegistry))
+
+	for _, nt := range nodeTypeRegistry {
+		result = append(result, nt)
+	}
+
+	return result
+}
+
+// NodeTypeOf returns the node type of n, or the node type registered for the Go type of n.
+// It returns nil if n has no node type.
+func NodeTypeOf(n Node) NodeType {
+	if typ := n.PsiNodeType(); typ != nil {
+		return typ
+	}
+
+	nodeTypeRegistryMu.RLock()
+	defer nodeTypeRegistryMu.RUnlock()
+
+	return nodeTypesByRuntimeType[reflect.TypeOf(n)]
+}
+
 func WithNodeClass(class NodeClass) NodeTypeOption {
 	return func(nt *nodeType) {
 		nt.class = class
 	}
 }
 
+// NodeMigration upgrades the persisted fields of a node by one schema version, in place.
+// Fields are decoded from JSON, with numbers as json.Number.
+type NodeMigration func(fields map[string]any) error
+
+// WithSchemaVersion sets the schema version of the persisted fields of the node type.
+// Node types start at schema version 0.
+func WithSchemaVersion(version int) NodeTypeOption {
+	return func(nt *nodeType) {
+		nt.version = version
+	}
+}
+
+// WithMigration registers the migration of the node type from schema version from to from+1.
+func WithMigration(from int, migration NodeMigration) NodeTypeOption {
+	return func(nt *nodeType) {
+		if nt.migrations == nil {
+			nt.migrations = map[int]NodeMigration{}
+		}
+
+		nt.migrations[from] = migration
+	}
+}
+
 type NodeType interface {
 	Name() string
 	Class() NodeClass
 	RuntimeType() reflect.Type
+
+	// SchemaVersion is the current schema version of the persisted fields of the node type.
+	SchemaVersion() int
+	// Migrate upgrades the persisted fields of a node from schema version from to SchemaVersion.
+	Migrate(fields map[string]any, from int) error
 }
 
 type TypedNodeType[T Node] interface {
@@ -55,8 +141,32 @@ type nodeType struct {
 	name  string
 	class NodeClass
 	typ   reflect.Type
+
+	version    int
+	migrations map[int]NodeMigration
 }
 
 func (n *nodeType) Name() string              { return n.name }
 func (n *nodeType) Class() NodeClass          { return n.class }
 func (n *nodeType) RuntimeType() reflect.Type { return n.typ }
+func (n *nodeType) SchemaVersion() int        { return n.version }
+
+func (n *nodeType) Migrate(fields map[string]any, from int) error {
+	if from > n.version {
+		return errors.Wrapf(ErrUnsupportedSchemaVersion, "%s: schema version %d, expected at most %d", n.name, from, n.version)
+	}
+
+	for v := from; v < n.version; v++ {
+		migration := n.migrations[v]
+
+		if migration == nil {
+			return errors.Wrapf(ErrMissingNodeMigration, "%s: from schema version %d", n.name, v)
+		}
+
+		if err := migration(fields); err != nil {
+			return errors.Wrapf(err, "%s: failed to migrate from schema version %d", n.name, v)
+		}
+	}
+
+	return nil
+}
@@ -3,21 +3,42 @@ package psi
 // WalkFunc is the type of the function called for each node visited by Walk.
 type WalkFunc func(cursor Cursor, entering bool) error
 
+// WalkOption configures a walk.
+type WalkOption func(c *cursor)
+
+// WithWalkEdges makes the walk follow the edges of every node, after its children.
+// If kinds are given, only edges of those kinds are followed. Otherwise, every non-child edge is.
+// Nodes reachable through several paths, including cycles, are visited once.
+func WithWalkEdges(kinds ...EdgeKind) WalkOption {
+	return func(c *cursor) {
+		c.walkEdges = true
+		c.edgeKinds = kinds
+	}
+}
+
 // Walk traverses a PSI Tree in depth-first order.
-func Walk(node Node, walkFn WalkFunc) error {
+func Walk(node Node, walkFn WalkFunc, options ...WalkOption) error {
 	c := &cursor{
 		walkChildren: true,
 	}
 
+	for _, opt := range options {
+		opt(c)
+	}
+
 	return c.Walk(node, walkFn)
 }
 
 // Rewrite traverses a PSI Tree in depth-first order and rewrites it.
-func Rewrite(node Node, walkFunc WalkFunc) (Node, error) {
+func Rewrite(node Node, walkFunc WalkFunc, options ...WalkOption) (Node, error) {
 	c := &cursor{
 		walkChildren: true,
 	}
 
+	for _, opt := range options {
+		opt(c)
+	}
+
 	if err := c.Walk(node, walkFunc); err != nil && err != ErrAbort {
 		return nil, err
 	}
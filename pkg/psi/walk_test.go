@@ -0,0 +1,57 @@
+package psi
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWalkEdges(t *testing.T) {
+	root := newQueryTestNode(nil, "root")
+	a := newQueryTestNode(root, "a")
+	newQueryTestNode(a, "b")
+
+	other := newQueryTestNode(nil, "other")
+	newQueryTestNode(other, "c")
+
+	declKey := EdgeKey{Kind: EdgeKindDeclaration, Name: "other"}
+
+	a.SetEdge(declKey, other)
+	// Cycle back into the tree being walked.
+	other.SetEdge(EdgeKey{Kind: EdgeKindReference}, root)
+
+	walk := func(options ...WalkOption) (entered []string, left []string, edges map[string]EdgeReference) {
+		edges = map[string]EdgeReference{}
+
+		err := Walk(root, func(cursor Cursor, entering bool) error {
+			name := cursor.Node().(*queryTestNode).name
+
+			if entering {
+				entered = append(entered, name)
+				edges[name] = cursor.Edge()
+			} else {
+				left = append(left, name)
+			}
+
+			return nil
+		}, options...)
+
+		require.NoError(t, err)
+
+		return
+	}
+
+	entered, left, _ := walk()
+	require.Equal(t, []string{"root", "a", "b"}, entered)
+	require.Equal(t, []string{"b", "a", "root"}, left)
+
+	entered, left, edges := walk(WithWalkEdges())
+	require.Equal(t, []string{"root", "a", "b", "other", "c"}, entered)
+	require.Equal(t, []string{"b", "c", "other", "a", "root"}, left)
+	require.Equal(t, declKey, edges["other"])
+	require.Nil(t, edges["root"])
+	require.Nil(t, edges["c"])
+
+	entered, _, _ = walk(WithWalkEdges(EdgeKindImplementation))
+	require.Equal(t, []string{"root", "a", "b"}, entered)
+}
@@ -14,6 +14,7 @@ type Document struct {
 	ID      string
 	Name    string
 	Content fyne.CanvasObject
+	Editor  Editor
 
 	manager *DocumentManager
 	tabItem *container.TabItem
@@ -70,7 +71,7 @@ func (m *DocumentManager) CloseDocument(d *Document) {
 	m.area.Refresh()
 }
 
-func (m *DocumentManager) OpenDocument(path psi.Path, node psi.Node) {
+func (m *DocumentManager) OpenDocument(path psi.Path, node psi.Node) *Document {
 	key := path.String()
 
 	if node == nil {
@@ -89,12 +90,13 @@ func (m *DocumentManager) OpenDocument(path psi.Path, node psi.Node) {
 		factory := FactoryForNode(node)
 
 		if factory == nil {
-			return
+			return nil
 		}
 
 		editor := factory(m.p, path, node)
 
 		existing = NewDocument(path.String(), path.String(), editor.Root())
+		existing.Editor = editor
 	}
 
 	if !existing.isOpen {
@@ -102,6 +104,40 @@ func (m *DocumentManager) OpenDocument(path psi.Path, node psi.Node) {
 	}
 
 	m.area.Select(existing.tabItem)
+
+	return existing
+}
+
+// OpenSource opens the source file containing n, scrolled to the source range of n. It returns false
+// if n has no source range.
+func (m *DocumentManager) OpenSource(n psi.Node) bool {
+	rng, ok := psi.NodeSourceRange(n)
+
+	if !ok {
+		return false
+	}
+
+	for p := n; p != nil; p = p.Parent() {
+		sf, ok := p.(psi.SourceFile)
+
+		if !ok {
+			continue
+		}
+
+		doc := m.OpenDocument(sf.CanonicalPath(), sf)
+
+		if doc == nil {
+			return false
+		}
+
+		if editor, ok := doc.Editor.(SourceEditor); ok {
+			editor.RevealSourceRange(rng)
+		}
+
+		return true
+	}
+
+	return false
 }
 
 func NewDocumentManager(p project.Project) *DocumentManager {
@@ -19,6 +19,14 @@ type Editor interface {
 	Root() fyne.CanvasObject
 }
 
+// SourceEditor is implemented by editors showing the text of a source file.
+type SourceEditor interface {
+	Editor
+
+	// RevealSourceRange scrolls the editor to a range of the source file.
+	RevealSourceRange(r psi.SourceRange)
+}
+
 type EditorFactory func(p project.Project, elementPath psi.Path, element psi.Node) Editor
 
 type PsiNodeDescription struct {
@@ -27,7 +35,42 @@ type PsiNodeDescription struct {
 	Icon        fyne.Resource
 }
 
+// GetPsiNodeDescription describes v for the project tree. Nodes with diagnostics get the icon of
+// their most severe diagnostic and list them in their description. Source files show the
+// diagnostics of all their nodes.
 func GetPsiNodeDescription(v psi.Node) PsiNodeDescription {
+	desc := describePsiNode(v)
+
+	var diagnostics []*psi.Diagnostic
+
+	if _, ok := v.(psi.SourceFile); ok {
+		diagnostics = psi.CollectDiagnostics(v)
+	} else {
+		diagnostics = psi.NodeDiagnostics(v)
+	}
+
+	// Severities decrease from errors to hints.
+	severity := psi.SeverityHint + 1
+
+	for _, d := range diagnostics {
+		desc.Description += "\n" + d.String()
+
+		if d.Severity < severity {
+			severity = d.Severity
+		}
+	}
+
+	switch severity {
+	case psi.SeverityError:
+		desc.Icon = theme.ErrorIcon()
+	case psi.SeverityWarning:
+		desc.Icon = theme.WarningIcon()
+	}
+
+	return desc
+}
+
+func describePsiNode(v psi.Node) PsiNodeDescription {
 	switch v := v.(type) {
 	case *thoughtstream.ThoughtLog:
 		return PsiNodeDescription{
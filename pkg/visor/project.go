@@ -16,6 +16,11 @@ func NewProjectExplorer(p project.Project, dm *DocumentManager) *ProjectExplorer
 	projectTree := NewPsiTreeWidget(p)
 
 	projectTree.OnNodeSelected = func(n psi.Node) {
+		// Code nodes jump to their source, other nodes open their own editor.
+		if FactoryForNode(n) == nil && dm.OpenSource(n) {
+			return
+		}
+
 		dm.OpenDocument(n.CanonicalPath(), n)
 	}
 
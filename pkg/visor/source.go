@@ -5,6 +5,7 @@ import (
 
 	"fyne.io/fyne/v2"
 	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/theme"
 	"fyne.io/fyne/v2/widget"
 
 	"github.com/greenboxal/agibootstrap/pkg/platform/project"
@@ -16,7 +17,8 @@ type SourceFileEditor struct {
 	elementPath psi.Path
 	element     psi.SourceFile
 
-	root fyne.CanvasObject
+	root   fyne.CanvasObject
+	scroll *container.Scroll
 }
 
 func (t *SourceFileEditor) Project() project.Project { return t.project }
@@ -34,7 +36,20 @@ func NewSourceFileEditor(p project.Project, elementPath psi.Path, element psi.No
 	textArea := widget.NewRichText()
 	textArea.ParseMarkdown(fmt.Sprintf("```\n%s\n```", tle.element.OriginalText()))
 
-	tle.root = container.NewScroll(textArea)
+	tle.scroll = container.NewScroll(textArea)
+	tle.root = tle.scroll
 
 	return tle
 }
+
+// RevealSourceRange scrolls to the first line of r.
+func (t *SourceFileEditor) RevealSourceRange(r psi.SourceRange) {
+	if !r.IsValid() {
+		return
+	}
+
+	lineHeight := fyne.MeasureText("M", theme.TextSize(), fyne.TextStyle{Monospace: true}).Height + theme.LineSpacing()
+
+	t.scroll.Offset = fyne.NewPos(0, float32(r.Start.Line-1)*lineHeight)
+	t.scroll.Refresh()
+}
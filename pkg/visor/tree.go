@@ -18,11 +18,16 @@ type PsiTreeWidget struct {
 	*widget.Tree
 
 	resolutionRoot psi.Node
+	// g is the graph of the resolution root, if known. Nodes are read with its read lock held.
+	g psi.Graph
 
 	mu        sync.RWMutex
 	pathCache map[string]*psiTreeNodeState
 	refresher *debouncer
 
+	closeOnce sync.Once
+	closed    chan struct{}
+
 	OnNodeSelected func(n psi.Node)
 }
 
@@ -30,17 +35,26 @@ func NewPsiTreeWidget(resolutionRoot psi.Node) *PsiTreeWidget {
 	ptw := &PsiTreeWidget{
 		resolutionRoot: resolutionRoot,
 		pathCache:      map[string]*psiTreeNodeState{},
+		closed:         make(chan struct{}),
+	}
+
+	if gp, ok := resolutionRoot.(interface{ Graph() psi.Graph }); ok {
+		ptw.g = gp.Graph()
 	}
 
 	ptw.Tree = &widget.Tree{
-		ChildUIDs: func(id widget.TreeNodeID) []widget.TreeNodeID {
-			existing := ptw.getNodeState(id, true)
+		ChildUIDs: func(id widget.TreeNodeID) (result []widget.TreeNodeID) {
+			ptw.readGraph(func() {
+				existing := ptw.getNodeState(id, true)
 
-			if existing.node == nil {
-				existing.loadNode()
-			}
+				if existing.node == nil {
+					existing.loadNode()
+				}
+
+				result = append(result, existing.childrenIds.Slice()...)
+			})
 
-			return existing.childrenIds.Slice()
+			return
 		},
 
 		IsBranch: func(id widget.TreeNodeID) bool {
@@ -52,14 +66,20 @@ func NewPsiTreeWidget(resolutionRoot psi.Node) *PsiTreeWidget {
 		},
 
 		UpdateNode: func(id widget.TreeNodeID, branch bool, o fyne.CanvasObject) {
-			n, err := ptw.resolveCached(id)
+			var info PsiNodeDescription
+			var found bool
+
+			ptw.readGraph(func() {
+				if n, err := ptw.resolveCached(id); err == nil && n != nil {
+					info = GetPsiNodeDescription(n)
+					found = true
+				}
+			})
 
-			if err != nil {
+			if !found {
 				return
 			}
 
-			info := GetPsiNodeDescription(n)
-
 			labelContainer := o.(*fyne.Container)
 			labelContainer.Objects[0].(*widget.Icon).SetResource(info.Icon)
 			labelContainer.Objects[1].(*widget.Label).SetText(info.Name)
@@ -80,21 +100,104 @@ func NewPsiTreeWidget(resolutionRoot psi.Node) *PsiTreeWidget {
 		ptw.Tree.Refresh()
 	})
 
-	ptw.SetRootItem(resolutionRoot.CanonicalPath())
+	ptw.readGraph(func() {
+		ptw.SetRootItem(resolutionRoot.CanonicalPath())
+	})
+
+	if ptw.g != nil {
+		go ptw.watchGraph()
+	}
 
 	return ptw
 }
 
-func (ptw *PsiTreeWidget) Node(id widget.TreeNodeID) psi.Node {
-	n, err := ptw.resolveCached(id)
+// Close stops following the changes of the graph.
+func (ptw *PsiTreeWidget) Close() {
+	ptw.closeOnce.Do(func() {
+		close(ptw.closed)
+	})
+}
 
-	if err != nil {
-		return nil
+// watchGraph refreshes the tree when nodes below the resolution root are added, removed or
+// updated, forgetting the removed ones.
+func (ptw *PsiTreeWidget) watchGraph() {
+	var filter psi.GraphEventFilter
+
+	ptw.readGraph(func() {
+		filter = psi.GraphEventFilter{
+			Kinds: []psi.GraphEventKind{
+				psi.GraphEventNodeAdded,
+				psi.GraphEventNodeRemoved,
+				psi.GraphEventNodeUpdated,
+			},
+
+			PathPrefix: ptw.resolutionRoot.CanonicalPath(),
+		}
+	})
+
+	for {
+		events := ptw.g.Subscribe(filter)
+
+		if !ptw.followEvents(events) {
+			ptw.g.Unsubscribe(events)
+			return
+		}
+
+		// The subscription was dropped because the tree fell behind, so every node may be stale.
+		ptw.refreshTree()
+	}
+}
+
+// followEvents handles events until the subscription is dropped, returning true, or the tree is
+// closed, returning false.
+func (ptw *PsiTreeWidget) followEvents(events <-chan psi.GraphEvent) bool {
+	for {
+		select {
+		case <-ptw.closed:
+			return false
+
+		case ev, ok := <-events:
+			if !ok {
+				return true
+			}
+
+			if ev.Kind == psi.GraphEventNodeRemoved {
+				ptw.forgetNode(ev.Path.String())
+			}
+
+			ptw.refreshTree()
+		}
 	}
+}
+
+func (ptw *PsiTreeWidget) forgetNode(id widget.TreeNodeID) {
+	ptw.mu.Lock()
+	state := ptw.pathCache[id]
+	delete(ptw.pathCache, id)
+	ptw.mu.Unlock()
+
+	if state != nil {
+		state.Close()
+	}
+}
+
+func (ptw *PsiTreeWidget) Node(id widget.TreeNodeID) (n psi.Node) {
+	ptw.readGraph(func() {
+		n, _ = ptw.resolveCached(id)
+	})
 
 	return n
 }
 
+// readGraph runs fn with the read lock of the graph held. See the Concurrency section of the psi package.
+func (ptw *PsiTreeWidget) readGraph(fn func()) {
+	_ = psi.ReadGraph(ptw.g, func() error {
+		fn()
+
+		return nil
+	})
+}
+
 func (ptw *PsiTreeWidget) getNodeState(id widget.TreeNodeID, create bool) *psiTreeNodeState {
 	if id == "" {
 		return nil
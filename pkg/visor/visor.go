@@ -19,10 +19,12 @@ import (
 	"github.com/greenboxal/agibootstrap/pkg/build"
 	"github.com/greenboxal/agibootstrap/pkg/build/codegen"
 	"github.com/greenboxal/agibootstrap/pkg/build/fiximports"
+	"github.com/greenboxal/agibootstrap/pkg/gpt"
 	"github.com/greenboxal/agibootstrap/pkg/platform/db/graphstore"
 	"github.com/greenboxal/agibootstrap/pkg/platform/db/thoughtstream"
 	"github.com/greenboxal/agibootstrap/pkg/platform/project"
 	"github.com/greenboxal/agibootstrap/pkg/platform/tasks"
+	"github.com/greenboxal/agibootstrap/pkg/psi"
 )
 
 type Visor struct {
@@ -69,7 +71,10 @@ func NewVisor(p project.Project) *Visor {
 		}),
 
 		widget.NewButton("Boot", func() {
-			v.p.TaskManager().SpawnTask(context.Background(), func(tctx tasks.TaskProgress) error {
+			// The agents and their prompts resolve the provider from the context.
+			ctx := gpt.WithProvider(context.Background(), v.p.ModelProvider())
+
+			v.p.TaskManager().SpawnTask(ctx, func(tctx tasks.TaskProgress) error {
 				s, err := singularity.NewSingularity(p.LogManager())
 
 				if err != nil {
@@ -110,7 +115,13 @@ Create a Pytorch model based on the human brain cytoarchitecture.
 		}),
 
 		widget.NewButton("Dump Graph", func() {
-			data, err := graphstore.SerializeGraph(p.Graph())
+			var data []byte
+
+			err := psi.ReadGraph(p.Graph(), func() (err error) {
+				data, err = graphstore.SerializeGraph(p.Graph())
+
+				return
+			})
 
 			if err != nil {
 				panic(err)